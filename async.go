@@ -0,0 +1,249 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultAsyncQueueSize is the default number of records
+// queued by AsyncHandler
+const DefaultAsyncQueueSize = 1024
+
+// asyncItem is a queued record, or a flush request if flushed is not nil
+type asyncItem struct {
+	record  *LogRecord
+	flushed chan struct{}
+}
+
+// AsyncHandler is a handler which emits records to the wrapped Handler
+// in a background goroutine, so logging does not wait for slow outputs.
+// Emit blocks if the queue is full.
+// Flush is a barrier, it returns after all records queued before it are
+// emitted and the wrapped handler is flushed, FlushContext bounds the
+// wait by a context. Close drains the queue and closes the
+// wrapped handler, records emitted after Close are dropped.
+// If HighWatermark is set, records below ShedLevel are shed once the
+// queue length reaches HighWatermark, until it drains to LowWatermark,
+// so logging degrades gracefully under pressure while WARN and above
+// are still queued
+type AsyncHandler struct {
+	Name    string
+	Handler Handler
+	// HighWatermark is the queue length which starts shedding,
+	// 0 means never shedding
+	HighWatermark int
+	// LowWatermark is the queue length which stops shedding
+	LowWatermark int
+	// ShedLevel is the min level of records which are never shed
+	ShedLevel Level
+
+	queue    chan asyncItem
+	done     chan struct{}
+	mu       sync.RWMutex
+	closed   bool
+	shedding uint32
+	shed     uint64
+	// aborted is set by CloseContext, the loop abandons queued records
+	aborted uint32
+	StatsCounter
+}
+
+// NewAsyncHandler returns a new AsyncHandler wrapping hdlr,
+// queueSize <= 0 means DefaultAsyncQueueSize
+func NewAsyncHandler(hdlr Handler, queueSize int, options ...Option) *AsyncHandler {
+	if queueSize <= 0 {
+		queueSize = DefaultAsyncQueueSize
+	}
+	async := &AsyncHandler{
+		Name:      "",
+		Handler:   hdlr,
+		ShedLevel: WarnLevel,
+		queue:     make(chan asyncItem, queueSize),
+		done:      make(chan struct{}),
+	}
+
+	applyOptions(async, options...)
+
+	go async.loop()
+
+	return async
+}
+
+// NewAsyncFileHandler returns a FileHandler built by NewFileHandler with
+// the options, e.g. OptionPath and a Level, wrapped in an AsyncHandler with
+// a queue of queueSize. The wrapper takes the Name of the FileHandler, so
+// it is fully configured and safe to share once returned
+func NewAsyncFileHandler(queueSize int, options ...Option) *AsyncHandler {
+	file := NewFileHandler(options...)
+	return NewAsyncHandler(file, queueSize, OptionName(file.Name))
+}
+
+func (hdlr *AsyncHandler) loop() {
+	defer close(hdlr.done)
+	for item := range hdlr.queue {
+		if item.flushed != nil {
+			close(item.flushed)
+			continue
+		}
+		if atomic.LoadUint32(&hdlr.aborted) == 1 {
+			hdlr.CountDropped()
+			continue
+		}
+		hdlr.Handler.Emit(item.record)
+		hdlr.CountEmitted()
+	}
+}
+
+// enqueue sends item to the queue, returns false if the handler is closed
+// or ctx is done before there is room in the queue
+func (hdlr *AsyncHandler) enqueue(ctx context.Context, item asyncItem) bool {
+	hdlr.mu.RLock()
+	defer hdlr.mu.RUnlock()
+	if hdlr.closed {
+		return false
+	}
+	select {
+	case hdlr.queue <- item:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Emit queues the record
+func (hdlr *AsyncHandler) Emit(record *LogRecord) {
+	if record.Level < hdlr.ShedLevel && hdlr.overloaded() {
+		atomic.AddUint64(&hdlr.shed, 1)
+		return
+	}
+	// evaluate now, the values may change before the record is written
+	record.ResolveLazy()
+	if !hdlr.enqueue(context.Background(), asyncItem{record: record}) {
+		hdlr.CountDropped()
+	}
+}
+
+// overloaded checks if the handler is shedding, it starts shedding
+// at HighWatermark and stops at LowWatermark
+func (hdlr *AsyncHandler) overloaded() bool {
+	if hdlr.HighWatermark <= 0 {
+		return false
+	}
+	n := len(hdlr.queue)
+	switch {
+	case n >= hdlr.HighWatermark:
+		atomic.StoreUint32(&hdlr.shedding, 1)
+		return true
+	case n <= hdlr.LowWatermark:
+		atomic.StoreUint32(&hdlr.shedding, 0)
+		return false
+	}
+	return atomic.LoadUint32(&hdlr.shedding) == 1
+}
+
+// Shed returns the number of records shed under pressure
+func (hdlr *AsyncHandler) Shed() uint64 {
+	return atomic.LoadUint64(&hdlr.shed)
+}
+
+// Stats returns the statistics of the handler, Emitted is the number of
+// records passed to the wrapped handler, Dropped includes the shed ones.
+// Failed and LastError are taken from the wrapped handler if it is
+// a StatsReporter
+func (hdlr *AsyncHandler) Stats() EmitStats {
+	stats := hdlr.StatsCounter.Stats()
+	stats.Dropped += hdlr.Shed()
+	stats.QueueLength, stats.QueueCapacity = len(hdlr.queue), cap(hdlr.queue)
+	if inner, ok := hdlr.Handler.(StatsReporter); ok {
+		innerStats := inner.Stats()
+		stats.Failed = innerStats.Failed
+		stats.LastError, stats.LastErrorTime = innerStats.LastError, innerStats.LastErrorTime
+	}
+	return stats
+}
+
+// ShouldEmit checks if the wrapped handler should emit the specified record
+func (hdlr *AsyncHandler) ShouldEmit(record *LogRecord) bool {
+	return ShouldEmit(hdlr.Handler, record)
+}
+
+// Filter checks if handler should filter the specified record.
+// Deprecated: use ShouldEmit instead
+func (hdlr *AsyncHandler) Filter(record *LogRecord) bool {
+	return !hdlr.ShouldEmit(record)
+}
+
+// Flush waits until all records queued before it are emitted,
+// then flushes the wrapped handler
+func (hdlr *AsyncHandler) Flush() error {
+	return hdlr.FlushContext(context.Background())
+}
+
+// FlushContext is like Flush, but returns ctx.Err() without flushing
+// the wrapped handler if ctx is done before the queued records are
+// emitted, they are still emitted later
+func (hdlr *AsyncHandler) FlushContext(ctx context.Context) error {
+	flushed := make(chan struct{})
+	if hdlr.enqueue(ctx, asyncItem{flushed: flushed}) {
+		select {
+		case <-flushed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return hdlr.Handler.Flush()
+}
+
+// Close emits all queued records and closes the wrapped handler
+func (hdlr *AsyncHandler) Close() error {
+	return hdlr.CloseContext(context.Background())
+}
+
+// CloseContext is like Close, but if ctx is done before the queued records
+// are emitted, they are abandoned and a *CloseAbortedError is returned,
+// the wrapped handler is closed in background once the record being
+// emitted returns. Otherwise the wrapped handler is closed by CloseContext
+func (hdlr *AsyncHandler) CloseContext(ctx context.Context) error {
+	hdlr.mu.Lock()
+	if hdlr.closed {
+		hdlr.mu.Unlock()
+		return nil
+	}
+	hdlr.closed = true
+	close(hdlr.queue)
+	hdlr.mu.Unlock()
+
+	select {
+	case <-hdlr.done:
+		return CloseContext(ctx, hdlr.Handler)
+	case <-ctx.Done():
+	}
+	atomic.StoreUint32(&hdlr.aborted, 1)
+	abandoned := len(hdlr.queue)
+	go func() {
+		<-hdlr.done
+		if err := hdlr.Handler.Close(); err != nil {
+			InternalError(fmt.Errorf("Close handler failed, [%v]", err))
+		}
+	}()
+	return &CloseAbortedError{Handler: hdlr.Name, Abandoned: abandoned, Err: ctx.Err()}
+}
@@ -0,0 +1,160 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"context"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsyncHandler(t *testing.T) {
+	var out lockedBuffer
+	inner := NewWriterHandler("", &out, &TextFormatter{Fmt: "%(message)"}, InfoLevel)
+	hdlr := NewAsyncHandler(inner, 2)
+	assert.Implements(t, (*Handler)(nil), hdlr)
+
+	hdlr.Emit(NewLogRecord("test", DebugLevel, "a/b.go", "a.b", 1, "filtered"))
+	for _, msg := range []string{"1", "2", "3", "4"} {
+		hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, msg))
+	}
+	assert.Nil(t, hdlr.Flush())
+	assert.Equal(t, "1\n2\n3\n4\n", out.String())
+
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "5"))
+	assert.Nil(t, hdlr.Close())
+	assert.Equal(t, "1\n2\n3\n4\n5\n", out.String())
+
+	// emitting and flushing after Close do not block
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "dropped"))
+	assert.Nil(t, hdlr.Flush())
+	assert.Nil(t, hdlr.Close())
+	assert.False(t, strings.Contains(out.String(), "dropped"))
+}
+
+// gateHandler blocks Emit until open is closed
+type gateHandler struct {
+	recordHandler
+	open chan struct{}
+}
+
+func (hdlr *gateHandler) Emit(record *LogRecord) {
+	<-hdlr.open
+	hdlr.recordHandler.Emit(record)
+}
+
+func TestAsyncHandlerShedding(t *testing.T) {
+	inner := &gateHandler{open: make(chan struct{})}
+	hdlr := NewAsyncHandler(inner, 8)
+	hdlr.HighWatermark = 4
+	hdlr.LowWatermark = 1
+
+	emit := func(level Level, msg string) {
+		hdlr.Emit(NewLogRecord("test", level, "a/b.go", "a.b", 1, msg))
+	}
+	// the first record is taken by the loop which blocks in inner.Emit
+	emit(InfoLevel, "0")
+	for len(hdlr.queue) > 0 {
+		runtime.Gosched()
+	}
+	for i := 1; i <= 4; i++ {
+		emit(InfoLevel, strconv.Itoa(i))
+	}
+	// queue reaches the high watermark
+	emit(DebugLevel, "shed")
+	emit(InfoLevel, "shed")
+	emit(WarnLevel, "warn")
+	emit(ErrorLevel, "error")
+	assert.Equal(t, uint64(2), hdlr.Shed())
+
+	close(inner.open)
+	assert.Nil(t, hdlr.Flush())
+	// queue drained below the low watermark
+	emit(InfoLevel, "resumed")
+	assert.Nil(t, hdlr.Close())
+
+	var msgs []string
+	for _, r := range inner.records {
+		msgs = append(msgs, r.GetMessage())
+	}
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "warn", "error", "resumed"}, msgs)
+	assert.Equal(t, uint64(2), hdlr.Shed())
+}
+
+func TestAsyncHandlerFlushContext(t *testing.T) {
+	inner := &gateHandler{open: make(chan struct{})}
+	hdlr := NewAsyncHandler(inner, 1)
+	for _, msg := range []string{"1", "2"} {
+		hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, msg))
+	}
+
+	// the queue is full and the loop blocks in inner.Emit
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, hdlr.FlushContext(ctx))
+
+	// all records emitted before Flush are emitted after it returns
+	close(inner.open)
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "3"))
+	assert.Nil(t, hdlr.Flush())
+	assert.Len(t, inner.records, 3)
+	assert.Nil(t, hdlr.Close())
+}
+
+func TestAsyncHandlerCloseContext(t *testing.T) {
+	inner := &gateHandler{open: make(chan struct{})}
+	hdlr := NewAsyncHandler(inner, 4)
+	hdlr.Name = "async"
+	// the first record is taken by the loop which blocks in inner.Emit
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "0"))
+	for len(hdlr.queue) > 0 {
+		runtime.Gosched()
+	}
+	for _, msg := range []string{"1", "2"} {
+		hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, msg))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := hdlr.CloseContext(ctx)
+	aborted, ok := err.(*CloseAbortedError)
+	assert.True(t, ok)
+	assert.Equal(t, "async", aborted.Handler)
+	assert.Equal(t, 2, aborted.Abandoned)
+	assert.Equal(t, context.DeadlineExceeded, aborted.Err)
+	assert.Equal(t, "close async aborted, 2 records abandoned, [context deadline exceeded]", err.Error())
+
+	// the queued records are abandoned
+	close(inner.open)
+	<-hdlr.done
+	assert.Len(t, inner.records, 1)
+	assert.Nil(t, hdlr.Close())
+}
+
+func TestCloseContext(t *testing.T) {
+	hdlr := &closeCounter{block: make(chan struct{})}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := CloseContext(ctx, hdlr)
+	assert.Equal(t, "close handler aborted, unknown number of records abandoned, [context deadline exceeded]", err.Error())
+	close(hdlr.block)
+
+	assert.Nil(t, CloseContext(context.Background(), &closeCounter{}))
+}
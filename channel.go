@@ -0,0 +1,121 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ChannelHandler is a handler which sends a clone of every record to
+// Channel, so records can be consumed in another part of the process,
+// e.g. to drive a live UI. If DropOnFull is true, which is the default,
+// records are dropped when Channel is full so a slow consumer can not
+// back up logging, otherwise Emit blocks.
+// Channel is not closed by the handler, close it after Close returns,
+// records emitted after Close are dropped
+type ChannelHandler struct {
+	Name string
+	// Deprecated: Level should not be changed directly while the
+	// handler is in use, use SetLevel and GetLevel instead
+	Level      Level
+	Channel    chan<- *LogRecord
+	DropOnFull bool
+
+	dropped uint64
+	mu      sync.RWMutex
+	closed  bool
+	Filterer
+}
+
+// NewChannelHandler returns a new ChannelHandler sending records to ch
+func NewChannelHandler(ch chan<- *LogRecord, options ...Option) *ChannelHandler {
+	hdlr := &ChannelHandler{
+		Name:       "",
+		Level:      NothingLevel,
+		Channel:    ch,
+		DropOnFull: true,
+	}
+
+	applyOptions(hdlr, options...)
+
+	return hdlr
+}
+
+// Emit sends a clone of the log record to Channel
+func (hdlr *ChannelHandler) Emit(record *LogRecord) {
+	if record.Level < hdlr.GetLevel() {
+		return
+	}
+
+	hdlr.mu.RLock()
+	defer hdlr.mu.RUnlock()
+	if hdlr.closed {
+		atomic.AddUint64(&hdlr.dropped, 1)
+		return
+	}
+
+	clone := record.Clone()
+	if !hdlr.DropOnFull {
+		hdlr.Channel <- clone
+		return
+	}
+	select {
+	case hdlr.Channel <- clone:
+	default:
+		atomic.AddUint64(&hdlr.dropped, 1)
+	}
+}
+
+// Dropped returns the number of records dropped
+func (hdlr *ChannelHandler) Dropped() uint64 {
+	return atomic.LoadUint64(&hdlr.dropped)
+}
+
+// ShouldEmit checks if handler should emit the specified record
+func (hdlr *ChannelHandler) ShouldEmit(record *LogRecord) bool {
+	return record.Level >= hdlr.GetLevel() && hdlr.PassFilters(record)
+}
+
+// Filter checks if handler should filter the specified record.
+// Deprecated: use ShouldEmit instead
+func (hdlr *ChannelHandler) Filter(record *LogRecord) bool {
+	return !hdlr.ShouldEmit(record)
+}
+
+// SetLevel sets the handler's level, it is safe to be called
+// while the handler is in use
+func (hdlr *ChannelHandler) SetLevel(level Level) {
+	storeLevel(&hdlr.Level, level)
+}
+
+// GetLevel returns the handler's level
+func (hdlr *ChannelHandler) GetLevel() Level {
+	return loadLevel(&hdlr.Level)
+}
+
+// Flush does nothing
+func (hdlr *ChannelHandler) Flush() error {
+	return nil
+}
+
+// Close stops sending records, it waits for blocked sends,
+// so Channel can be closed after it returns
+func (hdlr *ChannelHandler) Close() error {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	hdlr.closed = true
+	return nil
+}
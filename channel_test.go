@@ -0,0 +1,67 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelHandler(t *testing.T) {
+	ch := make(chan *LogRecord, 2)
+	hdlr := NewChannelHandler(ch, InfoLevel)
+	assert.True(t, hdlr.DropOnFull)
+	logger := NewLogger(OptionHandlers(hdlr))
+
+	fields := Fields{"k": "v"}
+	logger.Debug("filtered")
+	logger.Info("first", fields)
+	logger.Info("second")
+	logger.Info("dropped")
+	assert.Equal(t, uint64(1), hdlr.Dropped())
+
+	first := <-ch
+	assert.Equal(t, "first", first.GetMessage())
+	// records are cloned
+	first.Fields["k"] = "changed"
+	assert.Equal(t, "v", fields["k"])
+	assert.Equal(t, "second", (<-ch).GetMessage())
+
+	assert.Nil(t, hdlr.Close())
+	close(ch)
+	// records emitted after Close are dropped instead of panicking
+	logger.Info("closed")
+	assert.Equal(t, uint64(2), hdlr.Dropped())
+}
+
+func TestChannelHandlerBlocking(t *testing.T) {
+	ch := make(chan *LogRecord)
+	hdlr := NewChannelHandler(ch)
+	hdlr.DropOnFull = false
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			hdlr.Emit(NewLogRecord("app", InfoLevel, "a/b.go", "a.b", 1, "blocked"))
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "blocked", (<-ch).GetMessage())
+	}
+	<-done
+	assert.Equal(t, uint64(0), hdlr.Dropped())
+}
@@ -0,0 +1,104 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock is the source of the current time
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc is an adapter to allow the use of ordinary functions as Clock
+type ClockFunc func() time.Time
+
+// Now calls f()
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// Ticker delivers ticks until it is stopped
+type Ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+// TickerClock is a Clock which also drives periodic work, e.g. batching
+// and flushing of handlers. Tickers of clocks not implementing it
+// follow the real time
+type TickerClock interface {
+	Clock
+	NewTicker(d time.Duration) Ticker
+}
+
+// SystemClock is the real clock reading time.Now()
+var SystemClock Clock = ClockFunc(time.Now)
+
+// realTicker is a Ticker of the real time
+type realTicker struct {
+	*time.Ticker
+}
+
+// Chan returns the channel of ticks
+func (t realTicker) Chan() <-chan time.Time {
+	return t.C
+}
+
+// clockHolder is stored in clock, atomic.Value requires the values
+// stored in it have the same concrete type
+type clockHolder struct {
+	Clock
+}
+
+// clock holds the current clock, it is read by every record creation,
+// so it is loaded without locking
+var clock atomic.Value
+
+func init() {
+	clock.Store(clockHolder{SystemClock})
+}
+
+// SetClock sets the clock used wherever logdog reads the current time,
+// e.g. record creation, rotation, rate limiting and periodic flushing,
+// so tests can inject a fake clock. nil means SystemClock.
+// Periodic work started before SetClock keeps its tickers
+func SetClock(c Clock) {
+	if c == nil {
+		c = SystemClock
+	}
+	clock.Store(clockHolder{c})
+}
+
+// loadClock returns the clock set by SetClock
+func loadClock() Clock {
+	return clock.Load().(clockHolder).Clock
+}
+
+// Now returns the current time of the clock set by SetClock
+func Now() time.Time {
+	return loadClock().Now()
+}
+
+// NewTicker returns a ticker of the clock set by SetClock,
+// which ticks in real time if the clock is not a TickerClock
+func NewTicker(d time.Duration) Ticker {
+	if c, ok := loadClock().(TickerClock); ok {
+		return c.NewTicker(d)
+	}
+	return realTicker{time.NewTicker(d)}
+}
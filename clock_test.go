@@ -0,0 +1,89 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetClock(t *testing.T) {
+	now := time.Date(2024, 5, 1, 3, 4, 5, 123000000, time.UTC)
+	SetClock(ClockFunc(func() time.Time { return now }))
+	defer SetClock(nil)
+
+	record := NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "msg")
+	assert.Equal(t, now, record.Time)
+
+	formatter := &TextFormatter{Fmt: "%(time) %(message)", Precision: MilliPrecision}
+	msg, err := formatter.Format(record)
+	assert.Nil(t, err)
+	assert.Equal(t, "2024-05-01 03:04:05.123 msg", msg)
+
+	SetClock(nil)
+	assert.NotEqual(t, now, Now())
+}
+
+// manualClock is a TickerClock whose tickers tick by Tick
+type manualClock struct {
+	now   time.Time
+	ticks chan time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	return c.now
+}
+
+func (c *manualClock) NewTicker(time.Duration) Ticker {
+	return c
+}
+
+func (c *manualClock) Chan() <-chan time.Time {
+	return c.ticks
+}
+
+func (c *manualClock) Stop() {}
+
+func TestNewTicker(t *testing.T) {
+	// tickers of a plain Clock follow the real time
+	SetClock(ClockFunc(time.Now))
+	ticker := NewTicker(time.Millisecond)
+	<-ticker.Chan()
+	ticker.Stop()
+
+	clock := &manualClock{ticks: make(chan time.Time, 1)}
+	SetClock(clock)
+	defer SetClock(nil)
+	assert.Equal(t, Ticker(clock), NewTicker(time.Hour))
+}
+
+func TestShardedHandlerFollowsClock(t *testing.T) {
+	clock := &manualClock{now: time.Now(), ticks: make(chan time.Time)}
+	SetClock(clock)
+	defer SetClock(nil)
+
+	var buf bytes.Buffer
+	hdlr := NewShardedHandler(&buf, &TextFormatter{Fmt: "%(message)"})
+	hdlr.FlushInterval = time.Hour
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "ticked"))
+	// the flusher flushes on the tick of the clock instead of an hour later
+	clock.ticks <- clock.now
+	clock.ticks <- clock.now
+	assert.Equal(t, "ticked\n", buf.String())
+	assert.Nil(t, hdlr.Close())
+}
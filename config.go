@@ -24,6 +24,14 @@ type ConfigLoader interface {
 	LoadConfig(map[string]interface{}) error
 }
 
+// handlerNotFoundError is returned by LoadConfig of handlers
+// referring to a handler which is not registered
+type handlerNotFoundError string
+
+func (e handlerNotFoundError) Error() string {
+	return "can not find handler: " + string(e)
+}
+
 // Config is a alias of map[string]interface{}
 type Config map[string]interface{}
 
@@ -84,13 +92,29 @@ func LoadJSONConfig(config []byte) error {
 	}
 
 	if logConfig.Handlers != nil {
-		for name, conf := range logConfig.Handlers {
-			temp, err := builder(name, conf)
-			if err != nil {
-				return err
+		// handlers may refer to others by name, e.g. LevelRouterHandler,
+		// so build them in passes until all referred handlers exist
+		pending := logConfig.Handlers
+		for len(pending) > 0 {
+			var notFound error
+			next := make(map[string]map[string]interface{})
+			for name, conf := range pending {
+				temp, err := builder(name, conf)
+				if _, ok := err.(handlerNotFoundError); ok {
+					notFound = err
+					next[name] = conf
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				handler := temp.(Handler)
+				RegisterHandler(name, handler)
+			}
+			if len(next) == len(pending) {
+				return notFound
 			}
-			handler := temp.(Handler)
-			RegisterHandler(name, handler)
+			pending = next
 		}
 	}
 
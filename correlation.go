@@ -0,0 +1,175 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultCorrelationHeader is the default http header
+	// carrying the correlation ID of a request
+	DefaultCorrelationHeader = "X-Request-ID"
+	// DefaultCorrelationField is the default field key
+	// of the correlation ID
+	DefaultCorrelationField = "request_id"
+	// maxCorrelationIDLen limits the length of incoming IDs
+	maxCorrelationIDLen = 128
+)
+
+// crockford is the Crockford base32 alphabet used by ULIDs,
+// it keeps the lexical order of the encoded bytes
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewCorrelationID returns a ULID-style ID, 26 characters of Crockford
+// base32 encoding a 48 bit millisecond timestamp and 80 random bits,
+// so IDs sort by the time they are created
+func NewCorrelationID() string {
+	var id [16]byte
+	ms := uint64(Now().UnixNano() / int64(time.Millisecond))
+	for i := 5; i >= 0; i-- {
+		id[i] = byte(ms)
+		ms >>= 8
+	}
+	if _, err := rand.Read(id[6:]); err != nil {
+		panic(err)
+	}
+
+	// 128 bits are encoded from the most significant 5 bits,
+	// the first character holds the leading 3 bits
+	var dst [26]byte
+	dst[0] = crockford[id[0]>>5]
+	acc, bits, j := uint64(id[0]&0x1f), uint(5), 1
+	for _, b := range id[1:] {
+		acc = acc<<8 | uint64(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			dst[j] = crockford[(acc>>bits)&0x1f]
+			j++
+		}
+	}
+	return string(dst[:])
+}
+
+type contextKey int
+
+const (
+	loggerContextKey contextKey = iota
+	correlationContextKey
+)
+
+// NewContext returns a copy of ctx carrying the logger
+func NewContext(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey, logger)
+}
+
+// FromContext returns the logger carried by ctx, or Default() if none
+func FromContext(ctx context.Context) *Logger {
+	if logger, ok := ctx.Value(loggerContextKey).(*Logger); ok && logger != nil {
+		return logger
+	}
+	return Default()
+}
+
+// ContextWithCorrelationID returns a copy of ctx carrying the correlation ID
+func ContextWithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationContextKey, id)
+}
+
+// CorrelationIDFromContext returns the correlation ID carried by ctx, or ""
+func CorrelationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(correlationContextKey).(string)
+	return id
+}
+
+// CorrelationMiddleware is an http middleware which reads the correlation
+// ID of requests from Header, or generates one if it is absent or invalid,
+// stores it in the request context and stamps it as Field on a logger
+// derived from Logger, which handlers get by FromContext
+type CorrelationMiddleware struct {
+	// Logger is the base logger, nil means Default()
+	Logger *Logger
+	// Header is the request header of the ID,
+	// "" means DefaultCorrelationHeader
+	Header string
+	// Field is the field key of the ID, "" means DefaultCorrelationField
+	Field string
+	// EchoHeader sets the ID in Header of responses
+	EchoHeader bool
+	// Generate generates IDs, nil means NewCorrelationID
+	Generate func() string
+}
+
+// NewCorrelationMiddleware returns a CorrelationMiddleware
+// deriving request loggers from logger
+func NewCorrelationMiddleware(logger *Logger) *CorrelationMiddleware {
+	return &CorrelationMiddleware{
+		Logger: logger,
+		Header: DefaultCorrelationHeader,
+		Field:  DefaultCorrelationField,
+	}
+}
+
+// Wrap returns an http.Handler which serves requests by next
+// with the correlation ID and the derived logger in their context
+func (m *CorrelationMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header, field := m.Header, m.Field
+		if header == "" {
+			header = DefaultCorrelationHeader
+		}
+		if field == "" {
+			field = DefaultCorrelationField
+		}
+
+		id := r.Header.Get(header)
+		if !validCorrelationID(id) {
+			if m.Generate != nil {
+				id = m.Generate()
+			} else {
+				id = NewCorrelationID()
+			}
+		}
+		if m.EchoHeader {
+			w.Header().Set(header, id)
+		}
+
+		logger := m.Logger
+		if logger == nil {
+			logger = Default()
+		}
+		ctx := ContextWithCorrelationID(r.Context(), id)
+		ctx = NewContext(ctx, logger.With(field, id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validCorrelationID checks if the incoming ID is safe to log
+// and echo, it must be short printable ASCII
+func validCorrelationID(id string) bool {
+	if id == "" || len(id) > maxCorrelationIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCorrelationID(t *testing.T) {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	var offset time.Duration
+	SetClock(ClockFunc(func() time.Time { return start.Add(offset) }))
+	defer SetClock(nil)
+
+	ids := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		offset = time.Duration(i) * time.Millisecond
+		ids = append(ids, NewCorrelationID())
+	}
+	// IDs sort by the time they are created
+	assert.True(t, sort.StringsAreSorted(ids))
+	for _, id := range ids {
+		assert.Len(t, id, 26)
+	}
+	assert.NotEqual(t, ids[0], NewCorrelationID())
+	// 2024-05-01 is 1714521600000 ms
+	assert.Equal(t, "01HWRQ6W00", ids[0][:10])
+}
+
+func TestFromContext(t *testing.T) {
+	assert.True(t, FromContext(context.Background()) == Default())
+	logger := NewLogger()
+	assert.True(t, FromContext(NewContext(context.Background(), logger)) == logger)
+	assert.Equal(t, "", CorrelationIDFromContext(context.Background()))
+	assert.Equal(t, "id", CorrelationIDFromContext(ContextWithCorrelationID(context.Background(), "id")))
+}
+
+func TestCorrelationMiddleware(t *testing.T) {
+	hdlr := &recordHandler{}
+	middleware := NewCorrelationMiddleware(NewLogger(OptionHandlers(hdlr)))
+	middleware.EchoHeader = true
+	middleware.Generate = func() string { return "generated" }
+
+	var ids []string
+	server := middleware.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids = append(ids, CorrelationIDFromContext(r.Context()))
+		FromContext(r.Context()).Info("handle")
+	}))
+
+	for _, id := range []string{"req-1", "", "bad\nid"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if id != "" {
+			r.Header.Set("X-Request-ID", id)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		assert.Equal(t, ids[len(ids)-1], w.Header().Get("X-Request-ID"))
+	}
+
+	// missing and unsafe IDs are replaced
+	assert.Equal(t, []string{"req-1", "generated", "generated"}, ids)
+	assert.Len(t, hdlr.records, 3)
+	assert.Equal(t, Fields{"request_id": "req-1"}, hdlr.records[0].Fields)
+	assert.Equal(t, Fields{"request_id": "generated"}, hdlr.records[1].Fields)
+}
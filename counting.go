@@ -0,0 +1,213 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CountingHandler is a handler which writes nothing but counts records
+// emitted per level, the total bytes they would be written with Formatter
+// the time of the last record whose level >= ErrorLevel and the highest
+// level seen.
+// All counters are updated atomically, it is useful for health checks
+// and tests, e.g. attach it alongside real handlers, or for CI jobs which
+// should fail if any error was logged, see ExitCode.
+type CountingHandler struct {
+	Name string
+	// Deprecated: Level should not be changed directly while the
+	// handler is in use, use SetLevel and GetLevel instead
+	Level Level
+	// Formatter is used to compute bytes, nil means not computing
+	Formatter Formatter
+	// ExitLevel is the min level which makes ExitCode non-zero,
+	// 0 means ErrorLevel
+	ExitLevel Level
+
+	counts        sync.Map // Level -> *uint64
+	total         uint64
+	lastErrorTime int64
+	highest       Level
+	Filterer
+}
+
+// CountingSnapshot is the counters of CountingHandler read at once
+type CountingSnapshot struct {
+	Counts        map[Level]uint64
+	Total         uint64
+	LastErrorTime time.Time
+	HighestLevel  Level
+}
+
+// NewCountingHandler returns a new CountingHandler fully initialized
+func NewCountingHandler(options ...Option) *CountingHandler {
+	hdlr := &CountingHandler{
+		Name:      "",
+		Level:     NothingLevel,
+		Formatter: DefaultFormatter,
+		ExitLevel: ErrorLevel,
+	}
+
+	applyOptions(hdlr, options...)
+
+	return hdlr
+}
+
+// Emit counts the log record
+func (hdlr *CountingHandler) Emit(record *LogRecord) {
+	if record.Level < hdlr.GetLevel() {
+		return
+	}
+
+	v, ok := hdlr.counts.Load(record.Level)
+	if !ok {
+		v, _ = hdlr.counts.LoadOrStore(record.Level, new(uint64))
+	}
+	atomic.AddUint64(v.(*uint64), 1)
+
+	if record.Level >= ErrorLevel {
+		atomic.StoreInt64(&hdlr.lastErrorTime, record.Time.UnixNano())
+	}
+
+	for {
+		highest := loadLevel(&hdlr.highest)
+		if record.Level <= highest || atomic.CompareAndSwapInt32((*int32)(&hdlr.highest), int32(highest), int32(record.Level)) {
+			break
+		}
+	}
+
+	if hdlr.Formatter != nil {
+		msg, err := hdlr.Formatter.Format(record)
+		if err != nil {
+			InternalError(fmt.Errorf("Format record failed, [%v]", err))
+			return
+		}
+		// with the trailing newline
+		atomic.AddUint64(&hdlr.total, uint64(len(msg)+1))
+	}
+}
+
+// Count returns the number of records emitted with the level
+func (hdlr *CountingHandler) Count(level Level) uint64 {
+	if v, ok := hdlr.counts.Load(level); ok {
+		return atomic.LoadUint64(v.(*uint64))
+	}
+	return 0
+}
+
+// Total returns the total bytes of records emitted
+func (hdlr *CountingHandler) Total() uint64 {
+	return atomic.LoadUint64(&hdlr.total)
+}
+
+// LastErrorTime returns the time of the last record whose
+// level >= ErrorLevel, zero time if there is none
+func (hdlr *CountingHandler) LastErrorTime() time.Time {
+	nano := atomic.LoadInt64(&hdlr.lastErrorTime)
+	if nano == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nano)
+}
+
+// Counts returns the number of records emitted per level,
+// levels without records are omitted
+func (hdlr *CountingHandler) Counts() map[Level]uint64 {
+	counts := make(map[Level]uint64)
+	hdlr.counts.Range(func(k, v interface{}) bool {
+		if n := atomic.LoadUint64(v.(*uint64)); n > 0 {
+			counts[k.(Level)] = n
+		}
+		return true
+	})
+	return counts
+}
+
+// HighestLevel returns the highest level of records emitted,
+// NothingLevel if there is none
+func (hdlr *CountingHandler) HighestLevel() Level {
+	return loadLevel(&hdlr.highest)
+}
+
+// ExitCode returns 1 if any record whose level >= ExitLevel was emitted,
+// otherwise 0. e.g. os.Exit(counter.ExitCode()) at the end of main
+// fails a CI job which logged errors even if it finished normally
+func (hdlr *CountingHandler) ExitCode() int {
+	level := hdlr.ExitLevel
+	if level == NothingLevel {
+		level = ErrorLevel
+	}
+	if hdlr.HighestLevel() >= level {
+		return 1
+	}
+	return 0
+}
+
+// Snapshot returns all counters,
+// records emitted meanwhile may be partially counted
+func (hdlr *CountingHandler) Snapshot() CountingSnapshot {
+	return CountingSnapshot{
+		Counts:        hdlr.Counts(),
+		Total:         hdlr.Total(),
+		LastErrorTime: hdlr.LastErrorTime(),
+		HighestLevel:  hdlr.HighestLevel(),
+	}
+}
+
+// Reset sets all counters to zero
+func (hdlr *CountingHandler) Reset() {
+	hdlr.counts.Range(func(k, v interface{}) bool {
+		atomic.StoreUint64(v.(*uint64), 0)
+		return true
+	})
+	atomic.StoreUint64(&hdlr.total, 0)
+	atomic.StoreInt64(&hdlr.lastErrorTime, 0)
+	storeLevel(&hdlr.highest, NothingLevel)
+}
+
+// ShouldEmit checks if handler should emit the specified record
+func (hdlr *CountingHandler) ShouldEmit(record *LogRecord) bool {
+	return record.Level >= hdlr.GetLevel() && hdlr.PassFilters(record)
+}
+
+// Filter checks if handler should filter the specified record.
+// Deprecated: use ShouldEmit instead
+func (hdlr *CountingHandler) Filter(record *LogRecord) bool {
+	return !hdlr.ShouldEmit(record)
+}
+
+// SetLevel sets the handler's level, it is safe to be called
+// while the handler is in use
+func (hdlr *CountingHandler) SetLevel(level Level) {
+	storeLevel(&hdlr.Level, level)
+}
+
+// GetLevel returns the handler's level
+func (hdlr *CountingHandler) GetLevel() Level {
+	return loadLevel(&hdlr.Level)
+}
+
+// Flush does nothing
+func (hdlr *CountingHandler) Flush() error {
+	return nil
+}
+
+// Close does nothing
+func (hdlr *CountingHandler) Close() error {
+	return nil
+}
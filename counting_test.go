@@ -0,0 +1,90 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountingHandler(t *testing.T) {
+	hdlr := NewCountingHandler(&TextFormatter{Fmt: "%(message)"}, InfoLevel)
+	assert.Implements(t, (*Handler)(nil), hdlr)
+
+	hdlr.Emit(NewLogRecord("test", DebugLevel, "a/b.go", "a.b", 1, "filtered"))
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "info"))
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "info"))
+	assert.True(t, hdlr.LastErrorTime().IsZero())
+
+	record := NewLogRecord("test", ErrorLevel, "a/b.go", "a.b", 1, "error")
+	hdlr.Emit(record)
+
+	assert.Equal(t, uint64(0), hdlr.Count(DebugLevel))
+	assert.Equal(t, uint64(2), hdlr.Count(InfoLevel))
+	assert.Equal(t, uint64(1), hdlr.Count(ErrorLevel))
+	assert.Equal(t, uint64(len("info\ninfo\nerror\n")), hdlr.Total())
+	assert.True(t, record.Time.Equal(hdlr.LastErrorTime()))
+
+	snapshot := hdlr.Snapshot()
+	assert.Equal(t, map[Level]uint64{InfoLevel: 2, ErrorLevel: 1}, snapshot.Counts)
+	assert.Equal(t, hdlr.Total(), snapshot.Total)
+	assert.Equal(t, hdlr.LastErrorTime(), snapshot.LastErrorTime)
+
+	assert.Equal(t, ErrorLevel, snapshot.HighestLevel)
+
+	hdlr.Reset()
+	assert.Equal(t, CountingSnapshot{Counts: map[Level]uint64{}}, hdlr.Snapshot())
+}
+
+func TestCountingHandlerExitCode(t *testing.T) {
+	hdlr := NewCountingHandler()
+	assert.Equal(t, NothingLevel, hdlr.HighestLevel())
+	assert.Equal(t, 0, hdlr.ExitCode())
+
+	hdlr.Emit(NewLogRecord("test", WarnLevel, "a/b.go", "a.b", 1, "warn"))
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "info"))
+	assert.Equal(t, WarnLevel, hdlr.HighestLevel())
+	assert.Equal(t, map[Level]uint64{InfoLevel: 1, WarnLevel: 1}, hdlr.Counts())
+	assert.Equal(t, 0, hdlr.ExitCode())
+
+	hdlr.Emit(NewLogRecord("test", ErrorLevel, "a/b.go", "a.b", 1, "error"))
+	assert.Equal(t, ErrorLevel, hdlr.HighestLevel())
+	assert.Equal(t, 1, hdlr.ExitCode())
+
+	hdlr.ExitLevel = FatalLevel
+	assert.Equal(t, 0, hdlr.ExitCode())
+}
+
+func TestCountingHandlerConcurrent(t *testing.T) {
+	hdlr := NewCountingHandler()
+	hdlr.Formatter = nil
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				hdlr.Emit(NewLogRecord("test", WarnLevel, "a/b.go", "a.b", 1, "warn"))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, uint64(800), hdlr.Count(WarnLevel))
+	assert.Equal(t, uint64(0), hdlr.Total())
+	assert.True(t, time.Time{}.Equal(hdlr.LastErrorTime()))
+}
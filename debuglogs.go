@@ -0,0 +1,338 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// DefaultDebugLogsCapacity is the default number of recent records
+	// kept by DebugLogsHandler
+	DefaultDebugLogsCapacity = 1024
+	// DefaultDebugLogsLimit is the default number of records returned
+	// by DebugLogsHandler if the n parameter is absent
+	DefaultDebugLogsLimit = 100
+	// DefaultDebugLogsStreamQueueSize is the default number of records
+	// queued for a stream connection
+	DefaultDebugLogsStreamQueueSize = 256
+)
+
+// DebugLogsHandler is a handler which keeps the most recent records in a
+// ring buffer, and an http.Handler which serves them, e.g. mounted on a
+// debug mux next to pprof:
+//   mux.Handle("/debug/logs", hdlr)
+//   mux.Handle("/debug/logs/stream", hdlr)
+// GET /debug/logs returns the most recent matching records as JSON, or as
+// text if the client accepts text/plain but not application/json.
+// GET on a path ending with /stream sends new matching records as
+// Server-Sent Events, records are dropped if the queue of a connection
+// is full so a slow client can not back up logging.
+// Records are matched by the query parameters:
+//   level=WARN       records >= the level, parsed by ParseLevel
+//   logger=app.db    logger names matching the pattern like NameRule
+//   field=user:jim   records whose field user is jim, may be repeated
+//   n=200            the max number of records returned, 0 means all
+//   format=text      overrides the negotiated format, text or json
+type DebugLogsHandler struct {
+	Name string
+	// Ring keeps the recent records, it never flushes
+	Ring *MemoryHandler
+	// JSONFormatter and TextFormatter render records,
+	// nil means NewJSONFormatter() and NewTextFormatter()
+	JSONFormatter Formatter
+	TextFormatter Formatter
+	// StreamQueueSize is the number of records queued for
+	// a stream connection, it should be set before use
+	StreamQueueSize int
+
+	dropped uint64
+	mu      sync.RWMutex
+	streams map[chan *LogRecord]*debugLogsQuery
+	closed  bool
+	LevelFilterer
+}
+
+// NewDebugLogsHandler returns a new DebugLogsHandler keeping at most
+// capacity records, capacity <= 0 means DefaultDebugLogsCapacity
+func NewDebugLogsHandler(capacity int, options ...Option) *DebugLogsHandler {
+	if capacity <= 0 {
+		capacity = DefaultDebugLogsCapacity
+	}
+	hdlr := &DebugLogsHandler{
+		Name:            "",
+		Ring:            NewMemoryHandler(NewNullHandler(), capacity, AllLevel, OverflowDropOldest),
+		StreamQueueSize: DefaultDebugLogsStreamQueueSize,
+		streams:         make(map[chan *LogRecord]*debugLogsQuery),
+	}
+
+	applyOptions(hdlr, options...)
+
+	return hdlr
+}
+
+// Emit keeps the record in Ring and sends a clone of it
+// to every stream it matches
+func (hdlr *DebugLogsHandler) Emit(record *LogRecord) {
+	if record.Level < hdlr.GetLevel() {
+		return
+	}
+
+	hdlr.mu.RLock()
+	defer hdlr.mu.RUnlock()
+	if hdlr.closed {
+		return
+	}
+	hdlr.Ring.Emit(record.Clone())
+	for stream, q := range hdlr.streams {
+		if !q.match(record) {
+			continue
+		}
+		select {
+		case stream <- record.Clone():
+		default:
+			atomic.AddUint64(&hdlr.dropped, 1)
+		}
+	}
+}
+
+// Dropped returns the number of records dropped because
+// the queue of a stream connection is full
+func (hdlr *DebugLogsHandler) Dropped() uint64 {
+	return atomic.LoadUint64(&hdlr.dropped)
+}
+
+// Flush does nothing
+func (hdlr *DebugLogsHandler) Flush() error {
+	return nil
+}
+
+// Close stops keeping records and ends all streams
+func (hdlr *DebugLogsHandler) Close() error {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	if hdlr.closed {
+		return nil
+	}
+	hdlr.closed = true
+	for stream := range hdlr.streams {
+		close(stream)
+		delete(hdlr.streams, stream)
+	}
+	return nil
+}
+
+// debugLogsQuery is the parsed query parameters of a request
+type debugLogsQuery struct {
+	level  Level
+	logger string
+	fields [][2]string
+	limit  int
+	text   bool
+}
+
+// match checks if the record matches the query
+func (q *debugLogsQuery) match(record *LogRecord) bool {
+	if record.Level < q.level {
+		return false
+	}
+	if q.logger != "" && !matchName(q.logger, record.Name) {
+		return false
+	}
+	for _, field := range q.fields {
+		v, ok := record.Fields[field[0]]
+		if !ok || fmt.Sprint(v) != field[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseQuery parses the query parameters of the request
+func (hdlr *DebugLogsHandler) parseQuery(r *http.Request) (*debugLogsQuery, error) {
+	values := r.URL.Query()
+	q := &debugLogsQuery{limit: DefaultDebugLogsLimit}
+	if level := values.Get("level"); level != "" {
+		l, err := ParseLevel(level)
+		if err != nil {
+			return nil, err
+		}
+		q.level = l
+	}
+	q.logger = values.Get("logger")
+	for _, field := range values["field"] {
+		kv := strings.SplitN(field, ":", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid field %q, it should be key:value", field)
+		}
+		q.fields = append(q.fields, [2]string{kv[0], kv[1]})
+	}
+	if n := values.Get("n"); n != "" {
+		limit, err := strconv.Atoi(n)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid n %q", n)
+		}
+		q.limit = limit
+	}
+	switch format := values.Get("format"); format {
+	case "text":
+		q.text = true
+	case "json":
+	case "":
+		accept := r.Header.Get("Accept")
+		q.text = strings.Contains(accept, "text/plain") && !strings.Contains(accept, "application/json")
+	default:
+		return nil, fmt.Errorf("invalid format %q, it should be text or json", format)
+	}
+	return q, nil
+}
+
+// formatter returns the formatter of the query
+func (hdlr *DebugLogsHandler) formatter(q *debugLogsQuery) Formatter {
+	if q.text {
+		if hdlr.TextFormatter != nil {
+			return hdlr.TextFormatter
+		}
+		return NewTextFormatter()
+	}
+	if hdlr.JSONFormatter != nil {
+		return hdlr.JSONFormatter
+	}
+	return NewJSONFormatter()
+}
+
+// ServeHTTP serves the recent records, or streams new records
+// if the path ends with /stream
+func (hdlr *DebugLogsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := hdlr.parseQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/stream") {
+		hdlr.serveStream(w, r, q)
+		return
+	}
+	hdlr.serveRecent(w, q)
+}
+
+// serveRecent writes the most recent records matching the query
+func (hdlr *DebugLogsHandler) serveRecent(w http.ResponseWriter, q *debugLogsQuery) {
+	var records []*LogRecord
+	for _, record := range hdlr.Ring.Snapshot() {
+		if q.match(record) {
+			records = append(records, record)
+		}
+	}
+	if q.limit > 0 && len(records) > q.limit {
+		records = records[len(records)-q.limit:]
+	}
+
+	formatter := hdlr.formatter(q)
+	lines := make([]string, 0, len(records))
+	for _, record := range records {
+		line, err := formatter.Format(record)
+		if err != nil {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	if q.text {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+		return
+	}
+	// lines are json objects already
+	w.Header().Set("Content-Type", "application/json")
+	items := make([]json.RawMessage, len(lines))
+	for i, line := range lines {
+		items[i] = json.RawMessage(line)
+	}
+	if err := json.NewEncoder(w).Encode(items); err != nil {
+		InternalError(fmt.Errorf("Write debug logs failed, [%v]", err))
+	}
+}
+
+// serveStream sends new records matching the query as
+// Server-Sent Events until the client goes away or Close is called
+func (hdlr *DebugLogsHandler) serveStream(w http.ResponseWriter, r *http.Request, q *debugLogsQuery) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	size := hdlr.StreamQueueSize
+	if size <= 0 {
+		size = DefaultDebugLogsStreamQueueSize
+	}
+	stream := make(chan *LogRecord, size)
+	hdlr.mu.Lock()
+	if hdlr.closed {
+		hdlr.mu.Unlock()
+		http.Error(w, "handler closed", http.StatusServiceUnavailable)
+		return
+	}
+	hdlr.streams[stream] = q
+	hdlr.mu.Unlock()
+	defer func() {
+		hdlr.mu.Lock()
+		if _, ok := hdlr.streams[stream]; ok {
+			delete(hdlr.streams, stream)
+		}
+		hdlr.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	formatter := hdlr.formatter(q)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case record, ok := <-stream:
+			if !ok {
+				return
+			}
+			line, err := formatter.Format(record)
+			if err != nil {
+				continue
+			}
+			// a line of data per line of the record
+			for _, data := range strings.Split(line, "\n") {
+				fmt.Fprintf(w, "data: %s\n", data)
+			}
+			fmt.Fprint(w, "\n")
+			flusher.Flush()
+		}
+	}
+}
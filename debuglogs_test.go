@@ -0,0 +1,129 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebugLogsHandler(t *testing.T) {
+	hdlr := NewDebugLogsHandler(3)
+	hdlr.TextFormatter = &TextFormatter{Fmt: "%(name) %(message)"}
+	assert.Implements(t, (*Handler)(nil), hdlr)
+	assert.Implements(t, (*http.Handler)(nil), hdlr)
+	server := httptest.NewServer(hdlr)
+	defer server.Close()
+
+	emit := func(name string, level Level, msg string, fields Fields) {
+		record := NewLogRecord(name, level, "a/b.go", "a.b", 1, msg)
+		record.Fields = fields
+		hdlr.Emit(record)
+	}
+	emit("app", InfoLevel, "dropped", nil)
+	emit("app.db", WarnLevel, "slow query", Fields{"table": "users"})
+	emit("app.http", ErrorLevel, "bad gateway", nil)
+	emit("app.db", ErrorLevel, "lost connection", Fields{"table": "orders"})
+
+	get := func(query, accept string) (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/debug/logs"+query, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// the oldest record is dropped by the ring buffer
+	_, body := get("", "")
+	var records []map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(body), &records))
+	assert.Len(t, records, 3)
+	assert.Equal(t, "slow query", records[0]["message"])
+
+	_, body = get("?logger=app.db&n=1", "text/plain")
+	assert.Equal(t, "app.db lost connection | table=orders\n", body)
+	_, body = get("?level=warning&field=table:users&format=text", "")
+	assert.Equal(t, "app.db slow query | table=users\n", body)
+	_, body = get("?level=error", "text/plain")
+	assert.Equal(t, "app.http bad gateway\napp.db lost connection | table=orders\n", body)
+
+	code, _ := get("?level=loud", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = get("?field=table", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestDebugLogsHandlerStream(t *testing.T) {
+	hdlr := NewDebugLogsHandler(0)
+	hdlr.TextFormatter = &TextFormatter{Fmt: "%(message)"}
+	server := httptest.NewServer(hdlr)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/debug/logs/stream?level=WARN&format=text")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// wait for the stream to be registered
+	for {
+		hdlr.mu.RLock()
+		n := len(hdlr.streams)
+		hdlr.mu.RUnlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	hdlr.Emit(NewLogRecord("app", InfoLevel, "a/b.go", "a.b", 1, "filtered"))
+	hdlr.Emit(NewLogRecord("app", WarnLevel, "a/b.go", "a.b", 1, "disk low"))
+	hdlr.Emit(NewLogRecord("app", ErrorLevel, "a/b.go", "a.b", 1, "disk full"))
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 4 {
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	assert.Equal(t, []string{"data: disk low", "", "data: disk full", ""}, lines)
+
+	// Close ends the stream
+	assert.Nil(t, hdlr.Close())
+	_, err = reader.ReadString('\n')
+	assert.NotNil(t, err)
+}
+
+func TestDebugLogsHandlerSlowStream(t *testing.T) {
+	hdlr := NewDebugLogsHandler(0)
+	stream := make(chan *LogRecord, 1)
+	hdlr.streams[stream] = &debugLogsQuery{}
+	for i := 0; i < 3; i++ {
+		hdlr.Emit(NewLogRecord("app", InfoLevel, "a/b.go", "a.b", 1, "msg"))
+	}
+	assert.Equal(t, uint64(2), hdlr.Dropped())
+	assert.Equal(t, 3, hdlr.Ring.Len())
+}
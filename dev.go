@@ -0,0 +1,198 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zoumo/logdog/pkg/pythonic"
+)
+
+// devIndent is the indentation of each nested level of DevFormatter
+const devIndent = "  "
+
+// DevFormatter renders the structured data of JSONFormatter as an
+// indented block for development consoles. The time, level and message
+// are on the first line, the logger name, caller, event and fields are
+// aligned underneath, nested fields are indented, errors are expanded
+// to their chain by errors.Unwrap and multi-line strings, e.g. stack
+// traces, are expanded line by line.
+// Colors respect the same TTY and NO_COLOR detection as TerminalFormatter
+type DevFormatter struct {
+	DateFmt      string
+	EnableColors bool
+	ConfigLoader
+}
+
+// NewDevFormatter returns a DevFormatter with default config
+func NewDevFormatter() *DevFormatter {
+	return &DevFormatter{
+		DateFmt:      DefaultDateFmtTemplate,
+		EnableColors: true,
+	}
+}
+
+// LoadConfig loads config from its input and
+// stores it in the value pointed to by c
+func (df *DevFormatter) LoadConfig(c map[string]interface{}) error {
+	config, err := pythonic.DictReflect(c)
+	if err != nil {
+		return err
+	}
+
+	df.DateFmt = config.MustGetString("datefmt", DefaultDateFmtTemplate)
+	df.EnableColors = config.MustGetBool("enableColors", true)
+	return nil
+}
+
+// Format converts the specified record to an indented block
+func (df *DevFormatter) Format(record *LogRecord) (string, error) {
+	text, err := df.AppendFormat(nil, record)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+// devEntry is a key and value rendered by DevFormatter
+type devEntry struct {
+	key   string
+	value interface{}
+}
+
+// AppendFormat appends the record converted to an indented block to dst
+func (df *DevFormatter) AppendFormat(dst []byte, record *LogRecord) ([]byte, error) {
+	color, keyColor, endColor := "", "", ""
+	if colorEnabled(df.EnableColors) {
+		color, endColor = colorHash(record.Level)
+		keyColor = colorCode(darkGreen)
+	}
+
+	dst = AppendTimePrecision(dst, record, df.DateFmt, SecondPrecision)
+	dst = append(dst, ' ')
+	dst = append(dst, color...)
+	dst = appendPadLeft(dst, record.LevelName, 6)
+	dst = append(dst, endColor...)
+	dst = append(dst, ' ')
+	dst = record.AppendMessage(dst)
+
+	entries := make([]devEntry, 0, len(record.Fields)+3)
+	if record.Name != "" {
+		entries = append(entries, devEntry{"logger", record.Name})
+	}
+	if record.Line > 0 {
+		entries = append(entries, devEntry{"caller", fmt.Sprintf("%s:%d", record.FileName, record.Line)})
+	}
+	if record.Event != "" {
+		entries = append(entries, devEntry{"event", record.Event})
+	}
+	entries = append(entries, sortedEntries(record.Fields)...)
+	return appendDevEntries(dst, entries, 1, keyColor, endColor), nil
+}
+
+// sortedEntries returns the entries of the map sorted by key
+func sortedEntries(m map[string]interface{}) []devEntry {
+	entries := make([]devEntry, 0, len(m))
+	for k, v := range m {
+		entries = append(entries, devEntry{k, v})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	return entries
+}
+
+// appendDevEntries appends every entry on a new line at the depth,
+// values are aligned by the longest key
+func appendDevEntries(dst []byte, entries []devEntry, depth int, keyColor, endColor string) []byte {
+	width := 0
+	for _, e := range entries {
+		if len(e.key) > width {
+			width = len(e.key)
+		}
+	}
+	indent := strings.Repeat(devIndent, depth)
+	for _, e := range entries {
+		dst = append(dst, '\n')
+		dst = append(dst, indent...)
+		dst = append(dst, keyColor...)
+		dst = append(dst, e.key...)
+		dst = append(dst, ':')
+		dst = append(dst, endColor...)
+		dst = appendDevValue(dst, e.value, width-len(e.key)+1, depth, keyColor, endColor)
+	}
+	return dst
+}
+
+// appendDevValue appends the value after its key, scalars are placed
+// after pad spaces, maps, errors and multi-line strings are expanded
+// on the following lines
+func appendDevValue(dst []byte, value interface{}, pad, depth int, keyColor, endColor string) []byte {
+	indent := strings.Repeat(devIndent, depth+1)
+	switch v := value.(type) {
+	case LazyValue:
+		return appendDevValue(dst, v(), pad, depth, keyColor, endColor)
+	case Fields:
+		return appendDevEntries(dst, sortedEntries(v), depth+1, keyColor, endColor)
+	case map[string]interface{}:
+		return appendDevEntries(dst, sortedEntries(v), depth+1, keyColor, endColor)
+	case error:
+		dst = appendPad(dst, pad)
+		dst = append(dst, v.Error()...)
+		for err := errors.Unwrap(v); err != nil; err = errors.Unwrap(err) {
+			dst = append(dst, '\n')
+			dst = append(dst, indent...)
+			dst = append(dst, "caused by: "...)
+			dst = append(dst, err.Error()...)
+		}
+		return dst
+	case time.Duration, HumanReadable:
+		return append(appendPad(dst, pad), FieldText(v)...)
+	case string:
+		if !strings.Contains(v, "\n") {
+			return append(appendPad(dst, pad), v...)
+		}
+		for _, line := range strings.Split(strings.TrimRight(v, "\n"), "\n") {
+			dst = append(dst, '\n')
+			dst = append(dst, indent...)
+			dst = append(dst, line...)
+		}
+		return dst
+	}
+
+	dst = appendPad(dst, pad)
+	if b, err := safeMarshal(value); err == nil {
+		return append(dst, b...)
+	}
+	return append(dst, FieldText(value)...)
+}
+
+func appendPad(dst []byte, n int) []byte {
+	for i := 0; i < n; i++ {
+		dst = append(dst, ' ')
+	}
+	return dst
+}
+
+func init() {
+	RegisterConstructor("DevFormatter", func() ConfigLoader {
+		return NewDevFormatter()
+	})
+	RegisterFormatter("dev", NewDevFormatter())
+}
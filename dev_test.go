@@ -0,0 +1,82 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newDevRecord() *LogRecord {
+	cause := errors.New("connection refused")
+	err := fmt.Errorf("query users: %w", fmt.Errorf("dial db: %w", cause))
+	record := NewLogRecord("app", ErrorLevel, "pkg/a/b.go", "pkg/a.Func", 7, "request %s failed", "42", Event("req.failed"), Fields{
+		"status": 500,
+		"user": Fields{
+			"id":   1,
+			"name": "jim",
+			"tags": []string{"admin"},
+			"address": map[string]interface{}{
+				"city": "Hangzhou",
+			},
+		},
+		"error": err,
+		"stack": "goroutine 1 [running]:\nmain.main()\n\t/app/main.go:12\n",
+	})
+	record.Time = time.Date(2024, 5, 1, 3, 4, 5, 0, time.UTC)
+	return record
+}
+
+func TestDevFormatter(t *testing.T) {
+	formatter := NewDevFormatter()
+	assert.Implements(t, (*Formatter)(nil), formatter)
+	assert.Implements(t, (*ConfigLoader)(nil), formatter)
+
+	msg, err := formatter.Format(newDevRecord())
+	assert.Nil(t, err)
+	assert.Equal(t, `2024-05-01 03:04:05  ERROR request 42 failed
+  logger: app
+  caller: b.go:7
+  event:  req.failed
+  error:  query users: dial db: connection refused
+    caused by: dial db: connection refused
+    caused by: connection refused
+  stack:
+    goroutine 1 [running]:
+    main.main()
+    	/app/main.go:12
+  status: 500
+  user:
+    address:
+      city: Hangzhou
+    id:      1
+    name:    jim
+    tags:    ["admin"]`, msg)
+}
+
+func TestDevFormatterColor(t *testing.T) {
+	ForceColor = true
+	defer func() { ForceColor = false }()
+
+	record := NewLogRecord("", InfoLevel, "b.go", "pkg/a.Func", 0, "hello", Fields{"k": "v"})
+	record.Time = time.Date(2024, 5, 1, 3, 4, 5, 0, time.UTC)
+	msg, err := NewDevFormatter().Format(record)
+	assert.Nil(t, err)
+	assert.Equal(t, "2024-05-01 03:04:05 \033[32m  INFO\033[0m hello\n  \033[36mk:\033[0m v", msg)
+}
@@ -0,0 +1,133 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zoumo/logdog/pkg/pythonic"
+)
+
+// ECSVersion is the version of Elastic Common Schema ECSFormatter follows
+const ECSVersion = "1.12.0"
+
+// ECSFormatter converts LogRecord to Elastic Common Schema json, e.g.
+// {"@timestamp":"...","ecs":{"version":"1.12.0"},"log":{"level":"info"},"message":"..."}
+// so logs can be shipped to Elasticsearch without an ingest pipeline.
+// Fields with dotted keys are nested into objects, e.g. http.request.method,
+// record attributes override fields with the same key
+type ECSFormatter struct {
+	// ServiceName is rendered as service.name, omitted if it is empty
+	ServiceName string
+	ConfigLoader
+}
+
+// NewECSFormatter returns an ECSFormatter with the service name
+func NewECSFormatter(serviceName string) *ECSFormatter {
+	return &ECSFormatter{
+		ServiceName: serviceName,
+	}
+}
+
+// LoadConfig loads config from its input and
+// stores it in the value pointed to by c
+func (ef *ECSFormatter) LoadConfig(c map[string]interface{}) error {
+	config, err := pythonic.DictReflect(c)
+	if err != nil {
+		return err
+	}
+
+	ef.ServiceName = config.MustGetString("serviceName", "")
+	return nil
+}
+
+// Format converts the specified record to ECS json string.
+func (ef *ECSFormatter) Format(record *LogRecord) (string, error) {
+	jsonBytes, err := ef.AppendFormat(nil, record)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
+// AppendFormat appends the record converted to ECS json to dst
+func (ef *ECSFormatter) AppendFormat(dst []byte, record *LogRecord) ([]byte, error) {
+	jsonBytes, err := safeMarshal(ef.data(record, record.Fields))
+	if err != nil && len(record.Fields) > 0 {
+		// retry with the fields which can not be marshaled replaced
+		jsonBytes, err = safeMarshal(ef.data(record, JSONSafeFields(record.Fields)))
+	}
+	if err != nil {
+		return dst, fmt.Errorf("Marashal fields to Json failed, [%v]", err)
+	}
+
+	return append(dst, jsonBytes...), nil
+}
+
+// data returns the ECS object of the record with the given fields
+func (ef *ECSFormatter) data(record *LogRecord, fields Fields) map[string]interface{} {
+	data := make(map[string]interface{})
+
+	// set fields in order, so the result does not depend on map iteration
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		setDotted(data, k, fields[k])
+	}
+
+	setDotted(data, "@timestamp", record.Time.UTC().Format("2006-01-02T15:04:05.000Z07:00"))
+	setDotted(data, "ecs.version", ECSVersion)
+	setDotted(data, "message", record.GetMessage())
+	setDotted(data, "log.level", strings.ToLower(record.LevelName))
+	if record.Name != "" {
+		setDotted(data, "log.logger", record.Name)
+	}
+	setDotted(data, "log.origin.file.name", record.FileName)
+	setDotted(data, "log.origin.file.line", record.Line)
+	setDotted(data, "log.origin.function", record.FuncName)
+	if record.Event != "" {
+		setDotted(data, "event.action", record.Event)
+	}
+	if ef.ServiceName != "" {
+		setDotted(data, "service.name", ef.ServiceName)
+	}
+	return data
+}
+
+// setDotted sets value in data by the dotted key, e.g. a.b sets
+// data["a"]["b"], a non-object value on the path is replaced by an object
+func setDotted(data map[string]interface{}, key string, value interface{}) {
+	parts := strings.Split(key, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := data[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			data[part] = next
+		}
+		data = next
+	}
+	data[parts[len(parts)-1]] = value
+}
+
+func init() {
+	RegisterConstructor("ECSFormatter", func() ConfigLoader {
+		return &ECSFormatter{}
+	})
+}
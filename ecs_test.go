@@ -0,0 +1,75 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestECSFormatter(t *testing.T) {
+	formatter := NewECSFormatter("checkout")
+	assert.Implements(t, (*Formatter)(nil), formatter)
+	assert.Implements(t, (*ConfigLoader)(nil), formatter)
+
+	record := NewLogRecord("app", ErrorLevel, "pkg/a/b.go", "pkg/a.Func", 7, "paid %d", 3, Event("order.paid"), Fields{
+		"http.request.method": "POST",
+		"http.response":       "ignored",
+		"http.response.code":  200,
+		"log.level":           "overridden",
+		"user":                "jim",
+	})
+	record.Time = time.Date(2024, 5, 1, 3, 4, 5, 123456789, time.FixedZone("CST", 8*3600))
+
+	msg, err := formatter.Format(record)
+	assert.Nil(t, err)
+
+	var data map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(msg), &data))
+	assert.Equal(t, map[string]interface{}{
+		"@timestamp": "2024-04-30T19:04:05.123Z",
+		"ecs":        map[string]interface{}{"version": ECSVersion},
+		"message":    "paid 3",
+		"log": map[string]interface{}{
+			"level":  "error",
+			"logger": "app",
+			"origin": map[string]interface{}{
+				"file":     map[string]interface{}{"name": "b.go", "line": float64(7)},
+				"function": "a.Func",
+			},
+		},
+		"event":   map[string]interface{}{"action": "order.paid"},
+		"service": map[string]interface{}{"name": "checkout"},
+		"http": map[string]interface{}{
+			"request":  map[string]interface{}{"method": "POST"},
+			"response": map[string]interface{}{"code": float64(200)},
+		},
+		"user": "jim",
+	}, data)
+}
+
+func TestECSFormatterLoadConfig(t *testing.T) {
+	formatter := &ECSFormatter{}
+	assert.Nil(t, formatter.LoadConfig(Config{"serviceName": "api"}))
+	assert.Equal(t, "api", formatter.ServiceName)
+
+	msg, err := formatter.Format(NewLogRecord("", InfoLevel, "a/b.go", "a.b", 1, "hello"))
+	assert.Nil(t, err)
+	assert.Contains(t, msg, `"service":{"name":"api"}`)
+	assert.NotContains(t, msg, `"logger"`)
+}
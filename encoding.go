@@ -0,0 +1,171 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// Encoder converts UTF-8 text to another encoding,
+// e.g. *encoding.Encoder of golang.org/x/text satisfies it,
+// so logdog does not depend on golang.org/x/text
+type Encoder interface {
+	Bytes(b []byte) ([]byte, error)
+}
+
+// EncoderFactory returns a new Encoder, encoders may keep state
+// so every writer gets its own one
+type EncoderFactory func() Encoder
+
+// RegisterEncoding binds name and EncoderFactory, names are case-insensitive.
+// e.g. logdog.RegisterEncoding("shift_jis", func() logdog.Encoder {
+// 	return japanese.ShiftJIS.NewEncoder()
+// })
+func RegisterEncoding(name string, factory EncoderFactory) {
+	encodings.Register(strings.ToLower(name), factory)
+}
+
+// GetEncoder returns a new Encoder of the encoding registered with
+// the given name, returns nil if not found
+func GetEncoder(name string) Encoder {
+	v, ok := encodings.Get(strings.ToLower(name))
+	if !ok {
+		return nil
+	}
+	return v.(EncoderFactory)()
+}
+
+// EncodingWriter is a writer which converts UTF-8 text written to it by
+// Encoder before writing to W. Text which can not be converted is not
+// written, the error is reported by OnError, which prints it to stderr if
+// it is nil. Flush, Sync and Close are passed to W if it supports them.
+type EncodingWriter struct {
+	W       io.Writer
+	Encoder Encoder
+	// OnError is called with the error of converting
+	OnError func(err error)
+}
+
+// NewEncodingWriter returns a new EncodingWriter
+func NewEncodingWriter(w io.Writer, enc Encoder) *EncodingWriter {
+	return &EncodingWriter{
+		W:       w,
+		Encoder: enc,
+	}
+}
+
+// Write converts p and writes it to W, it returns len(p) on success
+func (ew *EncodingWriter) Write(p []byte) (int, error) {
+	encoded, err := ew.Encoder.Bytes(p)
+	if err != nil {
+		err = fmt.Errorf("Encode output failed, [%v]", err)
+		if ew.OnError != nil {
+			ew.OnError(err)
+		} else {
+			InternalError(err)
+		}
+		return 0, err
+	}
+	if _, err := ew.W.Write(encoded); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush flushes W if it supports Flush() or Sync()
+func (ew *EncodingWriter) Flush() error {
+	return flushOutput(ew.W)
+}
+
+// Sync commits W to stable storage if it supports Sync(),
+// errors of syncing stdout or stderr are ignored like syncOutput
+func (ew *EncodingWriter) Sync() error {
+	if f, ok := ew.W.(flusher); ok {
+		return syncOutput(f)
+	}
+	return nil
+}
+
+// Close closes W if it supports Close()
+func (ew *EncodingWriter) Close() error {
+	if c, ok := ew.W.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// OptionEncoding is an option
+// used in every target which has fields named `Output`
+// and wraps the output with an EncodingWriter of the named encoding,
+// it should be applied after the output is set
+func OptionEncoding(name string) Option {
+	return optFuncWraper(func(target interface{}) bool {
+		enc := GetEncoder(name)
+		if enc == nil {
+			InternalError(fmt.Errorf("can not find encoding: %s", name))
+			return false
+		}
+		v := reflect.ValueOf(target).Elem()
+		f := v.FieldByName("Output")
+		if !f.IsValid() || f.IsNil() {
+			return false
+		}
+		w := reflect.ValueOf(NewEncodingWriter(f.Interface().(io.Writer), enc))
+		if !w.Type().AssignableTo(f.Type()) {
+			return false
+		}
+		f.Set(w)
+		return true
+	})
+}
+
+// singleByteEncoder encodes runes < limit as single bytes, e.g. latin1
+type singleByteEncoder struct {
+	name  string
+	limit rune
+}
+
+func (e singleByteEncoder) Bytes(b []byte) ([]byte, error) {
+	dst := make([]byte, 0, len(b))
+	for i := 0; i < len(b); {
+		r, size := utf8.DecodeRune(b[i:])
+		if r == utf8.RuneError && size <= 1 {
+			return nil, fmt.Errorf("invalid UTF-8 at offset %d", i)
+		}
+		if r >= e.limit {
+			return nil, fmt.Errorf("rune %q at offset %d is not representable in %s", r, i, e.name)
+		}
+		dst = append(dst, byte(r))
+		i += size
+	}
+	return dst, nil
+}
+
+func init() {
+	latin1 := func() Encoder {
+		return singleByteEncoder{name: "latin1", limit: 0x100}
+	}
+	ascii := func() Encoder {
+		return singleByteEncoder{name: "us-ascii", limit: 0x80}
+	}
+	RegisterEncoding("latin1", latin1)
+	RegisterEncoding("iso-8859-1", latin1)
+	RegisterEncoding("us-ascii", ascii)
+	RegisterEncoding("ascii", ascii)
+}
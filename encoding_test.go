@@ -0,0 +1,86 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodingWriter(t *testing.T) {
+	var buf bytes.Buffer
+	var errs []error
+	w := NewEncodingWriter(&buf, GetEncoder("Latin1"))
+	w.OnError = func(err error) { errs = append(errs, err) }
+
+	n, err := w.Write([]byte("café\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, len("café\n"), n)
+	assert.Equal(t, []byte("caf\xe9\n"), buf.Bytes())
+
+	_, err = w.Write([]byte("日本\n"))
+	assert.NotNil(t, err)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, []byte("caf\xe9\n"), buf.Bytes())
+
+	assert.Nil(t, GetEncoder("unknown"))
+}
+
+func TestOptionEncoding(t *testing.T) {
+	var buf bytes.Buffer
+	hdlr := NewWriterHandler("", &buf, &TextFormatter{Fmt: "%(message)"}, NothingLevel, OptionEncoding("iso-8859-1"))
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "naïve"))
+	assert.Equal(t, []byte("na\xefve\n"), buf.Bytes())
+
+	stream := NewStreamHandler()
+	assert.Nil(t, stream.LoadConfig(Config{"encoding": "ascii"}))
+	assert.IsType(t, &EncodingWriter{}, stream.Output)
+	assert.NotNil(t, stream.LoadConfig(Config{"encoding": "unknown"}))
+
+	file := NewFileHandler()
+	assert.Nil(t, file.LoadConfig(Config{"filename": "/dev/null", "encoding": "latin1"}))
+	assert.IsType(t, &EncodingWriter{}, file.Output)
+	assert.Nil(t, file.Close())
+}
+
+func TestEncodingStderrFlush(t *testing.T) {
+	// stderr attached to a pipe fails to sync with EINVAL
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+	defer r.Close()
+	defer w.Close()
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+
+	stream := NewStreamHandler(OptionEncoding("latin1"))
+	assert.IsType(t, &EncodingWriter{}, stream.Output)
+	assert.Nil(t, stream.Flush())
+	assert.Nil(t, stream.Close())
+}
+
+func TestEncodingWriterErrorWrappedOnce(t *testing.T) {
+	var errs []error
+	w := NewEncodingWriter(&bytes.Buffer{}, GetEncoder("ascii"))
+	w.OnError = func(err error) { errs = append(errs, err) }
+	_, err := w.Write([]byte("日本"))
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, strings.Count(err.Error(), "ncode output failed"))
+	assert.Equal(t, []error{err}, errs)
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+const (
+	// DefaultExtraPrefix is the default key prefix of extras rendered
+	// with fields by TextFormatter and LogfmtFormatter, e.g. meta.sampled
+	DefaultExtraPrefix = "meta."
+	// DefaultJSONExtraKey is the default json key of the object
+	// which JSONFormatter renders extras in
+	DefaultJSONExtraKey = "meta"
+)
+
+// SetExtra sets the extra of the record, the map is copied on write,
+// so copies of the record passed to other handlers are not affected
+func (lr *LogRecord) SetExtra(key string, value interface{}) {
+	extra := make(map[string]interface{}, len(lr.Extra)+1)
+	for k, v := range lr.Extra {
+		extra[k] = v
+	}
+	extra[key] = value
+	lr.Extra = extra
+}
+
+// GetExtra returns the extra of the record and whether it is set
+func (lr *LogRecord) GetExtra(key string) (interface{}, bool) {
+	v, ok := lr.Extra[key]
+	return v, ok
+}
+
+// fieldsWithExtra returns the record fields merged with its extras,
+// whose keys are prefixed by prefix, "" means DefaultExtraPrefix.
+// Fields win on key collision, record.Fields is returned as it is
+// if the record has no extras
+func fieldsWithExtra(record *LogRecord, prefix string) Fields {
+	if len(record.Extra) == 0 {
+		return record.Fields
+	}
+	if prefix == "" {
+		prefix = DefaultExtraPrefix
+	}
+	fields := make(Fields, len(record.Fields)+len(record.Extra))
+	for k, v := range record.Extra {
+		fields[prefix+k] = v
+	}
+	for k, v := range record.Fields {
+		fields[k] = v
+	}
+	return fields
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogRecordExtra(t *testing.T) {
+	record := NewLogRecord("app", InfoLevel, pathname, fun, line, "msg")
+	_, ok := record.GetExtra("sampled")
+	assert.False(t, ok)
+	assert.Nil(t, record.Extra)
+
+	record.SetExtra("sampled", true)
+	copied := *record
+	copied.SetExtra("redacted", true)
+	// copy on write
+	assert.Len(t, record.Extra, 1)
+	v, ok := copied.GetExtra("sampled")
+	assert.True(t, ok)
+	assert.Equal(t, true, v)
+
+	clone := record.Clone()
+	clone.Extra["sampled"] = false
+	assert.Equal(t, true, record.Extra["sampled"])
+}
+
+func TestFormatterExtra(t *testing.T) {
+	record := NewLogRecord("app", InfoLevel, pathname, fun, line, "msg", Fields{"user": "jim", "meta.sampled": "field"})
+	record.SetExtra("sampled", true)
+	record.SetExtra("rule", "r1")
+
+	text := NewTextFormatter()
+	text.Fmt = "%(message)"
+	msg, err := text.Format(record)
+	assert.Nil(t, err)
+	// fields win on key collision
+	assert.Equal(t, "msg | meta.rule=r1 meta.sampled=field user=jim", msg)
+
+	text = NewTextFormatter()
+	text.Fmt = "%(message)"
+	text.ExtraPrefix = "_"
+	msg, _ = text.Format(record)
+	assert.Equal(t, "msg | _rule=r1 _sampled=true meta.sampled=field user=jim", msg)
+
+	text.DisableExtra = true
+	msg, _ = text.Format(record)
+	assert.Equal(t, "msg | meta.sampled=field user=jim", msg)
+
+	lf := NewLogfmtFormatter()
+	msg, err = lf.Format(record)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(msg, ` meta.rule=r1 meta.sampled=field user=jim`), msg)
+	lf.DisableExtra = true
+	msg, _ = lf.Format(record)
+	assert.True(t, strings.HasSuffix(msg, ` msg=msg meta.sampled=field user=jim`), msg)
+
+	jf := NewJSONFormatter()
+	msg, err = jf.Format(record)
+	assert.Nil(t, err)
+	data := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal([]byte(msg), &data))
+	assert.Equal(t, map[string]interface{}{"sampled": true, "rule": "r1"}, data[DefaultJSONExtraKey])
+	assert.Equal(t, map[string]interface{}{"user": "jim", "meta.sampled": "field"}, data["_fields"])
+
+	jf.DisableExtra = true
+	msg, _ = jf.Format(record)
+	data = map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal([]byte(msg), &data))
+	assert.NotContains(t, data, DefaultJSONExtraKey)
+}
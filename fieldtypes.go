@@ -0,0 +1,69 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"strconv"
+	"time"
+)
+
+// HumanReadable is implemented by typed field values which render as
+// human-readable text in text formatters, e.g. ByteSize renders as
+// 10.0MiB, while json formatters keep their machine-friendly json
+// form, e.g. the number of bytes
+type HumanReadable interface {
+	HumanString() string
+}
+
+// ByteSize is a field value of a number of bytes,
+// it renders as 10.0MiB in text and as a number in json
+type ByteSize int64
+
+const byteUnits = "KMGTPE"
+
+// HumanString returns the size in IEC units, e.g. 512B, 10.0MiB
+func (b ByteSize) HumanString() string {
+	n := int64(b)
+	abs := n
+	if abs < 0 {
+		abs = -abs
+	}
+	if abs < 1024 {
+		return strconv.FormatInt(n, 10) + "B"
+	}
+	div, exp := int64(1024), 0
+	for v := abs / 1024; v >= 1024 && exp < len(byteUnits)-1; v /= 1024 {
+		div *= 1024
+		exp++
+	}
+	return strconv.FormatFloat(float64(n)/float64(div), 'f', 1, 64) + byteUnits[exp:exp+1] + "iB"
+}
+
+// String returns HumanString
+func (b ByteSize) String() string {
+	return b.HumanString()
+}
+
+// Bytes returns Fields with a ByteSize value, e.g.
+// logger.Info("uploaded", logdog.Bytes("size", n))
+func Bytes(key string, n int64) Fields {
+	return Fields{key: ByteSize(n)}
+}
+
+// Duration returns Fields with a time.Duration value, which renders as
+// 1.23s in text and as nanoseconds in json
+func Duration(key string, d time.Duration) Fields {
+	return Fields{key: d}
+}
@@ -0,0 +1,73 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestByteSize(t *testing.T) {
+	cases := map[int64]string{
+		0:                   "0B",
+		512:                 "512B",
+		1024:                "1.0KiB",
+		1536:                "1.5KiB",
+		10485760:            "10.0MiB",
+		-2048:               "-2.0KiB",
+		1 << 40:             "1.0TiB",
+		9223372036854775807: "8.0EiB",
+	}
+	for n, want := range cases {
+		assert.Equal(t, want, ByteSize(n).HumanString(), "%d", n)
+	}
+}
+
+func TestTypedFields(t *testing.T) {
+	hdlr := &recordHandler{}
+	logger := NewLogger(OptionHandlers(hdlr)).With(Bytes("size", 10485760), "id", 1)
+	logger.Info("uploaded", Duration("elapsed", 1234*time.Millisecond))
+	record := hdlr.records[0]
+
+	text := NewTextFormatter()
+	text.Fmt = "%(message)"
+	msg, err := text.Format(record)
+	assert.Nil(t, err)
+	assert.Equal(t, "uploaded | elapsed=1.234s id=1 size=10.0MiB", msg)
+
+	msg, err = NewLogfmtFormatter().Format(record)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(msg, " elapsed=1.234s id=1 size=10.0MiB"), msg)
+
+	msg, err = NewDevFormatter().Format(record)
+	assert.Nil(t, err)
+	assert.Contains(t, msg, "10.0MiB")
+	assert.Contains(t, msg, "1.234s")
+
+	// json keeps numbers, which round-trip through JSONParser
+	jf := NewJSONFormatter()
+	msg, err = jf.Format(record)
+	assert.Nil(t, err)
+	assert.Contains(t, msg, `"size":10485760`)
+	assert.Contains(t, msg, `"elapsed":1234000000`)
+	parsed, err := NewJSONParser(bytes.NewBufferString(msg), jf).Next()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10485760), parsed.Fields["size"])
+	assert.Equal(t, int64(1234000000), parsed.Fields["elapsed"])
+}
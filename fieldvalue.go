@@ -0,0 +1,102 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// UnsupportedFieldFormat renders field values which have no meaningful
+// text or json form, e.g. <unsupported:chan>, <unsupported:func>
+const UnsupportedFieldFormat = "<unsupported:%s>"
+
+// unsupportedKind returns the kind of v if v is a chan, func or
+// unsafe.Pointer, which print as addresses and can not be marshaled
+// to json, or ""
+func unsupportedKind(v interface{}) string {
+	switch kind := reflect.ValueOf(v).Kind(); kind {
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return kind.String()
+	}
+	return ""
+}
+
+// FieldText renders a field value as text: nil as <nil>, time as
+// RFC3339, durations and HumanReadable values in human-readable form,
+// chan, func and unsafe.Pointer as UnsupportedFieldFormat and others
+// by %+v
+func FieldText(v interface{}) string {
+	switch vv := v.(type) {
+	case nil:
+		return "<nil>"
+	case string:
+		return vv
+	case time.Time:
+		return vv.Format(time.RFC3339)
+	case time.Duration:
+		return vv.String()
+	case HumanReadable:
+		return vv.HumanString()
+	case LazyValue:
+		return vv.String()
+	}
+	if kind := unsupportedKind(v); kind != "" {
+		return fmt.Sprintf(UnsupportedFieldFormat, kind)
+	}
+	return fmt.Sprintf("%+v", v)
+}
+
+// safeMarshal marshals v to json, a panic of a MarshalJSON
+// or MarshalText method is returned as an error
+func safeMarshal(v interface{}) (b []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("json marshaling panicked, [%v]", r)
+		}
+	}()
+	return json.Marshal(v)
+}
+
+// jsonSafeValue returns v, or UnsupportedFieldFormat of its kind or
+// type if v can not be marshaled to json, nil stays nil (null)
+func jsonSafeValue(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	if lv, ok := v.(LazyValue); ok {
+		return jsonSafeValue(lv())
+	}
+	if kind := unsupportedKind(v); kind != "" {
+		return fmt.Sprintf(UnsupportedFieldFormat, kind)
+	}
+	if _, err := safeMarshal(v); err != nil {
+		return fmt.Sprintf(UnsupportedFieldFormat, fmt.Sprintf("%T", v))
+	}
+	return v
+}
+
+// JSONSafeFields returns a copy of fields with values which can not be
+// marshaled to json replaced by placeholders, so one bad field does
+// not prevent the record from being logged
+func JSONSafeFields(fields Fields) Fields {
+	safe := make(Fields, len(fields))
+	for k, v := range fields {
+		safe[k] = jsonSafeValue(v)
+	}
+	return safe
+}
@@ -0,0 +1,81 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type panicMarshaler struct{}
+
+func (panicMarshaler) MarshalJSON() ([]byte, error) {
+	panic("boom")
+}
+
+func badFields() Fields {
+	return Fields{
+		"nil":   nil,
+		"ch":    make(chan int),
+		"fn":    func() {},
+		"nan":   math.NaN(),
+		"panic": panicMarshaler{},
+		"ok":    "fine",
+	}
+}
+
+func TestFieldText(t *testing.T) {
+	assert.Equal(t, "<nil>", FieldText(nil))
+	assert.Equal(t, "<unsupported:chan>", FieldText(make(chan int)))
+	assert.Equal(t, "<unsupported:func>", FieldText(func() {}))
+	assert.Equal(t, "{A:1}", FieldText(struct{ A int }{1}))
+}
+
+func TestFormatterBadFields(t *testing.T) {
+	record := NewLogRecord("app", InfoLevel, "/a/b.go", "main.main", 7, "hello", badFields())
+
+	text := NewTextFormatter()
+	text.Fmt = "%(message)"
+	msg, err := text.Format(record)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello | ch=<unsupported:chan> fn=<unsupported:func> nan=NaN nil=<nil> ok=fine panic={}", msg)
+
+	lf := NewLogfmtFormatter()
+	msg, err = lf.Format(record)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(msg, ` ch=<unsupported:chan> fn=<unsupported:func> nan=NaN nil=null ok=fine panic={}`), msg)
+
+	for _, f := range []Formatter{NewJSONFormatter(), NewECSFormatter("")} {
+		msg, err = f.Format(record)
+		assert.Nil(t, err)
+		data := map[string]interface{}{}
+		assert.Nil(t, json.Unmarshal([]byte(msg), &data), msg)
+		fields := data
+		if _, ok := data["_fields"]; ok {
+			fields = data["_fields"].(map[string]interface{})
+		}
+		assert.Equal(t, "<unsupported:chan>", fields["ch"])
+		assert.Equal(t, "<unsupported:func>", fields["fn"])
+		assert.Equal(t, "<unsupported:float64>", fields["nan"])
+		assert.Equal(t, "<unsupported:logdog.panicMarshaler>", fields["panic"])
+		assert.Nil(t, fields["nil"])
+		assert.Contains(t, fields, "nil")
+		assert.Equal(t, "fine", fields["ok"])
+	}
+}
@@ -0,0 +1,447 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// SuppressedFieldKey is the field key of the number of records
+	// suppressed by a filter, it is added to the next emitted record
+	SuppressedFieldKey = "suppressed"
+	// maxDedupEntries is the number of entries in DedupFilter
+	// which triggers sweeping expired entries
+	maxDedupEntries = 1024
+)
+
+// FilterFunc is an adapter to allow the use of ordinary functions
+// as EmitFilter, returns true means the record should be emitted
+type FilterFunc func(*LogRecord) bool
+
+// ShouldEmit calls f(record)
+func (f FilterFunc) ShouldEmit(record *LogRecord) bool {
+	return f(record)
+}
+
+// Filterer is the base of handlers which hold a chain of filters,
+// like Filterer in python logging. A record is emitted only if all
+// filters pass it, filters are checked in registration order and
+// may modify the record, e.g. add fields to it. The Fields map may be
+// shared with other records, so filters must replace it with a new
+// map instead of writing to it.
+type Filterer struct {
+	mu      sync.Mutex
+	filters atomic.Value
+}
+
+// AddFilters adds filters to the chain,
+// it is safe to be called while the handler is in use
+func (f *Filterer) AddFilters(filters ...EmitFilter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	old := f.Filters()
+	// copy on write
+	chain := make([]EmitFilter, 0, len(old)+len(filters))
+	chain = append(chain, old...)
+	chain = append(chain, filters...)
+	f.filters.Store(chain)
+}
+
+// Filters returns the chain of filters
+func (f *Filterer) Filters() []EmitFilter {
+	chain, _ := f.filters.Load().([]EmitFilter)
+	return chain
+}
+
+// PassFilters checks if all filters pass the specified record
+func (f *Filterer) PassFilters(record *LogRecord) bool {
+	for _, filter := range f.Filters() {
+		if !filter.ShouldEmit(record) {
+			return false
+		}
+	}
+	return true
+}
+
+// LevelFilterer is the base of handlers which hold a level and a chain
+// of filters, it implements ShouldEmit, Filter, SetLevel and GetLevel
+type LevelFilterer struct {
+	// Deprecated: Level should not be changed directly while the
+	// handler is in use, use SetLevel and GetLevel instead
+	Level Level
+	Filterer
+}
+
+// ShouldEmit checks if handler should emit the specified record
+func (f *LevelFilterer) ShouldEmit(record *LogRecord) bool {
+	return record.Level >= f.GetLevel() && f.PassFilters(record)
+}
+
+// Filter checks if handler should filter the specified record.
+// Deprecated: use ShouldEmit instead
+func (f *LevelFilterer) Filter(record *LogRecord) bool {
+	return !f.ShouldEmit(record)
+}
+
+// SetLevel sets the handler's level, it is safe to be called
+// while the handler is in use
+func (f *LevelFilterer) SetLevel(level Level) {
+	storeLevel(&f.Level, level)
+}
+
+// GetLevel returns the handler's level
+func (f *LevelFilterer) GetLevel() Level {
+	return loadLevel(&f.Level)
+}
+
+// FilterStats is the statistics of a filter
+type FilterStats struct {
+	// Passed is the number of records passed
+	Passed uint64
+	// Dropped is the number of records dropped
+	Dropped uint64
+	// Summaries is the number of suppressed summaries emitted
+	Summaries uint64
+}
+
+// filterCounter maintains FilterStats with atomics
+type filterCounter struct {
+	passed    uint64
+	dropped   uint64
+	summaries uint64
+}
+
+func (c *filterCounter) pass() bool {
+	atomic.AddUint64(&c.passed, 1)
+	return true
+}
+
+func (c *filterCounter) drop() bool {
+	atomic.AddUint64(&c.dropped, 1)
+	return false
+}
+
+// summarize adds the number of suppressed records to the fields of record,
+// fields are copied so that the map passed by caller is not modified
+func (c *filterCounter) summarize(record *LogRecord, suppressed uint64) {
+	fields := make(Fields, len(record.Fields)+1)
+	for k, v := range record.Fields {
+		fields[k] = v
+	}
+	fields[SuppressedFieldKey] = suppressed
+	record.Fields = fields
+	atomic.AddUint64(&c.summaries, 1)
+}
+
+// Stats returns the statistics of the filter
+func (c *filterCounter) Stats() FilterStats {
+	return FilterStats{
+		Passed:    atomic.LoadUint64(&c.passed),
+		Dropped:   atomic.LoadUint64(&c.dropped),
+		Summaries: atomic.LoadUint64(&c.summaries),
+	}
+}
+
+// RateLimitFilter passes at most Limit records per Interval,
+// the number of records suppressed in previous intervals is added
+// to the first record passed afterwards as SuppressedFieldKey field
+type RateLimitFilter struct {
+	Limit    int
+	Interval time.Duration
+
+	filterCounter
+	mu          sync.Mutex
+	windowStart time.Time
+	count       int
+	suppressed  uint64
+}
+
+// NewRateLimitFilter returns a new RateLimitFilter
+func NewRateLimitFilter(limit int, interval time.Duration) *RateLimitFilter {
+	return &RateLimitFilter{
+		Limit:    limit,
+		Interval: interval,
+	}
+}
+
+// ShouldEmit checks if the record is in the rate limit
+func (f *RateLimitFilter) ShouldEmit(record *LogRecord) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := Now()
+	if now.Sub(f.windowStart) >= f.Interval {
+		f.windowStart = now
+		f.count = 0
+	}
+
+	if f.count >= f.Limit {
+		f.suppressed++
+		return f.drop()
+	}
+
+	f.count++
+	if f.suppressed > 0 {
+		f.summarize(record, f.suppressed)
+		f.suppressed = 0
+	}
+	return f.pass()
+}
+
+// SamplingFilter passes the first record and then every Rate-th record
+type SamplingFilter struct {
+	Rate uint64
+
+	filterCounter
+	seen uint64
+}
+
+// NewSamplingFilter returns a new SamplingFilter passing 1 of rate records
+func NewSamplingFilter(rate uint64) *SamplingFilter {
+	return &SamplingFilter{
+		Rate: rate,
+	}
+}
+
+// ShouldEmit checks if the record is sampled
+func (f *SamplingFilter) ShouldEmit(record *LogRecord) bool {
+	n := atomic.AddUint64(&f.seen, 1)
+	if f.Rate <= 1 || (n-1)%f.Rate == 0 {
+		return f.pass()
+	}
+	return f.drop()
+}
+
+// KeySamplingFilter passes 1 of Rate keys, the key of a record is the
+// value of its field Field, so all records of a sampled key, e.g. a user
+// ID, are passed and all records of the other keys are dropped.
+// The decision is a stable hash of the key, it changes every Window to
+// sample other keys, 0 means never. Records without the field are passed
+type KeySamplingFilter struct {
+	Field  string
+	Rate   uint64
+	Window time.Duration
+
+	filterCounter
+}
+
+// NewKeySamplingFilter returns a new KeySamplingFilter passing
+// records of 1 of rate values of the field
+func NewKeySamplingFilter(field string, rate uint64, window time.Duration) *KeySamplingFilter {
+	return &KeySamplingFilter{
+		Field:  field,
+		Rate:   rate,
+		Window: window,
+	}
+}
+
+// ShouldEmit checks if the key of the record is sampled
+func (f *KeySamplingFilter) ShouldEmit(record *LogRecord) bool {
+	v, ok := record.Fields[f.Field]
+	if !ok || f.Rate <= 1 {
+		return f.pass()
+	}
+	if f.Sampled(fmt.Sprint(v), Now()) {
+		return f.pass()
+	}
+	return f.drop()
+}
+
+// Sampled checks if the key is sampled in the window of t
+func (f *KeySamplingFilter) Sampled(key string, t time.Time) bool {
+	if f.Rate <= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	if f.Window > 0 {
+		var window [8]byte
+		binary.BigEndian.PutUint64(window[:], uint64(t.UnixNano()/int64(f.Window)))
+		h.Write(window[:])
+	}
+	h.Write([]byte(key))
+	return h.Sum64()%f.Rate == 0
+}
+
+// DedupFilter drops records which have the same level and message as
+// a record passed within Window, the number of suppressed duplicates
+// is added to the first duplicate passed afterwards as
+// SuppressedFieldKey field
+type DedupFilter struct {
+	Window time.Duration
+
+	filterCounter
+	mu      sync.Mutex
+	entries map[dedupKey]*dedupEntry
+}
+
+type dedupKey struct {
+	level Level
+	msg   string
+}
+
+type dedupEntry struct {
+	passed     time.Time
+	suppressed uint64
+}
+
+// NewDedupFilter returns a new DedupFilter
+func NewDedupFilter(window time.Duration) *DedupFilter {
+	return &DedupFilter{
+		Window:  window,
+		entries: make(map[dedupKey]*dedupEntry),
+	}
+}
+
+// ShouldEmit checks if the record is not a duplicate
+func (f *DedupFilter) ShouldEmit(record *LogRecord) bool {
+	key := dedupKey{level: record.Level, msg: record.GetMessage()}
+	now := Now()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entry, ok := f.entries[key]
+	if ok && now.Sub(entry.passed) < f.Window {
+		entry.suppressed++
+		return f.drop()
+	}
+
+	if !ok {
+		f.sweep(now)
+		entry = &dedupEntry{}
+		f.entries[key] = entry
+	}
+	if entry.suppressed > 0 {
+		f.summarize(record, entry.suppressed)
+		entry.suppressed = 0
+	}
+	entry.passed = now
+	return f.pass()
+}
+
+// sweep removes expired entries when there are too many entries,
+// the suppressed counts of them are discarded
+func (f *DedupFilter) sweep(now time.Time) {
+	if len(f.entries) < maxDedupEntries {
+		return
+	}
+	for key, entry := range f.entries {
+		if now.Sub(entry.passed) >= f.Window {
+			delete(f.entries, key)
+		}
+	}
+}
+
+// NameRule is a rule of NameFilter, records of loggers whose names
+// match Pattern are passed only if their levels >= Level.
+// Pattern is a glob pattern, e.g. "vendor.*", or a prefix of dotted
+// logger names without any wildcard, e.g. "vendor" matches "vendor"
+// and "vendor.http". Use AllLevel to silence the matched loggers
+type NameRule struct {
+	Pattern string
+	Level   Level
+}
+
+// NameFilter filters records by the names of their loggers,
+// Deny and Allow are checked first, then the first matched rule
+// is applied, records of loggers matching no rule are passed
+type NameFilter struct {
+	Rules []NameRule
+	// Allow passes only records of loggers whose names match
+	// any of the patterns, empty means all loggers
+	Allow []string
+	// Deny drops records of loggers whose names match any of
+	// the patterns, it wins over Allow
+	Deny []string
+
+	filterCounter
+}
+
+// NewNameFilter returns a new NameFilter,
+// returns error if any pattern is malformed
+func NewNameFilter(rules ...NameRule) (*NameFilter, error) {
+	for _, rule := range rules {
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return nil, err
+		}
+	}
+	return &NameFilter{
+		Rules: rules,
+	}, nil
+}
+
+// NewNameListFilter returns a new NameFilter passing records of
+// loggers allowed and not denied, e.g. allow "app.payments" to keep
+// app.payments and app.payments.xxx only. Returns error if any
+// pattern is malformed
+func NewNameListFilter(allow, deny []string) (*NameFilter, error) {
+	for _, pattern := range append(append([]string{}, allow...), deny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+	}
+	return &NameFilter{
+		Allow: allow,
+		Deny:  deny,
+	}, nil
+}
+
+// ShouldEmit checks if the record reaches the level of the first
+// rule matching its logger name
+func (f *NameFilter) ShouldEmit(record *LogRecord) bool {
+	if matchAnyName(f.Deny, record.Name) {
+		return f.drop()
+	}
+	if len(f.Allow) > 0 && !matchAnyName(f.Allow, record.Name) {
+		return f.drop()
+	}
+	for _, rule := range f.Rules {
+		if matchName(rule.Pattern, record.Name) {
+			if record.Level >= rule.Level {
+				return f.pass()
+			}
+			return f.drop()
+		}
+	}
+	return f.pass()
+}
+
+// matchAnyName checks if the logger name matches any of the patterns
+func matchAnyName(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchName(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchName checks if the logger name matches the pattern,
+// patterns without wildcards are compared as prefixes
+func matchName(pattern, name string) bool {
+	if !strings.ContainsAny(pattern, "*?[\\") {
+		return name == pattern || strings.HasPrefix(name, pattern+".")
+	}
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
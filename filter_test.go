@@ -0,0 +1,245 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterer(t *testing.T) {
+	debug := NewLogRecord(name, DebugLevel, pathname, fun, line, "debug")
+	info := NewLogRecord(name, InfoLevel, pathname, fun, line, "info")
+
+	hdlr := NewStreamHandler(OptionDiscardOutput())
+	assert.True(t, hdlr.ShouldEmit(debug))
+
+	hdlr.AddFilters(FilterFunc(func(record *LogRecord) bool {
+		return record.Level >= InfoLevel
+	}))
+	assert.Len(t, hdlr.Filters(), 1)
+	assert.False(t, hdlr.ShouldEmit(debug))
+	assert.True(t, hdlr.ShouldEmit(info))
+	assert.True(t, hdlr.Filter(debug))
+}
+
+func TestLevelFilterer(t *testing.T) {
+	debug := NewLogRecord(name, DebugLevel, pathname, fun, line, "debug")
+	info := NewLogRecord(name, InfoLevel, pathname, fun, line, "info")
+	warn := NewLogRecord(name, WarnLevel, pathname, fun, line, "warn")
+
+	f := &LevelFilterer{}
+	assert.True(t, f.ShouldEmit(debug))
+
+	f.SetLevel(InfoLevel)
+	assert.Equal(t, InfoLevel, f.GetLevel())
+	assert.False(t, f.ShouldEmit(debug))
+	assert.True(t, f.Filter(debug))
+
+	f.AddFilters(FilterFunc(func(record *LogRecord) bool {
+		return record.Level != InfoLevel
+	}))
+	assert.False(t, f.ShouldEmit(info))
+	assert.True(t, f.ShouldEmit(warn))
+}
+
+func TestRateLimitFilter(t *testing.T) {
+	filter := NewRateLimitFilter(2, 50*time.Millisecond)
+	fields := Fields{"x": 1}
+	for i := 0; i < 5; i++ {
+		filter.ShouldEmit(NewLogRecord(name, InfoLevel, pathname, fun, line, "limited", fields))
+	}
+	assert.Equal(t, FilterStats{Passed: 2, Dropped: 3}, filter.Stats())
+
+	time.Sleep(60 * time.Millisecond)
+	record := NewLogRecord(name, InfoLevel, pathname, fun, line, "limited", fields)
+	assert.True(t, filter.ShouldEmit(record))
+	assert.Equal(t, uint64(3), record.Fields[SuppressedFieldKey])
+	// the fields passed by caller are not modified
+	assert.Len(t, fields, 1)
+	assert.Equal(t, FilterStats{Passed: 3, Dropped: 3, Summaries: 1}, filter.Stats())
+}
+
+func TestSamplingFilter(t *testing.T) {
+	filter := NewSamplingFilter(3)
+	passed := 0
+	for i := 0; i < 10; i++ {
+		if filter.ShouldEmit(NewLogRecord(name, InfoLevel, pathname, fun, line, "sampled")) {
+			passed++
+		}
+	}
+	assert.Equal(t, 4, passed)
+	assert.Equal(t, FilterStats{Passed: 4, Dropped: 6}, filter.Stats())
+}
+
+func TestKeySamplingFilter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	SetClock(ClockFunc(func() time.Time { return now }))
+	defer SetClock(nil)
+
+	filter := NewKeySamplingFilter("user", 4, time.Hour)
+	sample := func() map[string]bool {
+		sampled := map[string]bool{}
+		for i := 0; i < 100; i++ {
+			user := fmt.Sprintf("user-%d", i)
+			first := filter.ShouldEmit(NewLogRecord(name, InfoLevel, pathname, fun, line, "a", Fields{"user": user}))
+			// all records of a key are passed or dropped
+			for j := 0; j < 3; j++ {
+				assert.Equal(t, first, filter.ShouldEmit(NewLogRecord(name, InfoLevel, pathname, fun, line, "b", Fields{"user": user})))
+			}
+			sampled[user] = first
+		}
+		return sampled
+	}
+
+	first := sample()
+	passed := 0
+	for _, ok := range first {
+		if ok {
+			passed++
+		}
+	}
+	assert.True(t, passed > 10 && passed < 40, "%d keys sampled", passed)
+	assert.Equal(t, first, sample())
+
+	// other keys are sampled in the next window
+	now = now.Add(time.Hour)
+	assert.NotEqual(t, first, sample())
+
+	// records without the field are passed
+	assert.True(t, filter.ShouldEmit(NewLogRecord(name, InfoLevel, pathname, fun, line, "no user")))
+}
+
+func TestDedupFilter(t *testing.T) {
+	filter := NewDedupFilter(50 * time.Millisecond)
+	assert.True(t, filter.ShouldEmit(NewLogRecord(name, InfoLevel, pathname, fun, line, "dup")))
+	assert.False(t, filter.ShouldEmit(NewLogRecord(name, InfoLevel, pathname, fun, line, "dup")))
+	assert.False(t, filter.ShouldEmit(NewLogRecord(name, InfoLevel, pathname, fun, line, "dup")))
+	assert.True(t, filter.ShouldEmit(NewLogRecord(name, ErrorLevel, pathname, fun, line, "dup")))
+	assert.True(t, filter.ShouldEmit(NewLogRecord(name, InfoLevel, pathname, fun, line, "other")))
+
+	time.Sleep(60 * time.Millisecond)
+	record := NewLogRecord(name, InfoLevel, pathname, fun, line, "dup")
+	assert.True(t, filter.ShouldEmit(record))
+	assert.Equal(t, uint64(2), record.Fields[SuppressedFieldKey])
+	assert.Equal(t, FilterStats{Passed: 4, Dropped: 2, Summaries: 1}, filter.Stats())
+}
+
+func TestFilterFieldsNotShared(t *testing.T) {
+	var hooked *LogRecord
+	filtered := NewWriterHandler("", &bytes.Buffer{}, &TextFormatter{Fmt: "%(message)"}, NothingLevel)
+	dedup := NewDedupFilter(50 * time.Millisecond)
+	filtered.AddFilters(dedup)
+	plain := &recordHandler{}
+	logger := NewLogger(OptionHandlers(filtered, plain))
+	logger.AddHook(func(record *LogRecord) { hooked = record })
+
+	logger.Info("dup")
+	logger.Info("dup")
+	time.Sleep(60 * time.Millisecond)
+	logger.Info("dup", Fields{"x": 1})
+
+	assert.Equal(t, FilterStats{Passed: 2, Dropped: 1, Summaries: 1}, dedup.Stats())
+	// the summary of the filtered handler is not seen by others
+	assert.Len(t, plain.records, 3)
+	assert.Equal(t, Fields{"x": 1}, plain.records[2].Fields)
+	assert.Equal(t, Fields{"x": 1}, hooked.Fields)
+}
+
+func TestNameFilter(t *testing.T) {
+	_, err := NewNameFilter(NameRule{"vendor.[", AllLevel})
+	assert.NotNil(t, err)
+
+	filter, err := NewNameFilter(
+		NameRule{"vendor.*", AllLevel},
+		NameRule{"app", DebugLevel},
+		NameRule{"*", WarnLevel},
+	)
+	assert.Nil(t, err)
+
+	cases := []struct {
+		name     string
+		level    Level
+		expected bool
+	}{
+		{"vendor.http", FatalLevel, false},
+		{"vendor.http.client", FatalLevel, false},
+		{"app", DebugLevel, true},
+		{"app.db", DebugLevel, true},
+		{"application", InfoLevel, false},
+		{"application", WarnLevel, true},
+		{"vendor", DebugLevel, false},
+		{"vendor", ErrorLevel, true},
+	}
+	for _, c := range cases {
+		record := NewLogRecord(c.name, c.level, pathname, fun, line, "msg")
+		assert.Equal(t, c.expected, filter.ShouldEmit(record), "logger %s level %v", c.name, c.level)
+	}
+	assert.Equal(t, FilterStats{Passed: 4, Dropped: 4}, filter.Stats())
+
+	// records of loggers matching no rule are passed
+	filter, _ = NewNameFilter(NameRule{"vendor", AllLevel})
+	assert.True(t, filter.ShouldEmit(NewLogRecord("app", DebugLevel, pathname, fun, line, "msg")))
+}
+
+func TestNameListFilter(t *testing.T) {
+	_, err := NewNameListFilter(nil, []string{"vendor.["})
+	assert.NotNil(t, err)
+
+	filter, err := NewNameListFilter([]string{"app.payments", "jobs.*"}, []string{"app.payments.debug", "jobs.noisy"})
+	assert.Nil(t, err)
+	cases := map[string]bool{
+		"app.payments":           true,
+		"app.payments.card":      true,
+		"app.paymentsx":          false,
+		"app":                    false,
+		"root":                   false,
+		"app.payments.debug":     false,
+		"app.payments.debug.sql": false,
+		"jobs.mail":              true,
+		"jobs.noisy":             false,
+	}
+	for name, expected := range cases {
+		record := NewLogRecord(name, ErrorLevel, pathname, fun, line, "msg")
+		assert.Equal(t, expected, filter.ShouldEmit(record), "logger %s", name)
+	}
+
+	// deny only
+	filter, _ = NewNameListFilter(nil, []string{"vendor"})
+	assert.True(t, filter.ShouldEmit(NewLogRecord("app", DebugLevel, pathname, fun, line, "msg")))
+	assert.False(t, filter.ShouldEmit(NewLogRecord("vendor.http", DebugLevel, pathname, fun, line, "msg")))
+}
+
+func TestUnnamedLoggerRecordName(t *testing.T) {
+	hdlr := &recordHandler{}
+	NewLogger(OptionHandlers(hdlr)).Info("unnamed")
+	PrintLogger(hdlr, InfoLevel).Print("printed")
+	assert.Len(t, hdlr.records, 2)
+	for _, record := range hdlr.records {
+		assert.Equal(t, RootLoggerName, record.Name)
+	}
+}
+
+func TestFilterInterface(t *testing.T) {
+	assert.Implements(t, (*EmitFilter)(nil), FilterFunc(nil))
+	assert.Implements(t, (*EmitFilter)(nil), NewRateLimitFilter(1, time.Second))
+	assert.Implements(t, (*EmitFilter)(nil), NewSamplingFilter(1))
+	assert.Implements(t, (*EmitFilter)(nil), NewDedupFilter(time.Second))
+	assert.Implements(t, (*EmitFilter)(nil), &NameFilter{})
+}
@@ -15,12 +15,16 @@
 package logdog
 
 import (
-	"encoding/json"
 	"fmt"
+	"os"
 	"regexp"
-	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
-	"syscall"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/zoumo/logdog/pkg/pythonic"
 	"github.com/zoumo/logdog/pkg/when"
@@ -38,6 +42,26 @@ type Formatter interface {
 	Option
 }
 
+// AppendFormatter is implemented by formatters which can append a
+// formatted LogRecord to a byte slice, handlers use it to avoid
+// allocating a string for every record
+type AppendFormatter interface {
+	AppendFormat(dst []byte, record *LogRecord) ([]byte, error)
+}
+
+// AppendFormat appends the record formatted by f to dst, it falls back
+// to f.Format if f does not implement AppendFormatter
+func AppendFormat(dst []byte, f Formatter, record *LogRecord) ([]byte, error) {
+	if af, ok := f.(AppendFormatter); ok {
+		return af.AppendFormat(dst, record)
+	}
+	msg, err := f.Format(record)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, msg...), nil
+}
+
 // FormatTime returns the creation time of the specified LogRecord as formatted text.
 func FormatTime(record *LogRecord, datefmt string) string {
 	if datefmt == "" {
@@ -46,6 +70,202 @@ func FormatTime(record *LogRecord, datefmt string) string {
 	return when.Strftime(&record.Time, datefmt)
 }
 
+// TimePrecision is the sub-second precision of formatted time
+type TimePrecision int
+
+const (
+	// SecondPrecision renders time without fraction of second
+	SecondPrecision TimePrecision = iota
+	// MilliPrecision renders time with milliseconds, e.g. 05.123
+	MilliPrecision
+	// MicroPrecision renders time with microseconds, e.g. 05.123456
+	MicroPrecision
+	// NanoPrecision renders time with nanoseconds, e.g. 05.123456789
+	NanoPrecision
+)
+
+var timePrecisions = map[string]TimePrecision{
+	"seconds": SecondPrecision,
+	"millis":  MilliPrecision,
+	"micros":  MicroPrecision,
+	"nanos":   NanoPrecision,
+}
+
+// FormatTimePrecision returns the creation time of the specified LogRecord
+// as formatted text, the fraction of second is placed just after %S
+// in datefmt according to the precision, or after the last time field,
+// e.g. %H:%M.123, if datefmt has no seconds
+func FormatTimePrecision(record *LogRecord, datefmt string, precision TimePrecision) string {
+	return string(AppendTimePrecision(make([]byte, 0, 32), record, datefmt, precision))
+}
+
+// AppendTimePrecision is like FormatTimePrecision but appends
+// the formatted time to dst
+func AppendTimePrecision(dst []byte, record *LogRecord, datefmt string, precision TimePrecision) []byte {
+	if datefmt == "" {
+		datefmt = DefaultDateFmtTemplate
+	}
+	var digits, unit int
+	switch precision {
+	case MilliPrecision:
+		digits, unit = 3, int(time.Millisecond)
+	case MicroPrecision:
+		digits, unit = 6, int(time.Microsecond)
+	case NanoPrecision:
+		digits, unit = 9, 1
+	}
+	i := fractionIndex(datefmt)
+	if digits == 0 || i < 0 {
+		return when.AppendStrftime(dst, &record.Time, datefmt)
+	}
+	dst = when.AppendStrftime(dst, &record.Time, datefmt[:i])
+	dst = append(dst, '.')
+	dst = appendInt(dst, record.Time.Nanosecond()/unit, digits)
+	return when.AppendStrftime(dst, &record.Time, datefmt[i:])
+}
+
+// fractionIndex returns the index in datefmt where the fraction of second
+// is placed, just after the last %S or %X, or after the last %M, %H or %I
+// if datefmt has no seconds, it returns -1 if datefmt has no time field
+func fractionIndex(datefmt string) int {
+	seconds, fields := -1, -1
+	for i := 0; i < len(datefmt)-1; i++ {
+		if datefmt[i] != '%' {
+			continue
+		}
+		i++
+		switch datefmt[i] {
+		case 'S', 'X':
+			seconds = i + 1
+		case 'M', 'H', 'I':
+			fields = i + 1
+		}
+	}
+	if seconds >= 0 {
+		return seconds
+	}
+	return fields
+}
+
+// appendElapsed appends the elapsed time since StartTime
+// in milliseconds, e.g. +123.4ms
+func appendElapsed(dst []byte, record *LogRecord) []byte {
+	ms := float64(record.Elapsed()) / float64(time.Millisecond)
+	if ms >= 0 {
+		dst = append(dst, '+')
+	}
+	dst = strconv.AppendFloat(dst, ms, 'f', 1, 64)
+	return append(dst, "ms"...)
+}
+
+// FormatRelativeTime returns the creation time of the specified LogRecord
+// as elapsed time since StartTime, e.g. +0.014s
+func FormatRelativeTime(record *LogRecord) string {
+	return string(appendRelativeTime(make([]byte, 0, 16), record))
+}
+
+func appendRelativeTime(dst []byte, record *LogRecord) []byte {
+	secs := record.Elapsed().Seconds()
+	if secs >= 0 {
+		dst = append(dst, '+')
+	}
+	dst = strconv.AppendFloat(dst, secs, 'f', 3, 64)
+	return append(dst, 's')
+}
+
+// appendInt appends the non-negative v to dst padded with zeros to width
+func appendInt(dst []byte, v int, width int) []byte {
+	var tmp [20]byte
+	i := len(tmp)
+	for v >= 10 || width > 1 {
+		i--
+		tmp[i] = byte('0' + v%10)
+		v /= 10
+		width--
+	}
+	i--
+	tmp[i] = byte('0' + v)
+	return append(dst, tmp[i:]...)
+}
+
+var (
+	startTime   = time.Now()
+	startTimeMu sync.RWMutex
+)
+
+// StartTime returns the instant which relative time is measured from,
+// it is recorded when the package is initialized by default
+func StartTime() time.Time {
+	startTimeMu.RLock()
+	defer startTimeMu.RUnlock()
+	return startTime
+}
+
+// SetStartTime sets the instant which relative time is measured from,
+// t should be from time.Now() to keep the monotonic clock reading
+func SetStartTime(t time.Time) {
+	startTimeMu.Lock()
+	defer startTimeMu.Unlock()
+	startTime = t
+}
+
+// CallerPathMode decides how to render the path of caller
+type CallerPathMode int
+
+const (
+	// CallerPathShort keeps the last CallerPathSegments segments of path,
+	// e.g. pkg/file.go, it is the default mode
+	CallerPathShort CallerPathMode = iota
+	// CallerPathFull keeps the full path
+	CallerPathFull
+	// CallerPathRelative trims CallerPathPrefix from path, if path does
+	// not have the prefix, falls back to CallerPathShort
+	CallerPathRelative
+	// CallerPathBase keeps the base name of path, e.g. file.go
+	CallerPathBase
+)
+
+// DefaultCallerPathSegments is the default number of path segments
+// kept in CallerPathShort mode, it distinguishes files which share a name
+// in different packages
+const DefaultCallerPathSegments = 2
+
+var callerPathModes = map[string]CallerPathMode{
+	"short":    CallerPathShort,
+	"full":     CallerPathFull,
+	"relative": CallerPathRelative,
+	"base":     CallerPathBase,
+}
+
+// TrimCallerPath trims the caller path according to the mode
+func TrimCallerPath(pathname string, mode CallerPathMode, segments int, prefix string) string {
+	switch mode {
+	case CallerPathFull:
+		return pathname
+	case CallerPathBase:
+		segments = 1
+	case CallerPathRelative:
+		// the prefix must end at a path boundary, "/src/app"
+		// must not match "/src/application/main.go"
+		if prefix != "" && strings.HasPrefix(pathname, prefix) &&
+			(strings.HasSuffix(prefix, "/") || len(pathname) == len(prefix) || pathname[len(prefix)] == '/') {
+			return strings.TrimLeft(pathname[len(prefix):], "/")
+		}
+	}
+
+	if segments <= 0 {
+		segments = DefaultCallerPathSegments
+	}
+	i := len(pathname)
+	for ; segments > 0; segments-- {
+		i = strings.LastIndex(pathname[:i], "/")
+		if i < 0 {
+			return pathname
+		}
+	}
+	return pathname[i+1:]
+}
+
 // TextFormatter is the default formatter used to convert a LogRecord to text.
 //
 // The Formatter can be initialized with a format string which makes use of
@@ -64,19 +284,71 @@ func FormatTime(record *LogRecord, datefmt string) string {
 // %(filename)        Filename portion of pathname
 // %(lineno)          Source line number where the logging call was issued
 //                    (if available)
-// %(funcname)        Function name of caller or maybe ??
-// %(time)            Textual time when the LogRecord was created
+// %(funcname)        Function name of caller or maybe ??, e.g. Func, or
+//                    pkg/path.Type.Func if EnableFullFuncName is true
+// %(caller)          pathname:lineno of caller, pathname is trimmed
+//                    according to CallerPathMode
+// %(time)            Textual time when the LogRecord was created, or elapsed
+//                    time since StartTime if RelativeTime is true
+// %(elapsed)         Elapsed time since StartTime in milliseconds, e.g. +123.4ms
 // %(message)         The result of record.getMessage(), computed just as
 //                    the record is emitted
+// %(event)           Event code of the record, e.g. user.login
+// %(seq)             Sequence number of the record in the process
 // %(color)           Print color
 // %(endColor)        Reset color
 type TextFormatter struct {
 	fieldSequence []string
-	fmtTeplate    string
-	Fmt           string
-	DateFmt       string
-	EnableColors  bool
-	mu            sync.Mutex
+	// literals are the texts around fields, literals[i] is placed
+	// before fieldSequence[i] and the last one after all fields
+	literals     []string
+	parsed       uint32
+	Fmt          string
+	DateFmt      string
+	EnableColors bool
+	// RelativeTime renders %(time) as elapsed time since StartTime
+	RelativeTime bool
+	// Precision is the sub-second precision of %(time)
+	Precision TimePrecision
+	// LevelWidth pads or truncates %(levelname) to the fixed width,
+	// longer names are truncated from the right, e.g. WARNI,
+	// 0 means the default right-aligned width 6 without truncation
+	LevelWidth int
+	// ShortLevelNames renders %(levelname) as short tags, e.g. DBG INF
+	ShortLevelNames bool
+	// NameWidth right-aligns %(name) to the width, longer names are
+	// truncated from the left to keep the most specific part,
+	// 0 means no alignment
+	NameWidth int
+	// CallerPathMode decides how to render the path of %(caller)
+	CallerPathMode CallerPathMode
+	// CallerPathSegments is the number of path segments kept in
+	// CallerPathShort mode, 0 means DefaultCallerPathSegments
+	CallerPathSegments int
+	// CallerPathPrefix is trimmed from path in CallerPathRelative mode,
+	// e.g. the module root directory
+	CallerPathPrefix string
+	// EnableFullFuncName renders %(funcname) as pkg/path.Type.Func
+	EnableFullFuncName bool
+	// DisableSanitize renders %(message) and field values as they are,
+	// control characters in them are escaped by default to prevent
+	// log injection, see AppendSanitized
+	DisableSanitize bool
+	// Separator replaces the literals of Fmt made of spaces and |
+	// between segments, and the | before fields, e.g. "\t", so segments
+	// are delimited unambiguously. "" keeps Fmt as it is, it should be
+	// set before use
+	Separator string
+	// Interpolate substitutes {field} placeholders in %(message) by the
+	// record fields, see AppendInterpolated. %(fields) still renders them
+	Interpolate bool
+	// DisableExtra omits the record extras, which are rendered with
+	// %(fields) by default, prefixed by ExtraPrefix
+	DisableExtra bool
+	// ExtraPrefix prefixes the keys of extras,
+	// "" means DefaultExtraPrefix
+	ExtraPrefix string
+	mu          sync.Mutex
 	ConfigLoader
 }
 
@@ -122,15 +394,44 @@ var (
 		FatalLevel:  red,
 	}
 
+	// ShortLevelNameHash describes short tags of different log level
+	// you can add new tag for your own log level
+	ShortLevelNameHash = map[Level]string{
+		DebugLevel:  "DBG",
+		InfoLevel:   "INF",
+		WarnLevel:   "WRN",
+		ErrorLevel:  "ERR",
+		NoticeLevel: "NTC",
+		FatalLevel:  "FTL",
+	}
+
 	// check if stderr is terminal, sometimes it is redirected to a file
-	// isTerminal      = terminal.IsTerminal(syscall.Stderr)
-	isTerminal      = terminal.IsTerminal(syscall.Stderr)
-	isColorTerminal = isTerminal && (runtime.GOOS != "windows")
+	isTerminal = terminal.IsTerminal(int(os.Stderr.Fd()))
+	// https://no-color.org, colors are disabled unless ForceColor is set
+	noColor = os.Getenv("NO_COLOR") != ""
+
+	// isColorTerminal is detected once by colorTerminal, on Windows
+	// it enables ANSI escapes of the console, see enableColorConsole
+	isColorTerminal     bool
+	detectColorTerminal sync.Once
 )
 
+// colorTerminal returns true if stderr is a terminal rendering colors
+func colorTerminal() bool {
+	detectColorTerminal.Do(func() {
+		isColorTerminal = isTerminal && enableColorConsole(os.Stderr)
+	})
+	return isColorTerminal
+}
+
 //IsColorTerminal return isTerminal and isColorTerminal
 func IsColorTerminal() (bool, bool) {
-	return isTerminal, isColorTerminal
+	return isTerminal, colorTerminal()
+}
+
+// colorEnabled checks if a formatter enabling colors should output color
+func colorEnabled(enable bool) bool {
+	return ForceColor || (enable && !noColor && colorTerminal())
 }
 
 // colorHash returns color for deferent level, default is white
@@ -140,7 +441,22 @@ func colorHash(level Level) (string, string) {
 	if !ok {
 		color = white // white
 	}
-	return fmt.Sprintf("\033[%dm", color), "\033[0m"
+	return colorCode(color), "\033[0m"
+}
+
+// colorCodes caches the escape sequences of SGR codes
+var colorCodes = func() (codes [108]string) {
+	for i := range codes {
+		codes[i] = fmt.Sprintf("\033[%dm", i)
+	}
+	return codes
+}()
+
+func colorCode(color int) string {
+	if color >= 0 && color < len(colorCodes) {
+		return colorCodes[color]
+	}
+	return fmt.Sprintf("\033[%dm", color)
 }
 
 // NewTextFormatter return a new TextFormatter with default config
@@ -163,6 +479,28 @@ func (tf *TextFormatter) LoadConfig(c map[string]interface{}) error {
 	tf.Fmt = config.MustGetString("fmt", DefaultFmtTemplate)
 	tf.DateFmt = config.MustGetString("datefmt", DefaultDateFmtTemplate)
 	tf.EnableColors = config.MustGetBool("enableColors", false)
+	tf.RelativeTime = config.MustGetBool("relativeTime", false)
+	precision := config.MustGetString("precision", "seconds")
+	if _, ok := timePrecisions[precision]; !ok {
+		return fmt.Errorf("unknown time precision: %s", precision)
+	}
+	tf.Precision = timePrecisions[precision]
+	tf.LevelWidth = config.MustGetInt("levelWidth", 0)
+	tf.ShortLevelNames = config.MustGetBool("shortLevelNames", false)
+	tf.NameWidth = config.MustGetInt("nameWidth", 0)
+	mode := config.MustGetString("callerPathMode", "short")
+	if _, ok := callerPathModes[mode]; !ok {
+		return fmt.Errorf("unknown caller path mode: %s", mode)
+	}
+	tf.CallerPathMode = callerPathModes[mode]
+	tf.CallerPathSegments = config.MustGetInt("callerPathSegments", 0)
+	tf.CallerPathPrefix = config.MustGetString("callerPathPrefix", "")
+	tf.EnableFullFuncName = config.MustGetBool("enableFullFuncName", false)
+	tf.DisableSanitize = config.MustGetBool("disableSanitize", false)
+	tf.Separator = config.MustGetString("separator", "")
+	tf.Interpolate = config.MustGetBool("interpolate", false)
+	tf.DisableExtra = config.MustGetBool("disableExtra", false)
+	tf.ExtraPrefix = config.MustGetString("extraPrefix", DefaultExtraPrefix)
 
 	return nil
 
@@ -170,91 +508,246 @@ func (tf *TextFormatter) LoadConfig(c map[string]interface{}) error {
 
 // parse shoule run only once
 func (tf *TextFormatter) parse() {
-	if tf.fmtTeplate != "" && len(tf.fieldSequence) > 0 {
+	if atomic.LoadUint32(&tf.parsed) == 1 {
 		return
 	}
 
 	tf.mu.Lock()
 	defer tf.mu.Unlock()
+	if atomic.LoadUint32(&tf.parsed) == 1 {
+		return
+	}
 	if tf.Fmt == "" {
+		// Don't open color printing by default
+		tf.EnableColors = false
 		tf.Fmt = DefaultFmtTemplate
 	}
 
 	// append fields to Fmt no matter what it is
 	tf.Fmt += "%(fields)"
 
-	// replace %(field) with %s && add field name to sequence
-	// e.g. covert %(name) %(message) to %s %s
-	tf.fmtTeplate = LogRecordFieldRegexp.ReplaceAllStringFunc(tf.Fmt, func(match string) string {
-		// match : %(field)
-		field := match[2 : len(match)-1]
-		tf.fieldSequence = append(tf.fieldSequence, field)
-		return "%s"
-	})
+	// split Fmt into literals and field names
+	// e.g. split %(name) | %(message) to "", " | ", "" and name, message
+	last := 0
+	for _, loc := range LogRecordFieldRegexp.FindAllStringIndex(tf.Fmt, -1) {
+		tf.literals = append(tf.literals, strings.Replace(tf.Fmt[last:loc[0]], "%%", "%", -1))
+		tf.fieldSequence = append(tf.fieldSequence, tf.Fmt[loc[0]+2:loc[1]-1])
+		last = loc[1]
+	}
+	tf.literals = append(tf.literals, strings.Replace(tf.Fmt[last:], "%%", "%", -1))
+	if tf.Separator != "" {
+		for i, literal := range tf.literals {
+			if isSegmentSeparator(literal) {
+				tf.literals[i] = tf.Separator
+			}
+		}
+	}
+	atomic.StoreUint32(&tf.parsed, 1)
+}
 
+// isSegmentSeparator checks if the literal between fields is made of
+// spaces and |, e.g. " " or " | ", which TextFormatter.Separator replaces
+func isSegmentSeparator(literal string) bool {
+	if literal == "" {
+		return false
+	}
+	for i := 0; i < len(literal); i++ {
+		if literal[i] != ' ' && literal[i] != '|' && literal[i] != '\t' {
+			return false
+		}
+	}
+	return true
 }
 
 func (tf *TextFormatter) getColor(record *LogRecord) (string, string) {
 	color, endColor := "", ""
-	if ForceColor || (isColorTerminal && tf.EnableColors) {
+	if colorEnabled(tf.EnableColors) {
 		color, endColor = colorHash(record.Level)
 	}
 	return color, endColor
 }
 
+// appendLevelName appends the padded level name of record,
+// colors are added outside by %(color) so the visible width is kept
+func (tf *TextFormatter) appendLevelName(dst []byte, record *LogRecord) []byte {
+	name := record.LevelName
+	if tf.ShortLevelNames {
+		if short, ok := ShortLevelNameHash[record.Level]; ok {
+			name = short
+		} else if len(name) > 3 {
+			name = name[:3]
+		}
+	}
+	if tf.LevelWidth <= 0 {
+		return appendPadLeft(dst, name, 6)
+	}
+	return appendFixWidth(dst, name, tf.LevelWidth, false)
+}
+
+// appendLoggerName appends the aligned logger name of record
+func (tf *TextFormatter) appendLoggerName(dst []byte, record *LogRecord) []byte {
+	if tf.NameWidth <= 0 {
+		return append(dst, record.Name...)
+	}
+	return appendFixWidth(dst, record.Name, tf.NameWidth, true)
+}
+
+// appendFixWidth right-aligns s to width, if s is longer than width,
+// the leftmost runes are truncated if keepTail is true, e.g. logger names
+// whose last part is the most specific, otherwise the rightmost ones
+func appendFixWidth(dst []byte, s string, width int, keepTail bool) []byte {
+	for n := utf8.RuneCountInString(s); n > width; n-- {
+		if keepTail {
+			_, size := utf8.DecodeRuneInString(s)
+			s = s[size:]
+		} else {
+			_, size := utf8.DecodeLastRuneInString(s)
+			s = s[:len(s)-size]
+		}
+	}
+	return appendPadLeft(dst, s, width)
+}
+
+// appendPadLeft right-aligns s to width with spaces like %*s
+func appendPadLeft(dst []byte, s string, width int) []byte {
+	for n := utf8.RuneCountInString(s); n < width; n++ {
+		dst = append(dst, ' ')
+	}
+	return append(dst, s...)
+}
+
 // Format converts the specified record to string.
 // bench mark with 10 fields
 // go template            33153 ns/op
 // ReplaceAllStringFunc    8420 ns/op
 // field sequence          5046 ns/op
 func (tf *TextFormatter) Format(record *LogRecord) (string, error) {
+	msg, err := tf.AppendFormat(make([]byte, 0, 128), record)
+	return string(msg), err
+}
 
-	if tf.Fmt == "" {
-		// Don't open color printing by default
-		tf.EnableColors = false
-		tf.Fmt = DefaultFmtTemplate
-	}
-
+// AppendFormat appends the formatted record to dst, it does not allocate
+// unless the record has fields or the message has to be formatted
+func (tf *TextFormatter) AppendFormat(dst []byte, record *LogRecord) ([]byte, error) {
 	tf.parse()
 
 	color, endColor := tf.getColor(record)
 
-	sequnce := make([]interface{}, 0, 20)
-
-	for _, field := range tf.fieldSequence {
+	for i, field := range tf.fieldSequence {
+		dst = append(dst, tf.literals[i]...)
 		switch field {
 		case "name":
-			sequnce = append(sequnce, record.Name)
+			dst = tf.appendLoggerName(dst, record)
+		case "elapsed":
+			dst = appendElapsed(dst, record)
 		case "time":
-			sequnce = append(sequnce, FormatTime(record, tf.DateFmt))
+			if tf.RelativeTime {
+				dst = appendRelativeTime(dst, record)
+			} else {
+				dst = AppendTimePrecision(dst, record, tf.DateFmt, tf.Precision)
+			}
 		case "levelno":
-			sequnce = append(sequnce, fmt.Sprintf("%d", record.Level))
+			dst = strconv.AppendInt(dst, int64(record.Level), 10)
 		case "levelname":
-			sequnce = append(sequnce, fmt.Sprintf("%6s", record.LevelName))
+			dst = tf.appendLevelName(dst, record)
 		case "pathname":
-			sequnce = append(sequnce, record.PathName)
+			dst = append(dst, record.PathName...)
 		case "filename":
-			sequnce = append(sequnce, record.FileName)
+			dst = append(dst, record.FileName...)
 		case "funcname":
-			sequnce = append(sequnce, record.ShortFuncName)
+			if tf.EnableFullFuncName {
+				dst = append(dst, record.FullFuncName...)
+			} else {
+				dst = append(dst, record.ShortFuncName...)
+			}
+		case "caller":
+			dst = append(dst, TrimCallerPath(record.PathName, tf.CallerPathMode, tf.CallerPathSegments, tf.CallerPathPrefix)...)
+			dst = append(dst, ':')
+			dst = strconv.AppendInt(dst, int64(record.Line), 10)
 		case "lineno":
-			sequnce = append(sequnce, fmt.Sprintf("%d", record.Line))
+			dst = strconv.AppendInt(dst, int64(record.Line), 10)
 		case "message":
-			sequnce = append(sequnce, record.GetMessage())
+			start := len(dst)
+			if tf.Interpolate {
+				dst = record.AppendInterpolatedMessage(dst)
+			} else {
+				dst = record.AppendMessage(dst)
+			}
+			if !tf.DisableSanitize {
+				dst = sanitizeTail(dst, start)
+			}
+		case "event":
+			dst = append(dst, record.Event...)
+		case "seq":
+			dst = strconv.AppendUint(dst, record.Seq, 10)
 		case "color":
-			sequnce = append(sequnce, color)
+			dst = append(dst, color...)
 		case "endColor":
-			sequnce = append(sequnce, endColor)
+			dst = append(dst, endColor...)
 		case "fields":
-			sequnce = append(sequnce, record.Fields.ToKVString(color, endColor))
+			fields := record.Fields
+			if !tf.DisableExtra {
+				fields = fieldsWithExtra(record, tf.ExtraPrefix)
+			}
+			if len(fields) > 0 {
+				kv := fields.toKVString(color, endColor, !tf.DisableSanitize)
+				if tf.Separator != "" {
+					dst = append(dst, tf.Separator...)
+					kv = strings.TrimPrefix(kv, " | ")
+				}
+				dst = append(dst, kv...)
+			}
 		}
 	}
-	return fmt.Sprintf(tf.fmtTeplate, sequnce...), nil
+	return append(dst, tf.literals[len(tf.fieldSequence)]...), nil
 }
 
+const (
+	// DefaultJSONMessageKey is the default json key of message
+	DefaultJSONMessageKey = "message"
+	// DefaultJSONEventKey is the default json key of event
+	DefaultJSONEventKey = "event"
+
+	// EpochMillis renders json time as milliseconds since unix epoch
+	EpochMillis = "millis"
+	// EpochNanos renders json time as nanoseconds since unix epoch
+	EpochNanos = "nanos"
+)
+
 // JSONFormatter can convert LogRecord to json text
 type JSONFormatter struct {
 	Datefmt string
+	// RelativeTime renders time as elapsed time since StartTime
+	RelativeTime bool
+	// Precision is the sub-second precision of time
+	Precision TimePrecision
+	// Epoch renders time as a number since unix epoch instead of string,
+	// it can be EpochMillis or EpochNanos, "" means string time
+	Epoch string
+	// EnableElapsed adds elapsed_ms, the elapsed milliseconds
+	// since StartTime, to json
+	EnableElapsed bool
+	// EnableSeq adds seq, the sequence number of the record, to json,
+	// it orders records with the same time
+	EnableSeq bool
+	// MessageKey is the json key of message,
+	// "" means DefaultJSONMessageKey
+	MessageKey string
+	// EventKey is the json key of event, event is omitted if it is empty,
+	// "" means DefaultJSONEventKey
+	EventKey string
+	// NestFields expands dotted field keys into nested objects, e.g.
+	// http.request.method into {"http":{"request":{"method":...}}},
+	// see NestFields
+	NestFields bool
+	// Interpolate substitutes {field} placeholders in message by the
+	// record fields, see AppendInterpolated. _fields still keeps them
+	Interpolate bool
+	// DisableExtra omits the record extras, which are rendered as
+	// an object under ExtraKey by default
+	DisableExtra bool
+	// ExtraKey is the json key of extras, "" means DefaultJSONExtraKey
+	ExtraKey string
 	ConfigLoader
 }
 
@@ -274,11 +767,38 @@ func (jf *JSONFormatter) LoadConfig(c map[string]interface{}) error {
 	}
 
 	jf.Datefmt = config.MustGetString("datefmt", DefaultDateFmtTemplate)
+	jf.RelativeTime = config.MustGetBool("relativeTime", false)
+	precision := config.MustGetString("precision", "seconds")
+	if _, ok := timePrecisions[precision]; !ok {
+		return fmt.Errorf("unknown time precision: %s", precision)
+	}
+	jf.Precision = timePrecisions[precision]
+	jf.EnableElapsed = config.MustGetBool("enableElapsed", false)
+	jf.EnableSeq = config.MustGetBool("enableSeq", false)
+	jf.Epoch = config.MustGetString("epoch", "")
+	if jf.Epoch != "" && jf.Epoch != EpochMillis && jf.Epoch != EpochNanos {
+		return fmt.Errorf("unknown epoch: %s", jf.Epoch)
+	}
+	jf.MessageKey = config.MustGetString("messageKey", DefaultJSONMessageKey)
+	jf.EventKey = config.MustGetString("eventKey", DefaultJSONEventKey)
+	jf.NestFields = config.MustGetBool("nestFields", false)
+	jf.Interpolate = config.MustGetBool("interpolate", false)
+	jf.DisableExtra = config.MustGetBool("disableExtra", false)
+	jf.ExtraKey = config.MustGetString("extraKey", DefaultJSONExtraKey)
 	return nil
 }
 
 // Format converts the specified record to json string.
 func (jf *JSONFormatter) Format(record *LogRecord) (string, error) {
+	jsonBytes, err := jf.AppendFormat(nil, record)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
+// AppendFormat appends the record converted to json to dst
+func (jf *JSONFormatter) AppendFormat(dst []byte, record *LogRecord) ([]byte, error) {
 	fields := make(Fields, len(record.Fields)+4)
 	for k, v := range record.Fields {
 		fields[k] = v
@@ -286,21 +806,155 @@ func (jf *JSONFormatter) Format(record *LogRecord) (string, error) {
 	// jf.formatFields(fields)
 	data := make(map[string]interface{})
 
-	data["time"] = FormatTime(record, jf.Datefmt)
-	data["message"] = record.GetMessage()
+	switch {
+	case jf.RelativeTime:
+		data["time"] = FormatRelativeTime(record)
+	case jf.Epoch == EpochMillis:
+		data["time"] = record.Time.UnixNano() / int64(time.Millisecond)
+	case jf.Epoch == EpochNanos:
+		data["time"] = record.Time.UnixNano()
+	default:
+		data["time"] = FormatTimePrecision(record, jf.Datefmt, jf.Precision)
+	}
+	messageKey, eventKey := jf.MessageKey, jf.EventKey
+	if messageKey == "" {
+		messageKey = DefaultJSONMessageKey
+	}
+	if eventKey == "" {
+		eventKey = DefaultJSONEventKey
+	}
+	data[messageKey] = interpolatedMessage(record, jf.Interpolate)
+	if record.Event != "" {
+		data[eventKey] = record.Event
+	}
+	if jf.EnableElapsed {
+		data["elapsed_ms"] = float64(record.Elapsed()) / float64(time.Millisecond)
+	}
+	if jf.EnableSeq {
+		data["seq"] = record.Seq
+	}
 	data["file"] = record.FileName
 	data["line"] = record.Line
 	data["level"] = record.LevelName
-	if len(fields) > 0 {
-		data["_fields"] = fields
+	jf.setFields(data, fields)
+	extraKey := jf.ExtraKey
+	if extraKey == "" {
+		extraKey = DefaultJSONExtraKey
+	}
+	if !jf.DisableExtra && len(record.Extra) > 0 {
+		data[extraKey] = record.Extra
 	}
 
-	jsonBytes, err := json.Marshal(data)
+	jsonBytes, err := safeMarshal(data)
+	if err != nil && (len(fields) > 0 || data[extraKey] != nil) {
+		// retry with the fields which can not be marshaled replaced
+		jf.setFields(data, JSONSafeFields(fields))
+		if data[extraKey] != nil {
+			data[extraKey] = JSONSafeFields(record.Extra)
+		}
+		jsonBytes, err = safeMarshal(data)
+	}
 	if err != nil {
-		return "", fmt.Errorf("Marashal fields to Json failed, [%v]", err)
+		return dst, fmt.Errorf("Marashal fields to Json failed, [%v]", err)
 	}
 
-	return string(jsonBytes), nil
+	return append(dst, jsonBytes...), nil
+}
+
+// setFields sets fields to data as _fields
+func (jf *JSONFormatter) setFields(data map[string]interface{}, fields Fields) {
+	if len(fields) == 0 {
+		return
+	}
+	if jf.NestFields {
+		data["_fields"] = NestFields(fields)
+	} else {
+		data["_fields"] = fields
+	}
+}
+
+// NestedValueKey is the key which a value moves to
+// if nested fields are set under its key, see NestFields
+const NestedValueKey = "value"
+
+// NestFields returns the fields with dotted keys expanded into nested
+// objects, Fields and map[string]interface{} values are merged as
+// sub-objects. No value is dropped on collision, e.g. with fields
+// http=1 and http.code=200, 1 moves to http.value. fields are not modified
+func NestFields(fields Fields) map[string]interface{} {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	nested := make(map[string]interface{}, len(fields))
+	for _, k := range keys {
+		setNested(nested, splitDotted(k), fields[k])
+	}
+	return nested
+}
+
+// splitDotted splits the dotted key, keys with empty parts,
+// e.g. a..b or .a, are not split
+func splitDotted(key string) []string {
+	parts := strings.Split(key, ".")
+	for _, part := range parts {
+		if part == "" {
+			return []string{key}
+		}
+	}
+	return parts
+}
+
+// setNested sets value in data by the path, objects are merged,
+// a value colliding with an object moves to its NestedValueKey
+func setNested(data map[string]interface{}, path []string, value interface{}) {
+	for _, part := range path[:len(path)-1] {
+		data = nestedObject(data, part)
+	}
+
+	leaf := path[len(path)-1]
+	var sub map[string]interface{}
+	switch v := value.(type) {
+	case Fields:
+		sub = v
+	case map[string]interface{}:
+		sub = v
+	}
+	if sub != nil {
+		obj := nestedObject(data, leaf)
+		keys := make([]string, 0, len(sub))
+		for k := range sub {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			setNested(obj, []string{k}, sub[k])
+		}
+		return
+	}
+
+	if obj, ok := data[leaf].(map[string]interface{}); ok {
+		obj[NestedValueKey] = value
+		return
+	}
+	data[leaf] = value
+}
+
+// nestedObject returns the object of data[key], it is created
+// if absent, an existing value moves to its NestedValueKey
+func nestedObject(data map[string]interface{}, key string) map[string]interface{} {
+	existing, ok := data[key]
+	if obj, isObj := existing.(map[string]interface{}); isObj {
+		return obj
+	}
+	obj := make(map[string]interface{})
+	if ok {
+		obj[NestedValueKey] = existing
+	}
+	data[key] = obj
+	return obj
 }
 
 func init() {
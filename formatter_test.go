@@ -15,7 +15,11 @@
 package logdog
 
 import (
+	"os"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -88,6 +92,302 @@ func TestJsonFormatterLoadConfig(t *testing.T) {
 	assert.Equal(t, formatter.Datefmt, "test")
 }
 
+func TestRelativeTime(t *testing.T) {
+	start := StartTime()
+	defer SetStartTime(start)
+
+	now := time.Now()
+	SetStartTime(now)
+
+	record := NewLogRecord(name, InfoLevel, pathname, fun, line, "relative")
+	record.Time = now.Add(14 * time.Millisecond)
+
+	text := &TextFormatter{Fmt: "%(time) %(message)", RelativeTime: true}
+	msg, err := text.Format(record)
+	assert.Nil(t, err)
+	assert.Equal(t, "+0.014s relative", msg)
+
+	json := &JSONFormatter{RelativeTime: true}
+	msg, err = json.Format(record)
+	assert.Nil(t, err)
+	assert.Contains(t, msg, `"time":"+0.014s"`)
+}
+
+func TestElapsed(t *testing.T) {
+	start := StartTime()
+	defer SetStartTime(start)
+
+	now := time.Now()
+	SetStartTime(now)
+
+	record := NewLogRecord(name, InfoLevel, pathname, fun, line, "elapsed")
+	assert.True(t, record.Elapsed() >= 0)
+	record.Time = now.Add(123400 * time.Microsecond)
+	assert.Equal(t, 123400*time.Microsecond, record.Elapsed())
+
+	text := &TextFormatter{Fmt: "%(elapsed) %(message)"}
+	msg, err := text.Format(record)
+	assert.Nil(t, err)
+	assert.Equal(t, "+123.4ms elapsed", msg)
+
+	record.Time = now.Add(-1500 * time.Microsecond)
+	msg, err = text.Format(record)
+	assert.Nil(t, err)
+	assert.Equal(t, "-1.5ms elapsed", msg)
+	record.Time = now.Add(123400 * time.Microsecond)
+
+	json := &JSONFormatter{EnableElapsed: true}
+	msg, err = json.Format(record)
+	assert.Nil(t, err)
+	assert.Contains(t, msg, `"elapsed_ms":123.4`)
+}
+
+func TestColorEnabled(t *testing.T) {
+	isTerm, isColor := IsColorTerminal()
+	assert.Equal(t, isTerm && enableColorConsole(os.Stderr), isColor)
+	assert.Equal(t, isColor, colorEnabled(true))
+	assert.False(t, colorEnabled(false))
+
+	saved := noColor
+	noColor = true
+	defer func() { noColor = saved }()
+	assert.False(t, colorEnabled(true))
+	// ForceColor overrides NO_COLOR and terminal detection
+	ForceColor = true
+	defer func() { ForceColor = false }()
+	assert.True(t, colorEnabled(false))
+}
+
+func TestTextFormatterSeparator(t *testing.T) {
+	record := NewLogRecord("app", InfoLevel, "a/b.go", "a.b", 7, "hello world", Fields{"k": "v"})
+	record.Time = time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+
+	formatter := NewTextFormatter()
+	assert.Nil(t, formatter.LoadConfig(Config{"separator": "\t"}))
+	assert.Equal(t, "\t", formatter.Separator)
+	msg, err := formatter.Format(record)
+	assert.Nil(t, err)
+	assert.Equal(t, "2024-05-01 12:00:00\t  INFO\tb.go:7\thello world\tk=v", msg)
+
+	// literals other than spaces and | are kept
+	formatter = &TextFormatter{Fmt: "%(name) [%(levelname)] | %(message)", Separator: "|"}
+	msg, err = formatter.Format(record)
+	assert.Nil(t, err)
+	assert.Equal(t, "app [  INFO] | hello world|k=v", msg)
+
+	// the default keeps today's spacing
+	msg, err = NewTextFormatter().Format(record)
+	assert.Nil(t, err)
+	assert.Equal(t, "2024-05-01 12:00:00   INFO b.go:7 | hello world | k=v", msg)
+}
+
+func TestTextFormatterAlignment(t *testing.T) {
+	ForceColor = true
+	defer func() { ForceColor = false }()
+
+	formatter := &TextFormatter{
+		Fmt:        "%(color)%(levelname)%(endColor) %(name) | %(message)",
+		LevelWidth: 7,
+		NameWidth:  8,
+	}
+
+	records := []*LogRecord{
+		NewLogRecord("app", DebugLevel, pathname, fun, line, "debug"),
+		NewLogRecord("app.server.db", InfoLevel, pathname, fun, line, "info"),
+		NewLogRecord("a", WarnLevel, pathname, fun, line, "warn"),
+		NewLogRecord("app.http", ErrorLevel, pathname, fun, line, "error"),
+		NewLogRecord("app", Level(3), pathname, fun, line, "custom"),
+	}
+	snapshot := []string{
+		"  DEBUG      app | debug",
+		"   INFO erver.db | info",
+		"   WARN        a | warn",
+		"  ERROR app.http | error",
+		"Level 3      app | custom",
+	}
+
+	ansi := regexp.MustCompile("\033\\[[0-9]+m")
+	for i, record := range records {
+		msg, err := formatter.Format(record)
+		assert.Nil(t, err)
+		assert.Contains(t, msg, "\033[")
+		visible := ansi.ReplaceAllString(msg, "")
+		assert.Equal(t, snapshot[i], visible)
+		assert.Equal(t, 17, strings.Index(visible, "|"))
+	}
+
+	formatter = &TextFormatter{Fmt: "%(levelname) %(message)", ShortLevelNames: true, LevelWidth: 3}
+	msg, err := formatter.Format(NewLogRecord(name, WarnLevel, pathname, fun, line, "short"))
+	assert.Nil(t, err)
+	assert.Equal(t, "WRN short", msg)
+
+	// level names are truncated from the right, logger names from the left
+	formatter = &TextFormatter{Fmt: "%(levelname) %(name) %(message)", LevelWidth: 5, NameWidth: 6}
+	msg, err = formatter.Format(NewLogRecord("app.server", NoticeLevel, pathname, fun, line, "truncated"))
+	assert.Nil(t, err)
+	assert.Equal(t, "NOTIC server truncated", msg)
+}
+
+func TestTrimCallerPath(t *testing.T) {
+	pathname := "/home/ci/go/src/github.com/acme/app/server/server.go"
+	cases := []struct {
+		mode     CallerPathMode
+		segments int
+		prefix   string
+		expected string
+	}{
+		{CallerPathShort, 0, "", "server/server.go"},
+		{CallerPathShort, 1, "", "server.go"},
+		{CallerPathShort, 3, "", "app/server/server.go"},
+		{CallerPathShort, 100, "", pathname},
+		{CallerPathFull, 0, "", pathname},
+		{CallerPathBase, 0, "", "server.go"},
+		{CallerPathBase, 3, "", "server.go"},
+		{CallerPathRelative, 0, "/home/ci/go/src/github.com/acme/app", "server/server.go"},
+		{CallerPathRelative, 1, "/other", "server.go"},
+		{CallerPathRelative, 0, "/home/ci/go/src/github.com/acme/app/", "server/server.go"},
+		// the prefix must end at a path boundary
+		{CallerPathRelative, 1, "/home/ci/go/src/github.com/acme/ap", "server.go"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, TrimCallerPath(pathname, c.mode, c.segments, c.prefix))
+	}
+	assert.Equal(t, "??", TrimCallerPath("??", CallerPathShort, 0, ""))
+	assert.Equal(t, "x.go", TrimCallerPath("x.go", CallerPathBase, 0, ""))
+	assert.Equal(t, "x.go", TrimCallerPath("/src/app/x.go", CallerPathRelative, 0, "/src/app"))
+	assert.Equal(t, "application/x.go", TrimCallerPath("/src/application/x.go", CallerPathRelative, 0, "/src/app"))
+}
+
+func TestTextFormatterCaller(t *testing.T) {
+	record := NewLogRecord(name, InfoLevel, "/src/acme/app/main.go", "github.com/acme/app.(*Server).Run", 10, "caller")
+	formatter := &TextFormatter{Fmt: "%(caller) %(funcname)"}
+	msg, err := formatter.Format(record)
+	assert.Nil(t, err)
+	assert.Equal(t, "app/main.go:10 Run", msg)
+
+	formatter = NewTextFormatter()
+	assert.Nil(t, formatter.LoadConfig(Config{
+		"fmt":                "%(caller) %(funcname)",
+		"callerPathMode":     "relative",
+		"callerPathPrefix":   "/src/acme",
+		"enableFullFuncName": true,
+	}))
+	msg, err = formatter.Format(record)
+	assert.Nil(t, err)
+	assert.Equal(t, "app/main.go:10 github.com/acme/app.(*Server).Run", msg)
+
+	assert.Error(t, NewTextFormatter().LoadConfig(Config{"callerPathMode": "unknown"}))
+}
+
+func TestFormatEvent(t *testing.T) {
+	record := NewLogRecord(name, InfoLevel, pathname, fun, line, "", "jim logged in", Event("user.login"))
+
+	text := &TextFormatter{Fmt: "%(event): %(message)"}
+	msg, err := text.Format(record)
+	assert.Nil(t, err)
+	assert.Equal(t, "user.login: jim logged in", msg)
+
+	json := NewJSONFormatter()
+	msg, err = json.Format(record)
+	assert.Nil(t, err)
+	assert.Contains(t, msg, `"event":"user.login"`)
+	assert.Contains(t, msg, `"message":"jim logged in"`)
+
+	json.MessageKey, json.EventKey = "msg", "event_code"
+	msg, err = json.Format(record)
+	assert.Nil(t, err)
+	assert.Contains(t, msg, `"event_code":"user.login"`)
+	assert.Contains(t, msg, `"msg":"jim logged in"`)
+
+	msg, err = json.Format(NewLogRecord(name, InfoLevel, pathname, fun, line, "no event"))
+	assert.Nil(t, err)
+	assert.NotContains(t, msg, "event_code")
+}
+
+func TestTimePrecision(t *testing.T) {
+	base := time.Date(2024, 5, 1, 3, 4, 5, 123456789, time.UTC)
+	record := NewLogRecord(name, InfoLevel, pathname, fun, line, "precision")
+	record.Time = base
+
+	expected := map[TimePrecision]string{
+		SecondPrecision: "2024-05-01 03:04:05",
+		MilliPrecision:  "2024-05-01 03:04:05.123",
+		MicroPrecision:  "2024-05-01 03:04:05.123456",
+		NanoPrecision:   "2024-05-01 03:04:05.123456789",
+	}
+	for precision, text := range expected {
+		assert.Equal(t, text, FormatTimePrecision(record, "", precision))
+	}
+	assert.Equal(t, "05.123 +0000", FormatTimePrecision(record, "%S %z", MilliPrecision))
+	assert.Equal(t, "03:04:05.123 05/01/24", FormatTimePrecision(record, "%X %x", MilliPrecision))
+	// without seconds, the fraction follows the last time field
+	assert.Equal(t, "2024-05-01 03:04.123 +0000", FormatTimePrecision(record, "%Y-%m-%d %H:%M %z", MilliPrecision))
+	assert.Equal(t, "2024-05-01", FormatTimePrecision(record, "%Y-%m-%d", MilliPrecision))
+	assert.Equal(t, "%S", FormatTimePrecision(record, "%%S", MilliPrecision))
+
+	// two records in the same millisecond are distinguishable at micro precision
+	record2 := NewLogRecord(name, InfoLevel, pathname, fun, line, "precision")
+	record2.Time = base.Add(time.Microsecond)
+	text := &TextFormatter{Fmt: "%(time)", Precision: MicroPrecision}
+	msg1, err := text.Format(record)
+	assert.Nil(t, err)
+	msg2, err := text.Format(record2)
+	assert.Nil(t, err)
+	assert.NotEqual(t, msg1, msg2)
+	text.Precision = MilliPrecision
+	msg1, _ = text.Format(record)
+	msg2, _ = text.Format(record2)
+	assert.Equal(t, msg1, msg2)
+
+	json := &JSONFormatter{Precision: NanoPrecision}
+	msg, err := json.Format(record)
+	assert.Nil(t, err)
+	assert.Contains(t, msg, `"time":"2024-05-01 03:04:05.123456789"`)
+
+	json = &JSONFormatter{Epoch: EpochMillis}
+	msg, err = json.Format(record)
+	assert.Nil(t, err)
+	assert.Contains(t, msg, `"time":1714532645123}`)
+
+	json = &JSONFormatter{Epoch: EpochNanos}
+	msg, err = json.Format(record)
+	assert.Nil(t, err)
+	assert.Contains(t, msg, `"time":1714532645123456789`)
+
+	assert.Error(t, NewJSONFormatter().LoadConfig(Config{"epoch": "seconds"}))
+	assert.Error(t, NewTextFormatter().LoadConfig(Config{"precision": "hours"}))
+}
+
+func TestJSONFormatterNestFields(t *testing.T) {
+	fields := Fields{
+		"http.request.method": "GET",
+		"http":                "scalar",
+		"http.response":       map[string]interface{}{"code": 200},
+		"user":                Fields{"id": 1},
+		"user.name":           "jim",
+		"a..b":                1,
+	}
+	record := NewLogRecord("app", InfoLevel, pathname, fun, line, "nested", fields)
+
+	formatter := &JSONFormatter{NestFields: true}
+	msg, err := formatter.Format(record)
+	assert.Nil(t, err)
+	assert.Contains(t, msg, `"_fields":{"a..b":1,"http":{"request":{"method":"GET"},"response":{"code":200},"value":"scalar"},"user":{"id":1,"name":"jim"}}`)
+	// fields are not modified
+	assert.Equal(t, "scalar", fields["http"])
+	assert.Equal(t, Fields{"id": 1}, fields["user"])
+
+	// flat by default
+	msg, err = NewJSONFormatter().Format(record)
+	assert.Nil(t, err)
+	assert.Contains(t, msg, `"http.request.method":"GET"`)
+
+	// a scalar set after an object moves to value too
+	assert.Equal(t, map[string]interface{}{
+		"a": map[string]interface{}{"b": 1, "value": 2},
+	}, NestFields(Fields{"a": map[string]interface{}{"b": 1}, "a.value": 2}))
+}
+
 func TestFormatterInterface(t *testing.T) {
 	assert.Implements(t, (*Formatter)(nil), NewTextFormatter())
 	assert.Implements(t, (*ConfigLoader)(nil), NewTextFormatter())
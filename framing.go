@@ -0,0 +1,126 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"encoding/binary"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Framer frames a formatted record for a collector, e.g. a TCP or UDP
+// connection written by a WriterHandler. msg has no trailing newline
+type Framer interface {
+	Frame(msg string) []byte
+}
+
+// FramerFunc is an adapter to allow the use of ordinary functions as Framer
+type FramerFunc func(msg string) []byte
+
+// Frame calls f(msg)
+func (f FramerFunc) Frame(msg string) []byte {
+	return f(msg)
+}
+
+var (
+	// NewlineFramer terminates records by '\n', e.g. for line-based collectors
+	NewlineFramer Framer = FramerFunc(func(msg string) []byte {
+		return append([]byte(msg), '\n')
+	})
+	// LengthPrefixFramer prefixes records with their lengths
+	// as 4-byte big-endian integers
+	LengthPrefixFramer Framer = FramerFunc(func(msg string) []byte {
+		frame := make([]byte, 4, 4+len(msg))
+		binary.BigEndian.PutUint32(frame, uint32(len(msg)))
+		return append(frame, msg...)
+	})
+	// OctetCountingFramer prefixes records with their lengths in decimal
+	// and a space, i.e. syslog octet counting of RFC 6587
+	OctetCountingFramer Framer = FramerFunc(func(msg string) []byte {
+		frame := strconv.AppendInt(make([]byte, 0, len(msg)+8), int64(len(msg)), 10)
+		frame = append(frame, ' ')
+		return append(frame, msg...)
+	})
+)
+
+// FramedWriter is a writer which frames every Write by Framer before
+// writing it to W, handlers write a record by a single Write, so every
+// record becomes a frame. Flush, Sync and Close are passed to W if it
+// supports them.
+type FramedWriter struct {
+	W      io.Writer
+	Framer Framer
+}
+
+// NewFramedWriter returns a new FramedWriter
+func NewFramedWriter(w io.Writer, framer Framer) *FramedWriter {
+	return &FramedWriter{
+		W:      w,
+		Framer: framer,
+	}
+}
+
+// Write frames p without its trailing newline and writes the frame
+// to W by a single Write, it returns len(p) on success
+func (fw *FramedWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	if _, err := fw.W.Write(fw.Framer.Frame(msg)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush flushes W if it supports Flush() or Sync()
+func (fw *FramedWriter) Flush() error {
+	return flushOutput(fw.W)
+}
+
+// Sync commits W to stable storage if it supports Sync()
+func (fw *FramedWriter) Sync() error {
+	if f, ok := fw.W.(flusher); ok {
+		return f.Sync()
+	}
+	return nil
+}
+
+// Close closes W if it supports Close()
+func (fw *FramedWriter) Close() error {
+	if c, ok := fw.W.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// OptionFraming is an option
+// used in every target which has fields named `Output`
+// and wraps the output with a FramedWriter of the framer,
+// it should be applied after the output is set
+func OptionFraming(framer Framer) Option {
+	return optFuncWraper(func(target interface{}) bool {
+		v := reflect.ValueOf(target).Elem()
+		f := v.FieldByName("Output")
+		if !f.IsValid() || f.IsNil() {
+			return false
+		}
+		w := reflect.ValueOf(NewFramedWriter(f.Interface().(io.Writer), framer))
+		if !w.Type().AssignableTo(f.Type()) {
+			return false
+		}
+		f.Set(w)
+		return true
+	})
+}
@@ -0,0 +1,57 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFramers(t *testing.T) {
+	assert.Equal(t, []byte("hello\n"), NewlineFramer.Frame("hello"))
+	assert.Equal(t, []byte("\x00\x00\x00\x05hello"), LengthPrefixFramer.Frame("hello"))
+	assert.Equal(t, []byte("5 hello"), OctetCountingFramer.Frame("hello"))
+	assert.Equal(t, []byte("0 "), OctetCountingFramer.Frame(""))
+}
+
+func TestOptionFraming(t *testing.T) {
+	var buf bytes.Buffer
+	hdlr := NewWriterHandler("", &buf, &TextFormatter{Fmt: "%(message)"}, NothingLevel, OptionFraming(OctetCountingFramer))
+	assert.IsType(t, &FramedWriter{}, hdlr.Output)
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "first"))
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "multi\nline"))
+	// newlines in messages are escaped by the formatter
+	assert.Equal(t, `5 first13 multi\x0aline`, buf.String())
+}
+
+func TestFramedWriterUDP(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer server.Close()
+	conn, err := net.Dial("udp", server.LocalAddr().String())
+	assert.Nil(t, err)
+
+	hdlr := NewStreamHandler(OptionOutput(conn), &TextFormatter{Fmt: "%(message)"}, OptionFraming(LengthPrefixFramer))
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "datagram"))
+	assert.Nil(t, hdlr.Close())
+
+	packet := make([]byte, 64)
+	n, _, err := server.ReadFrom(packet)
+	assert.Nil(t, err)
+	assert.Equal(t, "\x00\x00\x00\x08datagram", string(packet[:n]))
+}
@@ -15,10 +15,14 @@
 package logdog
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/zoumo/logdog/pkg/pythonic"
 )
@@ -27,15 +31,21 @@ var (
 	// Discard is an io.ReadWriteCloser on which all Read | Write | Close calls succeed
 	// without doing anything.
 	Discard = devNull(0)
+	// ErrHandlerClosed is reported for records emitted to
+	// a closed handler, they are dropped
+	ErrHandlerClosed = errors.New("handler is closed")
+	// DefaultFileMode is the permissions of files created by
+	// FileHandler and RotatingFileHandler if FileMode is zero
+	DefaultFileMode os.FileMode = 0660
 )
 
 type flusher interface {
 	Sync() error
 }
 
-type flushWriter interface {
-	io.Writer
-	flusher
+// bufferFlusher is implemented by buffered writers, e.g. bufio.Writer
+type bufferFlusher interface {
+	Flush() error
 }
 
 type flushWriteCloser interface {
@@ -63,9 +73,14 @@ func (devNull) Close() error {
 
 // Handler specifies how to write a LoadConfig, appropriately formatted, to output.
 type Handler interface {
-	// Filter checks if handler should filter the specified record
+	// Filter checks if handler should filter the specified record,
+	// true means drop it.
+	// Deprecated: the inverted semantics is error-prone, implement
+	// EmitFilter as well and let Filter return !ShouldEmit
 	Filter(*LogRecord) bool
-	// Emit log record to output - e.g. stderr or file
+	// Emit log record to output - e.g. stderr or file.
+	// Emit of built-in handlers only checks the level, the filter chain
+	// is run once by ShouldEmit, which the caller calls before Emit
 	Emit(*LogRecord)
 	// Flush flushes the file system's in-memory copy of recently written data to disk.
 	// Typically, calls the file.Sync()
@@ -74,6 +89,22 @@ type Handler interface {
 	Close() error
 }
 
+// EmitFilter decides if a record should be emitted,
+// ShouldEmit returns true means the record should be emitted
+type EmitFilter interface {
+	ShouldEmit(*LogRecord) bool
+}
+
+// ShouldEmit checks if the handler should emit the specified record.
+// It prefers hdlr.ShouldEmit if hdlr implements EmitFilter,
+// otherwise falls back to the legacy !hdlr.Filter
+func ShouldEmit(hdlr Handler, record *LogRecord) bool {
+	if f, ok := hdlr.(EmitFilter); ok {
+		return f.ShouldEmit(record)
+	}
+	return !hdlr.Filter(record)
+}
+
 // NullHandler is an example handler doing nothing
 type NullHandler struct {
 	Name string
@@ -90,9 +121,15 @@ func (hdlr *NullHandler) LoadConfig(config map[string]interface{}) error {
 	return nil
 }
 
-// Filter checks if handler should filter the specified record
-func (hdlr *NullHandler) Filter(*LogRecord) bool {
-	return true
+// ShouldEmit checks if handler should emit the specified record
+func (hdlr *NullHandler) ShouldEmit(*LogRecord) bool {
+	return false
+}
+
+// Filter checks if handler should filter the specified record.
+// Deprecated: use ShouldEmit instead
+func (hdlr *NullHandler) Filter(record *LogRecord) bool {
+	return !hdlr.ShouldEmit(record)
 }
 
 // Emit log record to output - e.g. stderr or file
@@ -110,26 +147,220 @@ func (hdlr *NullHandler) Close() error {
 	return nil
 }
 
-// StreamHandler is a handler which writes logging records,
-// appropriately formatted, to a stream.
-// Note that this handler does not close the stream,
-// as os.Stdout or os.Stderr may be used.
-type StreamHandler struct {
-	Name      string
+// flushOutput flushes buffered data in w if it supports Flush(),
+// then commits it to stable storage if it supports Sync()
+func flushOutput(w io.Writer) error {
+	var err error
+	if f, ok := w.(bufferFlusher); ok {
+		err = f.Flush()
+	}
+	if f, ok := w.(flusher); ok {
+		if serr := syncOutput(f); err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// syncOutput commits the content of f to stable storage. Syncing
+// os.Stdout or os.Stderr fails with EINVAL, ENOTSUP or ENOTTY when they
+// are attached to a terminal or a pipe, these errors are ignored
+func syncOutput(f flusher) error {
+	err := f.Sync()
+	if err == nil || (f != os.Stdout && f != os.Stderr) {
+		return err
+	}
+	if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.ENOTTY) {
+		return nil
+	}
+	return err
+}
+
+// writeRecord formats record by f into a pooled buffer, appends a newline
+// and writes it to w with a single Write call, returns the error of
+// formatting or writing
+func writeRecord(w io.Writer, f Formatter, record *LogRecord) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	var err error
+	*buf, err = AppendFormat(*buf, f, record)
+	if err != nil {
+		InternalError(fmt.Errorf("Format record failed, [%v]", err))
+		return err
+	}
+	*buf = append(*buf, '\n')
+	_, err = w.Write(*buf)
+	return err
+}
+
+// WriterHandler is a handler which writes logging records,
+// appropriately formatted, to an io.Writer, e.g. a bytes.Buffer,
+// a gzip.Writer or a pipe in tests.
+// Flush flushes the writer if it supports Flush() or Sync(),
+// Close closes the writer only if OwnsWriter is true.
+type WriterHandler struct {
+	Name string
+	// Deprecated: Level should not be changed directly while the
+	// handler is in use, use SetLevel and GetLevel instead
 	Level     Level
 	Formatter Formatter
-	Output    flushWriter
-	mu        sync.Mutex
+	// Deprecated: Output should not be changed directly while the
+	// handler is in use, use SetOutput and GetOutput instead
+	Output     io.Writer
+	OwnsWriter bool
+	// OnOpen is called with the output before the first record is
+	// written to it, and again after it is swapped by SetOutput,
+	// e.g. to write a banner or a CSV header row
+	OnOpen func(io.Writer) error
+	opened bool
+	mu     sync.Mutex
+	Filterer
+	StatsCounter
+}
+
+// NewWriterHandler returns a new WriterHandler fully initialized
+func NewWriterHandler(name string, w io.Writer, f Formatter, level Level, options ...Option) *WriterHandler {
+	hdlr := &WriterHandler{
+		Name:      name,
+		Output:    w,
+		Formatter: f,
+		Level:     level,
+	}
+
+	applyOptions(hdlr, options...)
+
+	return hdlr
+}
+
+// Emit log record to output - e.g. stderr or file
+func (hdlr *WriterHandler) Emit(record *LogRecord) {
+	if record.Level < hdlr.GetLevel() {
+		return
+	}
+
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+
+	if hdlr.Output == nil || hdlr.Formatter == nil {
+		panic("you should set output and fomatter before use this handler")
+	}
+
+	if !hdlr.opened {
+		hdlr.opened = true
+		callOnOpen(hdlr.OnOpen, hdlr.Output)
+	}
+	hdlr.count(writeRecord(hdlr.Output, hdlr.Formatter, record))
+}
+
+// Stats returns the statistics of the handler
+func (hdlr *WriterHandler) Stats() EmitStats {
+	stats := hdlr.StatsCounter.Stats()
+	stats.Suppressed = hdlr.Suppressed()
+	return stats
+}
+
+// callOnOpen calls onOpen with the output, a failure is printed
+// to stderr and does not prevent logging to the output
+func callOnOpen(onOpen func(io.Writer) error, w io.Writer) {
+	if onOpen == nil {
+		return
+	}
+	if err := onOpen(w); err != nil {
+		InternalError(fmt.Errorf("Call OnOpen failed, [%v]", err))
+	}
+}
+
+// ShouldEmit checks if handler should emit the specified record
+func (hdlr *WriterHandler) ShouldEmit(record *LogRecord) bool {
+	return record.Level >= hdlr.GetLevel() && hdlr.PassFilters(record)
+}
+
+// Filter checks if handler should filter the specified record.
+// Deprecated: use ShouldEmit instead
+func (hdlr *WriterHandler) Filter(record *LogRecord) bool {
+	return !hdlr.ShouldEmit(record)
+}
+
+// SetLevel sets the handler's level, it is safe to be called
+// while the handler is in use
+func (hdlr *WriterHandler) SetLevel(level Level) {
+	storeLevel(&hdlr.Level, level)
+}
+
+// GetLevel returns the handler's level
+func (hdlr *WriterHandler) GetLevel() Level {
+	return loadLevel(&hdlr.Level)
+}
+
+// SetOutput swaps the writer, it is safe to be called while the handler
+// is in use. The old writer is flushed but never closed, even if
+// OwnsWriter is true, it is returned for the caller to close it
+func (hdlr *WriterHandler) SetOutput(w io.Writer) (old io.Writer) {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	old = hdlr.Output
+	if old != nil {
+		flushOutput(old)
+	}
+	hdlr.Output = w
+	hdlr.opened = false
+	return old
+}
+
+// GetOutput returns the writer
+func (hdlr *WriterHandler) GetOutput() io.Writer {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	return hdlr.Output
+}
+
+// Flush flushes buffered data of the writer
+// and the file system's in-memory copy to disk
+func (hdlr *WriterHandler) Flush() error {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	return flushOutput(hdlr.Output)
+}
+
+// Close flushes the writer, and closes it if OwnsWriter is true,
+// returns the first error of flushing and closing
+func (hdlr *WriterHandler) Close() error {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	err := flushOutput(hdlr.Output)
+	if !hdlr.OwnsWriter {
+		return err
+	}
+	if c, ok := hdlr.Output.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// StreamHandler is a WriterHandler which writes logging records,
+// appropriately formatted, to a stream, os.Stderr with TerminalFormatter
+// by default.
+// Note that this handler does not close the stream by default,
+// as os.Stdout or os.Stderr may be used. Set OwnsWriter to true
+// if the stream is created specifically for this handler, e.g. a
+// gzip.Writer or net.Conn, the handler will close it on Close.
+type StreamHandler struct {
+	WriterHandler
 }
 
 // NewStreamHandler returns a new StreamHandler fully initialized
 func NewStreamHandler(options ...Option) *StreamHandler {
-	hdlr := &StreamHandler{
-		Name:      "",
-		Output:    os.Stderr,
-		Formatter: TerminalFormatter,
-		Level:     NothingLevel,
-	}
+	hdlr := &StreamHandler{}
+	hdlr.Name = ""
+	hdlr.Output = os.Stderr
+	hdlr.Formatter = TerminalFormatter
+	hdlr.Level = NothingLevel
+	// detect colors, e.g. enable ANSI escapes of Windows console,
+	// before anything is written to stderr
+	colorTerminal()
 
 	hdlr.ApplyOptions(options...)
 
@@ -138,9 +369,7 @@ func NewStreamHandler(options ...Option) *StreamHandler {
 
 // ApplyOptions applys all option to StreamHandler
 func (hdlr *StreamHandler) ApplyOptions(options ...Option) *StreamHandler {
-	for _, opt := range options {
-		opt.applyOption(hdlr)
-	}
+	applyOptions(hdlr, options...)
 	return hdlr
 }
 
@@ -163,58 +392,212 @@ func (hdlr *StreamHandler) LoadConfig(c map[string]interface{}) error {
 	}
 	hdlr.Formatter = formatter
 
+	if encoding := config.MustGetString("encoding", ""); encoding != "" {
+		if !OptionEncoding(encoding).applyOption(hdlr) {
+			return fmt.Errorf("can not apply encoding: %s", encoding)
+		}
+	}
+
 	return nil
 }
 
-// Emit log record to output - e.g. stderr or file
-func (hdlr *StreamHandler) Emit(record *LogRecord) {
-	if hdlr.Output == nil || hdlr.Formatter == nil {
-		panic("you should set output and fomatter before use this handler")
+// MultiWriterHandler is a StreamHandler variant which writes logging
+// records, appropriately formatted, to several writers.
+// Unlike io.MultiWriter, a broken writer does not stop writing to the
+// others, the error of each writer is reported by OnError, which prints
+// it to stderr if it is nil. Writers are closed on Close only if
+// OwnsWriter is true.
+type MultiWriterHandler struct {
+	Name string
+	// Deprecated: Level should not be changed directly while the
+	// handler is in use, use SetLevel and GetLevel instead
+	Level      Level
+	Formatter  Formatter
+	Writers    []io.Writer
+	OwnsWriter bool
+	// OnError is called with the index of the writer and the error
+	OnError func(i int, w io.Writer, err error)
+	mu      sync.Mutex
+	Filterer
+	StatsCounter
+}
+
+// NewMultiWriterHandler returns a new MultiWriterHandler fully initialized
+func NewMultiWriterHandler(writers []io.Writer, options ...Option) *MultiWriterHandler {
+	hdlr := &MultiWriterHandler{
+		Name:      "",
+		Level:     NothingLevel,
+		Formatter: TerminalFormatter,
+		Writers:   writers,
+	}
+
+	applyOptions(hdlr, options...)
+
+	return hdlr
+}
+
+func (hdlr *MultiWriterHandler) onError(i int, w io.Writer, err error) {
+	if hdlr.OnError != nil {
+		hdlr.OnError(i, w, err)
+		return
 	}
+	InternalError(fmt.Errorf("Write to writer %d failed, [%v]", i, err))
+}
 
-	if hdlr.Filter(record) {
+// Emit log record to all writers
+func (hdlr *MultiWriterHandler) Emit(record *LogRecord) {
+	if record.Level < hdlr.GetLevel() {
 		return
 	}
 
 	hdlr.mu.Lock()
 	defer hdlr.mu.Unlock()
 
-	msg, err := hdlr.Formatter.Format(record)
+	if hdlr.Formatter == nil {
+		panic("you should set fomatter before use this handler")
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	var err error
+	*buf, err = AppendFormat(*buf, hdlr.Formatter, record)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Format record failed, [%v]\n", err)
+		InternalError(fmt.Errorf("Format record failed, [%v]", err))
+		hdlr.CountFailed(err)
 		return
 	}
-	fmt.Fprintln(hdlr.Output, msg)
+	*buf = append(*buf, '\n')
+	line := *buf
+	var failed error
+	for i, w := range hdlr.Writers {
+		n, err := w.Write(line)
+		if err == nil && n < len(line) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			hdlr.onError(i, w, err)
+			failed = fmt.Errorf("write to writer %d failed, [%v]", i, err)
+		}
+	}
+	// a record is failed if any writer failed
+	hdlr.count(failed)
 }
 
-// Filter checks if handler should filter the specified record
-func (hdlr *StreamHandler) Filter(record *LogRecord) bool {
-	return record.Level < hdlr.Level
+// Stats returns the statistics of the handler
+func (hdlr *MultiWriterHandler) Stats() EmitStats {
+	stats := hdlr.StatsCounter.Stats()
+	stats.Suppressed = hdlr.Suppressed()
+	return stats
 }
 
-// Flush flushes the file system's in-memory copy to disk
-func (hdlr *StreamHandler) Flush() error {
-	return hdlr.Output.Sync()
+// ShouldEmit checks if handler should emit the specified record
+func (hdlr *MultiWriterHandler) ShouldEmit(record *LogRecord) bool {
+	return record.Level >= hdlr.GetLevel() && hdlr.PassFilters(record)
 }
 
-// Close output stream, if not return error
-func (hdlr *StreamHandler) Close() error {
-	hdlr.Output.Sync()
-	return nil
+// Filter checks if handler should filter the specified record.
+// Deprecated: use ShouldEmit instead
+func (hdlr *MultiWriterHandler) Filter(record *LogRecord) bool {
+	return !hdlr.ShouldEmit(record)
+}
+
+// SetLevel sets the handler's level, it is safe to be called
+// while the handler is in use
+func (hdlr *MultiWriterHandler) SetLevel(level Level) {
+	storeLevel(&hdlr.Level, level)
+}
+
+// GetLevel returns the handler's level
+func (hdlr *MultiWriterHandler) GetLevel() Level {
+	return loadLevel(&hdlr.Level)
+}
+
+// Flush flushes all writers, returns the first error
+func (hdlr *MultiWriterHandler) Flush() error {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	var first error
+	for _, w := range hdlr.Writers {
+		if err := flushOutput(w); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Close flushes all writers, and closes them if OwnsWriter is true,
+// returns the first error of flushing or closing
+func (hdlr *MultiWriterHandler) Close() error {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	var first error
+	for _, w := range hdlr.Writers {
+		if err := flushOutput(w); err != nil && first == nil {
+			first = err
+		}
+		if !hdlr.OwnsWriter {
+			continue
+		}
+		if c, ok := w.(io.Closer); ok {
+			if err := c.Close(); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
 }
 
 // FileHandler is a handler similar to SteamHandler
-// if specified file and it will close the file
+// if specified file and it will close the file.
+// The path may have time tokens, strftime directives or Go layouts in
+// {time:layout}, e.g. /var/log/app/%Y-%m-%d/app-%H.log, the path is
+// resolved by the time of records and the file is reopened once it
+// changes, missing directories are created. The path is resolved in
+// Location, which is independent of the time zone of formatters
 type FileHandler struct {
-	Name      string
+	Name string
+	// Deprecated: Level should not be changed directly while the
+	// handler is in use, use SetLevel and GetLevel instead
 	Level     Level
 	Formatter Formatter
 	Output    flushWriteCloser
-	Path      string
-	mu        sync.Mutex
-}
-
-// NewFileHandler returns a new FileHandler fully initialized
+	// Path is the path of the current file, it is resolved
+	// from PathPattern if the path has time tokens
+	Path        string
+	PathPattern string
+	// Location is the time zone of time tokens in the path, and of
+	// rotation names and daily rollover of RotatingFileHandler,
+	// nil means the time zone of record times. It should be set
+	// before SetPath, e.g. by OptionLocation
+	Location *time.Location
+	// HeaderFunc writes a header to every new file opened by the
+	// handler, e.g. CommentFileHeader, it should be set before
+	// the file is opened
+	HeaderFunc func(*os.File) error
+	// OnOpen is called with the file before the first record is
+	// written to it, and again after it is reopened or rotated.
+	// Unlike HeaderFunc, it is called for non-empty files too
+	OnOpen func(io.Writer) error
+	// FileMode is the permissions of files created by the handler,
+	// DefaultFileMode if zero, e.g. set by OptionFileMode
+	FileMode os.FileMode
+	opened   bool
+	// timePath is parsed from PathPattern, Path is resolved
+	// again once records are not earlier than nextPathTime
+	timePath     *timePath
+	nextPathTime time.Time
+	// closed is set by Close, records emitted after it are
+	// dropped and reported by InternalError
+	closed bool
+	mu     sync.Mutex
+	Filterer
+	StatsCounter
+}
+
+// NewFileHandler returns a new FileHandler fully initialized,
+// the file is opened by SetPath after all options are applied if
+// OptionPath is given, so it is ready to be shared once returned
 func NewFileHandler(options ...Option) *FileHandler {
 	fh := &FileHandler{
 		Output:    Discard,
@@ -224,15 +607,16 @@ func NewFileHandler(options ...Option) *FileHandler {
 	}
 
 	fh.ApplyOptions(options...)
+	if fh.Path != "" {
+		fh.SetPath(fh.Path)
+	}
 
 	return fh
 }
 
 // ApplyOptions applys all option to StreamHandler
 func (hdlr *FileHandler) ApplyOptions(options ...Option) *FileHandler {
-	for _, opt := range options {
-		opt.applyOption(hdlr)
-	}
+	applyOptions(hdlr, options...)
 	return hdlr
 }
 
@@ -247,6 +631,24 @@ func (hdlr *FileHandler) LoadConfig(c map[string]interface{}) error {
 	// get name
 	hdlr.Name = config.MustGetString("name", "")
 
+	switch header := config.MustGetString("header", ""); header {
+	case "":
+	case "comment":
+		hdlr.HeaderFunc = CommentFileHeader
+	case "json":
+		hdlr.HeaderFunc = JSONFileHeader
+	default:
+		return fmt.Errorf("unknown file header: %s", header)
+	}
+
+	if location := config.MustGetString("location", ""); location != "" {
+		loc, err := time.LoadLocation(location)
+		if err != nil {
+			return err
+		}
+		hdlr.Location = loc
+	}
+
 	// get path and file
 	path := config.MustGetString("filename", "")
 	hdlr.SetPath(path)
@@ -262,67 +664,221 @@ func (hdlr *FileHandler) LoadConfig(c map[string]interface{}) error {
 	}
 	hdlr.Formatter = formatter
 
+	if encoding := config.MustGetString("encoding", ""); encoding != "" {
+		if !OptionEncoding(encoding).applyOption(hdlr) {
+			return fmt.Errorf("can not apply encoding: %s", encoding)
+		}
+	}
+
 	return nil
 }
 
-// SetPath opens file located in the path, if not, create it
+// SetPath opens file located in the path, if not, create it.
+// A path with time tokens is resolved by the current time
 func (hdlr *FileHandler) SetPath(path string) *FileHandler {
 	if path == "" {
 		panic("Should provide a valid file path")
 	}
 
-	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0660)
+	tp := parseTimePath(path)
+	resolved, pattern, next := path, "", time.Time{}
+	if tp != nil {
+		now := hdlr.inLocation(Now())
+		resolved, pattern, next = tp.format(now), path, tp.next(now)
+		if err := os.MkdirAll(filepath.Dir(resolved), 0755); err != nil {
+			panic(fmt.Sprintf("Can not create directory of file %s", resolved))
+		}
+	}
+
+	file, err := os.OpenFile(resolved, os.O_WRONLY|os.O_APPEND|os.O_CREATE, hdlr.OpenMode())
 	if err != nil {
-		panic(fmt.Sprintf("Can not open file %s", path))
+		panic(fmt.Sprintf("Can not open file %s", resolved))
 	}
+	writeFileHeader(file, hdlr.HeaderFunc)
 
-	hdlr.Path = path
+	hdlr.mu.Lock()
+	hdlr.Path = resolved
+	hdlr.PathPattern = pattern
+	hdlr.timePath = tp
+	hdlr.nextPathTime = next
 	hdlr.Output = file
+	hdlr.opened = false
+	hdlr.closed = false
+	hdlr.mu.Unlock()
 
 	return hdlr
 }
 
-// Emit log record to file
-func (hdlr *FileHandler) Emit(record *LogRecord) {
-	if hdlr.Output == nil || hdlr.Formatter == nil {
-		panic("you should set output and fomatter before use this handler")
+// switchPath reopens the file if the path resolved at t changes,
+// records earlier than the current file do not switch back to
+// the file of their time. It must be called with mu held
+func (hdlr *FileHandler) switchPath(t time.Time) {
+	if t.IsZero() {
+		t = Now()
+	}
+	if t.Before(hdlr.nextPathTime) {
+		return
+	}
+	t = hdlr.inLocation(t)
+	hdlr.nextPathTime = hdlr.timePath.next(t)
+	path := hdlr.timePath.format(t)
+	if path == hdlr.Path {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		InternalError(fmt.Errorf("Create directory failed, [%v]", err))
+		return
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, hdlr.OpenMode())
+	if err != nil {
+		// keep writing to the old file
+		InternalError(fmt.Errorf("Open file failed, [%v]", err))
+		return
+	}
+	writeFileHeader(file, hdlr.HeaderFunc)
+
+	if hdlr.Output != nil {
+		if err := hdlr.Output.Close(); err != nil {
+			InternalError(fmt.Errorf("Close file failed, [%v]", err))
+		}
+	}
+	hdlr.Path = path
+	hdlr.Output = file
+	hdlr.opened = false
+}
+
+// OpenMode returns FileMode, or DefaultFileMode if it is zero
+func (hdlr *FileHandler) OpenMode() os.FileMode {
+	if hdlr.FileMode == 0 {
+		return DefaultFileMode
+	}
+	return hdlr.FileMode
+}
+
+// inLocation returns t in Location
+func (hdlr *FileHandler) inLocation(t time.Time) time.Time {
+	if hdlr.Location == nil {
+		return t
+	}
+	return t.In(hdlr.Location)
+}
+
+// Reopen reopens the file located in Path and closes the old one,
+// e.g. on SIGHUP after the file is moved by logrotate.
+// It returns ErrHandlerClosed if the handler is closed
+func (hdlr *FileHandler) Reopen() error {
+	hdlr.mu.Lock()
+	path, closed := hdlr.Path, hdlr.closed
+	hdlr.mu.Unlock()
+	if closed {
+		return ErrHandlerClosed
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, hdlr.OpenMode())
+	if err != nil {
+		return err
+	}
+	writeFileHeader(file, hdlr.HeaderFunc)
+
+	hdlr.mu.Lock()
+	if hdlr.closed {
+		// closed while opening
+		hdlr.mu.Unlock()
+		file.Close()
+		return ErrHandlerClosed
 	}
+	old := hdlr.Output
+	hdlr.Output = file
+	hdlr.opened = false
+	hdlr.mu.Unlock()
 
-	if hdlr.Filter(record) {
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+// Emit log record to file, records emitted after Close are
+// dropped and reported by InternalError
+func (hdlr *FileHandler) Emit(record *LogRecord) {
+	if record.Level < hdlr.GetLevel() {
 		return
 	}
 
 	hdlr.mu.Lock()
 	defer hdlr.mu.Unlock()
 
-	msg, err := hdlr.Formatter.Format(record)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Format record failed, [%v]\n", err)
+	if hdlr.closed {
+		InternalError(fmt.Errorf("Write file %s failed, [%v]", hdlr.Path, ErrHandlerClosed))
+		hdlr.CountDropped()
 		return
 	}
 
-	fmt.Fprintln(hdlr.Output, msg)
+	// Output is swapped by Reopen
+	if hdlr.Output == nil || hdlr.Formatter == nil {
+		panic("you should set output and fomatter before use this handler")
+	}
+
+	if hdlr.timePath != nil {
+		hdlr.switchPath(record.Time)
+	}
+	if !hdlr.opened {
+		hdlr.opened = true
+		callOnOpen(hdlr.OnOpen, hdlr.Output)
+	}
+	hdlr.count(writeRecord(hdlr.Output, hdlr.Formatter, record))
 }
 
-// Filter checks if handler should filter the specified record
+// Stats returns the statistics of the handler
+func (hdlr *FileHandler) Stats() EmitStats {
+	stats := hdlr.StatsCounter.Stats()
+	stats.Suppressed = hdlr.Suppressed()
+	return stats
+}
+
+// ShouldEmit checks if handler should emit the specified record
+func (hdlr *FileHandler) ShouldEmit(record *LogRecord) bool {
+	return record.Level >= hdlr.GetLevel() && hdlr.PassFilters(record)
+}
+
+// Filter checks if handler should filter the specified record.
+// Deprecated: use ShouldEmit instead
 func (hdlr *FileHandler) Filter(record *LogRecord) bool {
-	return record.Level < hdlr.Level
+	return !hdlr.ShouldEmit(record)
+}
+
+// SetLevel sets the handler's level, it is safe to be called
+// while the handler is in use
+func (hdlr *FileHandler) SetLevel(level Level) {
+	storeLevel(&hdlr.Level, level)
+}
+
+// GetLevel returns the handler's level
+func (hdlr *FileHandler) GetLevel() Level {
+	return loadLevel(&hdlr.Level)
 }
 
 // Flush flushes the file system's in-memory copy
 // of recently written data to disk.
 func (hdlr *FileHandler) Flush() error {
-	if hdlr.Output == nil {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	if hdlr.Output == nil || hdlr.closed {
 		return nil
 	}
 	return hdlr.Output.Sync()
 }
 
-// Close file, if not return error
+// Close file, if not return error. Closing a closed handler does
+// nothing, SetPath opens a new file and makes it usable again
 func (hdlr *FileHandler) Close() error {
-	if hdlr.Output == nil {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	if hdlr.Output == nil || hdlr.closed {
 		return nil
 	}
+	hdlr.closed = true
 	return hdlr.Output.Close()
 }
 
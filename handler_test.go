@@ -15,6 +15,14 @@
 package logdog
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -33,8 +41,8 @@ func TestStreamHandler(t *testing.T) {
 	assert.Equal(t, handler.Formatter, TerminalFormatter)
 	assert.True(t, handler.Filter(record))
 	assert.False(t, handler.Filter(record2))
-	// sync on stderr stdout will fail
-	assert.Error(t, handler.Flush())
+	// errors of syncing stderr stdout are ignored
+	assert.Nil(t, handler.Flush())
 	assert.Nil(t, handler.Close())
 
 }
@@ -73,6 +81,39 @@ func TestFileHandler(t *testing.T) {
 	assert.Nil(t, handler.Close())
 }
 
+func TestFileHandlerEmitAfterClose(t *testing.T) {
+	var errs []error
+	SetInternalErrorHandler(func(err error) {
+		errs = append(errs, err)
+	})
+	defer SetInternalErrorHandler(nil)
+
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	hdlr := NewFileHandler(&TextFormatter{Fmt: "%(message)"}).SetPath(path)
+	hdlr.Emit(NewLogRecord(name, InfoLevel, pathname, fun, line, "before"))
+	assert.Nil(t, hdlr.Close())
+	assert.Nil(t, hdlr.Close())
+
+	// late records are dropped
+	hdlr.Emit(NewLogRecord(name, InfoLevel, pathname, fun, line, "after"))
+	assert.Nil(t, hdlr.Flush())
+	assert.Equal(t, ErrHandlerClosed, hdlr.Reopen())
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), ErrHandlerClosed.Error())
+
+	// SetPath makes it usable again
+	hdlr.SetPath(path)
+	hdlr.Emit(NewLogRecord(name, InfoLevel, pathname, fun, line, "reopened"))
+	assert.Nil(t, hdlr.Close())
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "before\nreopened\n", string(data))
+}
+
 func TestFileHandlerApplyOption(t *testing.T) {
 	fmt := NewTextFormatter()
 	hdlr := NewStreamHandler(
@@ -87,9 +128,215 @@ func TestFileHandlerApplyOption(t *testing.T) {
 	assert.Equal(t, hdlr.Output, Discard)
 }
 
+// legacyHandler only implements the deprecated Filter
+type legacyHandler struct {
+	level Level
+}
+
+func (hdlr *legacyHandler) Filter(record *LogRecord) bool {
+	return record.Level < hdlr.level
+}
+
+func (hdlr *legacyHandler) Emit(*LogRecord) {}
+
+func (hdlr *legacyHandler) Flush() error {
+	return nil
+}
+
+func (hdlr *legacyHandler) Close() error {
+	return nil
+}
+
+func TestShouldEmit(t *testing.T) {
+	debug := NewLogRecord(name, DebugLevel, pathname, fun, line, "debug")
+	info := NewLogRecord(name, InfoLevel, pathname, fun, line, "info")
+
+	// new path
+	hdlr := NewStreamHandler(InfoLevel, OptionDiscardOutput())
+	assert.False(t, ShouldEmit(hdlr, debug))
+	assert.True(t, ShouldEmit(hdlr, info))
+	assert.False(t, ShouldEmit(NewNullHandler(), info))
+
+	// legacy path falls back to !Filter
+	legacy := &legacyHandler{level: InfoLevel}
+	assert.False(t, ShouldEmit(legacy, debug))
+	assert.True(t, ShouldEmit(legacy, info))
+}
+
 func TestHandlerInterface(t *testing.T) {
 	assert.Implements(t, (*Handler)(nil), NewStreamHandler())
 	assert.Implements(t, (*ConfigLoader)(nil), NewStreamHandler())
 	assert.Implements(t, (*Handler)(nil), NewFileHandler())
 	assert.Implements(t, (*ConfigLoader)(nil), NewFileHandler())
+	assert.Implements(t, (*EmitFilter)(nil), NewNullHandler())
+	assert.Implements(t, (*EmitFilter)(nil), NewStreamHandler())
+	assert.Implements(t, (*EmitFilter)(nil), NewFileHandler())
+	assert.Implements(t, (*Handler)(nil), NewWriterHandler("", nil, nil, NothingLevel))
+	assert.Implements(t, (*EmitFilter)(nil), NewWriterHandler("", nil, nil, NothingLevel))
+}
+
+type closeRecorder struct {
+	bytes.Buffer
+	flushed  bool
+	closed   bool
+	flushErr error
+}
+
+func (w *closeRecorder) Flush() error {
+	w.flushed = true
+	return w.flushErr
+}
+
+func (w *closeRecorder) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestStreamHandlerOwnsWriter(t *testing.T) {
+	out := &closeRecorder{}
+	hdlr := NewStreamHandler(OptionOutput(out))
+	assert.Nil(t, hdlr.Flush())
+	assert.True(t, out.flushed)
+	assert.Nil(t, hdlr.Close())
+	assert.False(t, out.closed)
+
+	out = &closeRecorder{}
+	hdlr = NewStreamHandler(OptionOutput(out), OptionOwnsWriter(true))
+	assert.True(t, hdlr.OwnsWriter)
+	assert.Nil(t, hdlr.Close())
+	assert.True(t, out.flushed)
+	assert.True(t, out.closed)
+}
+
+func TestWriterHandler(t *testing.T) {
+	out := &closeRecorder{}
+	hdlr := NewWriterHandler("writer", out, &TextFormatter{Fmt: "%(message)"}, InfoLevel)
+	assert.Equal(t, "writer", hdlr.Name)
+
+	hdlr.Emit(NewLogRecord("test", DebugLevel, "a/b.go", "a.b", 1, "filtered"))
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "written"))
+	assert.Equal(t, "written\n", out.String())
+
+	assert.Nil(t, hdlr.Flush())
+	assert.True(t, out.flushed)
+	assert.Nil(t, hdlr.Close())
+	assert.False(t, out.closed)
+
+	out = &closeRecorder{}
+	hdlr = NewWriterHandler("", out, DefaultFormatter, NothingLevel, OptionOwnsWriter(true))
+	assert.Nil(t, hdlr.Close())
+	assert.True(t, out.closed)
+
+	// the flush error is returned, and the writer is still closed
+	out = &closeRecorder{flushErr: errors.New("disk full")}
+	hdlr = NewWriterHandler("", out, DefaultFormatter, NothingLevel, OptionOwnsWriter(true))
+	assert.EqualError(t, hdlr.Close(), "disk full")
+	assert.True(t, out.closed)
+}
+
+type syncRecorder struct {
+	err error
+}
+
+func (w *syncRecorder) Sync() error {
+	return w.err
+}
+
+func TestSyncOutput(t *testing.T) {
+	einval := &os.PathError{Op: "sync", Path: "/dev/stdout", Err: syscall.EINVAL}
+	assert.Equal(t, einval, syncOutput(&syncRecorder{err: einval}))
+	assert.Nil(t, syncOutput(&syncRecorder{}))
+}
+
+func TestWriterHandlerSetOutput(t *testing.T) {
+	first := &closeRecorder{}
+	hdlr := NewStreamHandler(OptionOutput(first), &TextFormatter{Fmt: "%(message)"}, OptionOwnsWriter(true))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "msg"))
+			}
+		}()
+	}
+	second := &bytes.Buffer{}
+	old := hdlr.SetOutput(second)
+	wg.Wait()
+
+	assert.Equal(t, first, old)
+	assert.True(t, first.flushed)
+	assert.False(t, first.closed)
+	assert.Equal(t, second, hdlr.GetOutput())
+	assert.Equal(t, 400*len("msg\n"), first.Len()+second.Len())
+}
+
+// brokenWriter fails all writes
+type brokenWriter struct{}
+
+func (brokenWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestWriterHandlerOnOpen(t *testing.T) {
+	var first, second bytes.Buffer
+	banner := func(w io.Writer) error {
+		_, err := io.WriteString(w, "level,message\n")
+		return err
+	}
+	hdlr := NewWriterHandler("", &first, &TextFormatter{Fmt: "%(levelname),%(message)"}, InfoLevel, OptionOnOpen(banner))
+
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "1"))
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "2"))
+	assert.Equal(t, 3, bytes.Count(first.Bytes(), []byte("\n")))
+	assert.Equal(t, 1, bytes.Count(first.Bytes(), []byte("level,message")))
+
+	// called again for the new output
+	hdlr.SetOutput(&second)
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "3"))
+	assert.True(t, bytes.HasPrefix(second.Bytes(), []byte("level,message\n")))
+
+	// a failure does not prevent logging
+	var third bytes.Buffer
+	hdlr.OnOpen = func(io.Writer) error { return errors.New("failed") }
+	hdlr.SetOutput(&third)
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "4"))
+	assert.Contains(t, third.String(), "INFO,4")
+}
+
+func TestMultiWriterHandler(t *testing.T) {
+	first, second := &closeRecorder{}, &closeRecorder{}
+	var failed []int
+	hdlr := NewMultiWriterHandler(
+		[]io.Writer{first, brokenWriter{}, second},
+		&TextFormatter{Fmt: "%(message)"},
+		OptionOwnsWriter(true),
+	)
+	hdlr.OnError = func(i int, w io.Writer, err error) {
+		failed = append(failed, i)
+	}
+	assert.Implements(t, (*Handler)(nil), hdlr)
+
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "msg"))
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "msg"))
+	assert.Equal(t, "msg\nmsg\n", first.String())
+	assert.Equal(t, "msg\nmsg\n", second.String())
+	assert.Equal(t, []int{1, 1}, failed)
+
+	assert.Nil(t, hdlr.Flush())
+	assert.True(t, first.flushed)
+	assert.Nil(t, hdlr.Close())
+	assert.True(t, first.closed)
+	assert.True(t, second.closed)
+}
+
+func TestStreamHandlerAllocs(t *testing.T) {
+	hdlr := NewStreamHandler(OptionDiscardOutput(), DefaultFormatter)
+	record := NewLogRecord(name, InfoLevel, pathname, fun, line, "", "test")
+	allocs := testing.AllocsPerRun(100, func() {
+		hdlr.Emit(record)
+	})
+	assert.True(t, allocs <= 1, "allocs per Emit: %v", allocs)
 }
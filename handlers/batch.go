@@ -0,0 +1,196 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zoumo/logdog"
+)
+
+// testHookBatchTaken is called after a batch is taken out and
+// before it is flushed, tests delay flushes by it
+var testHookBatchTaken = func() {}
+
+// batcher buffers items and flushes them through flushFunc
+// when the buffer is full or the interval elapsed
+type batcher struct {
+	size      int
+	flushFunc func([]interface{}) error
+
+	mu    sync.Mutex
+	items []interface{}
+	// bytes is the total size of items added by AddSized
+	bytes int
+	// cutSeq is the ticket of the next batch taken out under mu,
+	// batches are flushed in the order of their tickets
+	cutSeq uint64
+	// flushMu serializes flushFunc calls, flushSeq is the ticket
+	// of the next batch to flush, flushCond is signaled after
+	// every flush
+	flushMu   sync.Mutex
+	flushSeq  uint64
+	flushCond *sync.Cond
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// newBatcher returns a new batcher, if interval > 0, a goroutine
+// flushes the buffer periodically until the batcher is closed
+func newBatcher(size int, interval time.Duration, flushFunc func([]interface{}) error) *batcher {
+	if size <= 0 {
+		size = 1
+	}
+	b := &batcher{
+		size:      size,
+		flushFunc: flushFunc,
+		items:     make([]interface{}, 0, size),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	b.flushCond = sync.NewCond(&b.flushMu)
+
+	if interval > 0 {
+		go b.loop(interval)
+	} else {
+		close(b.done)
+	}
+
+	return b
+}
+
+func (b *batcher) loop(interval time.Duration) {
+	defer close(b.done)
+	ticker := logdog.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.Chan():
+			if err := b.Flush(); err != nil {
+				logdog.InternalError(fmt.Errorf("Flush batch failed, [%v]", err))
+			}
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// Add appends item to buffer, and flushes the buffer if it is full.
+// The batch is taken out under the lock and flushed outside it,
+// so other Adds are not blocked by flushFunc, batches are still
+// flushed in the order they were taken out
+func (b *batcher) Add(item interface{}) error {
+	return b.AddSized(item, 0, 0)
+}
+
+// AddSized is like Add, but item is size bytes and the buffer is flushed
+// before the total size would exceed maxBytes, an item larger than
+// maxBytes is flushed in a batch of its own. maxBytes <= 0 means no limit
+func (b *batcher) AddSized(item interface{}, size, maxBytes int) error {
+	b.mu.Lock()
+	// items are flushed before next
+	var items, next []interface{}
+	switch {
+	case maxBytes > 0 && size > maxBytes:
+		items, next = b.take(), []interface{}{item}
+	case maxBytes > 0 && b.bytes+size > maxBytes:
+		items = b.take()
+		fallthrough
+	default:
+		b.items = append(b.items, item)
+		b.bytes += size
+		if len(b.items) >= b.size {
+			next = b.take()
+		}
+	}
+	if len(items) == 0 && len(next) == 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	ticket := b.ticket()
+	b.mu.Unlock()
+
+	testHookBatchTaken()
+	return b.flush(ticket, items, next)
+}
+
+// Flush flushes all buffered items
+func (b *batcher) Flush() error {
+	b.mu.Lock()
+	items := b.take()
+	if len(items) == 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	ticket := b.ticket()
+	b.mu.Unlock()
+	return b.flush(ticket, items)
+}
+
+// ticket returns the ticket of the batches taken out, b.mu must be held
+func (b *batcher) ticket() uint64 {
+	ticket := b.cutSeq
+	b.cutSeq++
+	return ticket
+}
+
+// take takes the buffered items out, b.mu must be held
+func (b *batcher) take() []interface{} {
+	if len(b.items) == 0 {
+		return nil
+	}
+	items := b.items
+	b.items = make([]interface{}, 0, b.size)
+	b.bytes = 0
+	return items
+}
+
+// flush waits for the turn of ticket and calls flushFunc with every
+// non-empty batch, the first error is returned
+func (b *batcher) flush(ticket uint64, batches ...[]interface{}) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	for b.flushSeq != ticket {
+		b.flushCond.Wait()
+	}
+	defer func() {
+		b.flushSeq++
+		b.flushCond.Broadcast()
+	}()
+
+	var err error
+	for _, items := range batches {
+		if len(items) == 0 {
+			continue
+		}
+		if ferr := b.flushFunc(items); err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
+
+// Close stops the periodic flushing and flushes the final partial batch
+func (b *batcher) Close() error {
+	b.once.Do(func() {
+		close(b.stop)
+	})
+	<-b.done
+	return b.Flush()
+}
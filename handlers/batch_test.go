@@ -0,0 +1,173 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zoumo/logdog"
+)
+
+func TestBatcherAddDoesNotWaitForFlush(t *testing.T) {
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+	flushed := make(chan []interface{}, 2)
+	b := newBatcher(2, 0, func(items []interface{}) error {
+		entered <- struct{}{}
+		<-release
+		flushed <- items
+		return nil
+	})
+
+	assert.Nil(t, b.Add(1))
+	go b.Add(2)
+	<-entered
+
+	// the full batch is being flushed, adding to the next one does not block
+	added := make(chan error)
+	go func() {
+		added <- b.Add(3)
+	}()
+	select {
+	case err := <-added:
+		assert.Nil(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Add blocked by a running flush")
+	}
+
+	close(release)
+	assert.Equal(t, []interface{}{1, 2}, <-flushed)
+	assert.Nil(t, b.Close())
+	assert.Equal(t, []interface{}{3}, <-flushed)
+}
+
+func TestBatcherFlushInTakenOrder(t *testing.T) {
+	// the first batch is delayed between being taken out and flushed
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	var calls int32
+	testHookBatchTaken = func() {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(entered)
+			<-release
+		}
+	}
+	defer func() { testHookBatchTaken = func() {} }()
+
+	var mu sync.Mutex
+	var flushed []interface{}
+	b := newBatcher(1, 0, func(items []interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		flushed = append(flushed, items...)
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		b.Add(1)
+	}()
+	<-entered
+	go func() {
+		defer wg.Done()
+		b.Add(2)
+	}()
+
+	// the second batch waits for the first one
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	assert.Empty(t, flushed)
+	mu.Unlock()
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, []interface{}{1, 2}, flushed)
+}
+
+func TestBatcherFlushOrder(t *testing.T) {
+	const workers, perWorker = 8, 1000
+	type item struct{ worker, seq int }
+	var flushed []item
+	b := newBatcher(3, 0, func(items []interface{}) error {
+		for _, v := range items {
+			flushed = append(flushed, v.(item))
+		}
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				b.Add(item{w, i})
+			}
+		}(w)
+	}
+	wg.Wait()
+	assert.Nil(t, b.Close())
+
+	// the items of every worker are flushed in the order they were added
+	assert.Len(t, flushed, workers*perWorker)
+	next := make([]int, workers)
+	for _, v := range flushed {
+		if v.seq != next[v.worker] {
+			t.Fatalf("item %d of worker %d flushed before item %d", v.seq, v.worker, next[v.worker])
+		}
+		next[v.worker]++
+	}
+}
+
+// tickClock is a logdog.TickerClock whose tickers tick on ticks
+type tickClock struct {
+	ticks chan time.Time
+}
+
+func (c *tickClock) Now() time.Time {
+	return time.Now()
+}
+
+func (c *tickClock) NewTicker(time.Duration) logdog.Ticker {
+	return c
+}
+
+func (c *tickClock) Chan() <-chan time.Time {
+	return c.ticks
+}
+
+func (c *tickClock) Stop() {}
+
+func TestBatcherFollowsClock(t *testing.T) {
+	clock := &tickClock{ticks: make(chan time.Time)}
+	logdog.SetClock(clock)
+	defer logdog.SetClock(nil)
+
+	flushed := make(chan []interface{}, 1)
+	b := newBatcher(10, time.Hour, func(items []interface{}) error {
+		flushed <- items
+		return nil
+	})
+	assert.Nil(t, b.Add(1))
+	clock.ticks <- time.Now()
+	assert.Equal(t, []interface{}{1}, <-flushed)
+	assert.Nil(t, b.Close())
+}
@@ -0,0 +1,253 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zoumo/logdog"
+)
+
+const (
+	// DefaultCloudWatchFlushInterval is the default interval
+	// between two periodic flushes
+	DefaultCloudWatchFlushInterval = 5 * time.Second
+	// DefaultCloudWatchRetries is the default number of retries
+	// of a batch rejected for an invalid sequence token
+	DefaultCloudWatchRetries = 3
+
+	// limits of PutLogEvents
+	cloudWatchMaxBatchCount = 10000
+	cloudWatchMaxBatchBytes = 1048576
+	cloudWatchMaxBatchSpan  = 24 * time.Hour
+	cloudWatchEventOverhead = 26
+	cloudWatchMaxEventBytes = 256*1024 - cloudWatchEventOverhead
+)
+
+var (
+	// ErrCloudWatchStreamNotFound should be returned by CloudWatchClient
+	// if the log group or stream does not exist,
+	// i.e. ResourceNotFoundException
+	ErrCloudWatchStreamNotFound = errors.New("cloudwatch log stream not found")
+	// ErrCloudWatchStreamExists should be returned by CloudWatchClient
+	// if the log stream to create already exists,
+	// i.e. ResourceAlreadyExistsException
+	ErrCloudWatchStreamExists = errors.New("cloudwatch log stream already exists")
+)
+
+// CloudWatchInvalidTokenError should be returned by CloudWatchClient
+// if the sequence token is rejected, i.e. InvalidSequenceTokenException
+// or DataAlreadyAcceptedException
+type CloudWatchInvalidTokenError struct {
+	ExpectedSequenceToken string
+}
+
+func (e *CloudWatchInvalidTokenError) Error() string {
+	return fmt.Sprintf("invalid cloudwatch sequence token, expected %q", e.ExpectedSequenceToken)
+}
+
+// CloudWatchEvent is a log event of PutLogEvents
+type CloudWatchEvent struct {
+	// Timestamp is milliseconds since unix epoch
+	Timestamp int64
+	Message   string
+}
+
+// CloudWatchClient is the subset of CloudWatch Logs API used by
+// CloudWatchHandler, so logdog does not depend on the aws sdk.
+// Adapters should convert aws errors to ErrCloudWatchStreamNotFound,
+// ErrCloudWatchStreamExists and *CloudWatchInvalidTokenError
+type CloudWatchClient interface {
+	CreateLogStream(group, stream string) error
+	// PutLogEvents returns the next sequence token
+	PutLogEvents(group, stream string, events []CloudWatchEvent, sequenceToken string) (string, error)
+}
+
+// CloudWatchHandler is a handler which pushes logging records to
+// a CloudWatch Logs stream, the stream is created if it is absent.
+// Records are batched, the batch is flushed when it has 10000 events,
+// every FlushInterval and on Close. A batch is split by the limits of
+// PutLogEvents, 1MB and 24 hours, and is retried at most Retries times
+// if the sequence token is rejected. Messages longer than 256KB are
+// truncated.
+type CloudWatchHandler struct {
+	Name      string
+	Formatter logdog.Formatter
+	Client    CloudWatchClient
+	Group     string
+	Stream    string
+	Retries   int
+	// MaxBatchBytes limits the size of one PutLogEvents call below the
+	// limit of CloudWatch, an event larger than it is sent alone,
+	// 0 means the limit of CloudWatch
+	MaxBatchBytes int
+
+	// token and created are accessed only by put,
+	// which is serialized by batch
+	token   string
+	created bool
+	batch   *batcher
+	logdog.LevelFilterer
+}
+
+// NewCloudWatchHandler returns a new CloudWatchHandler fully initialized,
+// flushInterval <= 0 means DefaultCloudWatchFlushInterval
+func NewCloudWatchHandler(client CloudWatchClient, group, stream string, flushInterval time.Duration, options ...logdog.Option) *CloudWatchHandler {
+	if flushInterval <= 0 {
+		flushInterval = DefaultCloudWatchFlushInterval
+	}
+
+	hdlr := &CloudWatchHandler{
+		Name:      "",
+		Formatter: logdog.NewJSONFormatter(),
+		Client:    client,
+		Group:     group,
+		Stream:    stream,
+		Retries:   DefaultCloudWatchRetries,
+	}
+	hdlr.batch = newBatcher(cloudWatchMaxBatchCount, flushInterval, hdlr.put)
+
+	if err := logdog.ApplyOptionsTo(hdlr, options...); err != nil {
+		logdog.InternalError(err)
+	}
+
+	return hdlr
+}
+
+// Emit adds the log record to the batch
+func (hdlr *CloudWatchHandler) Emit(record *logdog.LogRecord) {
+	if hdlr.Client == nil || hdlr.Formatter == nil {
+		panic("you should set client and fomatter before use this handler")
+	}
+
+	if record.Level < hdlr.GetLevel() {
+		return
+	}
+
+	msg, err := hdlr.Formatter.Format(record)
+	if err != nil {
+		logdog.InternalError(fmt.Errorf("Format record failed, [%v]", err))
+		return
+	}
+	if len(msg) > cloudWatchMaxEventBytes {
+		msg = msg[:cloudWatchMaxEventBytes]
+	}
+
+	event := CloudWatchEvent{
+		Timestamp: record.Time.UnixNano() / int64(time.Millisecond),
+		Message:   msg,
+	}
+	if err := hdlr.batch.Add(event); err != nil {
+		logdog.InternalError(fmt.Errorf("Put log events failed, [%v]", err))
+	}
+}
+
+// put sends the items in chronological order,
+// in as few PutLogEvents calls as the limits allow
+func (hdlr *CloudWatchHandler) put(items []interface{}) error {
+	events := make([]CloudWatchEvent, len(items))
+	for i, item := range items {
+		events[i] = item.(CloudWatchEvent)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp < events[j].Timestamp
+	})
+
+	maxBytes := cloudWatchMaxBatchBytes
+	if hdlr.MaxBatchBytes > 0 && hdlr.MaxBatchBytes < maxBytes {
+		maxBytes = hdlr.MaxBatchBytes
+	}
+	maxSpan := int64(cloudWatchMaxBatchSpan / time.Millisecond)
+	for len(events) > 0 {
+		n, size := 0, 0
+		for n < len(events) {
+			eventSize := len(events[n].Message) + cloudWatchEventOverhead
+			if size+eventSize > maxBytes ||
+				events[n].Timestamp-events[0].Timestamp >= maxSpan {
+				break
+			}
+			size += eventSize
+			n++
+		}
+		if n == 0 {
+			// the event is larger than maxBytes
+			n = 1
+		}
+		if err := hdlr.putBatch(events[:n]); err != nil {
+			return err
+		}
+		events = events[n:]
+	}
+	return nil
+}
+
+// putBatch sends one batch, it creates the stream if it is absent
+// and retries with the expected sequence token
+func (hdlr *CloudWatchHandler) putBatch(events []CloudWatchEvent) error {
+	if !hdlr.created {
+		if err := hdlr.createStream(); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	for i := 0; i <= hdlr.Retries; i++ {
+		var token string
+		token, err = hdlr.Client.PutLogEvents(hdlr.Group, hdlr.Stream, events, hdlr.token)
+		if err == nil {
+			hdlr.token = token
+			return nil
+		}
+		switch e := err.(type) {
+		case *CloudWatchInvalidTokenError:
+			hdlr.token = e.ExpectedSequenceToken
+		default:
+			if err != ErrCloudWatchStreamNotFound {
+				return err
+			}
+			// the stream was deleted
+			if err := hdlr.createStream(); err != nil {
+				return err
+			}
+		}
+	}
+	return err
+}
+
+func (hdlr *CloudWatchHandler) createStream() error {
+	err := hdlr.Client.CreateLogStream(hdlr.Group, hdlr.Stream)
+	if err != nil && err != ErrCloudWatchStreamExists {
+		return err
+	}
+	if err == nil {
+		// a new stream does not need a sequence token
+		hdlr.token = ""
+	}
+	hdlr.created = true
+	return nil
+}
+
+// Flush puts all batched events
+func (hdlr *CloudWatchHandler) Flush() error {
+	return hdlr.batch.Flush()
+}
+
+// Close stops the periodic flushing and puts the final partial batch
+func (hdlr *CloudWatchHandler) Close() error {
+	return hdlr.batch.Close()
+}
@@ -0,0 +1,166 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zoumo/logdog"
+)
+
+// fakeCloudWatch validates sequence tokens like CloudWatch Logs
+type fakeCloudWatch struct {
+	mu      sync.Mutex
+	streams map[string]int // stream -> next token
+	creates int
+	puts    int
+	batches [][]CloudWatchEvent
+}
+
+func newFakeCloudWatch(streams ...string) *fakeCloudWatch {
+	c := &fakeCloudWatch{streams: make(map[string]int)}
+	for _, stream := range streams {
+		c.streams[stream] = 7
+	}
+	return c
+}
+
+func (c *fakeCloudWatch) CreateLogStream(group, stream string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.creates++
+	if _, ok := c.streams[stream]; ok {
+		return ErrCloudWatchStreamExists
+	}
+	c.streams[stream] = 0
+	return nil
+}
+
+func (c *fakeCloudWatch) PutLogEvents(group, stream string, events []CloudWatchEvent, token string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.puts++
+	next, ok := c.streams[stream]
+	if !ok {
+		return "", ErrCloudWatchStreamNotFound
+	}
+	expected := ""
+	if next > 0 {
+		expected = strconv.Itoa(next)
+	}
+	if token != expected {
+		return "", &CloudWatchInvalidTokenError{ExpectedSequenceToken: expected}
+	}
+	c.batches = append(c.batches, events)
+	c.streams[stream] = next + 1
+	return strconv.Itoa(next + 1), nil
+}
+
+func TestCloudWatchHandler(t *testing.T) {
+	client := newFakeCloudWatch()
+	hdlr := NewCloudWatchHandler(client, "group", "stream", time.Hour, &logdog.TextFormatter{Fmt: "%(message)"}, logdog.InfoLevel)
+
+	now := time.Now()
+	second := logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "second")
+	second.Time = now
+	first := logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "first")
+	first.Time = now.Add(-time.Second)
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.DebugLevel, "a/b.go", "a.b", 1, "filtered"))
+	hdlr.Emit(second)
+	hdlr.Emit(first)
+	assert.Nil(t, hdlr.Flush())
+
+	assert.Equal(t, 1, client.creates)
+	assert.Len(t, client.batches, 1)
+	// events are sorted chronologically
+	assert.Equal(t, []CloudWatchEvent{
+		{Timestamp: first.Time.UnixNano() / int64(time.Millisecond), Message: "first"},
+		{Timestamp: second.Time.UnixNano() / int64(time.Millisecond), Message: "second"},
+	}, client.batches[0])
+
+	// the next token is used, the final partial batch is flushed on close
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "third"))
+	assert.Nil(t, hdlr.Close())
+	assert.Len(t, client.batches, 2)
+	assert.Equal(t, 2, client.puts)
+	assert.Equal(t, 1, client.creates)
+}
+
+func TestCloudWatchHandlerInvalidToken(t *testing.T) {
+	// the stream exists and its token is unknown
+	client := newFakeCloudWatch("stream")
+	hdlr := NewCloudWatchHandler(client, "group", "stream", time.Hour)
+
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "retried"))
+	assert.Nil(t, hdlr.Close())
+	assert.Len(t, client.batches, 1)
+	assert.Equal(t, 2, client.puts)
+
+	// retries are bounded
+	client = newFakeCloudWatch("stream")
+	hdlr = NewCloudWatchHandler(client, "group", "stream", time.Hour)
+	hdlr.Retries = 0
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "dropped"))
+	assert.IsType(t, &CloudWatchInvalidTokenError{}, hdlr.Close())
+}
+
+func TestCloudWatchHandlerBatchLimits(t *testing.T) {
+	client := newFakeCloudWatch()
+	hdlr := NewCloudWatchHandler(client, "group", "stream", time.Hour, &logdog.TextFormatter{Fmt: "%(message)"})
+
+	big := strings.Repeat("x", 300*1024)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		record := logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, big)
+		record.Time = now
+		hdlr.Emit(record)
+	}
+	// a day later
+	record := logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "tomorrow")
+	record.Time = now.Add(25 * time.Hour)
+	hdlr.Emit(record)
+	assert.Nil(t, hdlr.Close())
+
+	// 256KB events, 4 of them fit in 1MB
+	assert.Len(t, client.batches, 3)
+	assert.Len(t, client.batches[0], 4)
+	assert.Len(t, client.batches[1], 1)
+	assert.Len(t, client.batches[2], 1)
+	assert.Len(t, client.batches[0][0].Message, cloudWatchMaxEventBytes)
+}
+
+func TestCloudWatchHandlerMaxBatchBytes(t *testing.T) {
+	client := newFakeCloudWatch()
+	hdlr := NewCloudWatchHandler(client, "group", "stream", time.Hour, &logdog.TextFormatter{Fmt: "%(message)"})
+	hdlr.MaxBatchBytes = 2 * (10 + cloudWatchEventOverhead)
+
+	for _, msg := range []string{"0123456789", "0123456789", "0123456789", strings.Repeat("x", 100), "end"} {
+		hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, msg))
+	}
+	assert.Nil(t, hdlr.Close())
+
+	// an over-limit event is sent alone
+	assert.Len(t, client.batches, 4)
+	assert.Len(t, client.batches[0], 2)
+	assert.Len(t, client.batches[1], 1)
+	assert.Len(t, client.batches[2], 1)
+	assert.Len(t, client.batches[2][0].Message, 100)
+	assert.Equal(t, "end", client.batches[3][0].Message)
+}
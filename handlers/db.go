@@ -0,0 +1,374 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zoumo/logdog"
+)
+
+const (
+	// DefaultDBBatchSize is the default number of rows
+	// inserted in one transaction
+	DefaultDBBatchSize = 100
+	// DefaultDBFlushInterval is the default interval
+	// between two periodic inserts
+	DefaultDBFlushInterval = time.Second
+	// DefaultDBPruneInterval is the default interval
+	// between two prunings of expired rows
+	DefaultDBPruneInterval = time.Minute
+	// DefaultDBBufferSize is the default number of rows buffered
+	// waiting for insertion
+	DefaultDBBufferSize = 4096
+)
+
+// DBColumns maps parts of the record to column names,
+// empty names mean the ones of DefaultDBColumns
+type DBColumns struct {
+	Time    string
+	Level   string
+	Logger  string
+	Message string
+	// Fields stores fields as JSON
+	Fields string
+}
+
+// DefaultDBColumns is the default column mapping of DBHandler
+var DefaultDBColumns = DBColumns{
+	Time:    "time",
+	Level:   "level",
+	Logger:  "logger",
+	Message: "message",
+	Fields:  "fields",
+}
+
+// QuestionBindVar returns the placeholder of mysql and sqlite, ?
+func QuestionBindVar(n int) string {
+	return "?"
+}
+
+// DollarBindVar returns the placeholder of postgres, $n
+func DollarBindVar(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// dbRow is a buffered row, or a flush request if flushed is not nil
+type dbRow struct {
+	time    time.Time
+	level   string
+	logger  string
+	message string
+	fields  string
+	flushed chan error
+}
+
+// DBHandler is a handler which inserts logging records into a table of
+// a sql database, so recent logs can be queried with plain SQL.
+// logdog does not depend on any driver, register one and open the DB.
+// Rows are inserted by a background goroutine in batches inside
+// transactions, when the batch is full, every FlushInterval and on
+// Flush and Close. A failed batch is reported by OnError and retried
+// once before being dropped. Emit never blocks, rows are dropped when
+// BufferSize rows are waiting.
+// If CreateTable is set, the table is created on first use, and if
+// Retention > 0, rows older than it are deleted every PruneInterval.
+// Fields should be set before the first Emit, Close does not close the DB.
+type DBHandler struct {
+	Name    string
+	DB      *sql.DB
+	Table   string
+	Columns DBColumns
+	// BindVar returns the placeholder of the nth (from 1) argument,
+	// nil means QuestionBindVar
+	BindVar       func(n int) string
+	CreateTable   bool
+	Retention     time.Duration
+	PruneInterval time.Duration
+	BatchSize     int
+	FlushInterval time.Duration
+	BufferSize    int
+	// OnError is called with insert and prune errors,
+	// errors are printed to stderr if it is nil
+	OnError func(err error)
+
+	queue   chan dbRow
+	dropped uint64
+	start   sync.Once
+	mu      sync.RWMutex
+	closed  bool
+	done    chan struct{}
+	err     error
+	logdog.LevelFilterer
+}
+
+// NewDBHandler returns a new DBHandler inserting into the table,
+// empty column names mean the ones of DefaultDBColumns
+func NewDBHandler(db *sql.DB, table string, columns DBColumns, options ...logdog.Option) *DBHandler {
+	hdlr := &DBHandler{
+		Name:          "",
+		DB:            db,
+		Table:         table,
+		Columns:       columns,
+		PruneInterval: DefaultDBPruneInterval,
+		BatchSize:     DefaultDBBatchSize,
+		FlushInterval: DefaultDBFlushInterval,
+		BufferSize:    DefaultDBBufferSize,
+		done:          make(chan struct{}),
+	}
+
+	if err := logdog.ApplyOptionsTo(hdlr, options...); err != nil {
+		logdog.InternalError(err)
+	}
+
+	return hdlr
+}
+
+// init starts the background goroutine on first use
+func (hdlr *DBHandler) init() {
+	hdlr.start.Do(func() {
+		if hdlr.DB == nil || hdlr.Table == "" {
+			panic("you should set db and table before use this handler")
+		}
+		columns := []*string{&hdlr.Columns.Time, &hdlr.Columns.Level, &hdlr.Columns.Logger, &hdlr.Columns.Message, &hdlr.Columns.Fields}
+		defaults := []string{DefaultDBColumns.Time, DefaultDBColumns.Level, DefaultDBColumns.Logger, DefaultDBColumns.Message, DefaultDBColumns.Fields}
+		for i, c := range columns {
+			if *c == "" {
+				*c = defaults[i]
+			}
+		}
+		if hdlr.BindVar == nil {
+			hdlr.BindVar = QuestionBindVar
+		}
+		if hdlr.BatchSize <= 0 {
+			hdlr.BatchSize = DefaultDBBatchSize
+		}
+		if hdlr.FlushInterval <= 0 {
+			hdlr.FlushInterval = DefaultDBFlushInterval
+		}
+		if hdlr.PruneInterval <= 0 {
+			hdlr.PruneInterval = DefaultDBPruneInterval
+		}
+		if hdlr.BufferSize <= 0 {
+			hdlr.BufferSize = DefaultDBBufferSize
+		}
+		hdlr.queue = make(chan dbRow, hdlr.BufferSize)
+		go hdlr.loop()
+	})
+}
+
+// loop inserts buffered rows and prunes expired ones until the queue is closed
+func (hdlr *DBHandler) loop() {
+	defer close(hdlr.done)
+
+	if hdlr.CreateTable {
+		if err := hdlr.createTable(); err != nil {
+			hdlr.onError(fmt.Errorf("create table %s failed, [%v]", hdlr.Table, err))
+		}
+	}
+
+	flush := logdog.NewTicker(hdlr.FlushInterval)
+	defer flush.Stop()
+	var prune <-chan time.Time
+	if hdlr.Retention > 0 {
+		ticker := logdog.NewTicker(hdlr.PruneInterval)
+		defer ticker.Stop()
+		prune = ticker.Chan()
+		hdlr.prune()
+	}
+
+	batch := make([]dbRow, 0, hdlr.BatchSize)
+	for {
+		select {
+		case row, ok := <-hdlr.queue:
+			if !ok {
+				hdlr.err = hdlr.insert(batch)
+				return
+			}
+			if row.flushed != nil {
+				row.flushed <- hdlr.insert(batch)
+				batch = batch[:0]
+				continue
+			}
+			batch = append(batch, row)
+			if len(batch) >= hdlr.BatchSize {
+				hdlr.insert(batch)
+				batch = batch[:0]
+			}
+		case <-flush.Chan():
+			hdlr.insert(batch)
+			batch = batch[:0]
+		case <-prune:
+			hdlr.prune()
+		}
+	}
+}
+
+func (hdlr *DBHandler) createTable() error {
+	c := hdlr.Columns
+	_, err := hdlr.DB.Exec(fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (%s TIMESTAMP NOT NULL, %s VARCHAR(16) NOT NULL, %s VARCHAR(255) NOT NULL, %s TEXT NOT NULL, %s TEXT NOT NULL)",
+		hdlr.Table, c.Time, c.Level, c.Logger, c.Message, c.Fields))
+	return err
+}
+
+// insert inserts the batch, it is retried once before being dropped
+func (hdlr *DBHandler) insert(batch []dbRow) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	err := hdlr.insertTx(batch)
+	if err == nil {
+		return nil
+	}
+	hdlr.onError(fmt.Errorf("insert %d rows failed, retrying, [%v]", len(batch), err))
+	if err = hdlr.insertTx(batch); err != nil {
+		err = fmt.Errorf("insert %d rows failed, dropped, [%v]", len(batch), err)
+		hdlr.onError(err)
+		atomic.AddUint64(&hdlr.dropped, uint64(len(batch)))
+	}
+	return err
+}
+
+// insertTx inserts the batch in a transaction
+func (hdlr *DBHandler) insertTx(batch []dbRow) error {
+	c := hdlr.Columns
+	vars := make([]string, 5)
+	for i := range vars {
+		vars[i] = hdlr.BindVar(i + 1)
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s, %s, %s, %s, %s) VALUES (%s)",
+		hdlr.Table, c.Time, c.Level, c.Logger, c.Message, c.Fields, strings.Join(vars, ", "))
+
+	tx, err := hdlr.DB.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, row := range batch {
+		if _, err := stmt.Exec(row.time, row.level, row.logger, row.message, row.fields); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// prune deletes rows older than Retention
+func (hdlr *DBHandler) prune() {
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s < %s", hdlr.Table, hdlr.Columns.Time, hdlr.BindVar(1))
+	if _, err := hdlr.DB.Exec(query, logdog.Now().Add(-hdlr.Retention)); err != nil {
+		hdlr.onError(fmt.Errorf("prune table %s failed, [%v]", hdlr.Table, err))
+	}
+}
+
+func (hdlr *DBHandler) onError(err error) {
+	if hdlr.OnError != nil {
+		hdlr.OnError(err)
+		return
+	}
+	logdog.InternalError(fmt.Errorf("DBHandler failed, [%v]", err))
+}
+
+// Emit buffers the log record, it never blocks
+func (hdlr *DBHandler) Emit(record *logdog.LogRecord) {
+	if record.Level < hdlr.GetLevel() {
+		return
+	}
+	hdlr.init()
+
+	fields := "{}"
+	if len(record.Fields) > 0 {
+		b, err := json.Marshal(record.Fields)
+		if err != nil {
+			// replace the fields which can not be marshaled
+			b, err = json.Marshal(logdog.JSONSafeFields(record.Fields))
+		}
+		if err != nil {
+			hdlr.onError(fmt.Errorf("marshal fields failed, [%v]", err))
+		} else {
+			fields = string(b)
+		}
+	}
+	row := dbRow{
+		time:    record.Time,
+		level:   record.LevelName,
+		logger:  record.Name,
+		message: record.GetMessage(),
+		fields:  fields,
+	}
+
+	hdlr.mu.RLock()
+	defer hdlr.mu.RUnlock()
+	if hdlr.closed {
+		atomic.AddUint64(&hdlr.dropped, 1)
+		return
+	}
+	select {
+	case hdlr.queue <- row:
+	default:
+		atomic.AddUint64(&hdlr.dropped, 1)
+	}
+}
+
+// Dropped returns the number of records dropped because the buffer is
+// full, the handler is closed or inserting failed twice
+func (hdlr *DBHandler) Dropped() uint64 {
+	return atomic.LoadUint64(&hdlr.dropped)
+}
+
+// Flush inserts all buffered rows, it returns the error of the final try
+func (hdlr *DBHandler) Flush() error {
+	hdlr.init()
+	hdlr.mu.RLock()
+	if hdlr.closed {
+		hdlr.mu.RUnlock()
+		return nil
+	}
+	flushed := make(chan error, 1)
+	// flush requests are never dropped
+	hdlr.queue <- dbRow{flushed: flushed}
+	hdlr.mu.RUnlock()
+	return <-flushed
+}
+
+// Close inserts all buffered rows and stops the background goroutine,
+// it returns the error of inserting the final batch
+func (hdlr *DBHandler) Close() error {
+	// a handler never used has nothing to insert
+	hdlr.start.Do(func() {
+		hdlr.closed = true
+		close(hdlr.done)
+	})
+	hdlr.mu.Lock()
+	if !hdlr.closed {
+		hdlr.closed = true
+		close(hdlr.queue)
+	}
+	hdlr.mu.Unlock()
+	<-hdlr.done
+	return hdlr.err
+}
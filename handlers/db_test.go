@@ -0,0 +1,238 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zoumo/logdog"
+)
+
+// fakeDB is an in-memory table served by fakeDriver
+type fakeDB struct {
+	mu          sync.Mutex
+	created     []string
+	rows        [][]driver.Value
+	commits     int
+	failInserts int
+}
+
+func (db *fakeDB) len() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return len(db.rows)
+}
+
+var (
+	fakeDBs   = map[string]*fakeDB{}
+	fakeDBsMu sync.Mutex
+)
+
+// fakeDriver opens the fakeDB registered with the data source name
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+	return &fakeConn{db: fakeDBs[name]}, nil
+}
+
+type fakeConn struct {
+	db      *fakeDB
+	pending [][]driver.Value
+	inTx    bool
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.inTx = true
+	c.pending = nil
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.rows = append(c.db.rows, c.pending...)
+	c.db.commits++
+	c.pending, c.inTx = nil, false
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.pending, c.inTx = nil, false
+	return nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	db := s.conn.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE"):
+		db.created = append(db.created, s.query)
+	case strings.HasPrefix(s.query, "INSERT"):
+		if db.failInserts > 0 {
+			db.failInserts--
+			return nil, errors.New("disk full")
+		}
+		if s.conn.inTx {
+			s.conn.pending = append(s.conn.pending, args)
+		} else {
+			db.rows = append(db.rows, args)
+		}
+	case strings.HasPrefix(s.query, "DELETE"):
+		before := args[0].(time.Time)
+		rows := db.rows[:0]
+		for _, row := range db.rows {
+			if !row[0].(time.Time).Before(before) {
+				rows = append(rows, row)
+			}
+		}
+		db.rows = rows
+	default:
+		return nil, errors.New("unsupported query: " + s.query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, io.EOF
+}
+
+func init() {
+	sql.Register("logdogfake", fakeDriver{})
+}
+
+// openFakeDB opens a new fakeDB named name
+func openFakeDB(t *testing.T, name string) (*sql.DB, *fakeDB) {
+	fake := &fakeDB{}
+	fakeDBsMu.Lock()
+	fakeDBs[name] = fake
+	fakeDBsMu.Unlock()
+	db, err := sql.Open("logdogfake", name)
+	assert.Nil(t, err)
+	return db, fake
+}
+
+func TestDBHandler(t *testing.T) {
+	db, fake := openFakeDB(t, "batch")
+	defer db.Close()
+
+	hdlr := NewDBHandler(db, "logs", DBColumns{Message: "msg"}, logdog.InfoLevel)
+	hdlr.CreateTable = true
+	hdlr.BatchSize = 2
+	hdlr.FlushInterval = time.Hour
+
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.DebugLevel, "a/b.go", "a.b", 1, "filtered"))
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "first", logdog.Fields{"x": 1}))
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.ErrorLevel, "a/b.go", "a.b", 1, "second"))
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "third"))
+	assert.Nil(t, hdlr.Flush())
+
+	fake.mu.Lock()
+	assert.Len(t, fake.created, 1)
+	assert.Contains(t, fake.created[0], "CREATE TABLE IF NOT EXISTS logs (time TIMESTAMP NOT NULL")
+	assert.Contains(t, fake.created[0], "msg TEXT NOT NULL")
+	// a full batch and the flushed partial one
+	assert.Equal(t, 2, fake.commits)
+	assert.Len(t, fake.rows, 3)
+	assert.Equal(t, []driver.Value{"INFO", "test", "first", `{"x":1}`}, fake.rows[0][1:])
+	assert.Equal(t, "{}", fake.rows[1][4])
+	fake.mu.Unlock()
+
+	assert.Nil(t, hdlr.Close())
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "closed"))
+	assert.Equal(t, uint64(1), hdlr.Dropped())
+	assert.Equal(t, 3, fake.len())
+}
+
+func TestDBHandlerRetry(t *testing.T) {
+	db, fake := openFakeDB(t, "retry")
+	defer db.Close()
+
+	var errs []error
+	hdlr := NewDBHandler(db, "logs", DBColumns{})
+	hdlr.OnError = func(err error) {
+		errs = append(errs, err)
+	}
+
+	// retried once
+	fake.failInserts = 1
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "retried"))
+	assert.Nil(t, hdlr.Flush())
+	assert.Equal(t, 1, fake.len())
+	assert.Len(t, errs, 1)
+
+	// dropped after the retry failed
+	fake.mu.Lock()
+	fake.failInserts = 2
+	fake.mu.Unlock()
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "dropped"))
+	assert.NotNil(t, hdlr.Flush())
+	assert.Equal(t, 1, fake.len())
+	assert.Len(t, errs, 3)
+	assert.Contains(t, errs[2].Error(), "dropped")
+	assert.Equal(t, uint64(1), hdlr.Dropped())
+	assert.Nil(t, hdlr.Close())
+}
+
+func TestDBHandlerRetention(t *testing.T) {
+	db, fake := openFakeDB(t, "retention")
+	defer db.Close()
+
+	now := time.Now()
+	logdog.SetClock(logdog.ClockFunc(func() time.Time { return now }))
+	defer logdog.SetClock(nil)
+
+	fake.rows = [][]driver.Value{
+		{now.Add(-2 * time.Hour), "INFO", "test", "expired", "{}"},
+		{now.Add(-time.Minute), "INFO", "test", "kept", "{}"},
+	}
+	hdlr := NewDBHandler(db, "logs", DBColumns{})
+	hdlr.Retention = time.Hour
+	hdlr.PruneInterval = 10 * time.Millisecond
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "new"))
+	assert.Nil(t, hdlr.Close())
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.Len(t, fake.rows, 2)
+	assert.Equal(t, "kept", fake.rows[0][3])
+
+	// a handler never used closes without a db
+	assert.Nil(t, NewDBHandler(nil, "", DBColumns{}).Close())
+}
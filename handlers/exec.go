@@ -0,0 +1,397 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zoumo/logdog"
+)
+
+const (
+	// DefaultExecBufferSize is the default number of records
+	// buffered while the process is down
+	DefaultExecBufferSize = 1024
+	// DefaultExecCloseTimeout is the default max duration Close
+	// waits for the process to exit before killing it
+	DefaultExecCloseTimeout = 5 * time.Second
+	// execMinBackoff and execMaxBackoff bound the restart backoff
+	execMinBackoff = 100 * time.Millisecond
+	execMaxBackoff = 30 * time.Second
+)
+
+// ErrExecUnavailable is returned by Flush while the process is
+// restarting, buffered records are written after it is restarted
+var ErrExecUnavailable = errors.New("exec log process is not running")
+
+// execProcess is a started process
+type execProcess struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	// exited is closed after the process exited, err is the
+	// result of Wait then
+	exited chan struct{}
+	err    error
+}
+
+// ExecHandler is a handler which pipes logging records, appropriately
+// formatted, to the stdin of a process, one record per line, e.g. a log
+// shipper reading newline-delimited JSON like vector.
+// Records are queued and written by a background goroutine, the process
+// is restarted with exponential backoff if it exits, and up to BufferSize
+// records are buffered meanwhile, the newer ones are dropped when the
+// buffer is full. Lines the process writes to stderr and its exits are
+// reported by OnError. Close closes stdin and waits for the process at
+// most CloseTimeout, then kills it.
+type ExecHandler struct {
+	Name      string
+	Formatter logdog.Formatter
+	// Command, Env and OnError should be set before use.
+	// Command is the program and its arguments
+	Command []string
+	// Env is the environment of the process, nil means the
+	// environment of the current process
+	Env          []string
+	CloseTimeout time.Duration
+	// OnError is called with the stderr lines and exits of the process,
+	// errors are printed to stderr if it is nil
+	OnError func(err error)
+
+	queue    chan []byte
+	flushes  chan chan error
+	dropped  uint64
+	restarts uint64
+	// mu guards closed, Close waits for in-flight Emits by it
+	mu     sync.RWMutex
+	closed bool
+
+	stop chan struct{}
+	done chan struct{}
+	// err is the error of stopping the process, set before done is closed
+	err  error
+	run  sync.Once
+	once sync.Once
+	logdog.LevelFilterer
+}
+
+// NewExecHandler returns a new ExecHandler fully initialized, the process
+// of command is started by a background goroutine on first use.
+// bufferSize <= 0 means DefaultExecBufferSize
+func NewExecHandler(command []string, bufferSize int, options ...logdog.Option) *ExecHandler {
+	if bufferSize <= 0 {
+		bufferSize = DefaultExecBufferSize
+	}
+
+	hdlr := &ExecHandler{
+		Name:         "",
+		Formatter:    logdog.NewJSONFormatter(),
+		Command:      command,
+		CloseTimeout: DefaultExecCloseTimeout,
+		queue:        make(chan []byte, bufferSize),
+		flushes:      make(chan chan error),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	hdlr.Level = logdog.NothingLevel
+
+	if err := logdog.ApplyOptionsTo(hdlr, options...); err != nil {
+		logdog.InternalError(err)
+	}
+
+	return hdlr
+}
+
+// init starts the background goroutine on first use
+func (hdlr *ExecHandler) init() {
+	hdlr.run.Do(func() {
+		go hdlr.loop()
+	})
+}
+
+// spawn starts the process, its stderr is reported line by line
+func (hdlr *ExecHandler) spawn() (*execProcess, error) {
+	if len(hdlr.Command) == 0 {
+		return nil, errors.New("should provide a command")
+	}
+	cmd := exec.Command(hdlr.Command[0], hdlr.Command[1:]...)
+	cmd.Env = hdlr.Env
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	proc := &execProcess{
+		cmd:    cmd,
+		stdin:  stdin,
+		exited: make(chan struct{}),
+	}
+	go func() {
+		// stderr should be read to the end before Wait
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			hdlr.onError(fmt.Errorf("%s: %s", hdlr.Command[0], scanner.Text()))
+		}
+		proc.err = cmd.Wait()
+		close(proc.exited)
+	}()
+	return proc, nil
+}
+
+// loop writes queued lines until the handler is closed
+func (hdlr *ExecHandler) loop() {
+	defer close(hdlr.done)
+
+	var proc *execProcess
+	// pending is the line failed to be written to the exited process
+	var pending []byte
+	started := false
+	backoff := time.Duration(0)
+
+	for {
+		if proc == nil {
+			if !hdlr.sleep(backoff) {
+				if pending != nil {
+					atomic.AddUint64(&hdlr.dropped, 1)
+				}
+				hdlr.err = hdlr.drain(nil)
+				return
+			}
+			p, err := hdlr.spawn()
+			if err != nil {
+				hdlr.onError(fmt.Errorf("start process failed, [%v]", err))
+				backoff = nextExecBackoff(backoff)
+				continue
+			}
+			if started {
+				atomic.AddUint64(&hdlr.restarts, 1)
+			}
+			started = true
+			proc = p
+		}
+
+		if pending == nil {
+			// queued lines go before flush requests
+			select {
+			case pending = <-hdlr.queue:
+			default:
+				select {
+				case pending = <-hdlr.queue:
+				case flushed := <-hdlr.flushes:
+					flushed <- nil
+					continue
+				case <-proc.exited:
+					hdlr.exited(proc)
+					proc = nil
+					backoff = nextExecBackoff(backoff)
+					continue
+				case <-hdlr.stop:
+					hdlr.err = hdlr.drain(proc)
+					return
+				}
+			}
+		}
+
+		if _, err := proc.stdin.Write(pending); err != nil {
+			// the process exited, the line is written after restarting
+			<-proc.exited
+			hdlr.exited(proc)
+			proc = nil
+			backoff = nextExecBackoff(backoff)
+			continue
+		}
+		pending = nil
+		backoff = 0
+	}
+}
+
+// nextExecBackoff doubles the backoff between execMinBackoff and execMaxBackoff
+func nextExecBackoff(backoff time.Duration) time.Duration {
+	if backoff < execMinBackoff {
+		return execMinBackoff
+	}
+	if backoff *= 2; backoff > execMaxBackoff {
+		return execMaxBackoff
+	}
+	return backoff
+}
+
+// exited reports the exit of the process
+func (hdlr *ExecHandler) exited(proc *execProcess) {
+	if proc.err != nil {
+		hdlr.onError(fmt.Errorf("process %s exited, [%v]", hdlr.Command[0], proc.err))
+		return
+	}
+	hdlr.onError(fmt.Errorf("process %s exited", hdlr.Command[0]))
+}
+
+// sleep waits d, flush requests get ErrExecUnavailable meanwhile,
+// it returns false if the handler is closed
+func (hdlr *ExecHandler) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case flushed := <-hdlr.flushes:
+			flushed <- ErrExecUnavailable
+		case <-hdlr.stop:
+			return false
+		}
+	}
+}
+
+// drain writes the remaining lines if the process is running,
+// then stops the process
+func (hdlr *ExecHandler) drain(proc *execProcess) error {
+	for {
+		select {
+		case line := <-hdlr.queue:
+			if proc == nil {
+				atomic.AddUint64(&hdlr.dropped, 1)
+				continue
+			}
+			if _, err := proc.stdin.Write(line); err != nil {
+				atomic.AddUint64(&hdlr.dropped, 1)
+			}
+		default:
+			if proc == nil {
+				return nil
+			}
+			return hdlr.stopProcess(proc)
+		}
+	}
+}
+
+// stopProcess closes stdin of the process and waits for it
+// at most CloseTimeout, then kills it
+func (hdlr *ExecHandler) stopProcess(proc *execProcess) error {
+	proc.stdin.Close()
+
+	timeout := hdlr.CloseTimeout
+	if timeout <= 0 {
+		timeout = DefaultExecCloseTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-proc.exited:
+		return proc.err
+	case <-timer.C:
+		proc.cmd.Process.Kill()
+		<-proc.exited
+		return fmt.Errorf("process %s is killed after waiting %v", hdlr.Command[0], timeout)
+	}
+}
+
+// Emit queues the log record, it never blocks.
+// Records emitted after Close are dropped
+func (hdlr *ExecHandler) Emit(record *logdog.LogRecord) {
+	if hdlr.Formatter == nil {
+		panic("you should set fomatter before use this handler")
+	}
+
+	if record.Level < hdlr.GetLevel() {
+		return
+	}
+
+	line, err := logdog.AppendFormat(nil, hdlr.Formatter, record)
+	if err != nil {
+		logdog.InternalError(fmt.Errorf("Format record failed, [%v]", err))
+		return
+	}
+	line = append(line, '\n')
+
+	hdlr.init()
+	hdlr.mu.RLock()
+	defer hdlr.mu.RUnlock()
+	if hdlr.closed {
+		atomic.AddUint64(&hdlr.dropped, 1)
+		return
+	}
+	select {
+	case hdlr.queue <- line:
+	default:
+		atomic.AddUint64(&hdlr.dropped, 1)
+	}
+}
+
+// onError reports the error of the process
+func (hdlr *ExecHandler) onError(err error) {
+	if hdlr.OnError != nil {
+		hdlr.OnError(err)
+		return
+	}
+	logdog.InternalError(fmt.Errorf("ExecHandler failed, [%v]", err))
+}
+
+// Dropped returns the number of records dropped
+// because the buffer is full or the handler is closed
+func (hdlr *ExecHandler) Dropped() uint64 {
+	return atomic.LoadUint64(&hdlr.dropped)
+}
+
+// Restarts returns the number of times the process is restarted
+func (hdlr *ExecHandler) Restarts() uint64 {
+	return atomic.LoadUint64(&hdlr.restarts)
+}
+
+// Flush waits until the queued records are written to the process,
+// it returns ErrExecUnavailable if the process is restarting
+func (hdlr *ExecHandler) Flush() error {
+	hdlr.init()
+	flushed := make(chan error, 1)
+	select {
+	case hdlr.flushes <- flushed:
+		return <-flushed
+	case <-hdlr.done:
+		return nil
+	}
+}
+
+// Close writes the queued records, closes stdin of the process and
+// waits for it to exit at most CloseTimeout, then kills it.
+// It returns the exit error of the process, or an error if it is killed
+func (hdlr *ExecHandler) Close() error {
+	// the process is not started if the handler is never used
+	hdlr.run.Do(func() {
+		close(hdlr.done)
+	})
+	hdlr.once.Do(func() {
+		hdlr.mu.Lock()
+		hdlr.closed = true
+		hdlr.mu.Unlock()
+		close(hdlr.stop)
+	})
+	<-hdlr.done
+	return hdlr.err
+}
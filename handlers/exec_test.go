@@ -0,0 +1,194 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zoumo/logdog"
+)
+
+// TestExecHelperProcess is not a real test, it is the process
+// started by ExecHandler tests, e.g. helperCommand("append", path)
+func TestExecHelperProcess(t *testing.T) {
+	if os.Getenv("LOGDOG_EXEC_HELPER") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	mode, path := args[1], args[2]
+
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0660)
+	if err != nil {
+		os.Exit(2)
+	}
+	reader := bufio.NewReader(os.Stdin)
+	switch mode {
+	case "append":
+		// like `cat >> path`, warns on stderr first
+		fmt.Fprintln(os.Stderr, "warming up")
+		io.Copy(out, reader)
+	case "once":
+		// appends one line and exits
+		line, _ := reader.ReadString('\n')
+		out.WriteString(line)
+		os.Exit(1)
+	case "hang":
+		// ignores the end of stdin
+		io.Copy(out, reader)
+		time.Sleep(time.Minute)
+	}
+	os.Exit(0)
+}
+
+// helperCommand returns the command running TestExecHelperProcess
+func helperCommand(mode, path string) []string {
+	return []string{os.Args[0], "-test.run=TestExecHelperProcess", "--", mode, path}
+}
+
+func newHelperExecHandler(mode, path string) (*ExecHandler, *errorRecorder) {
+	errs := &errorRecorder{}
+	hdlr := NewExecHandler(helperCommand(mode, path), 0, &logdog.TextFormatter{Fmt: "%(message)"})
+	hdlr.Env = append(os.Environ(), "LOGDOG_EXEC_HELPER=1")
+	hdlr.OnError = errs.record
+	return hdlr, errs
+}
+
+// errorRecorder keeps the errors reported by OnError
+type errorRecorder struct {
+	mu   sync.Mutex
+	errs []string
+}
+
+func (r *errorRecorder) record(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, err.Error())
+}
+
+func (r *errorRecorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strings.Join(r.errs, "\n")
+}
+
+// waitFile waits until the content of the file is expected
+func waitFile(t *testing.T, path, expected string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := ioutil.ReadFile(path)
+		if string(data) == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("file content is %q, expected %q", data, expected)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestExecHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.log")
+
+	hdlr, errs := newHelperExecHandler("append", path)
+	for _, msg := range []string{"1", "2", "3"} {
+		hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, msg))
+	}
+	assert.Nil(t, hdlr.Flush())
+	// stdin is closed and the process exits with the records written
+	assert.Nil(t, hdlr.Close())
+	assert.Equal(t, "1\n2\n3\n", readFile(t, path))
+	assert.Contains(t, errs.String(), "warming up")
+
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "closed"))
+	assert.Equal(t, uint64(1), hdlr.Dropped())
+}
+
+func TestExecHandlerRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.log")
+
+	hdlr, errs := newHelperExecHandler("once", path)
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "1"))
+	waitFile(t, path, "1\n")
+
+	// the process is restarted after exiting
+	deadline := time.Now().Add(5 * time.Second)
+	for hdlr.Restarts() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, uint64(1), hdlr.Restarts())
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "2"))
+	waitFile(t, path, "1\n2\n")
+	assert.Contains(t, errs.String(), "exited")
+	hdlr.Close()
+}
+
+func TestExecHandlerCloseTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.log")
+
+	hdlr, _ := newHelperExecHandler("hang", path)
+	hdlr.CloseTimeout = 50 * time.Millisecond
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "1"))
+
+	start := time.Now()
+	err = hdlr.Close()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "killed")
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, "1\n", readFile(t, path))
+}
+
+func TestExecHandlerUnavailable(t *testing.T) {
+	hdlr := NewExecHandler([]string{filepath.Join(os.TempDir(), "logdog-no-such-command")}, 1)
+	hdlr.OnError = func(error) {}
+	deadline := time.Now().Add(5 * time.Second)
+	for hdlr.Flush() != ErrExecUnavailable && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, ErrExecUnavailable, hdlr.Flush())
+	for i := 0; i < 3; i++ {
+		hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "buffered"))
+	}
+	assert.Nil(t, hdlr.Close())
+	assert.Equal(t, uint64(3), hdlr.Dropped())
+}
+
+func TestExecHandlerInterface(t *testing.T) {
+	hdlr := NewExecHandler(nil, 0)
+	hdlr.OnError = func(error) {}
+	assert.Implements(t, (*logdog.Handler)(nil), hdlr)
+	assert.Nil(t, hdlr.Close())
+}
@@ -0,0 +1,59 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package handler
+
+import (
+	"os"
+	"time"
+)
+
+const (
+	// lockRetryInterval is the interval of retrying to create the lock file
+	lockRetryInterval = 10 * time.Millisecond
+	// staleLockAge is the age of a lock file left by a crashed process
+	staleLockAge = time.Minute
+)
+
+// lockFile creates the lock file exclusively, it retries until the lock
+// file is removed by other processes, a lock file older than
+// staleLockAge is removed
+func lockFile(path string) (*os.File, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0660)
+		if err == nil {
+			return file, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(path)
+			continue
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// unlockFile removes the lock file created by lockFile
+func unlockFile(file *os.File) error {
+	err := file.Close()
+	if rerr := os.Remove(file.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
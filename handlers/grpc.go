@@ -0,0 +1,341 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zoumo/logdog"
+)
+
+const (
+	// DefaultGRPCBufferSize is the default number of entries
+	// buffered while the stream is reconnecting
+	DefaultGRPCBufferSize = 1024
+	// DefaultGRPCCloseTimeout is the default max duration Close
+	// waits for CloseAndRecv
+	DefaultGRPCCloseTimeout = 5 * time.Second
+	// grpcMinBackoff and grpcMaxBackoff bound the reconnect backoff
+	grpcMinBackoff = 100 * time.Millisecond
+	grpcMaxBackoff = 30 * time.Second
+)
+
+// ErrGRPCUnavailable is returned by Flush while the stream is
+// reconnecting, buffered records are sent after it is re-established
+var ErrGRPCUnavailable = errors.New("grpc log stream is unavailable")
+
+// GRPCLogEntry is the message sent by GRPCHandler, its schema is
+//
+//	message LogEntry {
+//	    string level = 1;
+//	    int32 level_no = 2;
+//	    int64 time_unix_nano = 3;
+//	    string name = 4;
+//	    string message = 5;
+//	    map<string, string> fields = 6;
+//	    int32 severity = 7;
+//	    string severity_text = 8;
+//	}
+//
+//	service LogIngest {
+//	    rpc Push(stream LogEntry) returns (PushResponse);
+//	}
+type GRPCLogEntry struct {
+	Level        string
+	LevelNo      int32
+	TimeUnixNano int64
+	Name         string
+	Message      string
+	Fields       map[string]string
+	Severity     int32
+	SeverityText string
+}
+
+// NewGRPCLogEntry converts the record to a GRPCLogEntry
+func NewGRPCLogEntry(record *logdog.LogRecord) *GRPCLogEntry {
+	entry := &GRPCLogEntry{
+		Level:        record.LevelName,
+		LevelNo:      int32(record.Level),
+		TimeUnixNano: record.Time.UnixNano(),
+		Name:         record.Name,
+		Message:      record.GetMessage(),
+	}
+	if len(record.Fields) > 0 {
+		entry.Fields = make(map[string]string, len(record.Fields))
+		for k, v := range record.Fields {
+			entry.Fields[k] = fmt.Sprint(v)
+		}
+	}
+	return entry
+}
+
+// GRPCStream is the client side of the client-streaming rpc,
+// so logdog does not depend on grpc. Adapt the generated
+// LogIngest_PushClient to it by converting GRPCLogEntry to
+// the generated message.
+type GRPCStream interface {
+	Send(*GRPCLogEntry) error
+	CloseAndRecv() error
+}
+
+// GRPCStreamOpener opens a new stream, e.g. calls client.Push(ctx).
+// ctx belongs to the stream, it is canceled after the stream is closed
+type GRPCStreamOpener func(ctx context.Context) (GRPCStream, error)
+
+// GRPCHandler is a handler which sends logging records to a collector
+// service through a client-streaming rpc.
+// The severity of entries is resolved by OTelSeverityMap by default,
+// use SetSeverityMapping to override it.
+// Records are queued and sent by a background goroutine, when the stream
+// fails, it is re-established with exponential backoff, and up to
+// BufferSize entries are buffered during reconnecting, the newer ones are
+// dropped when the buffer is full. Close sends the queued entries and
+// closes the stream by CloseAndRecv, waiting at most CloseTimeout.
+type GRPCHandler struct {
+	Name         string
+	Open         GRPCStreamOpener
+	CloseTimeout time.Duration
+
+	queue   chan *GRPCLogEntry
+	flushes chan chan error
+	dropped uint64
+	// mu guards closed, Close waits for in-flight Emits by it
+	mu     sync.RWMutex
+	closed bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+	logdog.LevelFilterer
+	logdog.SeverityMapper
+}
+
+// grpcStream is an established stream with the cancel func of its context
+type grpcStream struct {
+	GRPCStream
+	cancel context.CancelFunc
+}
+
+// NewGRPCHandler returns a new GRPCHandler fully initialized,
+// bufferSize <= 0 means DefaultGRPCBufferSize
+func NewGRPCHandler(open GRPCStreamOpener, bufferSize int, options ...logdog.Option) *GRPCHandler {
+	if bufferSize <= 0 {
+		bufferSize = DefaultGRPCBufferSize
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	hdlr := &GRPCHandler{
+		Name:         "",
+		Open:         open,
+		CloseTimeout: DefaultGRPCCloseTimeout,
+		queue:        make(chan *GRPCLogEntry, bufferSize),
+		flushes:      make(chan chan error),
+		ctx:          ctx,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+	}
+
+	hdlr.SetSeverityMapping(logdog.OTelSeverityMap)
+
+	if err := logdog.ApplyOptionsTo(hdlr, options...); err != nil {
+		logdog.InternalError(err)
+	}
+
+	go hdlr.loop()
+
+	return hdlr
+}
+
+// loop sends queued entries until the handler is closed
+func (hdlr *GRPCHandler) loop() {
+	defer close(hdlr.done)
+
+	var stream *grpcStream
+	backoff := grpcMinBackoff
+
+	for {
+		var entry *GRPCLogEntry
+		// queued entries go before flush requests
+		select {
+		case entry = <-hdlr.queue:
+		default:
+			select {
+			case entry = <-hdlr.queue:
+			case flushed := <-hdlr.flushes:
+				flushed <- nil
+				continue
+			case <-hdlr.ctx.Done():
+				hdlr.drain(stream)
+				return
+			}
+		}
+
+		// retry the entry until it is sent or the handler is closed
+		for {
+			if stream == nil {
+				ctx, cancel := context.WithCancel(context.Background())
+				s, err := hdlr.Open(ctx)
+				if err != nil {
+					cancel()
+					if !hdlr.sleep(backoff) {
+						atomic.AddUint64(&hdlr.dropped, 1)
+						hdlr.drain(nil)
+						return
+					}
+					if backoff *= 2; backoff > grpcMaxBackoff {
+						backoff = grpcMaxBackoff
+					}
+					continue
+				}
+				stream = &grpcStream{GRPCStream: s, cancel: cancel}
+				backoff = grpcMinBackoff
+			}
+
+			if err := stream.Send(entry); err != nil {
+				hdlr.closeStream(stream)
+				stream = nil
+				continue
+			}
+			break
+		}
+	}
+}
+
+// sleep waits d, flush requests get ErrGRPCUnavailable meanwhile,
+// it returns false if the handler is closed
+func (hdlr *GRPCHandler) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case flushed := <-hdlr.flushes:
+			flushed <- ErrGRPCUnavailable
+		case <-hdlr.ctx.Done():
+			return false
+		}
+	}
+}
+
+// closeStream closes the stream by CloseAndRecv, and cancels its context
+// after CloseAndRecv returns or CloseTimeout elapsed
+func (hdlr *GRPCHandler) closeStream(stream *grpcStream) error {
+	defer stream.cancel()
+
+	timeout := hdlr.CloseTimeout
+	if timeout <= 0 {
+		timeout = DefaultGRPCCloseTimeout
+	}
+	closed := make(chan error, 1)
+	go func() {
+		closed <- stream.CloseAndRecv()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-closed:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("close stream timeout after %v", timeout)
+	}
+}
+
+// drain sends the remaining entries if the stream is established,
+// then closes the stream
+func (hdlr *GRPCHandler) drain(stream *grpcStream) {
+	for {
+		select {
+		case entry := <-hdlr.queue:
+			if stream == nil || stream.Send(entry) != nil {
+				atomic.AddUint64(&hdlr.dropped, 1)
+			}
+		default:
+			if stream != nil {
+				if err := hdlr.closeStream(stream); err != nil {
+					logdog.InternalError(fmt.Errorf("Close stream failed, [%v]", err))
+				}
+			}
+			return
+		}
+	}
+}
+
+// Emit queues the log record, it never blocks.
+// Records emitted after Close are dropped
+func (hdlr *GRPCHandler) Emit(record *logdog.LogRecord) {
+	if hdlr.Open == nil {
+		panic("you should set stream opener before use this handler")
+	}
+
+	if record.Level < hdlr.GetLevel() {
+		return
+	}
+
+	entry := NewGRPCLogEntry(record)
+	severity := hdlr.ResolveSeverity(record.Level)
+	entry.Severity = int32(severity.Code)
+	entry.SeverityText = severity.Name
+
+	hdlr.mu.RLock()
+	defer hdlr.mu.RUnlock()
+	if hdlr.closed {
+		atomic.AddUint64(&hdlr.dropped, 1)
+		return
+	}
+	select {
+	case hdlr.queue <- entry:
+	default:
+		atomic.AddUint64(&hdlr.dropped, 1)
+	}
+}
+
+// Dropped returns the number of records dropped
+// because the buffer is full or the handler is closed
+func (hdlr *GRPCHandler) Dropped() uint64 {
+	return atomic.LoadUint64(&hdlr.dropped)
+}
+
+// Flush waits until the queued records are sent, it returns
+// ErrGRPCUnavailable if the stream is reconnecting
+func (hdlr *GRPCHandler) Flush() error {
+	flushed := make(chan error, 1)
+	select {
+	case hdlr.flushes <- flushed:
+		return <-flushed
+	case <-hdlr.done:
+		return nil
+	}
+}
+
+// Close sends the queued records if the stream is established,
+// and closes the stream by CloseAndRecv
+func (hdlr *GRPCHandler) Close() error {
+	hdlr.once.Do(func() {
+		hdlr.mu.Lock()
+		hdlr.closed = true
+		hdlr.mu.Unlock()
+		hdlr.cancel()
+	})
+	<-hdlr.done
+	return nil
+}
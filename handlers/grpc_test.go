@@ -0,0 +1,195 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zoumo/logdog"
+)
+
+type fakeGRPCCollector struct {
+	mu      sync.Mutex
+	fail    int
+	opened  int
+	closed  int
+	entries []*GRPCLogEntry
+	// hang makes CloseAndRecv block until the stream is canceled
+	hang    bool
+	streams []*fakeGRPCStream
+}
+
+// fakeGRPCStream behaves like a grpc client stream,
+// it fails once its context is canceled
+type fakeGRPCStream struct {
+	c      *fakeGRPCCollector
+	ctx    context.Context
+	broken bool
+}
+
+func (s *fakeGRPCStream) Send(entry *GRPCLogEntry) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	s.c.mu.Lock()
+	defer s.c.mu.Unlock()
+	if s.broken {
+		return errors.New("broken stream")
+	}
+	s.c.entries = append(s.c.entries, entry)
+	return nil
+}
+
+func (s *fakeGRPCStream) CloseAndRecv() error {
+	s.c.mu.Lock()
+	hang := s.c.hang
+	s.c.mu.Unlock()
+	if hang {
+		<-s.ctx.Done()
+	}
+	if err := s.ctx.Err(); err != nil {
+		// the stream was canceled before the response arrived
+		return err
+	}
+	s.c.mu.Lock()
+	defer s.c.mu.Unlock()
+	s.c.closed++
+	return nil
+}
+
+func (c *fakeGRPCCollector) open(ctx context.Context) (GRPCStream, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fail > 0 {
+		c.fail--
+		return nil, errors.New("unavailable")
+	}
+	c.opened++
+	// the first stream breaks on first send
+	stream := &fakeGRPCStream{c: c, ctx: ctx, broken: c.opened == 1}
+	c.streams = append(c.streams, stream)
+	return stream, nil
+}
+
+func (c *fakeGRPCCollector) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func TestGRPCHandler(t *testing.T) {
+	collector := &fakeGRPCCollector{fail: 1}
+	hdlr := NewGRPCHandler(collector.open, 10, logdog.InfoLevel)
+
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.DebugLevel, "a/b.go", "a.b", 1, "filtered"))
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "first", logdog.Fields{"x": 1}))
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.ErrorLevel, "a/b.go", "a.b", 1, "second"))
+
+	// Flush reports the stream unavailable while reconnecting,
+	// then waits until the queue is drained
+	deadline := time.Now().Add(2 * time.Second)
+	for hdlr.Flush() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for flush")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 2, collector.count())
+	assert.Nil(t, hdlr.Close())
+
+	// emitted after Close
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "dropped"))
+	assert.Equal(t, uint64(1), hdlr.Dropped())
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	assert.Len(t, collector.entries, 2)
+	assert.Equal(t, "first", collector.entries[0].Message)
+	assert.Equal(t, map[string]string{"x": "1"}, collector.entries[0].Fields)
+	assert.Equal(t, "ERROR", collector.entries[1].Level)
+	assert.Equal(t, int32(9), collector.entries[0].Severity)
+	assert.Equal(t, "ERROR", collector.entries[1].SeverityText)
+	// reconnected once after the broken stream, CloseAndRecv of both
+	// streams got the response before their contexts are canceled
+	assert.Equal(t, 2, collector.opened)
+	assert.Equal(t, 2, collector.closed)
+	for _, stream := range collector.streams {
+		assert.NotNil(t, stream.ctx.Err())
+	}
+}
+
+func TestGRPCHandlerCloseTimeout(t *testing.T) {
+	collector := &fakeGRPCCollector{hang: true}
+	// skip the broken first stream
+	collector.opened = 1
+	hdlr := NewGRPCHandler(collector.open, 10)
+	hdlr.CloseTimeout = 50 * time.Millisecond
+
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "sent"))
+	assert.Nil(t, hdlr.Flush())
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		hdlr.Close()
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close blocked by a hanging CloseAndRecv")
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	assert.Len(t, collector.entries, 1)
+	assert.Equal(t, 0, collector.closed)
+	// the stream is canceled after the timeout
+	assert.NotNil(t, collector.streams[0].ctx.Err())
+}
+
+func TestGRPCHandlerSeverityMapping(t *testing.T) {
+	collector := &fakeGRPCCollector{}
+	hdlr := NewGRPCHandler(collector.open, 10)
+	hdlr.SetSeverityMapping(logdog.SyslogSeverityMap)
+
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.WarnLevel, "a/b.go", "a.b", 1, "msg"))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for collector.count() < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Nil(t, hdlr.Close())
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	assert.Len(t, collector.entries, 1)
+	assert.Equal(t, int32(4), collector.entries[0].Severity)
+	assert.Equal(t, "warning", collector.entries[0].SeverityText)
+}
+
+func TestGRPCHandlerBufferFull(t *testing.T) {
+	collector := &fakeGRPCCollector{fail: 1 << 30}
+	hdlr := NewGRPCHandler(collector.open, 1)
+	for i := 0; i < 5; i++ {
+		hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "dropped"))
+	}
+	assert.Nil(t, hdlr.Close())
+	assert.Equal(t, uint64(5), hdlr.Dropped())
+}
@@ -0,0 +1,143 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/zoumo/logdog"
+)
+
+const (
+	// DefaultMongoBatchSize is the default number of documents
+	// inserted in one batch
+	DefaultMongoBatchSize = 100
+	// DefaultMongoFlushInterval is the default interval
+	// between two periodic flushes
+	DefaultMongoFlushInterval = time.Second
+)
+
+// MongoCollection is the subset of a mongo collection used by MongoHandler,
+// so logdog does not depend on the mongo driver.
+// e.g. *mgo.Collection satisfies it, the collection should be created
+// as a capped collection to get size-bounded retention.
+type MongoCollection interface {
+	Insert(docs ...interface{}) error
+}
+
+// MongoDocument is the document inserted by MongoHandler
+type MongoDocument struct {
+	Time    time.Time     `bson:"time"`
+	Level   string        `bson:"level"`
+	Name    string        `bson:"name"`
+	Message string        `bson:"message"`
+	Fields  logdog.Fields `bson:"fields,omitempty"`
+}
+
+// NewMongoDocument converts the record to a MongoDocument
+func NewMongoDocument(record *logdog.LogRecord) *MongoDocument {
+	return &MongoDocument{
+		Time:    record.Time,
+		Level:   record.LevelName,
+		Name:    record.Name,
+		Message: record.GetMessage(),
+		Fields:  record.Fields,
+	}
+}
+
+// MongoHandler is a handler which inserts logging records as documents
+// into a mongo (capped) collection.
+// Documents are inserted in batches, the batch is flushed when it is full,
+// every FlushInterval and on Close.
+type MongoHandler struct {
+	Name       string
+	Collection MongoCollection
+	// MaxBatchBytes flushes the batch before its size would exceed it,
+	// a larger document is inserted alone, 0 means no limit.
+	// The size of a document is estimated by its JSON encoding
+	MaxBatchBytes int
+	batch         *batcher
+	logdog.LevelFilterer
+}
+
+// NewMongoHandler returns a new MongoHandler fully initialized,
+// batchSize <= 0 means DefaultMongoBatchSize and
+// flushInterval <= 0 means DefaultMongoFlushInterval
+func NewMongoHandler(collection MongoCollection, batchSize int, flushInterval time.Duration, options ...logdog.Option) *MongoHandler {
+	if batchSize <= 0 {
+		batchSize = DefaultMongoBatchSize
+	}
+	if flushInterval <= 0 {
+		flushInterval = DefaultMongoFlushInterval
+	}
+
+	hdlr := &MongoHandler{
+		Name:       "",
+		Collection: collection,
+	}
+	hdlr.batch = newBatcher(batchSize, flushInterval, hdlr.insert)
+
+	if err := logdog.ApplyOptionsTo(hdlr, options...); err != nil {
+		logdog.InternalError(err)
+	}
+
+	return hdlr
+}
+
+func (hdlr *MongoHandler) insert(docs []interface{}) error {
+	return hdlr.Collection.Insert(docs...)
+}
+
+// Emit adds the log record to the batch
+func (hdlr *MongoHandler) Emit(record *logdog.LogRecord) {
+	if hdlr.Collection == nil {
+		panic("you should set collection before use this handler")
+	}
+
+	if record.Level < hdlr.GetLevel() {
+		return
+	}
+
+	doc := NewMongoDocument(record)
+	size := 0
+	if hdlr.MaxBatchBytes > 0 {
+		size = documentSize(doc)
+	}
+	if err := hdlr.batch.AddSized(doc, size, hdlr.MaxBatchBytes); err != nil {
+		logdog.InternalError(fmt.Errorf("Insert documents failed, [%v]", err))
+	}
+}
+
+// documentSize estimates the size of the document by its JSON encoding,
+// or by its message if it can not be encoded
+func documentSize(doc *MongoDocument) int {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return len(doc.Message)
+	}
+	return len(data)
+}
+
+// Flush inserts all buffered documents
+func (hdlr *MongoHandler) Flush() error {
+	return hdlr.batch.Flush()
+}
+
+// Close stops the periodic flushing and inserts the final partial batch
+func (hdlr *MongoHandler) Close() error {
+	return hdlr.batch.Close()
+}
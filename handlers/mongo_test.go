@@ -0,0 +1,102 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zoumo/logdog"
+)
+
+type fakeMongoCollection struct {
+	mu      sync.Mutex
+	batches [][]interface{}
+}
+
+func (c *fakeMongoCollection) Insert(docs ...interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.batches = append(c.batches, docs)
+	return nil
+}
+
+func TestMongoHandler(t *testing.T) {
+	collection := &fakeMongoCollection{}
+	hdlr := NewMongoHandler(collection, 2, time.Hour, logdog.InfoLevel)
+
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.DebugLevel, "a/b.go", "a.b", 1, "filtered"))
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "first", logdog.Fields{"x": 1}))
+	assert.Len(t, collection.batches, 0)
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.ErrorLevel, "a/b.go", "a.b", 1, "second"))
+	assert.Len(t, collection.batches, 1)
+	assert.Len(t, collection.batches[0], 2)
+
+	doc := collection.batches[0][0].(*MongoDocument)
+	assert.Equal(t, "INFO", doc.Level)
+	assert.Equal(t, "first", doc.Message)
+	assert.Equal(t, logdog.Fields{"x": 1}, doc.Fields)
+
+	// final partial batch is flushed on close
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "third"))
+	assert.Nil(t, hdlr.Close())
+	assert.Len(t, collection.batches, 2)
+}
+
+func TestMongoHandlerFlushInterval(t *testing.T) {
+	collection := &fakeMongoCollection{}
+	hdlr := NewMongoHandler(collection, 100, 10*time.Millisecond)
+	defer hdlr.Close()
+
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "tick"))
+	time.Sleep(50 * time.Millisecond)
+
+	collection.mu.Lock()
+	defer collection.mu.Unlock()
+	assert.Len(t, collection.batches, 1)
+}
+
+func TestMongoHandlerMaxBatchBytes(t *testing.T) {
+	collection := &fakeMongoCollection{}
+	hdlr := NewMongoHandler(collection, 100, time.Hour)
+	now := time.Now()
+	record := func(msg string) *logdog.LogRecord {
+		record := logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, msg)
+		// the size of the encoded time does not vary
+		record.Time = now
+		return record
+	}
+	// two documents fit in a batch
+	hdlr.MaxBatchBytes = 2*documentSize(NewMongoDocument(record("1"))) + 1
+
+	hdlr.Emit(record("1"))
+	hdlr.Emit(record("2"))
+	assert.Len(t, collection.batches, 0)
+	hdlr.Emit(record("3"))
+	assert.Len(t, collection.batches, 1)
+	assert.Len(t, collection.batches[0], 2)
+
+	// an over-limit document is inserted alone
+	hdlr.Emit(record(strings.Repeat("x", hdlr.MaxBatchBytes)))
+	assert.Len(t, collection.batches, 3)
+	assert.Equal(t, "3", collection.batches[1][0].(*MongoDocument).Message)
+	assert.Len(t, collection.batches[2], 1)
+
+	assert.Nil(t, hdlr.Close())
+	assert.Len(t, collection.batches, 3)
+}
@@ -0,0 +1,365 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zoumo/logdog"
+)
+
+const (
+	// DefaultMQTTBufferSize is the default number of records
+	// buffered while the client is offline
+	DefaultMQTTBufferSize = 1024
+	// DefaultMQTTMaxPacketSize is the default max packet size of
+	// the broker, the max size allowed by MQTT is 256MB
+	DefaultMQTTMaxPacketSize = 256 << 20
+	// mqttMinBackoff and mqttMaxBackoff bound the reconnect backoff
+	mqttMinBackoff = 100 * time.Millisecond
+	mqttMaxBackoff = 30 * time.Second
+	// mqttFixedHeaderSize is the max size of the fixed header
+	mqttFixedHeaderSize = 5
+)
+
+// ErrMQTTOffline is returned by Flush while the client is offline,
+// buffered records are published after it reconnects
+var ErrMQTTOffline = errors.New("mqtt client is offline")
+
+// MQTTClient is the subset of a mqtt client used by MQTTHandler,
+// so logdog does not depend on any mqtt library,
+// e.g. adapt paho.mqtt.golang to it
+type MQTTClient interface {
+	// Publish publishes the payload, at QoS 1 it waits for PUBACK
+	Publish(topic string, qos byte, payload []byte) error
+	IsConnected() bool
+	Disconnect()
+}
+
+// MQTTConnector connects to the broker, tlsConfig is the TLSConfig
+// of the handler, e.g. with client certificates, nil means plain tcp
+type MQTTConnector func(tlsConfig *tls.Config) (MQTTClient, error)
+
+// mqttMsg is a queued message
+type mqttMsg struct {
+	topic   string
+	payload []byte
+}
+
+// MQTTHandler is a handler which publishes logging records,
+// appropriately formatted, to a mqtt topic, e.g. from edge devices.
+// Topic may contain {level}, the lowercase level name, and {device},
+// the DeviceID. QoS is 0 or 1.
+// Records are queued and published by a background goroutine, which
+// connects by Connect with TLSConfig. While the client is offline, it
+// reconnects with exponential backoff, and up to BufferSize records are
+// buffered, the newer ones are dropped, buffered records are published
+// after reconnecting. Payloads exceeding MaxPacketSize are truncated.
+// TLSConfig, QoS and MaxPacketSize should be set before use, a QoS
+// greater than 1 is reported by OnError and 1 is used instead.
+type MQTTHandler struct {
+	Name          string
+	Formatter     logdog.Formatter
+	Connect       MQTTConnector
+	TLSConfig     *tls.Config
+	Topic         string
+	DeviceID      string
+	QoS           byte
+	MaxPacketSize int
+	// OnError is called with connect and publish errors,
+	// errors are printed to stderr if it is nil
+	OnError func(err error)
+
+	qos       byte
+	queue     chan mqttMsg
+	flushes   chan chan error
+	dropped   uint64
+	truncated uint64
+	mu        sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	start  sync.Once
+	once   sync.Once
+	logdog.LevelFilterer
+}
+
+// NewMQTTHandler returns a new MQTTHandler fully initialized,
+// bufferSize <= 0 means DefaultMQTTBufferSize
+func NewMQTTHandler(connect MQTTConnector, topic string, bufferSize int, options ...logdog.Option) *MQTTHandler {
+	if bufferSize <= 0 {
+		bufferSize = DefaultMQTTBufferSize
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	hdlr := &MQTTHandler{
+		Name:          "",
+		Formatter:     logdog.NewJSONFormatter(),
+		Connect:       connect,
+		Topic:         topic,
+		MaxPacketSize: DefaultMQTTMaxPacketSize,
+		queue:         make(chan mqttMsg, bufferSize),
+		flushes:       make(chan chan error),
+		ctx:           ctx,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+	}
+
+	if err := logdog.ApplyOptionsTo(hdlr, options...); err != nil {
+		logdog.InternalError(err)
+	}
+
+	return hdlr
+}
+
+// init validates QoS and starts the background goroutine on first use
+func (hdlr *MQTTHandler) init() {
+	hdlr.start.Do(func() {
+		hdlr.qos = hdlr.QoS
+		if hdlr.qos > 1 {
+			hdlr.onError(fmt.Errorf("unsupported QoS %d, 1 is used", hdlr.QoS))
+			hdlr.qos = 1
+		}
+		go hdlr.loop()
+	})
+}
+
+// loop publishes queued messages until the handler is closed.
+// Messages stay in the queue while the client is offline, a message
+// whose publishing fails because of connection loss is published
+// again after reconnecting
+func (hdlr *MQTTHandler) loop() {
+	defer close(hdlr.done)
+
+	var client MQTTClient
+	var pending *mqttMsg
+
+	for {
+		if client == nil || !client.IsConnected() {
+			if client = hdlr.reconnect(client); client == nil {
+				if pending != nil {
+					atomic.AddUint64(&hdlr.dropped, 1)
+				}
+				hdlr.drain(nil)
+				return
+			}
+		}
+
+		if pending == nil {
+			// queued messages go before flush requests
+			select {
+			case msg := <-hdlr.queue:
+				pending = &msg
+			default:
+				select {
+				case msg := <-hdlr.queue:
+					pending = &msg
+				case flushed := <-hdlr.flushes:
+					flushed <- nil
+					continue
+				case <-hdlr.ctx.Done():
+					hdlr.drain(client)
+					return
+				}
+			}
+		}
+
+		if err := client.Publish(pending.topic, hdlr.qos, pending.payload); err != nil {
+			if !client.IsConnected() {
+				// publish it again after reconnecting
+				continue
+			}
+			atomic.AddUint64(&hdlr.dropped, 1)
+			hdlr.onError(fmt.Errorf("publish to %s failed, [%v]", pending.topic, err))
+		}
+		pending = nil
+	}
+}
+
+// reconnect disconnects client if it is not nil and connects again with
+// exponential backoff, it returns nil if the handler is closed meanwhile
+func (hdlr *MQTTHandler) reconnect(client MQTTClient) MQTTClient {
+	if client != nil {
+		client.Disconnect()
+	}
+	backoff := mqttMinBackoff
+	for {
+		c, err := hdlr.Connect(hdlr.TLSConfig)
+		if err == nil {
+			return c
+		}
+		hdlr.onError(fmt.Errorf("connect to mqtt broker failed, [%v]", err))
+		if !hdlr.sleep(backoff) {
+			return nil
+		}
+		if backoff *= 2; backoff > mqttMaxBackoff {
+			backoff = mqttMaxBackoff
+		}
+	}
+}
+
+// sleep waits d, flush requests get ErrMQTTOffline meanwhile,
+// it returns false if the handler is closed
+func (hdlr *MQTTHandler) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case flushed := <-hdlr.flushes:
+			flushed <- ErrMQTTOffline
+		case <-hdlr.ctx.Done():
+			return false
+		}
+	}
+}
+
+// drain publishes the remaining messages if the client is connected,
+// then disconnects
+func (hdlr *MQTTHandler) drain(client MQTTClient) {
+	for {
+		select {
+		case msg := <-hdlr.queue:
+			if client == nil || !client.IsConnected() || client.Publish(msg.topic, hdlr.qos, msg.payload) != nil {
+				atomic.AddUint64(&hdlr.dropped, 1)
+			}
+		default:
+			if client != nil {
+				client.Disconnect()
+			}
+			return
+		}
+	}
+}
+
+func (hdlr *MQTTHandler) onError(err error) {
+	if hdlr.OnError != nil {
+		hdlr.OnError(err)
+		return
+	}
+	logdog.InternalError(fmt.Errorf("MQTTHandler failed, [%v]", err))
+}
+
+// ResolveTopic returns the topic of the record
+func (hdlr *MQTTHandler) ResolveTopic(record *logdog.LogRecord) string {
+	if !strings.Contains(hdlr.Topic, "{") {
+		return hdlr.Topic
+	}
+	return strings.NewReplacer(
+		"{level}", strings.ToLower(record.LevelName),
+		"{device}", hdlr.DeviceID,
+	).Replace(hdlr.Topic)
+}
+
+// truncate truncates the payload so the publish packet fits in
+// MaxPacketSize, it is cut at a rune boundary
+func (hdlr *MQTTHandler) truncate(topic string, payload []byte) []byte {
+	if hdlr.MaxPacketSize <= 0 {
+		return payload
+	}
+	limit := hdlr.MaxPacketSize - mqttFixedHeaderSize - 2 - len(topic)
+	if hdlr.qos > 0 {
+		// packet identifier
+		limit -= 2
+	}
+	if len(payload) <= limit {
+		return payload
+	}
+	if limit < 0 {
+		limit = 0
+	}
+	for limit > 0 && !utf8.RuneStart(payload[limit]) {
+		limit--
+	}
+	atomic.AddUint64(&hdlr.truncated, 1)
+	return payload[:limit]
+}
+
+// Emit queues the log record, it never blocks
+func (hdlr *MQTTHandler) Emit(record *logdog.LogRecord) {
+	if hdlr.Connect == nil || hdlr.Formatter == nil {
+		panic("you should set connector and fomatter before use this handler")
+	}
+
+	if record.Level < hdlr.GetLevel() {
+		return
+	}
+	hdlr.init()
+
+	hdlr.mu.Lock()
+	msg, err := hdlr.Formatter.Format(record)
+	hdlr.mu.Unlock()
+	if err != nil {
+		logdog.InternalError(fmt.Errorf("Format record failed, [%v]", err))
+		return
+	}
+
+	select {
+	case <-hdlr.ctx.Done():
+		atomic.AddUint64(&hdlr.dropped, 1)
+		return
+	default:
+	}
+
+	topic := hdlr.ResolveTopic(record)
+	select {
+	case hdlr.queue <- mqttMsg{topic: topic, payload: hdlr.truncate(topic, []byte(msg))}:
+	default:
+		atomic.AddUint64(&hdlr.dropped, 1)
+	}
+}
+
+// Dropped returns the number of records dropped because the buffer
+// is full, publishing failed or the handler is closed
+func (hdlr *MQTTHandler) Dropped() uint64 {
+	return atomic.LoadUint64(&hdlr.dropped)
+}
+
+// Truncated returns the number of records truncated to MaxPacketSize
+func (hdlr *MQTTHandler) Truncated() uint64 {
+	return atomic.LoadUint64(&hdlr.truncated)
+}
+
+// Flush waits until the queued records are published, it returns
+// ErrMQTTOffline if the client is offline
+func (hdlr *MQTTHandler) Flush() error {
+	hdlr.init()
+	flushed := make(chan error, 1)
+	select {
+	case hdlr.flushes <- flushed:
+		return <-flushed
+	case <-hdlr.done:
+		return nil
+	}
+}
+
+// Close publishes the queued records if the client is connected,
+// and disconnects the client
+func (hdlr *MQTTHandler) Close() error {
+	hdlr.init()
+	hdlr.once.Do(hdlr.cancel)
+	<-hdlr.done
+	return nil
+}
@@ -0,0 +1,198 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zoumo/logdog"
+)
+
+// fakeMQTTBroker hands out clients which publish to it
+type fakeMQTTBroker struct {
+	mu        sync.Mutex
+	online    bool
+	connects  int
+	tlsConfig *tls.Config
+	client    *fakeMQTTClient
+	topics    []string
+	payloads  []string
+	qos       []byte
+}
+
+func (b *fakeMQTTBroker) connect(tlsConfig *tls.Config) (MQTTClient, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.connects++
+	b.tlsConfig = tlsConfig
+	if !b.online {
+		return nil, errors.New("connection refused")
+	}
+	b.client = &fakeMQTTClient{broker: b, connected: true}
+	return b.client, nil
+}
+
+func (b *fakeMQTTBroker) setOnline(online bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.online = online
+	if !online && b.client != nil {
+		b.client.connected = false
+	}
+}
+
+func (b *fakeMQTTBroker) published() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.payloads...)
+}
+
+type fakeMQTTClient struct {
+	broker    *fakeMQTTBroker
+	connected bool
+}
+
+func (c *fakeMQTTClient) Publish(topic string, qos byte, payload []byte) error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	if !c.connected {
+		return errors.New("not connected")
+	}
+	c.broker.topics = append(c.broker.topics, topic)
+	c.broker.payloads = append(c.broker.payloads, string(payload))
+	c.broker.qos = append(c.broker.qos, qos)
+	return nil
+}
+
+func (c *fakeMQTTClient) IsConnected() bool {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	return c.connected
+}
+
+func (c *fakeMQTTClient) Disconnect() {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	c.connected = false
+}
+
+// waitPublished waits until n messages are published to the broker
+func waitPublished(t *testing.T, broker *fakeMQTTBroker, n int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for len(broker.published()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %d published messages, got %d", n, len(broker.published()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// payloadMessages extracts the messages from json payloads
+func payloadMessages(payloads []string) []string {
+	msgs := make([]string, 0, len(payloads))
+	for _, payload := range payloads {
+		var v struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal([]byte(payload), &v)
+		msgs = append(msgs, v.Message)
+	}
+	return msgs
+}
+
+func TestMQTTHandler(t *testing.T) {
+	broker := &fakeMQTTBroker{online: true}
+	tlsConfig := &tls.Config{ServerName: "broker"}
+	hdlr := NewMQTTHandler(broker.connect, "devices/{device}/logs/{level}", 0, logdog.InfoLevel)
+	hdlr.DeviceID = "dev-1"
+	hdlr.QoS = 1
+	hdlr.TLSConfig = tlsConfig
+
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.DebugLevel, "a/b.go", "a.b", 1, "filtered"))
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.WarnLevel, "a/b.go", "a.b", 1, "published"))
+	assert.Nil(t, hdlr.Flush())
+
+	assert.Equal(t, []string{"devices/dev-1/logs/warn"}, broker.topics)
+	assert.Equal(t, []byte{1}, broker.qos)
+	assert.Contains(t, broker.payloads[0], `"message":"published"`)
+	assert.True(t, broker.tlsConfig == tlsConfig)
+
+	assert.Nil(t, hdlr.Close())
+	assert.False(t, broker.client.IsConnected())
+}
+
+func TestMQTTHandlerOffline(t *testing.T) {
+	broker := &fakeMQTTBroker{}
+	hdlr := NewMQTTHandler(broker.connect, "logs", 2)
+	var mu sync.Mutex
+	var errs []error
+	hdlr.OnError = func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	}
+
+	for _, msg := range []string{"a", "b", "c", "d"} {
+		hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, msg))
+	}
+	// records stay in the buffer while offline, the newer ones are dropped
+	assert.Equal(t, uint64(2), hdlr.Dropped())
+	assert.Equal(t, ErrMQTTOffline, hdlr.Flush())
+
+	// buffered records are published after reconnecting
+	broker.setOnline(true)
+	waitPublished(t, broker, 2)
+	assert.Nil(t, hdlr.Flush())
+	assert.Equal(t, []string{"a", "b"}, payloadMessages(broker.published()))
+
+	// connection loss while publishing
+	broker.setOnline(false)
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "e"))
+	time.Sleep(20 * time.Millisecond)
+	broker.setOnline(true)
+	waitPublished(t, broker, 3)
+	assert.Equal(t, []string{"a", "b", "e"}, payloadMessages(broker.published()))
+	assert.Nil(t, hdlr.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(t, errs[0].Error(), "connection refused")
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	assert.True(t, broker.connects >= 3)
+}
+
+func TestMQTTHandlerTruncate(t *testing.T) {
+	broker := &fakeMQTTBroker{online: true}
+	hdlr := NewMQTTHandler(broker.connect, "logs", 0)
+	hdlr.MaxPacketSize = 64
+	hdlr.Formatter = logdog.NewTextFormatter()
+	hdlr.Formatter.(*logdog.TextFormatter).Fmt = "%(message)"
+
+	// the limit of payload is 64-5-2-4 = 53 bytes
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, strings.Repeat("x", 52)+"é"))
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "short"))
+	assert.Nil(t, hdlr.Close())
+
+	assert.Equal(t, []string{strings.Repeat("x", 52), "short"}, broker.published())
+	assert.Equal(t, uint64(1), hdlr.Truncated())
+}
@@ -0,0 +1,299 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/zoumo/logdog"
+)
+
+const (
+	// DefaultNATSSubjectFallback replaces subject tokens
+	// whose fields are missing
+	DefaultNATSSubjectFallback = "unknown"
+	// DefaultNATSBufferSize is the default number of records
+	// buffered waiting for acks of JetStream
+	DefaultNATSBufferSize = 1024
+	// DefaultNATSAckRetries is the default number of retries
+	// when publishing to JetStream is not acked
+	DefaultNATSAckRetries = 2
+)
+
+// NATSConn is the subset of *nats.Conn used by NATSHandler,
+// so logdog does not depend on the nats client.
+// Connection management and reconnect are handled by the client.
+type NATSConn interface {
+	Publish(subject string, data []byte) error
+	IsConnected() bool
+}
+
+// NATSJetStream publishes a message to JetStream and waits for the ack,
+// e.g. adapt nats.JetStreamContext by calling Publish and dropping PubAck
+type NATSJetStream interface {
+	PublishAck(subject string, data []byte) error
+}
+
+// natsMsg is a queued message, or a flush request if flushed is not nil
+type natsMsg struct {
+	subject string
+	data    []byte
+	flushed chan struct{}
+}
+
+// NATSHandler is a handler which publishes logging records,
+// appropriately formatted, to a NATS subject.
+// Subject may be a template like "logs.{service}.{level}", {level} is
+// the lowercase level name, {logger} the logger name, others are fields
+// of the record. Tokens of missing fields are replaced with SubjectFallback,
+// '.', '*', '>' and spaces in values are replaced with '_'.
+// Records are dropped while the connection is down,
+// so logging never blocks when the server is unreachable.
+// If JetStream is set, records are published by it for at-least-once
+// delivery instead: they are queued and published by a background
+// goroutine waiting for acks, retried up to AckRetries times, so slow
+// acks never block Emit, records are dropped when BufferSize records are
+// waiting. Publish errors are counted and reported by OnError.
+type NATSHandler struct {
+	Name      string
+	Formatter logdog.Formatter
+	Conn      NATSConn
+	Subject   string
+	// SubjectFallback replaces tokens of missing fields,
+	// "" means DefaultNATSSubjectFallback
+	SubjectFallback string
+	// JetStream, AckRetries and BufferSize should be set before use
+	JetStream  NATSJetStream
+	AckRetries int
+	BufferSize int
+	// OnError is called with publish errors,
+	// errors are printed to stderr if it is nil
+	OnError func(err error)
+	dropped uint64
+	errors  uint64
+	mu      sync.Mutex
+
+	queue  chan natsMsg
+	start  sync.Once
+	closed bool
+	done   chan struct{}
+	logdog.LevelFilterer
+}
+
+// NewNATSHandler returns a new NATSHandler fully initialized
+func NewNATSHandler(conn NATSConn, subject string, options ...logdog.Option) *NATSHandler {
+	hdlr := &NATSHandler{
+		Name:       "",
+		Formatter:  logdog.NewJSONFormatter(),
+		Conn:       conn,
+		Subject:    subject,
+		AckRetries: DefaultNATSAckRetries,
+		BufferSize: DefaultNATSBufferSize,
+		done:       make(chan struct{}),
+	}
+
+	if err := logdog.ApplyOptionsTo(hdlr, options...); err != nil {
+		logdog.InternalError(err)
+	}
+
+	return hdlr
+}
+
+// ResolveSubject returns the subject of the record by the template
+func (hdlr *NATSHandler) ResolveSubject(record *logdog.LogRecord) string {
+	if !strings.Contains(hdlr.Subject, "{") {
+		return hdlr.Subject
+	}
+	fallback := hdlr.SubjectFallback
+	if fallback == "" {
+		fallback = DefaultNATSSubjectFallback
+	}
+
+	var subject strings.Builder
+	tmpl := hdlr.Subject
+	for {
+		i := strings.IndexByte(tmpl, '{')
+		j := strings.IndexByte(tmpl[i+1:], '}')
+		if i < 0 || j < 0 {
+			subject.WriteString(tmpl)
+			return subject.String()
+		}
+		subject.WriteString(tmpl[:i])
+		token := ""
+		switch key := tmpl[i+1 : i+1+j]; key {
+		case "level":
+			token = strings.ToLower(record.LevelName)
+		case "logger":
+			token = record.Name
+		default:
+			if v, ok := record.Fields[key]; ok && v != nil {
+				token = fmt.Sprint(v)
+			}
+		}
+		if token == "" {
+			token = fallback
+		}
+		subject.WriteString(natsTokenReplacer.Replace(token))
+		tmpl = tmpl[i+j+2:]
+	}
+}
+
+// natsTokenReplacer replaces characters not allowed in subject tokens
+var natsTokenReplacer = strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_", "\t", "_", "\n", "_", "\r", "_")
+
+// Emit publishes log record to subject
+func (hdlr *NATSHandler) Emit(record *logdog.LogRecord) {
+	if (hdlr.Conn == nil && hdlr.JetStream == nil) || hdlr.Formatter == nil {
+		panic("you should set conn and fomatter before use this handler")
+	}
+
+	if record.Level < hdlr.GetLevel() {
+		return
+	}
+
+	if hdlr.JetStream == nil && !hdlr.Conn.IsConnected() {
+		atomic.AddUint64(&hdlr.dropped, 1)
+		return
+	}
+
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+
+	msg, err := hdlr.Formatter.Format(record)
+	if err != nil {
+		logdog.InternalError(fmt.Errorf("Format record failed, [%v]", err))
+		return
+	}
+	subject := hdlr.ResolveSubject(record)
+
+	if hdlr.JetStream != nil {
+		hdlr.init()
+		if hdlr.closed {
+			atomic.AddUint64(&hdlr.dropped, 1)
+			return
+		}
+		select {
+		case hdlr.queue <- natsMsg{subject: subject, data: []byte(msg)}:
+		default:
+			atomic.AddUint64(&hdlr.dropped, 1)
+		}
+		return
+	}
+
+	if err := hdlr.Conn.Publish(subject, []byte(msg)); err != nil {
+		atomic.AddUint64(&hdlr.dropped, 1)
+		hdlr.onError(fmt.Errorf("publish to %s failed, [%v]", subject, err))
+	}
+}
+
+// init starts the background goroutine publishing to JetStream on first use
+func (hdlr *NATSHandler) init() {
+	hdlr.start.Do(func() {
+		size := hdlr.BufferSize
+		if size <= 0 {
+			size = DefaultNATSBufferSize
+		}
+		hdlr.queue = make(chan natsMsg, size)
+		go hdlr.loop()
+	})
+}
+
+// loop publishes queued messages to JetStream until the queue is closed
+func (hdlr *NATSHandler) loop() {
+	defer close(hdlr.done)
+	for msg := range hdlr.queue {
+		if msg.flushed != nil {
+			close(msg.flushed)
+			continue
+		}
+		var err error
+		for i := 0; i <= hdlr.AckRetries; i++ {
+			if err = hdlr.JetStream.PublishAck(msg.subject, msg.data); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			atomic.AddUint64(&hdlr.dropped, 1)
+			hdlr.onError(fmt.Errorf("publish to %s is not acked, [%v]", msg.subject, err))
+		}
+	}
+}
+
+// onError counts and reports the publish error
+func (hdlr *NATSHandler) onError(err error) {
+	atomic.AddUint64(&hdlr.errors, 1)
+	if hdlr.OnError != nil {
+		hdlr.OnError(err)
+		return
+	}
+	logdog.InternalError(fmt.Errorf("NATSHandler failed, [%v]", err))
+}
+
+// Dropped returns the number of records dropped because the connection
+// is down, publishing failed, or the buffer of JetStream is full
+func (hdlr *NATSHandler) Dropped() uint64 {
+	return atomic.LoadUint64(&hdlr.dropped)
+}
+
+// Errors returns the number of publish errors,
+// a record not acked after retries counts once
+func (hdlr *NATSHandler) Errors() uint64 {
+	return atomic.LoadUint64(&hdlr.errors)
+}
+
+// Flush waits until records queued for JetStream are acked, and
+// flushes the connection's buffered data to server if the connection
+// supports it
+func (hdlr *NATSHandler) Flush() error {
+	if hdlr.JetStream != nil {
+		hdlr.mu.Lock()
+		hdlr.init()
+		flushed := make(chan struct{})
+		if hdlr.closed {
+			close(flushed)
+		} else {
+			// flush requests are never dropped
+			hdlr.queue <- natsMsg{flushed: flushed}
+		}
+		hdlr.mu.Unlock()
+		<-flushed
+	}
+	if f, ok := hdlr.Conn.(interface {
+		Flush() error
+	}); ok && hdlr.Conn.IsConnected() {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close waits until records queued for JetStream are acked and flushes
+// the connection, the connection itself is owned by caller and will not
+// be closed
+func (hdlr *NATSHandler) Close() error {
+	if hdlr.JetStream != nil {
+		hdlr.mu.Lock()
+		hdlr.init()
+		if !hdlr.closed {
+			hdlr.closed = true
+			close(hdlr.queue)
+		}
+		hdlr.mu.Unlock()
+		<-hdlr.done
+	}
+	return hdlr.Flush()
+}
@@ -0,0 +1,141 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zoumo/logdog"
+)
+
+type fakeNATSConn struct {
+	connected bool
+	subjects  []string
+	msgs      []string
+}
+
+func (c *fakeNATSConn) Publish(subject string, data []byte) error {
+	c.subjects = append(c.subjects, subject)
+	c.msgs = append(c.msgs, string(data))
+	return nil
+}
+
+func (c *fakeNATSConn) IsConnected() bool {
+	return c.connected
+}
+
+func TestNATSHandler(t *testing.T) {
+	conn := &fakeNATSConn{connected: true}
+	hdlr := NewNATSHandler(conn, "logs", logdog.InfoLevel)
+	assert.Equal(t, logdog.InfoLevel, hdlr.Level)
+
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.DebugLevel, "a/b.go", "a.b", 1, "filtered"))
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "published"))
+	assert.Equal(t, []string{"logs"}, conn.subjects)
+	assert.Contains(t, conn.msgs[0], `"message":"published"`)
+
+	conn.connected = false
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "dropped"))
+	assert.Len(t, conn.msgs, 1)
+	assert.Equal(t, uint64(1), hdlr.Dropped())
+	assert.Nil(t, hdlr.Close())
+}
+
+func TestNATSHandlerInterface(t *testing.T) {
+	assert.Implements(t, (*logdog.Handler)(nil), NewNATSHandler(&fakeNATSConn{}, "logs"))
+}
+
+func TestNATSHandlerSubjectTemplate(t *testing.T) {
+	conn := &fakeNATSConn{connected: true}
+	hdlr := NewNATSHandler(conn, "logs.{service}.{level}")
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.WarnLevel, "a/b.go", "a.b", 1, "m", logdog.Fields{"service": "api.v2"}))
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "m"))
+	hdlr.SubjectFallback = "none"
+	hdlr.Subject = "logs.{logger}.{region"
+	hdlr.Emit(logdog.NewLogRecord("", logdog.InfoLevel, "a/b.go", "a.b", 1, "m"))
+	assert.Equal(t, []string{"logs.api_v2.warn", "logs.unknown.info", "logs.none.{region"}, conn.subjects)
+}
+
+type fakeJetStream struct {
+	mu    sync.Mutex
+	fails int
+	acked []string
+}
+
+func (js *fakeJetStream) PublishAck(subject string, data []byte) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if js.fails > 0 {
+		js.fails--
+		return errors.New("ack timeout")
+	}
+	js.acked = append(js.acked, subject)
+	return nil
+}
+
+func TestNATSHandlerJetStream(t *testing.T) {
+	js := &fakeJetStream{fails: 4}
+	var errs []error
+	hdlr := NewNATSHandler(nil, "logs.{level}")
+	hdlr.JetStream = js
+	hdlr.OnError = func(err error) {
+		errs = append(errs, err)
+	}
+
+	// not acked after 2 retries
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "dropped"))
+	// acked by the second retry
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.ErrorLevel, "a/b.go", "a.b", 1, "acked"))
+	assert.Nil(t, hdlr.Flush())
+	assert.Equal(t, []string{"logs.error"}, js.acked)
+	assert.Equal(t, uint64(1), hdlr.Errors())
+	assert.Equal(t, uint64(1), hdlr.Dropped())
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "ack timeout")
+
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "closed"))
+	assert.Nil(t, hdlr.Close())
+	assert.Len(t, js.acked, 2)
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "closed"))
+	assert.Equal(t, uint64(2), hdlr.Dropped())
+}
+
+// blockedJetStream never acks until released
+type blockedJetStream struct {
+	release chan struct{}
+}
+
+func (js *blockedJetStream) PublishAck(subject string, data []byte) error {
+	<-js.release
+	return nil
+}
+
+func TestNATSHandlerJetStreamNonBlocking(t *testing.T) {
+	js := &blockedJetStream{release: make(chan struct{})}
+	hdlr := NewNATSHandler(nil, "logs", logdog.OptionName("nats"))
+	hdlr.JetStream = js
+	hdlr.BufferSize = 2
+
+	// the first record may be taken by the goroutine
+	for i := 0; i < 10; i++ {
+		hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "slow"))
+	}
+	assert.True(t, hdlr.Dropped() >= 7)
+	close(js.release)
+	assert.Nil(t, hdlr.Close())
+}
@@ -0,0 +1,319 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zoumo/logdog"
+)
+
+const (
+	// DefaultRedisBufferSize is the default number of records
+	// buffered waiting for adding
+	DefaultRedisBufferSize = 4096
+	// DefaultRedisBatchSize is the default number of XADDs
+	// pipelined in one round trip
+	DefaultRedisBatchSize = 100
+	// redisMinBackoff and redisMaxBackoff bound the retry backoff
+	redisMinBackoff = 100 * time.Millisecond
+	redisMaxBackoff = 30 * time.Second
+)
+
+// RedisClient is the minimal redis client used by RedisStreamHandler,
+// so logdog does not depend on any redis client library.
+// Connection management and reconnect are handled by the client,
+// adapt e.g. redigo or go-redis to it.
+type RedisClient interface {
+	// Do executes a command, e.g. Do("PING")
+	Do(args ...interface{}) (interface{}, error)
+	// Pipeline executes the commands in one round trip,
+	// it returns the first error
+	Pipeline(cmds [][]interface{}) error
+}
+
+// RedisStreamHandler is a handler which adds logging records to a redis
+// stream by XADD, every entry has the fields time, level, logger and msg,
+// plus the record's fields flattened to strings, nested maps are flattened
+// to dotted keys, and keys colliding with the fixed ones are prefixed by
+// "fields.".
+// If MaxLen > 0, the stream is trimmed by MAXLEN ~ to bound memory.
+// Records are queued and added by a background goroutine, pipelined in
+// batches of up to BatchSize. When adding fails, the error is reported by
+// OnError and the batch is retried with exponential backoff after the
+// connection answers PING again, meanwhile up to BufferSize records are
+// buffered and the newer ones are dropped.
+// Flush and Close send all queued records.
+type RedisStreamHandler struct {
+	Name      string
+	Client    RedisClient
+	Stream    string
+	MaxLen    int
+	BatchSize int
+	// OnError is called with errors of adding,
+	// errors are printed to stderr if it is nil
+	OnError func(err error)
+
+	queue   chan []interface{}
+	flushes chan chan error
+	dropped uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+	err    error
+	logdog.LevelFilterer
+}
+
+// NewRedisStreamHandler returns a new RedisStreamHandler fully initialized,
+// bufferSize <= 0 means DefaultRedisBufferSize
+func NewRedisStreamHandler(client RedisClient, stream string, bufferSize int, options ...logdog.Option) *RedisStreamHandler {
+	if bufferSize <= 0 {
+		bufferSize = DefaultRedisBufferSize
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	hdlr := &RedisStreamHandler{
+		Name:      "",
+		Client:    client,
+		Stream:    stream,
+		BatchSize: DefaultRedisBatchSize,
+		queue:     make(chan []interface{}, bufferSize),
+		flushes:   make(chan chan error),
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	if err := logdog.ApplyOptionsTo(hdlr, options...); err != nil {
+		logdog.InternalError(err)
+	}
+
+	go hdlr.loop()
+
+	return hdlr
+}
+
+// loop adds queued records until the handler is closed,
+// a failed batch is retried before taking new records
+func (hdlr *RedisStreamHandler) loop() {
+	defer close(hdlr.done)
+
+	var batch [][]interface{}
+	backoff := redisMinBackoff
+
+	for {
+		queue := hdlr.queue
+		var timer *time.Timer
+		var retry <-chan time.Time
+		if len(batch) > 0 {
+			timer = time.NewTimer(backoff)
+			queue, retry = nil, timer.C
+		}
+
+		select {
+		case cmd := <-queue:
+			batch = hdlr.gather(append(batch, cmd))
+		case <-retry:
+			if _, err := hdlr.Client.Do("PING"); err != nil {
+				if backoff *= 2; backoff > redisMaxBackoff {
+					backoff = redisMaxBackoff
+				}
+				continue
+			}
+		case flushed := <-hdlr.flushes:
+			var err error
+			batch, err = hdlr.sendAll(batch)
+			flushed <- err
+		case <-hdlr.ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			if batch, hdlr.err = hdlr.sendAll(batch); hdlr.err != nil {
+				atomic.AddUint64(&hdlr.dropped, uint64(len(batch)+len(hdlr.queue)))
+			}
+			return
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+
+		if len(batch) == 0 {
+			continue
+		}
+		if err := hdlr.send(batch); err != nil {
+			if backoff *= 2; backoff > redisMaxBackoff {
+				backoff = redisMaxBackoff
+			}
+			continue
+		}
+		batch = batch[:0]
+		backoff = redisMinBackoff
+	}
+}
+
+// gather appends queued commands to batch without blocking,
+// until it has BatchSize commands
+func (hdlr *RedisStreamHandler) gather(batch [][]interface{}) [][]interface{} {
+	for len(batch) < hdlr.BatchSize {
+		select {
+		case cmd := <-hdlr.queue:
+			batch = append(batch, cmd)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// sendAll sends batch and all queued commands, it returns
+// the batch not sent and the error if sending failed
+func (hdlr *RedisStreamHandler) sendAll(batch [][]interface{}) ([][]interface{}, error) {
+	for {
+		batch = hdlr.gather(batch)
+		if len(batch) == 0 {
+			return batch, nil
+		}
+		if err := hdlr.send(batch); err != nil {
+			return batch, err
+		}
+		batch = batch[:0]
+	}
+}
+
+// send pipelines the batch, the error is reported by OnError
+func (hdlr *RedisStreamHandler) send(batch [][]interface{}) error {
+	err := hdlr.Client.Pipeline(batch)
+	if err != nil {
+		err = fmt.Errorf("add %d entries to stream %s failed, [%v]", len(batch), hdlr.Stream, err)
+		if hdlr.OnError != nil {
+			hdlr.OnError(err)
+		} else {
+			logdog.InternalError(fmt.Errorf("RedisStreamHandler failed, [%v]", err))
+		}
+	}
+	return err
+}
+
+// xadd returns the XADD command adding the record
+func (hdlr *RedisStreamHandler) xadd(record *logdog.LogRecord) []interface{} {
+	cmd := []interface{}{"XADD", hdlr.Stream}
+	if hdlr.MaxLen > 0 {
+		cmd = append(cmd, "MAXLEN", "~", hdlr.MaxLen)
+	}
+	cmd = append(cmd, "*",
+		"time", record.Time.Format(time.RFC3339Nano),
+		"level", record.LevelName,
+		"logger", record.Name,
+		"msg", record.GetMessage(),
+	)
+
+	fields := make(map[string]string, len(record.Fields))
+	flattenRedisFields(fields, "", record.Fields)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name := k
+		switch name {
+		case "time", "level", "logger", "msg":
+			name = "fields." + name
+		}
+		cmd = append(cmd, name, fields[k])
+	}
+	return cmd
+}
+
+// flattenRedisFields flattens fields to strings, nested maps
+// are flattened to dotted keys
+func flattenRedisFields(dst map[string]string, prefix string, fields map[string]interface{}) {
+	for k, v := range fields {
+		switch vv := v.(type) {
+		case logdog.Fields:
+			flattenRedisFields(dst, prefix+k+".", vv)
+		case map[string]interface{}:
+			flattenRedisFields(dst, prefix+k+".", vv)
+		case string:
+			dst[prefix+k] = vv
+		case time.Time:
+			dst[prefix+k] = vv.Format(time.RFC3339Nano)
+		case error:
+			dst[prefix+k] = vv.Error()
+		case nil:
+			dst[prefix+k] = ""
+		default:
+			dst[prefix+k] = fmt.Sprint(v)
+		}
+	}
+}
+
+// Emit queues the log record, it never blocks
+func (hdlr *RedisStreamHandler) Emit(record *logdog.LogRecord) {
+	if hdlr.Client == nil {
+		panic("you should set client before use this handler")
+	}
+
+	if record.Level < hdlr.GetLevel() {
+		return
+	}
+
+	select {
+	case <-hdlr.ctx.Done():
+		atomic.AddUint64(&hdlr.dropped, 1)
+		return
+	default:
+	}
+
+	select {
+	case hdlr.queue <- hdlr.xadd(record):
+	default:
+		atomic.AddUint64(&hdlr.dropped, 1)
+	}
+}
+
+// Dropped returns the number of records dropped because the buffer
+// is full, or they are not sent when the handler is closed
+func (hdlr *RedisStreamHandler) Dropped() uint64 {
+	return atomic.LoadUint64(&hdlr.dropped)
+}
+
+// Flush sends all queued records in pipelines, it returns the error
+// of sending, the records not sent are retried in the background
+func (hdlr *RedisStreamHandler) Flush() error {
+	flushed := make(chan error, 1)
+	select {
+	case hdlr.flushes <- flushed:
+		return <-flushed
+	case <-hdlr.done:
+		return nil
+	}
+}
+
+// Close sends all queued records and stops the background goroutine,
+// it returns the error of sending, the records not sent are dropped.
+// The client is owned by caller and will not be closed
+func (hdlr *RedisStreamHandler) Close() error {
+	hdlr.once.Do(hdlr.cancel)
+	<-hdlr.done
+	return hdlr.err
+}
@@ -0,0 +1,126 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zoumo/logdog"
+)
+
+type fakeRedisClient struct {
+	mu        sync.Mutex
+	pipelines [][][]interface{}
+	down      bool
+	pings     int
+}
+
+func (c *fakeRedisClient) Do(args ...interface{}) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pings++
+	if c.down {
+		return nil, errors.New("connection refused")
+	}
+	return "PONG", nil
+}
+
+func (c *fakeRedisClient) Pipeline(cmds [][]interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.down {
+		return errors.New("connection reset")
+	}
+	c.pipelines = append(c.pipelines, append([][]interface{}(nil), cmds...))
+	return nil
+}
+
+func (c *fakeRedisClient) setDown(down bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.down = down
+}
+
+func (c *fakeRedisClient) entries() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, p := range c.pipelines {
+		n += len(p)
+	}
+	return n
+}
+
+func TestRedisStreamHandler(t *testing.T) {
+	client := &fakeRedisClient{}
+	hdlr := NewRedisStreamHandler(client, "logs", 0, logdog.InfoLevel)
+	hdlr.MaxLen = 1000
+
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.DebugLevel, "a/b.go", "a.b", 1, "filtered"))
+	record := logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "hello",
+		logdog.Fields{"user": "jim", "level": 1, "req": map[string]interface{}{"id": 2}, "err": errors.New("boom")})
+	hdlr.Emit(record)
+	assert.Nil(t, hdlr.Flush())
+
+	assert.Len(t, client.pipelines, 1)
+	assert.Equal(t, []interface{}{
+		"XADD", "logs", "MAXLEN", "~", 1000, "*",
+		"time", record.Time.Format(time.RFC3339Nano),
+		"level", "INFO", "logger", "test", "msg", "hello",
+		"err", "boom", "fields.level", "1", "req.id", "2", "user", "jim",
+	}, client.pipelines[0][0])
+
+	assert.Nil(t, hdlr.Close())
+	hdlr.Emit(record)
+	assert.Equal(t, uint64(1), hdlr.Dropped())
+}
+
+func TestRedisStreamHandlerReconnect(t *testing.T) {
+	client := &fakeRedisClient{down: true}
+	var mu sync.Mutex
+	var errs []error
+	hdlr := NewRedisStreamHandler(client, "logs", 4)
+	hdlr.OnError = func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "buffered"))
+	}
+	assert.NotNil(t, hdlr.Flush())
+	// the failed batch is kept, newer records are dropped when the buffer is full
+	for i := 0; i < 6; i++ {
+		hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "queued"))
+	}
+	assert.Equal(t, uint64(2), hdlr.Dropped())
+
+	// the batch is retried after the connection answers PING
+	client.setDown(false)
+	for client.entries() < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Nil(t, hdlr.Close())
+	assert.Equal(t, 6, client.entries())
+	mu.Lock()
+	assert.NotEmpty(t, errs)
+	assert.Contains(t, errs[0].Error(), "entries to stream logs failed")
+	mu.Unlock()
+}
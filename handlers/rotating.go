@@ -15,12 +15,242 @@ package handler
 
 import (
 	"bytes"
+	"compress/gzip"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/zoumo/logdog"
 )
 
+const (
+	// DefaultNamePattern is the default pattern of rotated files,
+	// e.g. app.log.1, app.log.2, the smaller sequence is the newer one
+	DefaultNamePattern = "{base}.{seq}"
+	// DefaultNameTimeLayout is the layout of {time} without a layout
+	DefaultNameTimeLayout = "20060102T150405"
+	// DefaultMaintenanceTimeout is the default max duration Close waits
+	// for pending rotations
+	DefaultMaintenanceTimeout = 10 * time.Second
+
+	// gzipExt is the extension of compressed rotated files
+	gzipExt = ".gz"
+	// lockExt is the extension of the lock file of SharedRotation
+	lockExt = ".lock"
+	// rotationQueueSize is the number of rotations queued for the
+	// maintenance goroutine before rollovers are dropped
+	rotationQueueSize = 16
+)
+
+// tempSeq keeps the names of pending and spare files unique
+var tempSeq uint64
+
+// nameTokenRegexp matches the tokens in NamePattern
+var nameTokenRegexp = regexp.MustCompile(`\{(base|name|ext|seq|time)(?::([^}]*))?\}`)
+
+// namePattern is a parsed NamePattern bound to a file
+type namePattern struct {
+	base   string
+	name   string
+	ext    string
+	layout string
+	raw    string
+	// hasTime and hasSeq report if the pattern has {time} or {seq}
+	hasTime bool
+	hasSeq  bool
+	regexp  *regexp.Regexp
+	// timeIndex and seqIndex are the submatch indexes in regexp
+	timeIndex int
+	seqIndex  int
+}
+
+// parseNamePattern parses the pattern of rotated files of the file base,
+// it returns error if the pattern can not be parsed back from file names
+func parseNamePattern(base string, pattern string) (*namePattern, error) {
+	if strings.ContainsRune(pattern, filepath.Separator) || strings.ContainsRune(pattern, '/') {
+		return nil, fmt.Errorf("name pattern %q should not contain path separator", pattern)
+	}
+
+	ext := filepath.Ext(base)
+	p := &namePattern{
+		base:   base,
+		name:   strings.TrimSuffix(base, ext),
+		ext:    ext,
+		layout: DefaultNameTimeLayout,
+		raw:    pattern,
+	}
+
+	var expr bytes.Buffer
+	expr.WriteString("^")
+	hasBase := false
+	group := 0
+	last := 0
+	for _, match := range nameTokenRegexp.FindAllStringSubmatchIndex(pattern, -1) {
+		expr.WriteString(regexp.QuoteMeta(pattern[last:match[0]]))
+		last = match[1]
+
+		switch token := pattern[match[2]:match[3]]; token {
+		case "base":
+			hasBase = true
+			expr.WriteString(regexp.QuoteMeta(p.base))
+		case "name":
+			hasBase = true
+			expr.WriteString(regexp.QuoteMeta(p.name))
+		case "ext":
+			expr.WriteString(regexp.QuoteMeta(p.ext))
+		case "seq":
+			if p.hasSeq {
+				return nil, fmt.Errorf("name pattern %q has more than one {seq}", pattern)
+			}
+			p.hasSeq = true
+			group++
+			p.seqIndex = group
+			expr.WriteString(`(\d+)`)
+		case "time":
+			if p.hasTime {
+				return nil, fmt.Errorf("name pattern %q has more than one {time}", pattern)
+			}
+			p.hasTime = true
+			if match[4] >= 0 && match[5] > match[4] {
+				p.layout = pattern[match[4]:match[5]]
+			}
+			group++
+			p.timeIndex = group
+			expr.WriteString(`(.+?)`)
+		}
+	}
+	expr.WriteString(regexp.QuoteMeta(pattern[last:]))
+	expr.WriteString("$")
+
+	if !hasBase {
+		return nil, fmt.Errorf("name pattern %q should contain {base} or {name}", pattern)
+	}
+	if !p.hasTime && !p.hasSeq {
+		return nil, fmt.Errorf("name pattern %q should contain {time} or {seq}", pattern)
+	}
+
+	p.regexp = regexp.MustCompile(expr.String())
+	return p, nil
+}
+
+// format returns the file name of the rotated file
+func (p *namePattern) format(t time.Time, seq int) string {
+	return nameTokenRegexp.ReplaceAllStringFunc(p.raw, func(token string) string {
+		match := nameTokenRegexp.FindStringSubmatch(token)
+		switch match[1] {
+		case "base":
+			return p.base
+		case "name":
+			return p.name
+		case "ext":
+			return p.ext
+		case "seq":
+			return strconv.Itoa(seq)
+		default:
+			return t.Format(p.layout)
+		}
+	})
+}
+
+// parse extracts the rotation time in the local time zone and sequence
+// from the file name of a rotated file, ok is false if the name does not
+// match the pattern
+func (p *namePattern) parse(filename string) (t time.Time, seq int, ok bool) {
+	return p.parseIn(filename, time.Local)
+}
+
+// parseIn is like parse, but the rotation time is in loc
+func (p *namePattern) parseIn(filename string, loc *time.Location) (t time.Time, seq int, ok bool) {
+	match := p.regexp.FindStringSubmatch(filename)
+	if match == nil {
+		return t, 0, false
+	}
+	if p.hasTime {
+		var err error
+		if t, err = time.ParseInLocation(p.layout, match[p.timeIndex], loc); err != nil {
+			return t, 0, false
+		}
+	}
+	if p.hasSeq {
+		var err error
+		if seq, err = strconv.Atoi(match[p.seqIndex]); err != nil {
+			return t, 0, false
+		}
+	}
+	return t, seq, true
+}
+
+// rotatedFile is a rotated file found in the directory
+type rotatedFile struct {
+	path    string
+	ext     string
+	time    time.Time
+	seq     int
+	size    int64
+	modTime time.Time
+}
+
+// rotation is a file rotated by Emit, which is waiting for
+// the maintenance goroutine to be renamed, compressed and pruned
+type rotation struct {
+	file    io.Closer
+	pending string
+	time    time.Time
+	// lock is the lock of SharedRotation released after rotating
+	lock *os.File
+}
+
+// RotatingFileHandler is a handler which writes logging records to a file,
+// and rotates the file when it reaches MaxSize bytes or MaxLine lines,
+// or when the day changes in Location if Daily is true.
+// Rotated files are named by NamePattern, which supports the tokens:
+//
+//	{base}         the base name of the file, e.g. app.log
+//	{name}         the base name without extension, e.g. app
+//	{ext}          the extension of the file, e.g. .log
+//	{time:layout}  the rotation time in Location formatted by the layout,
+//	               {time} means DefaultNameTimeLayout
+//	{seq}          the sequence number
+//
+// e.g. "{name}-{time:20060102T1504}{ext}" names app-20240501T0300.log.
+// Without {time}, rotated files are shifted like python logging,
+// the smaller sequence is the newer one. With {time}, {seq} starts from 1
+// to distinguish files rotated in the same time layout unit, the record
+// is appended to the existing one if there is no {seq}.
+// After each rotation, the oldest rotated files are removed until at most
+// BackupCount files are kept, none of them is older than MaxAge and their
+// total size is at most MaxTotalSize bytes, 0 means no limit. The age of
+// a rotated file is its rotation time if NamePattern has {time},
+// otherwise its modification time.
+// Rotated files are compressed by gzip with .gz extension if Compress is true.
+//
+// Emit only renames the file to a pending name and swaps to a new file
+// pre-opened in the background when the file should be rotated, renaming,
+// compressing and pruning rotated files are done by a background
+// maintenance goroutine in order, and Close waits for them at most
+// MaintenanceTimeout. If rotationQueueSize rotations are still pending,
+// the rollover is dropped and counted by DroppedRotations, the file keeps
+// growing until a later record rotates it.
+//
+// SharedRotation makes rotation safe for several cooperating processes
+// appending to the same file, each with its own handler: a rollover takes
+// an exclusive lock of the file Path + ".lock", flock on unix or the
+// existence of the lock file on other platforms, which is held until the
+// rotated file is renamed and pruned. After the lock is acquired, the file
+// is reopened instead of rotated if another process rotated it already.
+// Every Emit compares the file with the one in Path and refreshes CurSize
+// from it, so MaxSize counts the writes of all processes, MaxLine counts
+// the lines written by this handler only. It does not protect the file
+// from processes not using logdog.
 type RotatingFileHandler struct {
 	logdog.FileHandler
 
@@ -31,20 +261,654 @@ type RotatingFileHandler struct {
 	CurSize int
 
 	Daily bool
+
+	BackupCount  int
+	MaxAge       time.Duration
+	MaxTotalSize int64
+	NamePattern  string
+	Compress     bool
+	// SharedRotation should be set before use, e.g. by an option
+	SharedRotation bool
+
+	MaintenanceTimeout time.Duration
+
+	pattern   *namePattern
+	openTime  time.Time
+	rotations chan rotation
+	// spares holds the next file pre-opened by the maintenance goroutine
+	spares  chan *os.File
+	done    chan struct{}
+	closed  bool
+	dropped uint64
+	// opened is false until OnOpen is called with the current file
+	opened bool
+	// buf is reused by Emit under mu to avoid allocations
+	buf []byte
+	mu  sync.Mutex
+}
+
+// NewRotatingFileHandler returns a new RotatingFileHandler fully initialized,
+// which writes to the file located in the path.
+// An empty namePattern means DefaultNamePattern, it returns error if the
+// namePattern is invalid or the file can not be opened
+func NewRotatingFileHandler(path string, namePattern string, options ...logdog.Option) (*RotatingFileHandler, error) {
+	if path == "" {
+		return nil, fmt.Errorf("should provide a valid file path")
+	}
+	if namePattern == "" {
+		namePattern = DefaultNamePattern
+	}
+	pattern, err := parseNamePattern(filepath.Base(path), namePattern)
+	if err != nil {
+		return nil, err
+	}
+
+	hdlr := &RotatingFileHandler{
+		NamePattern:        namePattern,
+		MaintenanceTimeout: DefaultMaintenanceTimeout,
+		pattern:            pattern,
+		rotations:          make(chan rotation, rotationQueueSize),
+		spares:             make(chan *os.File, 1),
+		done:               make(chan struct{}),
+	}
+	hdlr.Name = ""
+	hdlr.Level = logdog.NothingLevel
+	hdlr.Formatter = logdog.DefaultFormatter
+	hdlr.Path = path
+
+	if err := logdog.ApplyOptionsTo(hdlr, options...); err != nil {
+		return nil, err
+	}
+
+	if err := hdlr.open(); err != nil {
+		return nil, err
+	}
+
+	go hdlr.maintain()
+
+	return hdlr, nil
 }
 
-func NewRotatingFileHandler(name string, path string) {
+// open opens the file and loads its size and lines,
+// the header of a new file does not count
+func (hdlr *RotatingFileHandler) open() error {
+	file, err := os.OpenFile(hdlr.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, hdlr.OpenMode())
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if info.Size() == 0 {
+		writeHeader(file, hdlr.HeaderFunc)
+	}
+
+	hdlr.Output = file
+	hdlr.opened = false
+	hdlr.CurSize = int(info.Size())
+	hdlr.CurLine = 0
+	hdlr.openTime = logdog.Now()
+	if hdlr.CurSize > 0 {
+		hdlr.openTime = info.ModTime()
+		if hdlr.CurLine, err = hdlr.countLine(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeHeader writes the header to the new file, a failure is
+// printed to stderr and does not prevent logging to the file
+func writeHeader(file *os.File, header func(*os.File) error) {
+	if err := logdog.WriteFileHeader(file, header); err != nil {
+		logdog.InternalError(fmt.Errorf("Write file header failed, [%v]", err))
+	}
+}
+
+// callOnOpen calls OnOpen with the file, it does not count in CurSize
+// and CurLine, a failure is printed to stderr and does not prevent
+// logging to the file
+func callOnOpen(onOpen func(io.Writer) error, w io.Writer) {
+	if onOpen == nil {
+		return
+	}
+	if err := onOpen(w); err != nil {
+		logdog.InternalError(fmt.Errorf("Call OnOpen failed, [%v]", err))
+	}
+}
+
+// Reopen reopens the file located in Path and closes the old one,
+// e.g. on SIGHUP after the file is moved by others
+func (hdlr *RotatingFileHandler) Reopen() error {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	old := hdlr.Output
+	if err := hdlr.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+// reopen swaps to the file pre-opened by the maintenance goroutine at
+// now, the file is opened in place if there is none yet
+func (hdlr *RotatingFileHandler) reopen(now time.Time) error {
+	select {
+	case spare := <-hdlr.spares:
+		// renaming would replace the file opened by another process
+		if hdlr.SharedRotation {
+			spare.Close()
+			os.Remove(spare.Name())
+			break
+		}
+		if err := os.Rename(spare.Name(), hdlr.Path); err == nil {
+			hdlr.Output = spare
+			hdlr.opened = false
+			hdlr.CurSize = 0
+			hdlr.CurLine = 0
+			hdlr.openTime = now
+			return nil
+		}
+		spare.Close()
+		os.Remove(spare.Name())
+	default:
+	}
+	if err := hdlr.open(); err != nil {
+		return err
+	}
+	hdlr.openTime = now
+	return nil
+}
+
+// recordTime returns the time of the record,
+// or the current time if it is not set
+func recordTime(record *logdog.LogRecord) time.Time {
+	if record.Time.IsZero() {
+		return logdog.Now()
+	}
+	return record.Time
+}
+
+// Emit log record to file, rotates the file before writing if needed
+func (hdlr *RotatingFileHandler) Emit(record *logdog.LogRecord) {
+	if record.Level < hdlr.GetLevel() {
+		return
+	}
+
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+
+	if hdlr.closed {
+		logdog.InternalError(fmt.Errorf("Write file %s failed, [%v]", hdlr.Path, logdog.ErrHandlerClosed))
+		hdlr.CountDropped()
+		return
+	}
+
+	// Output is swapped by rotating
+	if hdlr.Output == nil || hdlr.Formatter == nil {
+		panic("you should set output and fomatter before use this handler")
+	}
+
+	line, err := logdog.AppendFormat(hdlr.buf[:0], hdlr.Formatter, record)
+	if err != nil {
+		logdog.InternalError(fmt.Errorf("Format record failed, [%v]", err))
+		hdlr.CountFailed(err)
+		return
+	}
+	line = append(line, '\n')
+	hdlr.buf = line
+
+	// the time of the record is used, it may be earlier than now
+	now := recordTime(record)
+	if !hdlr.closed && hdlr.SharedRotation {
+		hdlr.syncShared(false)
+	}
+	if !hdlr.closed && hdlr.shouldRollover(now, len(line)) {
+		var err error
+		if hdlr.SharedRotation {
+			err = hdlr.sharedRollover(now, len(line))
+		} else {
+			err = hdlr.doRollover(now, nil)
+		}
+		if err != nil {
+			logdog.InternalError(fmt.Errorf("Rotate file failed, [%v]", err))
+		}
+	}
+
+	if !hdlr.opened {
+		hdlr.opened = true
+		callOnOpen(hdlr.OnOpen, hdlr.Output)
+	}
+	n, err := hdlr.Output.Write(line)
+	hdlr.CurSize += n
+	hdlr.CurLine++
+	if err != nil {
+		hdlr.CountFailed(err)
+	} else {
+		hdlr.CountEmitted()
+	}
+}
+
+// Flush flushes the file system's in-memory copy
+// of recently written data to disk.
+func (hdlr *RotatingFileHandler) Flush() error {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	return hdlr.FileHandler.Flush()
+}
+
+// Close file and waits for pending rotations at most MaintenanceTimeout,
+// if not return error
+func (hdlr *RotatingFileHandler) Close() error {
+	hdlr.mu.Lock()
+	if hdlr.closed {
+		hdlr.mu.Unlock()
+		return nil
+	}
+	hdlr.closed = true
+	err := hdlr.FileHandler.Close()
+	close(hdlr.rotations)
+	hdlr.mu.Unlock()
+
+	timeout := hdlr.MaintenanceTimeout
+	if timeout <= 0 {
+		timeout = DefaultMaintenanceTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-hdlr.done:
+	case <-timer.C:
+		return fmt.Errorf("wait for pending rotations timeout after %v", timeout)
+	}
+	return err
+}
 
+// DroppedRotations returns the number of rollovers dropped because
+// the maintenance goroutine was behind
+func (hdlr *RotatingFileHandler) DroppedRotations() uint64 {
+	return atomic.LoadUint64(&hdlr.dropped)
 }
 
-func (hdlr RotatingFileHandler) shouldRollover(size int) bool {
-	needed := (hdlr.MaxSize > 0 && (hdlr.CurSize+size) >= hdlr.MaxSize) ||
-		(hdlr.MaxLine > 0 && (hdlr.CurLine+1) >= hdlr.MaxLine)
+// shouldRollover checks if the file should be rotated before writing
+// size bytes of a record logged at now, records of earlier days, e.g.
+// logged with an event time, do not rotate a daily file
+func (hdlr *RotatingFileHandler) shouldRollover(now time.Time, size int) bool {
+	if hdlr.CurSize == 0 {
+		// never rotate an empty file
+		return false
+	}
+	needed := (hdlr.MaxSize > 0 && (hdlr.CurSize+size) > hdlr.MaxSize) ||
+		(hdlr.MaxLine > 0 && (hdlr.CurLine+1) > hdlr.MaxLine)
+	if !needed && hdlr.Daily {
+		// days of Location, which may be 23 or 25 hours long
+		y1, m1, d1 := hdlr.inLocation(hdlr.openTime).Date()
+		y2, m2, d2 := hdlr.inLocation(now).Date()
+		needed = y2 > y1 || (y2 == y1 && (m2 > m1 || (m2 == m1 && d2 > d1)))
+	}
 	return needed
 }
 
-func (hdlr RotatingFileHandler) doRollover() {
+// syncShared refreshes CurSize from the file, and reopens the file if
+// it is no longer the one in Path, i.e. another process rotated it,
+// the lock is taken to reopen unless locked is true.
+// It returns true if the file is reopened
+func (hdlr *RotatingFileHandler) syncShared(locked bool) bool {
+	file, ok := hdlr.Output.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	if current, err := os.Stat(hdlr.Path); err == nil && os.SameFile(info, current) {
+		hdlr.CurSize = int(info.Size())
+		return false
+	}
+
+	if !locked {
+		// wait until the other process finishes rotating
+		lock, err := lockFile(hdlr.Path + lockExt)
+		if err != nil {
+			logdog.InternalError(fmt.Errorf("Lock file failed, [%v]", err))
+			return false
+		}
+		defer unlockFile(lock)
+	}
+	if err := hdlr.open(); err != nil {
+		logdog.InternalError(fmt.Errorf("Reopen file failed, [%v]", err))
+	}
+	if hdlr.Output == io.Writer(file) {
+		return false
+	}
+	file.Close()
+	return true
+}
+
+// sharedRollover rotates the file at now under the lock of SharedRotation,
+// the file is only reopened if another process rotated it while waiting
+// for the lock
+func (hdlr *RotatingFileHandler) sharedRollover(now time.Time, size int) error {
+	lock, err := lockFile(hdlr.Path + lockExt)
+	if err != nil {
+		return err
+	}
+	if hdlr.syncShared(true) || !hdlr.shouldRollover(now, size) {
+		return unlockFile(lock)
+	}
+	return hdlr.doRollover(now, lock)
+}
+
+// doRollover renames the file to a pending name, reopens the file and
+// queues the pending one rotated at now for the maintenance goroutine,
+// it never blocks, the rollover is dropped if the queue is full.
+// The lock, if not nil, is released once the rotation is done
+func (hdlr *RotatingFileHandler) doRollover(now time.Time, lock *os.File) error {
+	defer func() {
+		// the lock of a queued rotation is released by the maintenance goroutine
+		if lock != nil {
+			unlockFile(lock)
+		}
+	}()
+
+	// Emit is the only sender and holds mu, so the queue can not
+	// become full after the check
+	if len(hdlr.rotations) == cap(hdlr.rotations) {
+		atomic.AddUint64(&hdlr.dropped, 1)
+		return nil
+	}
+
+	// the sequence keeps pending names unique even with a fake clock
+	pending := filepath.Join(filepath.Dir(hdlr.Path),
+		fmt.Sprintf(".%s.rotating-%d-%d", hdlr.pattern.base, now.UnixNano(), atomic.AddUint64(&tempSeq, 1)))
+	if err := os.Rename(hdlr.Path, pending); err != nil {
+		return err
+	}
+
+	old := hdlr.Output
+	if err := hdlr.reopen(now); err != nil {
+		// keep writing to the old file
+		os.Rename(pending, hdlr.Path)
+		return err
+	}
+
+	hdlr.rotations <- rotation{
+		file:    old,
+		pending: pending,
+		time:    now,
+		lock:    lock,
+	}
+	lock = nil
+	return nil
+}
+
+// maintain pre-opens the next file, renames, compresses and prunes
+// rotated files in order until the handler is closed
+func (hdlr *RotatingFileHandler) maintain() {
+	defer close(hdlr.done)
+	defer hdlr.removeSpare()
+
+	hdlr.prepareSpare()
+	for r := range hdlr.rotations {
+		// the next rollover should not wait for this one
+		hdlr.prepareSpare()
+
+		if err := r.file.Close(); err != nil {
+			logdog.InternalError(fmt.Errorf("Close file failed, [%v]", err))
+		}
+
+		var err error
+		if hdlr.pattern.hasTime {
+			err = hdlr.rotateByTime(r.pending, r.time)
+		} else {
+			err = hdlr.rotateBySeq(r.pending)
+		}
+		if err == nil {
+			err = hdlr.prune(r.time)
+		}
+		if err != nil {
+			logdog.InternalError(fmt.Errorf("Rotate file failed, [%v]", err))
+		}
+		if r.lock != nil {
+			unlockFile(r.lock)
+		}
+	}
+}
+
+// prepareSpare opens the next file under a hidden name if there is none,
+// Emit renames it to the path when rotating
+func (hdlr *RotatingFileHandler) prepareSpare() {
+	// maintain is the only sender, so there is room after the check
+	if len(hdlr.spares) > 0 || hdlr.SharedRotation {
+		return
+	}
+	name := filepath.Join(filepath.Dir(hdlr.Path),
+		fmt.Sprintf(".%s.next-%d-%d", hdlr.pattern.base, logdog.Now().UnixNano(), atomic.AddUint64(&tempSeq, 1)))
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_EXCL, hdlr.OpenMode())
+	if err != nil {
+		logdog.InternalError(fmt.Errorf("Open file failed, [%v]", err))
+		return
+	}
+	writeHeader(file, hdlr.HeaderFunc)
+	hdlr.spares <- file
+}
+
+// removeSpare closes and removes the unused pre-opened file
+func (hdlr *RotatingFileHandler) removeSpare() {
+	select {
+	case spare := <-hdlr.spares:
+		spare.Close()
+		os.Remove(spare.Name())
+	default:
+	}
+}
+
+// rotatedPath returns the path of the rotated file
+func (hdlr *RotatingFileHandler) rotatedPath(t time.Time, seq int) string {
+	return filepath.Join(filepath.Dir(hdlr.Path), hdlr.pattern.format(hdlr.inLocation(t), seq))
+}
+
+// inLocation returns t in Location
+func (hdlr *RotatingFileHandler) inLocation(t time.Time) time.Time {
+	if hdlr.Location == nil {
+		return t
+	}
+	return t.In(hdlr.Location)
+}
+
+// location returns the time zone of rotation names
+func (hdlr *RotatingFileHandler) location() *time.Location {
+	if hdlr.Location == nil {
+		return time.Local
+	}
+	return hdlr.Location
+}
+
+// rotatedExists checks if the rotated file exists, compressed or not
+func (hdlr *RotatingFileHandler) rotatedExists(path string) bool {
+	return exists(path) || exists(path+gzipExt)
+}
+
+// rotateBySeq shifts rotated files and renames the pending file
+// to the first one
+func (hdlr *RotatingFileHandler) rotateBySeq(pending string) error {
+	files, err := hdlr.rotatedFiles()
+	if err != nil {
+		return err
+	}
+	// files are sorted from the newest, shift them from the oldest
+	for i := len(files) - 1; i >= 0; i-- {
+		f := files[i]
+		if hdlr.BackupCount > 0 && f.seq >= hdlr.BackupCount {
+			// it would be pruned after shifting
+			if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if err := os.Rename(f.path, hdlr.rotatedPath(f.time, f.seq+1)+f.ext); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
 
+	target := hdlr.rotatedPath(time.Time{}, 1)
+	if hdlr.Compress {
+		return gzipFile(target+gzipExt, pending, hdlr.OpenMode())
+	}
+	return os.Rename(pending, target)
+}
+
+// rotateByTime renames the pending file to the one of the rotation time
+func (hdlr *RotatingFileHandler) rotateByTime(pending string, now time.Time) error {
+	seq := 1
+	target := hdlr.rotatedPath(now, seq)
+	for hdlr.pattern.hasSeq && hdlr.rotatedExists(target) {
+		seq++
+		target = hdlr.rotatedPath(now, seq)
+	}
+
+	if hdlr.Compress {
+		// appended as a new gzip member if it exists
+		return gzipFile(target+gzipExt, pending, hdlr.OpenMode())
+	}
+	if exists(target) {
+		return appendFile(target, pending)
+	}
+	return os.Rename(pending, target)
+}
+
+// prune removes the oldest rotated files exceeding BackupCount, MaxAge or
+// MaxTotalSize, files removed by others meanwhile are ignored
+func (hdlr *RotatingFileHandler) prune(now time.Time) error {
+	if hdlr.BackupCount <= 0 && hdlr.MaxAge <= 0 && hdlr.MaxTotalSize <= 0 {
+		return nil
+	}
+	files, err := hdlr.rotatedFiles()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for i, f := range files {
+		age := f.modTime
+		if hdlr.pattern.hasTime {
+			age = f.time
+		}
+		total += f.size
+
+		// once a file exceeds any limit, all the older ones exceed too
+		if (hdlr.BackupCount > 0 && i >= hdlr.BackupCount) ||
+			(hdlr.MaxAge > 0 && now.Sub(age) > hdlr.MaxAge) ||
+			(hdlr.MaxTotalSize > 0 && total > hdlr.MaxTotalSize) {
+			for _, old := range files[i:] {
+				if err := os.Remove(old.path); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// rotatedFiles scans the directory for rotated files,
+// sorted from the newest to the oldest
+func (hdlr *RotatingFileHandler) rotatedFiles() ([]rotatedFile, error) {
+	dir := filepath.Dir(hdlr.Path)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]rotatedFile, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		name, ext := info.Name(), ""
+		if strings.HasSuffix(name, gzipExt) {
+			name, ext = strings.TrimSuffix(name, gzipExt), gzipExt
+		}
+		if t, seq, ok := hdlr.pattern.parseIn(name, hdlr.location()); ok {
+			files = append(files, rotatedFile{
+				path:    filepath.Join(dir, info.Name()),
+				ext:     ext,
+				time:    t,
+				seq:     seq,
+				size:    info.Size(),
+				modTime: info.ModTime(),
+			})
+		}
+	}
+
+	hasTime := hdlr.pattern.hasTime
+	sort.SliceStable(files, func(i, j int) bool {
+		if !hasTime {
+			return files[i].seq < files[j].seq
+		}
+		if !files[i].time.Equal(files[j].time) {
+			return files[i].time.After(files[j].time)
+		}
+		return files[i].seq > files[j].seq
+	})
+	return files, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// appendFile appends the content of src to dst and removes src
+func appendFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// gzipFile compresses src, appends it to dst created with mode
+// and removes src
+func gzipFile(dst, src string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND|os.O_CREATE, mode)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
 }
 
 // Here is a faster line counter useing bytes.Count
@@ -53,7 +917,8 @@ func (hdlr RotatingFileHandler) doRollover() {
 // BenchmarkBuffioScan   500      6408963 ns/op     4208 B/op    2 allocs/op
 // BenchmarkBytesCount   500      4323397 ns/op     8200 B/op    1 allocs/op
 // BenchmarkBytes32k     500      3650818 ns/op     65545 B/op   1 allocs/op
-func (hdlr RotatingFileHandler) countLine() (int, error) {
+func (hdlr *RotatingFileHandler) countLine() (int, error) {
+
 	file, err := os.Open(hdlr.Path)
 	if err != nil {
 		return 0, err
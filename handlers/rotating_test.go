@@ -0,0 +1,692 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zoumo/logdog"
+)
+
+var rotatingFormatter = &logdog.TextFormatter{Fmt: "%(message)"}
+
+func listDir(t *testing.T, dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func readFile(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	return string(data)
+}
+
+func TestParseNamePattern(t *testing.T) {
+	for _, pattern := range []string{
+		"app.log",
+		"{seq}.log",
+		"{base}",
+		"{base}.{seq}.{seq}",
+		"{name}-{time}-{time}{ext}",
+		"old/{base}.{seq}",
+	} {
+		_, err := parseNamePattern("app.log", pattern)
+		assert.NotNil(t, err, pattern)
+	}
+
+	p, err := parseNamePattern("app.log", "{name}-{time:20060102T1504}{ext}")
+	assert.Nil(t, err)
+	now := time.Date(2024, 5, 1, 3, 0, 0, 0, time.Local)
+	name := p.format(now, 0)
+	assert.Equal(t, "app-20240501T0300.log", name)
+	parsed, _, ok := p.parse(name)
+	assert.True(t, ok)
+	assert.True(t, now.Equal(parsed))
+	_, _, ok = p.parse("app-2024.log")
+	assert.False(t, ok)
+	_, _, ok = p.parse("other-20240501T0300.log")
+	assert.False(t, ok)
+
+	p, err = parseNamePattern("app.log", DefaultNamePattern)
+	assert.Nil(t, err)
+	assert.Equal(t, "app.log.3", p.format(now, 3))
+	_, seq, ok := p.parse("app.log.3")
+	assert.True(t, ok)
+	assert.Equal(t, 3, seq)
+	_, _, ok = p.parse("app.log")
+	assert.False(t, ok)
+}
+
+func TestRotatingFileHandlerDefaultPattern(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	hdlr, err := NewRotatingFileHandler(path, "", rotatingFormatter)
+	assert.Nil(t, err)
+	hdlr.MaxLine = 2
+	hdlr.BackupCount = 2
+
+	for _, msg := range []string{"1", "2", "3", "4", "5", "6", "7"} {
+		hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, msg))
+	}
+	assert.Nil(t, hdlr.Close())
+
+	assert.Equal(t, []string{"app.log", "app.log.1", "app.log.2"}, listDir(t, dir))
+	assert.Equal(t, "7\n", readFile(t, path))
+	assert.Equal(t, "5\n6\n", readFile(t, path+".1"))
+	assert.Equal(t, "3\n4\n", readFile(t, path+".2"))
+}
+
+func TestRotatingFileHandlerReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("1\n2\n"), 0660))
+
+	hdlr, err := NewRotatingFileHandler(path, "", rotatingFormatter)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, hdlr.CurLine)
+	assert.Equal(t, 4, hdlr.CurSize)
+	hdlr.MaxSize = 5
+
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "3"))
+	assert.Nil(t, hdlr.Close())
+
+	assert.Equal(t, "3\n", readFile(t, path))
+	assert.Equal(t, "1\n2\n", readFile(t, path+".1"))
+}
+
+func TestRotatingFileHandlerEmitAfterClose(t *testing.T) {
+	var errs []error
+	logdog.SetInternalErrorHandler(func(err error) {
+		errs = append(errs, err)
+	})
+	defer logdog.SetInternalErrorHandler(nil)
+
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	hdlr, err := NewRotatingFileHandler(path, "", rotatingFormatter)
+	assert.Nil(t, err)
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "1"))
+	assert.Nil(t, hdlr.Close())
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "2"))
+
+	assert.Equal(t, "1\n", readFile(t, path))
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), logdog.ErrHandlerClosed.Error())
+}
+
+func TestRotatingFileHandlerOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	_, err = NewRotatingFileHandler(path, "", logdog.OptionBufferSize(8))
+	assert.IsType(t, &logdog.UnsupportedOptionError{}, err)
+
+	hdlr, err := NewRotatingFileHandler(path, "", rotatingFormatter, logdog.OptionFileMode(0600))
+	assert.Nil(t, err)
+	defer hdlr.Close()
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestRotatingFileHandlerDaily(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// the clock is read by the maintenance goroutine too
+	start := time.Date(2024, 5, 1, 23, 59, 59, 0, time.Local)
+	var elapsed int64
+	logdog.SetClock(logdog.ClockFunc(func() time.Time {
+		return start.Add(time.Duration(atomic.LoadInt64(&elapsed)))
+	}))
+	defer logdog.SetClock(nil)
+
+	path := filepath.Join(dir, "app.log")
+	hdlr, err := NewRotatingFileHandler(path, "", rotatingFormatter)
+	assert.Nil(t, err)
+	hdlr.Daily = true
+
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "1"))
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "2"))
+	atomic.AddInt64(&elapsed, int64(time.Second))
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "3"))
+	assert.Nil(t, hdlr.Close())
+
+	assert.Equal(t, []string{"app.log", "app.log.1"}, listDir(t, dir))
+	assert.Equal(t, "3\n", readFile(t, path))
+	assert.Equal(t, "1\n2\n", readFile(t, path+".1"))
+}
+
+func TestRotatingFileHandlerLocation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.Nil(t, err)
+	// the clock is read by the maintenance goroutine too
+	var now int64
+	clock := func(t time.Time) {
+		atomic.StoreInt64(&now, t.UnixNano())
+	}
+	clock(time.Date(2024, 3, 30, 22, 30, 0, 0, time.UTC))
+	logdog.SetClock(logdog.ClockFunc(func() time.Time {
+		return time.Unix(0, atomic.LoadInt64(&now)).UTC()
+	}))
+	defer logdog.SetClock(nil)
+
+	path := filepath.Join(dir, "app.log")
+	hdlr, err := NewRotatingFileHandler(path, "{name}-{time:2006-01-02T15}{ext}", rotatingFormatter, logdog.OptionLocation(berlin))
+	assert.Nil(t, err)
+	hdlr.Daily = true
+
+	// records are in UTC, the comments are the time of Europe/Berlin
+	for _, r := range []struct {
+		t   time.Time
+		msg string
+	}{
+		{time.Date(2024, 3, 30, 22, 30, 0, 0, time.UTC), "03-30 23:30 CET"},
+		{time.Date(2024, 3, 30, 23, 30, 0, 0, time.UTC), "03-31 00:30 CET"},
+		// the day of 23 hours
+		{time.Date(2024, 3, 31, 21, 30, 0, 0, time.UTC), "03-31 23:30 CEST"},
+		{time.Date(2024, 3, 31, 22, 30, 0, 0, time.UTC), "04-01 00:30 CEST"},
+		{time.Date(2024, 10, 26, 22, 30, 0, 0, time.UTC), "10-27 00:30 CEST"},
+		// the day of 25 hours
+		{time.Date(2024, 10, 27, 22, 30, 0, 0, time.UTC), "10-27 23:30 CET"},
+		{time.Date(2024, 10, 27, 23, 30, 0, 0, time.UTC), "10-28 00:30 CET"},
+	} {
+		clock(r.t)
+		record := logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, r.msg)
+		hdlr.Emit(record)
+	}
+	assert.Nil(t, hdlr.Close())
+
+	// one rotation at every midnight of Europe/Berlin, named in its time
+	assert.Equal(t, []string{
+		"app-2024-03-31T00.log",
+		"app-2024-04-01T00.log",
+		"app-2024-10-27T00.log",
+		"app-2024-10-28T00.log",
+		"app.log",
+	}, listDir(t, dir))
+	assert.Equal(t, "03-30 23:30 CET\n", readFile(t, filepath.Join(dir, "app-2024-03-31T00.log")))
+	assert.Equal(t, "03-31 00:30 CET\n03-31 23:30 CEST\n", readFile(t, filepath.Join(dir, "app-2024-04-01T00.log")))
+	assert.Equal(t, "04-01 00:30 CEST\n", readFile(t, filepath.Join(dir, "app-2024-10-27T00.log")))
+	assert.Equal(t, "10-27 00:30 CEST\n10-27 23:30 CET\n", readFile(t, filepath.Join(dir, "app-2024-10-28T00.log")))
+	assert.Equal(t, "10-28 00:30 CET\n", readFile(t, path))
+
+	files, err := hdlr.rotatedFiles()
+	assert.Nil(t, err)
+	assert.Len(t, files, 4)
+	assert.Equal(t, time.Date(2024, 10, 28, 0, 0, 0, 0, berlin), files[0].time)
+}
+
+func TestRotatingFileHandlerEventTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	logdog.SetClock(logdog.ClockFunc(func() time.Time {
+		return time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	}))
+	defer logdog.SetClock(nil)
+
+	path := filepath.Join(dir, "app.log")
+	hdlr, err := NewRotatingFileHandler(path, "{name}-{time:20060102T1504}{ext}", rotatingFormatter)
+	assert.Nil(t, err)
+	hdlr.Daily = true
+	logger := logdog.NewLogger(logdog.OptionHandlers(hdlr))
+
+	logger.WithTime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)).Info("1")
+	// records of earlier days do not rotate the file
+	logger.WithTime(time.Date(2024, 4, 30, 12, 0, 0, 0, time.Local)).Info("0")
+	// the time of the record rotates the file, not the clock
+	logger.WithTime(time.Date(2024, 5, 2, 0, 0, 0, 0, time.Local)).Info("2")
+	logger.WithTime(time.Date(2024, 5, 1, 13, 0, 0, 0, time.Local)).Info("3")
+	assert.Nil(t, hdlr.Close())
+
+	assert.Equal(t, []string{"app-20240502T0000.log", "app.log"}, listDir(t, dir))
+	assert.Equal(t, "1\n0\n", readFile(t, filepath.Join(dir, "app-20240502T0000.log")))
+	assert.Equal(t, "2\n3\n", readFile(t, path))
+}
+
+func TestRotatingFileHandlerTimePattern(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// rotated files left by previous runs, and a file of others
+	for _, name := range []string{
+		"app-20200101T000000-1.log",
+		"app-20200102T000000-1.log",
+		"app-20200102T000000-2.log",
+		"other-20200101T000000-1.log",
+	} {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), nil, 0660))
+	}
+
+	path := filepath.Join(dir, "app.log")
+	hdlr, err := NewRotatingFileHandler(path, "{name}-{time}-{seq}{ext}", rotatingFormatter)
+	assert.Nil(t, err)
+	hdlr.MaxLine = 1
+	hdlr.BackupCount = 2
+
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "1"))
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "2"))
+	assert.Nil(t, hdlr.Close())
+
+	files, err := hdlr.rotatedFiles()
+	assert.Nil(t, err)
+	assert.Len(t, files, 2)
+	// the newest rotated file and the newest one of previous runs are kept
+	assert.Equal(t, "1\n", readFile(t, files[0].path))
+	assert.Equal(t, filepath.Join(dir, "app-20200102T000000-2.log"), files[1].path)
+	assert.Contains(t, listDir(t, dir), "other-20200101T000000-1.log")
+	assert.Equal(t, "2\n", readFile(t, path))
+}
+
+func TestRotatingFileHandlerTimeCollision(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	// a layout without any time unit never changes
+	hdlr, err := NewRotatingFileHandler(path, "{name}-{time:rotated}{ext}", rotatingFormatter)
+	assert.Nil(t, err)
+	hdlr.MaxLine = 1
+
+	for _, msg := range []string{"1", "2", "3"} {
+		hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, msg))
+	}
+	assert.Nil(t, hdlr.Close())
+
+	// records are appended to the existing rotated file
+	assert.Equal(t, []string{"app-rotated.log", "app.log"}, listDir(t, dir))
+	assert.Equal(t, "1\n2\n", readFile(t, filepath.Join(dir, "app-rotated.log")))
+	assert.Equal(t, "3\n", readFile(t, path))
+}
+
+func TestRotatingFileHandlerMaxTotalSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for name, size := range map[string]int{
+		"app-20200101T000000.log": 40,
+		"app-20200102T000000.log": 10,
+		"app-20200103T000000.log": 20,
+	} {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), make([]byte, size), 0660))
+	}
+
+	path := filepath.Join(dir, "app.log")
+	hdlr, err := NewRotatingFileHandler(path, "{name}-{time}{ext}", rotatingFormatter)
+	assert.Nil(t, err)
+	hdlr.MaxLine = 1
+	hdlr.BackupCount = 10
+	hdlr.MaxTotalSize = 35
+
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "1"))
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "2"))
+	assert.Nil(t, hdlr.Close())
+
+	// 2 + 20 + 10 bytes are kept, the active file is excluded
+	files, err := hdlr.rotatedFiles()
+	assert.Nil(t, err)
+	assert.Len(t, files, 3)
+	assert.Equal(t, filepath.Join(dir, "app-20200102T000000.log"), files[2].path)
+}
+
+func TestRotatingFileHandlerMaxAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	layout := "20060102T150405"
+	now := time.Now()
+	recent := "app-" + now.Add(-time.Hour).Format(layout) + ".log"
+	for _, name := range []string{
+		"app-" + now.Add(-72*time.Hour).Format(layout) + ".log",
+		"app-" + now.Add(-48*time.Hour).Format(layout) + ".log",
+		recent,
+	} {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), nil, 0660))
+	}
+
+	path := filepath.Join(dir, "app.log")
+	hdlr, err := NewRotatingFileHandler(path, "{name}-{time}{ext}", rotatingFormatter)
+	assert.Nil(t, err)
+	hdlr.MaxLine = 1
+	hdlr.BackupCount = 10
+	hdlr.MaxAge = 24 * time.Hour
+
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "1"))
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "2"))
+	assert.Nil(t, hdlr.Close())
+
+	files, err := hdlr.rotatedFiles()
+	assert.Nil(t, err)
+	assert.Len(t, files, 2)
+	assert.Equal(t, filepath.Join(dir, recent), files[1].path)
+}
+
+func readGzipFile(t *testing.T, path string) string {
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	assert.Nil(t, err)
+	data, err := ioutil.ReadAll(zr)
+	assert.Nil(t, err)
+	return string(data)
+}
+
+func TestRotatingFileHandlerCompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	hdlr, err := NewRotatingFileHandler(path, "", rotatingFormatter)
+	assert.Nil(t, err)
+	hdlr.MaxLine = 1
+	hdlr.BackupCount = 2
+	hdlr.Compress = true
+
+	for _, msg := range []string{"1", "2", "3", "4"} {
+		hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, msg))
+	}
+	assert.Nil(t, hdlr.Close())
+	// closing twice is harmless
+	assert.Nil(t, hdlr.Close())
+
+	assert.Equal(t, []string{"app.log", "app.log.1.gz", "app.log.2.gz"}, listDir(t, dir))
+	assert.Equal(t, "3\n", readGzipFile(t, path+".1.gz"))
+	assert.Equal(t, "2\n", readGzipFile(t, path+".2.gz"))
+
+	// compressed files are appended as gzip members
+	dir2, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir2)
+
+	path = filepath.Join(dir2, "app.log")
+	hdlr, err = NewRotatingFileHandler(path, "{name}-{time:rotated}{ext}", rotatingFormatter)
+	assert.Nil(t, err)
+	hdlr.MaxLine = 1
+	hdlr.Compress = true
+	for _, msg := range []string{"1", "2", "3"} {
+		hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, msg))
+	}
+	assert.Nil(t, hdlr.Close())
+	assert.Equal(t, []string{"app-rotated.log.gz", "app.log"}, listDir(t, dir2))
+	assert.Equal(t, "1\n2\n", readGzipFile(t, filepath.Join(dir2, "app-rotated.log.gz")))
+}
+
+func TestRotatingFileHandlerConcurrentRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	hdlr, err := NewRotatingFileHandler(path, "{name}-{seq}{ext}", rotatingFormatter)
+	assert.Nil(t, err)
+	hdlr.MaxLine = 10
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, fmt.Sprintf("%d-%d", i, j)))
+				hdlr.Flush()
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Nil(t, hdlr.Close())
+
+	// no record is lost and no pending file is left
+	lines := 0
+	for _, name := range listDir(t, dir) {
+		assert.NotContains(t, name, "rotating")
+		data := readFile(t, filepath.Join(dir, name))
+		for _, c := range data {
+			if c == '\n' {
+				lines++
+			}
+		}
+	}
+	assert.Equal(t, 400, lines)
+	files, err := hdlr.rotatedFiles()
+	assert.Nil(t, err)
+	if hdlr.DroppedRotations() == 0 {
+		assert.Len(t, files, 39)
+	}
+}
+
+func TestRotatingFileHandlerSharedRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// handlers on the same path simulate processes
+	path := filepath.Join(dir, "app.log")
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		hdlr, err := NewRotatingFileHandler(path, "", rotatingFormatter, logdog.OptionSharedRotation(true))
+		assert.Nil(t, err)
+		hdlr.MaxSize = 100
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, fmt.Sprintf("%d-%03d", i, j)))
+			}
+			assert.Nil(t, hdlr.Close())
+		}(i)
+	}
+	wg.Wait()
+
+	// no record is lost and the backups are a consistent chain
+	names := listDir(t, dir)
+	seen := map[string]bool{}
+	backups := 0
+	for _, name := range names {
+		assert.NotContains(t, name, "rotating")
+		assert.NotContains(t, name, "next")
+		if name == "app.log.lock" || name == "app.log" {
+			continue
+		}
+		backups++
+	}
+	for seq := 0; seq <= backups; seq++ {
+		name := "app.log"
+		if seq > 0 {
+			name = fmt.Sprintf("app.log.%d", seq)
+		}
+		data := readFile(t, filepath.Join(dir, name))
+		// each process may write a line after the other reached MaxSize
+		if seq > 0 {
+			assert.True(t, len(data) <= 100+2*len("0-000\n"), name)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+			if line != "" {
+				assert.False(t, seen[line], line)
+				seen[line] = true
+			}
+		}
+	}
+	assert.Len(t, seen, 200)
+}
+
+func TestRotatingFileHandlerSpare(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	hdlr, err := NewRotatingFileHandler(path, "", rotatingFormatter)
+	assert.Nil(t, err)
+	hdlr.MaxLine = 1
+
+	// the next file is pre-opened in the background
+	deadline := time.Now().Add(2 * time.Second)
+	for len(hdlr.spares) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Len(t, hdlr.spares, 1)
+
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "1"))
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "2"))
+	assert.Contains(t, hdlr.Output.(*os.File).Name(), ".app.log.next-")
+	assert.Nil(t, hdlr.Close())
+
+	// the unused spare is removed
+	assert.Equal(t, []string{"app.log", "app.log.1"}, listDir(t, dir))
+	assert.Equal(t, "2\n", readFile(t, path))
+	assert.Equal(t, "1\n", readFile(t, path+".1"))
+}
+
+func TestRotatingFileHandlerDropRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	hdlr, err := NewRotatingFileHandler(path, "", rotatingFormatter)
+	assert.Nil(t, err)
+	hdlr.MaxLine = 1
+
+	// a queue without room stands for a maintenance goroutine
+	// which is behind
+	rotations := hdlr.rotations
+	hdlr.rotations = make(chan rotation)
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "1"))
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "2"))
+	assert.Equal(t, uint64(1), hdlr.DroppedRotations())
+
+	// the dropped rollover is done by the next record
+	hdlr.rotations = rotations
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "3"))
+	assert.Nil(t, hdlr.Close())
+	assert.Equal(t, "3\n", readFile(t, path))
+	assert.Equal(t, "1\n2\n", readFile(t, path+".1"))
+}
+
+func TestRotatingFileHandlerHeader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	header := func(file *os.File) error {
+		_, err := file.WriteString("# header\n")
+		return err
+	}
+	opens := 0
+	onOpen := func(io.Writer) error {
+		opens++
+		return nil
+	}
+	path := filepath.Join(dir, "app.log")
+	hdlr, err := NewRotatingFileHandler(path, "", rotatingFormatter, logdog.OptionHeaderFunc(header), logdog.OptionOnOpen(onOpen))
+	assert.Nil(t, err)
+	hdlr.MaxLine = 2
+
+	for _, msg := range []string{"1", "2", "3", "4", "5"} {
+		hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, msg))
+	}
+	// reopening the same file does not write the header again
+	assert.Nil(t, hdlr.Reopen())
+	assert.Nil(t, hdlr.Close())
+
+	// the header does not count as a line
+	assert.Equal(t, "# header\n5\n", readFile(t, path))
+	assert.Equal(t, "# header\n3\n4\n", readFile(t, path+".1"))
+	assert.Equal(t, "# header\n1\n2\n", readFile(t, path+".2"))
+	// OnOpen is called before the first record of every file
+	assert.Equal(t, 3, opens)
+}
+
+func TestNewRotatingFileHandlerInvalidPattern(t *testing.T) {
+	_, err := NewRotatingFileHandler(filepath.Join(os.TempDir(), "app.log"), "{base}")
+	assert.NotNil(t, err)
+	_, err = NewRotatingFileHandler("", "")
+	assert.NotNil(t, err)
+}
+
+// BenchmarkRotatingFileHandler logs through many rotation boundaries,
+// p99 Emit latency should not be affected by rotating
+func BenchmarkRotatingFileHandler(b *testing.B) {
+	dir, err := ioutil.TempDir("", "logdog")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hdlr, err := NewRotatingFileHandler(filepath.Join(dir, "app.log"), "", rotatingFormatter)
+	if err != nil {
+		b.Fatal(err)
+	}
+	hdlr.MaxSize = 64 << 10
+	hdlr.BackupCount = 3
+	hdlr.Compress = true
+
+	record := logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "benchmark rotating file handler with a message of moderate length")
+	latencies := make([]time.Duration, b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		hdlr.Emit(record)
+		latencies[i] = time.Since(start)
+	}
+	b.StopTimer()
+	hdlr.Close()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	b.ReportMetric(float64(latencies[len(latencies)*99/100]), "p99-ns")
+}
@@ -0,0 +1,355 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zoumo/logdog"
+)
+
+const (
+	// DefaultS3ChunkSize is the default uncompressed size of a chunk
+	DefaultS3ChunkSize = 8 * 1024 * 1024
+	// DefaultS3FlushInterval is the default interval
+	// between two periodic uploads
+	DefaultS3FlushInterval = 5 * time.Minute
+	// DefaultS3MaxPendingChunks is the default number of sealed chunks
+	// waiting for uploading
+	DefaultS3MaxPendingChunks = 4
+	// DefaultS3Retries is the default number of retries of an upload
+	DefaultS3Retries = 3
+	// s3RetryBackoff is the backoff before the first retry,
+	// it doubles after each retry
+	s3RetryBackoff = 100 * time.Millisecond
+)
+
+// ObjectUploader is the subset of an S3-compatible client used by S3Handler,
+// so logdog does not depend on any storage sdk.
+// Endpoint and credentials are configured on the client.
+type ObjectUploader interface {
+	PutObject(bucket, key string, body io.Reader, size int64) error
+}
+
+// s3Chunk is a sealed chunk
+type s3Chunk struct {
+	data  []byte
+	start time.Time
+}
+
+// s3Item is a queued chunk or a flush request
+type s3Item struct {
+	chunk   *s3Chunk
+	flushed chan error
+}
+
+// S3Handler is a handler which archives logging records to
+// S3-compatible object storage.
+// Records are buffered, when the buffer reaches MaxChunkSize or
+// FlushInterval elapsed, the chunk is sealed, compressed by gzip and
+// uploaded as Prefix/year/month/day/hour/uuid.log.gz, partitioned by the
+// UTC time of the first record in the chunk.
+// Sealed chunks are uploaded by a background goroutine, so Emit never waits
+// for uploading. A failed upload is retried at most Retries times, up to
+// MaxPendingChunks chunks wait for uploading, newer chunks are dropped.
+// Flush and Close wait for pending uploads, Close uploads the final
+// partial chunk, records emitted after Close are ignored.
+// MaxPendingChunks should be set before use.
+type S3Handler struct {
+	Name             string
+	Formatter        logdog.Formatter
+	Client           ObjectUploader
+	Bucket           string
+	Prefix           string
+	MaxChunkSize     int
+	MaxPendingChunks int
+	Retries          int
+	// OnError is called with upload errors,
+	// errors are printed to stderr if it is nil
+	OnError func(err error)
+
+	mu         sync.Mutex
+	buf        []byte
+	chunkStart time.Time
+
+	interval time.Duration
+	queue    chan s3Item
+	dropped  uint64
+	// qmu guards closed, Close waits for blocked enqueues by it
+	qmu    sync.RWMutex
+	closed bool
+
+	stop  chan struct{}
+	done  chan struct{}
+	start sync.Once
+	once  sync.Once
+	logdog.LevelFilterer
+}
+
+// NewS3Handler returns a new S3Handler fully initialized,
+// flushInterval <= 0 means DefaultS3FlushInterval
+func NewS3Handler(client ObjectUploader, bucket string, flushInterval time.Duration, options ...logdog.Option) *S3Handler {
+	if flushInterval <= 0 {
+		flushInterval = DefaultS3FlushInterval
+	}
+
+	hdlr := &S3Handler{
+		Name:             "",
+		Formatter:        logdog.NewJSONFormatter(),
+		Client:           client,
+		Bucket:           bucket,
+		MaxChunkSize:     DefaultS3ChunkSize,
+		MaxPendingChunks: DefaultS3MaxPendingChunks,
+		Retries:          DefaultS3Retries,
+		interval:         flushInterval,
+		stop:             make(chan struct{}),
+		done:             make(chan struct{}),
+	}
+
+	if err := logdog.ApplyOptionsTo(hdlr, options...); err != nil {
+		logdog.InternalError(err)
+	}
+
+	return hdlr
+}
+
+// init starts the background goroutine on first use
+func (hdlr *S3Handler) init() {
+	hdlr.start.Do(func() {
+		size := hdlr.MaxPendingChunks
+		if size <= 0 {
+			size = DefaultS3MaxPendingChunks
+		}
+		hdlr.queue = make(chan s3Item, size)
+		go hdlr.loop()
+	})
+}
+
+// loop uploads sealed chunks, and seals the current chunk every interval
+func (hdlr *S3Handler) loop() {
+	defer close(hdlr.done)
+	ticker := logdog.NewTicker(hdlr.interval)
+	defer ticker.Stop()
+
+	var err error
+	handle := func(item s3Item) {
+		if item.chunk != nil {
+			if uerr := hdlr.upload(item.chunk); uerr != nil && err == nil {
+				err = uerr
+			}
+		}
+		if item.flushed != nil {
+			item.flushed <- err
+			err = nil
+		}
+	}
+
+	for {
+		select {
+		case item := <-hdlr.queue:
+			handle(item)
+		case <-ticker.Chan():
+			if chunk := hdlr.seal(); chunk != nil {
+				handle(s3Item{chunk: chunk})
+			}
+		case <-hdlr.stop:
+			for {
+				select {
+				case item := <-hdlr.queue:
+					handle(item)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Emit appends log record to the current chunk, and hands the chunk
+// to the background goroutine if it is full
+func (hdlr *S3Handler) Emit(record *logdog.LogRecord) {
+	if hdlr.Client == nil || hdlr.Formatter == nil {
+		panic("you should set client and fomatter before use this handler")
+	}
+
+	if record.Level < hdlr.GetLevel() || hdlr.isClosed() {
+		return
+	}
+	hdlr.init()
+
+	msg, err := hdlr.Formatter.Format(record)
+	if err != nil {
+		logdog.InternalError(fmt.Errorf("Format record failed, [%v]", err))
+		return
+	}
+
+	hdlr.mu.Lock()
+	if len(hdlr.buf) == 0 {
+		hdlr.chunkStart = record.Time
+	}
+	hdlr.buf = append(hdlr.buf, msg...)
+	hdlr.buf = append(hdlr.buf, '\n')
+	var chunk *s3Chunk
+	if hdlr.MaxChunkSize > 0 && len(hdlr.buf) >= hdlr.MaxChunkSize {
+		chunk = hdlr.sealLocked()
+	}
+	hdlr.mu.Unlock()
+
+	if chunk != nil && !hdlr.enqueue(s3Item{chunk: chunk}, false) {
+		atomic.AddUint64(&hdlr.dropped, 1)
+		hdlr.onError(fmt.Errorf("drop chunk of %d bytes, too many pending chunks or handler closed", len(chunk.data)))
+	}
+}
+
+// seal takes the current chunk out, it returns nil if the chunk is empty
+func (hdlr *S3Handler) seal() *s3Chunk {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	return hdlr.sealLocked()
+}
+
+// sealLocked is seal with hdlr.mu held
+func (hdlr *S3Handler) sealLocked() *s3Chunk {
+	if len(hdlr.buf) == 0 {
+		return nil
+	}
+	chunk := &s3Chunk{data: hdlr.buf, start: hdlr.chunkStart}
+	hdlr.buf = nil
+	return chunk
+}
+
+// isClosed checks if the handler is closed
+func (hdlr *S3Handler) isClosed() bool {
+	hdlr.qmu.RLock()
+	defer hdlr.qmu.RUnlock()
+	return hdlr.closed
+}
+
+// enqueue hands item to the background goroutine, it blocks if the
+// queue is full and block is true, otherwise returns false.
+// It returns false if the handler is closed
+func (hdlr *S3Handler) enqueue(item s3Item, block bool) bool {
+	hdlr.qmu.RLock()
+	defer hdlr.qmu.RUnlock()
+	if hdlr.closed {
+		return false
+	}
+	if block {
+		hdlr.queue <- item
+		return true
+	}
+	select {
+	case hdlr.queue <- item:
+		return true
+	default:
+		return false
+	}
+}
+
+// upload compresses and uploads the chunk, it is retried at most
+// Retries times with exponential backoff, the chunk is discarded
+// if all attempts failed to keep memory bounded
+func (hdlr *S3Handler) upload(chunk *s3Chunk) error {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	if _, err := w.Write(chunk.data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+	key := path.Join(hdlr.Prefix, chunk.start.UTC().Format("2006/01/02/15"), id+".log.gz")
+
+	backoff := s3RetryBackoff
+	for i := 0; ; i++ {
+		err = hdlr.Client.PutObject(hdlr.Bucket, key, bytes.NewReader(gz.Bytes()), int64(gz.Len()))
+		if err == nil || i >= hdlr.Retries {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	if err != nil {
+		err = fmt.Errorf("upload %s failed, [%v]", key, err)
+		hdlr.onError(err)
+	}
+	return err
+}
+
+func (hdlr *S3Handler) onError(err error) {
+	if hdlr.OnError != nil {
+		hdlr.OnError(err)
+		return
+	}
+	logdog.InternalError(fmt.Errorf("S3Handler failed, [%v]", err))
+}
+
+// newUUID returns a random (version 4) uuid
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// Dropped returns the number of chunks dropped because too many chunks
+// are pending or the handler is closed
+func (hdlr *S3Handler) Dropped() uint64 {
+	return atomic.LoadUint64(&hdlr.dropped)
+}
+
+// Flush seals the current chunk, and waits until it and all pending
+// chunks are uploaded, it returns the first upload error since the
+// previous Flush
+func (hdlr *S3Handler) Flush() error {
+	hdlr.init()
+	flushed := make(chan error, 1)
+	if !hdlr.enqueue(s3Item{chunk: hdlr.seal(), flushed: flushed}, true) {
+		return nil
+	}
+	return <-flushed
+}
+
+// Close uploads the final partial chunk, waits for pending uploads
+// and stops the background goroutine
+func (hdlr *S3Handler) Close() error {
+	hdlr.init()
+	var err error
+	hdlr.once.Do(func() {
+		err = hdlr.Flush()
+		hdlr.qmu.Lock()
+		hdlr.closed = true
+		hdlr.qmu.Unlock()
+		close(hdlr.stop)
+		<-hdlr.done
+	})
+	return err
+}
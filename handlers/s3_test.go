@@ -0,0 +1,156 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zoumo/logdog"
+)
+
+type fakeUploader struct {
+	mu      sync.Mutex
+	buckets []string
+	keys    []string
+	bodies  []string
+	// fails is the number of uploads failing before success
+	fails int
+	// block blocks uploads until it is closed if it is not nil
+	block chan struct{}
+}
+
+func (u *fakeUploader) PutObject(bucket, key string, body io.Reader, size int64) error {
+	if u.block != nil {
+		<-u.block
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.fails > 0 {
+		u.fails--
+		return errors.New("service unavailable")
+	}
+	r, err := gzip.NewReader(body)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	u.buckets = append(u.buckets, bucket)
+	u.keys = append(u.keys, key)
+	u.bodies = append(u.bodies, string(data))
+	return nil
+}
+
+func TestS3Handler(t *testing.T) {
+	uploader := &fakeUploader{}
+	hdlr := NewS3Handler(uploader, "logs", time.Hour, logdog.InfoLevel)
+	hdlr.Prefix = "app"
+	hdlr.MaxChunkSize = 100
+
+	record := logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "first")
+	record.Time = time.Date(2024, 5, 1, 3, 4, 5, 0, time.UTC)
+	hdlr.Emit(record)
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.DebugLevel, "a/b.go", "a.b", 1, "filtered"))
+	assert.Len(t, uploader.keys, 0)
+
+	// exceed MaxChunkSize
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, strings.Repeat("x", 100)))
+	assert.Nil(t, hdlr.Flush())
+	assert.Len(t, uploader.keys, 1)
+	assert.Equal(t, "logs", uploader.buckets[0])
+	assert.Regexp(t, regexp.MustCompile(`^app/2024/05/01/03/[0-9a-f-]{36}\.log\.gz$`), uploader.keys[0])
+	assert.Equal(t, 2, strings.Count(uploader.bodies[0], "\n"))
+	assert.Contains(t, uploader.bodies[0], `"message":"first"`)
+
+	// final partial chunk is uploaded on close
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "last"))
+	assert.Nil(t, hdlr.Close())
+	assert.Len(t, uploader.keys, 2)
+	assert.Contains(t, uploader.bodies[1], `"message":"last"`)
+}
+
+func TestS3HandlerBackgroundUpload(t *testing.T) {
+	uploader := &fakeUploader{block: make(chan struct{})}
+	hdlr := NewS3Handler(uploader, "logs", time.Hour)
+	hdlr.MaxChunkSize = 10
+	hdlr.MaxPendingChunks = 1
+	var errs []error
+	hdlr.OnError = func(err error) {
+		errs = append(errs, err)
+	}
+
+	// Emit never waits for uploading, the first chunk is being uploaded,
+	// the second one is pending, and the third one is dropped
+	emitted := make(chan struct{})
+	go func() {
+		defer close(emitted)
+		for _, msg := range []string{"first", "second", "third"} {
+			hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, msg))
+			// wait until the first chunk is taken by the background goroutine
+			for len(hdlr.queue) > 0 && msg == "first" {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+	select {
+	case <-emitted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Emit blocked by uploading")
+	}
+	assert.Equal(t, uint64(1), hdlr.Dropped())
+	assert.Len(t, errs, 1)
+
+	close(uploader.block)
+	assert.Nil(t, hdlr.Close())
+	assert.Len(t, uploader.keys, 2)
+	assert.Contains(t, uploader.bodies[0], `"message":"first"`)
+	assert.Contains(t, uploader.bodies[1], `"message":"second"`)
+
+	// records emitted after Close are ignored
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "closed"))
+	assert.Nil(t, hdlr.Flush())
+	assert.Len(t, uploader.keys, 2)
+}
+
+func TestS3HandlerRetry(t *testing.T) {
+	uploader := &fakeUploader{fails: 2}
+	hdlr := NewS3Handler(uploader, "logs", time.Hour)
+	hdlr.Retries = 2
+	hdlr.OnError = func(err error) {}
+
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "retried"))
+	assert.Nil(t, hdlr.Flush())
+	assert.Len(t, uploader.keys, 1)
+
+	// the chunk is discarded after all attempts failed
+	uploader.fails = 3
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.InfoLevel, "a/b.go", "a.b", 1, "discarded"))
+	err := hdlr.Flush()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "service unavailable")
+	assert.Nil(t, hdlr.Close())
+	assert.Len(t, uploader.keys, 1)
+}
@@ -0,0 +1,346 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/zoumo/logdog"
+)
+
+const (
+	// DefaultWebhookTemplate is the default payload template,
+	// which is accepted by Slack and Mattermost
+	DefaultWebhookTemplate = `{"text": {{json .Text}}}`
+	// DefaultWebhookBufferSize is the default number of alerts
+	// queued for delivery
+	DefaultWebhookBufferSize = 64
+	// DefaultWebhookTimeout is the default timeout of a delivery
+	DefaultWebhookTimeout = 5 * time.Second
+	// DefaultWebhookRateLimit is the default number of alerts
+	// sent per DefaultWebhookRateInterval
+	DefaultWebhookRateLimit = 5
+	// DefaultWebhookRateInterval is the default interval of rate limiting
+	DefaultWebhookRateInterval = time.Minute
+)
+
+// defaultWebhookTemplate is the parsed DefaultWebhookTemplate
+var defaultWebhookTemplate = template.Must(NewWebhookTemplate(DefaultWebhookTemplate))
+
+// NewWebhookTemplate parses the payload template of WebhookHandler.
+// The template is executed with a WebhookAlert, the func json renders
+// a value as JSON, e.g. `{"content": {{json .Text}}}` for Discord
+func NewWebhookTemplate(text string) (*template.Template, error) {
+	return template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(text)
+}
+
+// WebhookAlert is the data the payload template is executed with
+type WebhookAlert struct {
+	// Text is the compact rendering of the record, e.g.
+	// "[ERROR] app: disk full user=jim (5 suppressed)"
+	Text    string
+	Level   string
+	Logger  string
+	Message string
+	Time    time.Time
+	// Fields are the fields selected by WebhookHandler.Fields
+	Fields logdog.Fields
+	// Suppressed is the number of alerts suppressed by rate limiting
+	// before this one
+	Suppressed uint64
+}
+
+// webhookItem is a queued payload, or a flush request if flushed is not nil
+type webhookItem struct {
+	payload []byte
+	flushed chan struct{}
+}
+
+// WebhookHandler is a handler which posts alerts of records to a webhook,
+// e.g. an incoming webhook of Slack, Teams, Discord or Mattermost.
+// It handles ERROR and above by default, and passes at most
+// DefaultWebhookRateLimit alerts per DefaultWebhookRateInterval by
+// RateLimit, the number of suppressed alerts is shown in the next one.
+// The payload is rendered by Template, DefaultWebhookTemplate by default.
+// Alerts are posted by a background goroutine and retried once, they are
+// dropped if BufferSize alerts are waiting, so Emit never blocks even if
+// the webhook is unreachable. Delivery errors are counted and reported
+// by OnError.
+type WebhookHandler struct {
+	Name string
+	URL  string
+	// Template renders the payload from a WebhookAlert,
+	// nil means DefaultWebhookTemplate
+	Template *template.Template
+	// Fields are the names of fields rendered in the alert text
+	Fields []string
+	// RateLimit limits the alerts sent, its Limit and Interval
+	// can be changed before use
+	RateLimit *logdog.RateLimitFilter
+	// Client posts the payloads, nil means a client with
+	// DefaultWebhookTimeout
+	Client *http.Client
+	// BufferSize should be set before use
+	BufferSize int
+	// OnError is called with delivery errors,
+	// errors are printed to stderr if it is nil
+	OnError func(err error)
+
+	dropped uint64
+	errors  uint64
+	// pending is the number of queued alerts not posted yet
+	pending int64
+	// ctx is canceled when Close is aborted
+	ctx    context.Context
+	cancel context.CancelFunc
+	mu     sync.RWMutex
+	queue  chan webhookItem
+	start  sync.Once
+	closed bool
+	done   chan struct{}
+	logdog.LevelFilterer
+}
+
+// NewWebhookHandler returns a new WebhookHandler fully initialized,
+// which posts alerts to url
+func NewWebhookHandler(url string, options ...logdog.Option) *WebhookHandler {
+	hdlr := &WebhookHandler{
+		Name:       "",
+		URL:        url,
+		RateLimit:  logdog.NewRateLimitFilter(DefaultWebhookRateLimit, DefaultWebhookRateInterval),
+		BufferSize: DefaultWebhookBufferSize,
+		done:       make(chan struct{}),
+	}
+	hdlr.Level = logdog.ErrorLevel
+	hdlr.AddFilters(hdlr.RateLimit)
+
+	if err := logdog.ApplyOptionsTo(hdlr, options...); err != nil {
+		logdog.InternalError(err)
+	}
+
+	return hdlr
+}
+
+// Alert returns the alert of the record
+func (hdlr *WebhookHandler) Alert(record *logdog.LogRecord) *WebhookAlert {
+	alert := &WebhookAlert{
+		Level:   record.LevelName,
+		Logger:  record.Name,
+		Message: record.GetMessage(),
+		Time:    record.Time,
+	}
+	if n, ok := record.Fields[logdog.SuppressedFieldKey].(uint64); ok {
+		alert.Suppressed = n
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "[%s] ", alert.Level)
+	if alert.Logger != "" {
+		text.WriteString(alert.Logger)
+		text.WriteString(": ")
+	}
+	text.WriteString(alert.Message)
+
+	names := append([]string(nil), hdlr.Fields...)
+	sort.Strings(names)
+	for _, name := range names {
+		v, ok := record.Fields[name]
+		if !ok {
+			continue
+		}
+		if alert.Fields == nil {
+			alert.Fields = make(logdog.Fields, len(names))
+		}
+		alert.Fields[name] = v
+		fmt.Fprintf(&text, " %s=%v", name, v)
+	}
+	if alert.Suppressed > 0 {
+		fmt.Fprintf(&text, " (%d suppressed)", alert.Suppressed)
+	}
+	alert.Text = text.String()
+	return alert
+}
+
+// Emit renders the alert of the record and queues it for delivery
+func (hdlr *WebhookHandler) Emit(record *logdog.LogRecord) {
+	if record.Level < hdlr.GetLevel() {
+		return
+	}
+
+	tmpl := hdlr.Template
+	if tmpl == nil {
+		tmpl = defaultWebhookTemplate
+	}
+	var payload bytes.Buffer
+	if err := tmpl.Execute(&payload, hdlr.Alert(record)); err != nil {
+		hdlr.onError(fmt.Errorf("render payload failed, [%v]", err))
+		return
+	}
+
+	hdlr.init()
+	hdlr.mu.RLock()
+	defer hdlr.mu.RUnlock()
+	if hdlr.closed {
+		atomic.AddUint64(&hdlr.dropped, 1)
+		return
+	}
+	select {
+	case hdlr.queue <- webhookItem{payload: payload.Bytes()}:
+		atomic.AddInt64(&hdlr.pending, 1)
+	default:
+		atomic.AddUint64(&hdlr.dropped, 1)
+	}
+}
+
+// init starts the background goroutine posting alerts on first use
+func (hdlr *WebhookHandler) init() {
+	hdlr.start.Do(func() {
+		size := hdlr.BufferSize
+		if size <= 0 {
+			size = DefaultWebhookBufferSize
+		}
+		if hdlr.Client == nil {
+			hdlr.Client = &http.Client{Timeout: DefaultWebhookTimeout}
+		}
+		hdlr.ctx, hdlr.cancel = context.WithCancel(context.Background())
+		hdlr.queue = make(chan webhookItem, size)
+		go hdlr.loop()
+	})
+}
+
+// loop posts queued alerts until the queue is closed
+func (hdlr *WebhookHandler) loop() {
+	defer close(hdlr.done)
+	for item := range hdlr.queue {
+		if item.flushed != nil {
+			close(item.flushed)
+			continue
+		}
+		// retried once
+		err := hdlr.post(item.payload)
+		if err != nil && hdlr.ctx.Err() == nil {
+			err = hdlr.post(item.payload)
+		}
+		atomic.AddInt64(&hdlr.pending, -1)
+		if err != nil {
+			atomic.AddUint64(&hdlr.dropped, 1)
+			// alerts abandoned by CloseContext are not reported
+			if hdlr.ctx.Err() == nil {
+				hdlr.onError(err)
+			}
+		}
+	}
+}
+
+// post posts the payload to URL
+func (hdlr *WebhookHandler) post(payload []byte) error {
+	if err := hdlr.ctx.Err(); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(hdlr.ctx, http.MethodPost, hdlr.URL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("post to webhook failed, [%v]", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := hdlr.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post to webhook failed, [%v]", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("post to webhook failed, [%s]", resp.Status)
+	}
+	return nil
+}
+
+// onError counts and reports the delivery error
+func (hdlr *WebhookHandler) onError(err error) {
+	atomic.AddUint64(&hdlr.errors, 1)
+	if hdlr.OnError != nil {
+		hdlr.OnError(err)
+		return
+	}
+	logdog.InternalError(fmt.Errorf("WebhookHandler failed, [%v]", err))
+}
+
+// Dropped returns the number of alerts dropped because the buffer
+// is full, the handler is closed or delivery failed after retrying
+func (hdlr *WebhookHandler) Dropped() uint64 {
+	return atomic.LoadUint64(&hdlr.dropped)
+}
+
+// Errors returns the number of errors, an alert not delivered
+// after retrying counts once
+func (hdlr *WebhookHandler) Errors() uint64 {
+	return atomic.LoadUint64(&hdlr.errors)
+}
+
+// Flush waits until alerts queued before it are posted or dropped
+func (hdlr *WebhookHandler) Flush() error {
+	hdlr.init()
+	flushed := make(chan struct{})
+	hdlr.mu.RLock()
+	if hdlr.closed {
+		close(flushed)
+	} else {
+		// flush requests are never dropped
+		hdlr.queue <- webhookItem{flushed: flushed}
+	}
+	hdlr.mu.RUnlock()
+	<-flushed
+	return nil
+}
+
+// Close waits until queued alerts are posted or dropped,
+// alerts emitted after Close are dropped
+func (hdlr *WebhookHandler) Close() error {
+	return hdlr.CloseContext(context.Background())
+}
+
+// CloseContext is like Close, but if ctx is done before queued alerts
+// are posted, the pending post is canceled, the remaining alerts are
+// dropped and a *logdog.CloseAbortedError is returned
+func (hdlr *WebhookHandler) CloseContext(ctx context.Context) error {
+	hdlr.init()
+	hdlr.mu.Lock()
+	if !hdlr.closed {
+		hdlr.closed = true
+		close(hdlr.queue)
+	}
+	hdlr.mu.Unlock()
+	select {
+	case <-hdlr.done:
+		return nil
+	case <-ctx.Done():
+	}
+	abandoned := int(atomic.LoadInt64(&hdlr.pending))
+	hdlr.cancel()
+	return &logdog.CloseAbortedError{Handler: hdlr.Name, Abandoned: abandoned, Err: ctx.Err()}
+}
@@ -0,0 +1,150 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zoumo/logdog"
+)
+
+// webhookServer records the payloads posted to it, the first
+// fails requests fail with 500
+type webhookServer struct {
+	mu       sync.Mutex
+	fails    int
+	payloads []string
+}
+
+func (s *webhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fails > 0 {
+		s.fails--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	s.payloads = append(s.payloads, string(body))
+}
+
+func TestWebhookHandler(t *testing.T) {
+	hook := &webhookServer{fails: 1}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	hdlr := NewWebhookHandler(server.URL)
+	hdlr.Fields = []string{"user", "disk"}
+	hdlr.RateLimit.Limit = 1
+	hdlr.RateLimit.Interval = 50 * time.Millisecond
+	logger := logdog.NewLogger(logdog.OptionName("app"), logdog.OptionHandlers(hdlr))
+
+	logger.Info("ignored")
+	logger.Error("disk full", logdog.Fields{"user": "jim", "disk": "/dev/sda", "token": "secret"})
+	logger.Error("suppressed")
+	logger.Error("suppressed")
+	time.Sleep(60 * time.Millisecond)
+	logger.Error("again")
+	assert.Nil(t, hdlr.Close())
+
+	// the first alert is retried once
+	assert.Equal(t, []string{
+		`{"text": "[ERROR] app: disk full disk=/dev/sda user=jim"}`,
+		`{"text": "[ERROR] app: again (2 suppressed)"}`,
+	}, hook.payloads)
+	assert.Equal(t, uint64(0), hdlr.Errors())
+}
+
+func TestWebhookHandlerTemplate(t *testing.T) {
+	hook := &webhookServer{}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	tmpl, err := NewWebhookTemplate(`{"content": {{json .Message}}, "level": {{json .Level}}}`)
+	assert.Nil(t, err)
+	hdlr := NewWebhookHandler(server.URL)
+	hdlr.Template = tmpl
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.ErrorLevel, "a/b.go", "a.b", 1, `say "hi"`))
+	assert.Nil(t, hdlr.Flush())
+	assert.Equal(t, []string{`{"content": "say \"hi\"", "level": "ERROR"}`}, hook.payloads)
+	assert.Nil(t, hdlr.Close())
+}
+
+func TestWebhookHandlerUnreachable(t *testing.T) {
+	server := httptest.NewServer(&webhookServer{})
+	server.Close()
+
+	var errs []error
+	hdlr := NewWebhookHandler(server.URL)
+	hdlr.BufferSize = 1
+	hdlr.OnError = func(err error) {
+		errs = append(errs, err)
+	}
+	for i := 0; i < 10; i++ {
+		hdlr.Emit(logdog.NewLogRecord("test", logdog.ErrorLevel, "a/b.go", "a.b", 1, "unreachable"))
+	}
+	assert.Nil(t, hdlr.Close())
+	assert.Equal(t, uint64(10), hdlr.Dropped())
+	assert.Equal(t, uint64(len(errs)), hdlr.Errors())
+	assert.NotEmpty(t, errs)
+
+	hdlr.Emit(logdog.NewLogRecord("test", logdog.ErrorLevel, "a/b.go", "a.b", 1, "closed"))
+	assert.Equal(t, uint64(11), hdlr.Dropped())
+}
+
+func TestWebhookHandlerCloseContext(t *testing.T) {
+	hang := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(hang)
+
+	var errs []error
+	hdlr := NewWebhookHandler(server.URL)
+	hdlr.Name = "webhook"
+	hdlr.OnError = func(err error) {
+		errs = append(errs, err)
+	}
+	for i := 0; i < 3; i++ {
+		hdlr.Emit(logdog.NewLogRecord("test", logdog.ErrorLevel, "a/b.go", "a.b", 1, "hang"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := hdlr.CloseContext(ctx)
+	assert.True(t, time.Since(start) < DefaultWebhookTimeout)
+	assert.Equal(t, "close webhook aborted, 3 records abandoned, [context deadline exceeded]", err.Error())
+
+	// the pending post is canceled and the abandoned alerts are dropped
+	assert.Nil(t, hdlr.Close())
+	assert.Equal(t, uint64(3), hdlr.Dropped())
+	assert.Empty(t, errs)
+}
+
+func TestWebhookHandlerInterface(t *testing.T) {
+	assert.Implements(t, (*logdog.Handler)(nil), NewWebhookHandler(""))
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// FileHeader describes the process writing a log file
+type FileHeader struct {
+	Start      time.Time `json:"start"`
+	Executable string    `json:"exe"`
+	Version    string    `json:"version"`
+	GoVersion  string    `json:"go"`
+	Host       string    `json:"host"`
+	PID        int       `json:"pid"`
+}
+
+// NewFileHeader returns the FileHeader of the current process, Start is
+// StartTime() and Version is the module version from the build info,
+// with the vcs revision if it is recorded
+func NewFileHeader() *FileHeader {
+	header := &FileHeader{
+		Start:     StartTime(),
+		GoVersion: runtime.Version(),
+		PID:       os.Getpid(),
+	}
+	header.Executable, _ = os.Executable()
+	header.Host, _ = os.Hostname()
+	if info, ok := debug.ReadBuildInfo(); ok {
+		header.Version = info.Main.Version
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				header.Version += "+" + setting.Value
+			}
+		}
+	}
+	return header
+}
+
+// CommentFileHeader writes the FileHeader of the current process as
+// a comment line, e.g.
+// # logdog: start=2024-05-01T03:04:05Z exe=/usr/bin/app version=v1.2.0 go=go1.22.1 host=web-1 pid=42
+func CommentFileHeader(file *os.File) error {
+	h := NewFileHeader()
+	_, err := fmt.Fprintf(file, "# logdog: start=%s exe=%s version=%s go=%s host=%s pid=%d\n",
+		h.Start.Format(time.RFC3339), h.Executable, h.Version, h.GoVersion, h.Host, h.PID)
+	return err
+}
+
+// JSONFileHeader writes the FileHeader of the current process as
+// a JSON line with "logdog": "header"
+func JSONFileHeader(file *os.File) error {
+	data, err := json.Marshal(struct {
+		Logdog string `json:"logdog"`
+		*FileHeader
+	}{"header", NewFileHeader()})
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+// WriteFileHeader calls header with the file if it is empty, so the
+// header is written once per physical file even if it is reopened.
+// A nil header does nothing
+func WriteFileHeader(file *os.File, header func(*os.File) error) error {
+	if header == nil {
+		return nil
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > 0 {
+		return nil
+	}
+	return header(file)
+}
+
+// writeFileHeader writes the header like WriteFileHeader, a failure is
+// printed to stderr and does not prevent logging to the file
+func writeFileHeader(file *os.File, header func(*os.File) error) {
+	if err := WriteFileHeader(file, header); err != nil {
+		InternalError(fmt.Errorf("Write file header failed, [%v]", err))
+	}
+}
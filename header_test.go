@@ -0,0 +1,119 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileHeader(t *testing.T) {
+	header := NewFileHeader()
+	assert.Equal(t, os.Getpid(), header.PID)
+	assert.NotEmpty(t, header.GoVersion)
+
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file, err := os.Create(filepath.Join(dir, "comment.log"))
+	assert.Nil(t, err)
+	assert.Nil(t, CommentFileHeader(file))
+	file.Close()
+	data, err := ioutil.ReadFile(file.Name())
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "# logdog: start="))
+	assert.Contains(t, string(data), " pid=")
+
+	file, err = os.Create(filepath.Join(dir, "json.log"))
+	assert.Nil(t, err)
+	assert.Nil(t, JSONFileHeader(file))
+	file.Close()
+	data, err = ioutil.ReadFile(file.Name())
+	assert.Nil(t, err)
+	var decoded map[string]interface{}
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, "header", decoded["logdog"])
+	assert.Equal(t, float64(os.Getpid()), decoded["pid"])
+}
+
+func TestFileHandlerHeader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+
+	headers, opens := 0, 0
+	header := func(file *os.File) error {
+		headers++
+		_, err := file.WriteString("# header\n")
+		return err
+	}
+	onOpen := func(io.Writer) error {
+		opens++
+		return nil
+	}
+	hdlr := NewFileHandler(OptionHeaderFunc(header), OptionOnOpen(onOpen), &TextFormatter{Fmt: "%(message)"})
+	hdlr.SetPath(path)
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "1"))
+
+	// reopening the same file does not write the header again
+	assert.Nil(t, hdlr.Reopen())
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "2"))
+	assert.Equal(t, 1, headers)
+
+	// a new file after it is moved gets its own header
+	assert.Nil(t, os.Rename(path, path+".1"))
+	assert.Nil(t, hdlr.Reopen())
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "3"))
+	assert.Nil(t, hdlr.Close())
+	assert.Equal(t, 2, headers)
+	// OnOpen is called for every opened file, even if it is not empty
+	assert.Equal(t, 3, opens)
+
+	data, err := ioutil.ReadFile(path + ".1")
+	assert.Nil(t, err)
+	assert.Equal(t, "# header\n1\n2\n", string(data))
+	data, err = ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "# header\n3\n", string(data))
+}
+
+func TestFileHandlerHeaderFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+
+	hdlr := NewFileHandler(&TextFormatter{Fmt: "%(message)"})
+	hdlr.HeaderFunc = func(*os.File) error {
+		return errors.New("no build info")
+	}
+	hdlr.SetPath(path)
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "logged"))
+	assert.Nil(t, hdlr.Close())
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "logged\n", string(data))
+}
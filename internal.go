@@ -0,0 +1,57 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// errorHandlerHolder is stored in internalErrorHandler, atomic.Value
+// requires the values stored in it have the same concrete type
+type errorHandlerHolder struct {
+	handle func(error)
+}
+
+// internalErrorHandler holds the handler set by SetInternalErrorHandler
+var internalErrorHandler atomic.Value
+
+func init() {
+	internalErrorHandler.Store(errorHandlerHolder{printInternalError})
+}
+
+// printInternalError prints the error to stderr
+func printInternalError(err error) {
+	fmt.Fprintf(os.Stderr, "%v\n", err)
+}
+
+// SetInternalErrorHandler sets the handler of internal errors of logdog,
+// e.g. a record failed to be formatted or a file failed to be opened,
+// which are printed to stderr by default. nil means the default, use
+// func(error) {} to suppress them. The handler may be called concurrently,
+// it should not log to a logger whose failure calls it again
+func SetInternalErrorHandler(handle func(error)) {
+	if handle == nil {
+		handle = printInternalError
+	}
+	internalErrorHandler.Store(errorHandlerHolder{handle})
+}
+
+// InternalError reports an internal error of logdog or a handler
+// to the handler set by SetInternalErrorHandler
+func InternalError(err error) {
+	internalErrorHandler.Load().(errorHandlerHolder).handle(err)
+}
@@ -0,0 +1,56 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingFormatter fails to format every record
+type failingFormatter struct {
+	*TextFormatter
+}
+
+func (failingFormatter) Format(*LogRecord) (string, error) {
+	return "", errors.New("unformattable")
+}
+
+func (failingFormatter) AppendFormat(dst []byte, record *LogRecord) ([]byte, error) {
+	return dst, errors.New("unformattable")
+}
+
+func TestSetInternalErrorHandler(t *testing.T) {
+	var errs []error
+	SetInternalErrorHandler(func(err error) {
+		errs = append(errs, err)
+	})
+	defer SetInternalErrorHandler(nil)
+
+	var out bytes.Buffer
+	hdlr := NewWriterHandler("", &out, failingFormatter{NewTextFormatter()}, NothingLevel)
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "unformattable"))
+	assert.Empty(t, out.String())
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "Format record failed")
+
+	// suppressed
+	SetInternalErrorHandler(func(error) {})
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "unformattable"))
+	assert.Len(t, errs, 1)
+}
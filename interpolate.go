@@ -0,0 +1,98 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MissingFieldFormat renders placeholders whose field is not in the
+// record, like fmt renders missing arguments, e.g. %!{user_id}(MISSING)
+const MissingFieldFormat = "%%!{%s}(MISSING)"
+
+// AppendInterpolated appends msg to dst with {field} placeholders
+// substituted by the values of fields, {{ and }} are literal braces.
+// Values are rendered by FieldText like %(fields), missing fields
+// are rendered by MissingFieldFormat. Braces which do not enclose a
+// name, e.g. {} or { a }, are kept as they are
+func AppendInterpolated(dst []byte, msg string, fields Fields) []byte {
+	for {
+		i := strings.IndexAny(msg, "{}")
+		if i < 0 {
+			return append(dst, msg...)
+		}
+		dst = append(dst, msg[:i]...)
+		c := msg[i]
+		msg = msg[i+1:]
+		if msg != "" && msg[0] == c {
+			// escaped brace
+			dst = append(dst, c)
+			msg = msg[1:]
+			continue
+		}
+		j := strings.IndexByte(msg, '}')
+		if c == '}' || j <= 0 || strings.ContainsAny(msg[:j], "{ \t\r\n") {
+			dst = append(dst, c)
+			continue
+		}
+		dst = appendFieldValue(dst, msg[:j], fields)
+		msg = msg[j+1:]
+	}
+}
+
+// Interpolate returns msg with {field} placeholders substituted by
+// the values of fields, see AppendInterpolated
+func Interpolate(msg string, fields Fields) string {
+	return string(AppendInterpolated(nil, msg, fields))
+}
+
+// appendFieldValue appends the value of fields[key] to dst
+func appendFieldValue(dst []byte, key string, fields Fields) []byte {
+	v, ok := fields[key]
+	if !ok {
+		return append(dst, fmt.Sprintf(MissingFieldFormat, key)...)
+	}
+	return append(dst, FieldText(v)...)
+}
+
+// AppendInterpolatedMessage appends the record message, formatted by
+// msg and args, to dst with {field} placeholders substituted by the
+// record fields, see AppendInterpolated
+func (lr *LogRecord) AppendInterpolatedMessage(dst []byte) []byte {
+	if lr.Msg != "" && len(lr.Args) == 0 && strings.IndexByte(lr.Msg, '%') < 0 {
+		return AppendInterpolated(dst, lr.Msg, lr.Fields)
+	}
+	buf := getBuffer()
+	*buf = lr.AppendMessage(*buf)
+	dst = AppendInterpolated(dst, string(*buf), lr.Fields)
+	putBuffer(buf)
+	return dst
+}
+
+// GetInterpolatedMessage returns the record message with {field}
+// placeholders substituted by the record fields
+func (lr *LogRecord) GetInterpolatedMessage() string {
+	return string(lr.AppendInterpolatedMessage(nil))
+}
+
+// interpolatedMessage returns the record message, interpolated if
+// interpolate is true, it is shared by formatters
+func interpolatedMessage(record *LogRecord, interpolate bool) string {
+	if interpolate {
+		return record.GetInterpolatedMessage()
+	}
+	return record.GetMessage()
+}
@@ -0,0 +1,70 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterpolate(t *testing.T) {
+	fields := Fields{"user_id": 42, "limit": "10GB", "ok": true}
+	cases := map[string]string{
+		"user {user_id} exceeded quota {limit}": "user 42 exceeded quota 10GB",
+		"no placeholders":                       "no placeholders",
+		"{ok}":                                  "true",
+		"missing {plan}":                        "missing %!{plan}(MISSING)",
+		"escaped {{user_id}}":                   "escaped {user_id}",
+		"kept {} { user_id } }":                 "kept {} { user_id } }",
+		"unclosed {user_id":                     "unclosed {user_id",
+	}
+	for in, want := range cases {
+		assert.Equal(t, want, Interpolate(in, fields), "%q", in)
+	}
+}
+
+func TestFormatterInterpolate(t *testing.T) {
+	record := NewLogRecord("app", InfoLevel, "/a/b.go", "main.main", 7, "user {user_id} exceeded quota {limit} %s", "today", Fields{"user_id": 42})
+
+	text := NewTextFormatter()
+	text.Fmt = "%(message)"
+	text.Interpolate = true
+	msg, err := text.Format(record)
+	assert.Nil(t, err)
+	assert.Equal(t, "user 42 exceeded quota %!{limit}(MISSING) today | user_id=42", msg)
+
+	jf := NewJSONFormatter()
+	jf.Interpolate = true
+	msg, err = jf.Format(record)
+	assert.Nil(t, err)
+	data := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal([]byte(msg), &data))
+	assert.Equal(t, "user 42 exceeded quota %!{limit}(MISSING) today", data[DefaultJSONMessageKey])
+	assert.Equal(t, map[string]interface{}{"user_id": float64(42)}, data["_fields"])
+
+	lf := NewLogfmtFormatter()
+	lf.Interpolate = true
+	msg, err = lf.Format(record)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(msg, `msg="user 42 exceeded quota %!{limit}(MISSING) today" user_id=42`), msg)
+
+	// disabled by default
+	text.Interpolate = false
+	msg, _ = text.Format(record)
+	assert.Equal(t, "user {user_id} exceeded quota {limit} today | user_id=42", msg)
+}
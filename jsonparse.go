@@ -0,0 +1,210 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zoumo/logdog/pkg/when"
+)
+
+// Parse converts json text formatted by the JSONFormatter back to a
+// LogRecord, it is the inverse of Format with the same config, except
+// for what Format does not keep:
+// Time is parsed by Datefmt (see when.StrftimeLayout) in the local
+// location, or as Epoch or RelativeTime. File names are restored as
+// PathName, logger names and func names are not formatted, the logger
+// name is read from the key logger if the json is written by others.
+// Integral numbers in fields are restored as int64, others as
+// float64, objects as map[string]interface{}. Extras are restored from
+// ExtraKey unless DisableExtra is set. Fields nested by NestFields are
+// flattened to dotted keys by FlattenFields, so object values of fields
+// come back as dotted keys too.
+func (jf *JSONFormatter) Parse(data []byte) (*LogRecord, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var raw map[string]interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("decode json record failed, [%v]", err)
+	}
+
+	messageKey, eventKey := jf.MessageKey, jf.EventKey
+	if messageKey == "" {
+		messageKey = DefaultJSONMessageKey
+	}
+	if eventKey == "" {
+		eventKey = DefaultJSONEventKey
+	}
+
+	record := &LogRecord{}
+	var err error
+	if record.Time, err = jf.parseTime(raw["time"]); err != nil {
+		return nil, err
+	}
+	record.Msg, _ = raw[messageKey].(string)
+	record.Event, _ = raw[eventKey].(string)
+	record.LevelName, _ = raw["level"].(string)
+	if level, err := ParseLevel(record.LevelName); err == nil {
+		record.Level = level
+	}
+	record.Name, _ = raw["logger"].(string)
+	record.FileName, _ = raw["file"].(string)
+	record.PathName = record.FileName
+	if line, ok := raw["line"].(json.Number); ok {
+		n, _ := line.Int64()
+		record.Line = int(n)
+	}
+	if seq, ok := raw["seq"].(json.Number); ok {
+		record.Seq, _ = strconv.ParseUint(string(seq), 10, 64)
+	}
+	if fields, ok := raw["_fields"].(map[string]interface{}); ok {
+		fields = restoreNumbers(fields).(map[string]interface{})
+		if jf.NestFields {
+			record.Fields = FlattenFields(fields)
+		} else {
+			record.Fields = Fields(fields)
+		}
+	}
+	extraKey := jf.ExtraKey
+	if extraKey == "" {
+		extraKey = DefaultJSONExtraKey
+	}
+	if extra, ok := raw[extraKey].(map[string]interface{}); ok && !jf.DisableExtra {
+		record.Extra = restoreNumbers(extra).(map[string]interface{})
+	}
+	return record, nil
+}
+
+// FlattenFields is the inverse of NestFields, nested objects are
+// flattened to dotted keys, and NestedValueKey of an object with other
+// keys is restored as the key of the object, e.g.
+// {"http":{"value":1,"code":200}} to http=1 and http.code=200
+func FlattenFields(nested map[string]interface{}) Fields {
+	fields := make(Fields, len(nested))
+	flattenInto(fields, "", nested)
+	return fields
+}
+
+func flattenInto(fields Fields, prefix string, nested map[string]interface{}) {
+	for k, v := range nested {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+			if k == NestedValueKey && len(nested) > 1 {
+				key = prefix
+			}
+		}
+		if obj, ok := v.(map[string]interface{}); ok && len(obj) > 0 {
+			flattenInto(fields, key, obj)
+			continue
+		}
+		fields[key] = v
+	}
+}
+
+// parseTime parses the json time formatted by the formatter
+func (jf *JSONFormatter) parseTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case json.Number:
+		n, err := t.Int64()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("parse epoch time %s failed, [%v]", t, err)
+		}
+		if jf.Epoch == EpochNanos {
+			return time.Unix(0, n), nil
+		}
+		return time.Unix(0, n*int64(time.Millisecond)), nil
+	case string:
+		if jf.RelativeTime {
+			secs, err := strconv.ParseFloat(strings.TrimSuffix(t, "s"), 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("parse relative time %s failed, [%v]", t, err)
+			}
+			return StartTime().Add(time.Duration(secs * float64(time.Second))), nil
+		}
+		datefmt := jf.Datefmt
+		if datefmt == "" {
+			datefmt = DefaultDateFmtTemplate
+		}
+		layout, err := when.StrftimeLayout(datefmt)
+		if err != nil {
+			return time.Time{}, err
+		}
+		// time.Parse accepts the fraction of second added by Precision
+		parsed, err := time.ParseInLocation(layout, t, time.Local)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("parse time %s failed, [%v]", t, err)
+		}
+		return parsed, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid json record time: %v", v)
+}
+
+// restoreNumbers converts json.Number in v to int64 or float64
+func restoreNumbers(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case json.Number:
+		if n, err := vv.Int64(); err == nil {
+			return n
+		}
+		f, _ := vv.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range vv {
+			vv[k] = restoreNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range vv {
+			vv[i] = restoreNumbers(e)
+		}
+	}
+	return v
+}
+
+// JSONParser reads records from a NDJSON stream written by a
+// JSONFormatter, e.g. a log file, for replaying and reprocessing.
+// It is a RecordReader parsing json only, in Strict mode
+type JSONParser struct {
+	Formatter *JSONFormatter
+	reader    *RecordReader
+}
+
+// NewJSONParser returns a JSONParser reading from r, jf should have the
+// config of the formatter which wrote the stream, nil means the default one
+func NewJSONParser(r io.Reader, jf *JSONFormatter) *JSONParser {
+	if jf == nil {
+		jf = NewJSONFormatter()
+	}
+	reader := NewRecordReader(r, jf)
+	reader.Strict = true
+	return &JSONParser{
+		Formatter: jf,
+		reader:    reader,
+	}
+}
+
+// Next returns the next record, blank lines are skipped. It returns io.EOF
+// at the end of the stream, a line which can not be parsed returns an error
+// with its line number, and the next call continues with the next line
+func (p *JSONParser) Next() (*LogRecord, error) {
+	p.reader.Parsers[0] = p.Formatter
+	return p.reader.Next()
+}
@@ -0,0 +1,133 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONFormatterParse(t *testing.T) {
+	record := NewLogRecord(name, WarnLevel, "a/b.go", fun, line, "hello %s", "jim",
+		Event("user.login"), Fields{"n": 1, "f": 1.5, "s": "x", "m": map[string]interface{}{"k": 2}, "l": []int{3}})
+	record.Time = time.Date(2024, 5, 1, 3, 4, 5, 123456789, time.Local)
+
+	cases := []struct {
+		formatter *JSONFormatter
+		time      time.Time
+	}{
+		{NewJSONFormatter(), record.Time.Truncate(time.Second)},
+		{&JSONFormatter{Datefmt: "%Y-%m-%dT%H:%M:%S%z", Precision: NanoPrecision, MessageKey: "msg"}, record.Time},
+		{&JSONFormatter{Epoch: EpochMillis}, record.Time.Truncate(time.Millisecond)},
+		{&JSONFormatter{Epoch: EpochNanos, EventKey: "code"}, record.Time},
+	}
+	for _, c := range cases {
+		text, err := c.formatter.Format(record)
+		assert.Nil(t, err)
+		parsed, err := c.formatter.Parse([]byte(text))
+		assert.Nil(t, err, text)
+
+		assert.True(t, c.time.Equal(parsed.Time), text)
+		assert.Equal(t, WarnLevel, parsed.Level)
+		assert.Equal(t, "WARN", parsed.LevelName)
+		assert.Equal(t, "hello jim", parsed.GetMessage())
+		assert.Equal(t, "user.login", parsed.Event)
+		assert.Equal(t, "b.go", parsed.FileName)
+		assert.Equal(t, line, parsed.Line)
+		assert.Equal(t, Fields{
+			"n": int64(1), "f": 1.5, "s": "x",
+			"m": map[string]interface{}{"k": int64(2)},
+			"l": []interface{}{int64(3)},
+		}, parsed.Fields)
+	}
+
+	_, err := NewJSONFormatter().Parse([]byte(`{"time": true}`))
+	assert.NotNil(t, err)
+	_, err = NewJSONFormatter().Parse([]byte(`not json`))
+	assert.NotNil(t, err)
+}
+
+func TestJSONParser(t *testing.T) {
+	jf := NewJSONFormatter()
+	var lines []string
+	for _, msg := range []string{"first", "second"} {
+		text, err := jf.Format(NewLogRecord(name, InfoLevel, pathname, fun, line, msg))
+		assert.Nil(t, err)
+		lines = append(lines, text)
+	}
+	stream := lines[0] + "\n\n{broken\n" + lines[1]
+
+	parser := NewJSONParser(strings.NewReader(stream), nil)
+	record, err := parser.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "first", record.Msg)
+	// a broken line does not stop parsing
+	_, err = parser.Next()
+	assert.Contains(t, err.Error(), "line 3")
+	record, err = parser.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "second", record.Msg)
+	_, err = parser.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestJSONParserCRLF(t *testing.T) {
+	jf := NewJSONFormatter()
+	text, err := jf.Format(NewLogRecord(name, InfoLevel, pathname, fun, line, "first"))
+	assert.Nil(t, err)
+
+	parser := NewJSONParser(strings.NewReader(text+"\r\n \r\n{broken\r\n"), jf)
+	record, err := parser.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "first", record.Msg)
+	_, err = parser.Next()
+	assert.Contains(t, err.Error(), "line 3")
+	_, err = parser.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestJSONFormatterParseExtraAndNestedFields(t *testing.T) {
+	jf := NewJSONFormatter()
+	jf.NestFields = true
+	record := NewLogRecord(name, InfoLevel, pathname, fun, line, "request", Fields{
+		"http":            1,
+		"http.code":       200,
+		"http.req.method": "GET",
+		"user":            "jim",
+	})
+	record.SetExtra("sampled", true)
+	text, err := jf.Format(record)
+	assert.Nil(t, err)
+
+	parsed, err := jf.Parse([]byte(text))
+	assert.Nil(t, err)
+	assert.Equal(t, Fields{
+		"http":            int64(1),
+		"http.code":       int64(200),
+		"http.req.method": "GET",
+		"user":            "jim",
+	}, parsed.Fields)
+	assert.Equal(t, map[string]interface{}{"sampled": true}, parsed.Extra)
+
+	// extras are ignored if disabled
+	jf.DisableExtra = true
+	parsed, err = jf.Parse([]byte(text))
+	assert.Nil(t, err)
+	assert.Nil(t, parsed.Extra)
+}
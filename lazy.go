@@ -0,0 +1,107 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// LazyValue is a field value computed only when the record is emitted,
+// after it passed the levels and filters, e.g. the plan of a sql query,
+//
+//	logger.Debug("query", logdog.Fields{"plan": logdog.LazyValue(func() interface{} {
+//		return explain(query)
+//	})})
+//
+// The function is called at most once for a record and its copies,
+// even if several handlers format it
+type LazyValue func() interface{}
+
+// String returns the value as FieldText
+func (lv LazyValue) String() string {
+	return FieldText(lv())
+}
+
+// MarshalJSON returns the json of the value
+func (lv LazyValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(lv())
+}
+
+// Stringer returns Fields with a LazyValue calling s.String, so the
+// string is built only if the record is emitted and only once, it
+// renders as a json string rather than the json of s
+func Stringer(key string, s fmt.Stringer) Fields {
+	return Fields{key: LazyValue(func() interface{} {
+		return s.String()
+	})}
+}
+
+// lazyValues memoizes the LazyValues of a record, it is shared by the
+// shallow copies of the record made for each handler
+type lazyValues struct {
+	mu     sync.Mutex
+	values map[string]interface{}
+}
+
+// hasLazyValue checks if any of fields is a LazyValue
+func hasLazyValue(fields Fields) bool {
+	for _, v := range fields {
+		if _, ok := v.(LazyValue); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareLazy sets up the memo of the record before it is copied,
+// so all copies evaluate its LazyValues once
+func (lr *LogRecord) prepareLazy() {
+	if lr.lazy == nil && hasLazyValue(lr.Fields) {
+		lr.lazy = &lazyValues{}
+	}
+}
+
+// ResolveLazy replaces the LazyValues of Fields by their values, each
+// is evaluated once for the record and its copies. Fields is replaced
+// by a copy, maps shared with other records are not modified.
+// Loggers call it after the filters of a handler passed, AsyncHandler
+// calls it before queueing, so later mutations are not observed
+func (lr *LogRecord) ResolveLazy() {
+	if !hasLazyValue(lr.Fields) {
+		return
+	}
+	lr.prepareLazy()
+	lz := lr.lazy
+	lz.mu.Lock()
+	defer lz.mu.Unlock()
+	if lz.values == nil {
+		lz.values = make(map[string]interface{})
+	}
+	fields := make(Fields, len(lr.Fields))
+	for k, v := range lr.Fields {
+		if lv, ok := v.(LazyValue); ok {
+			resolved, ok := lz.values[k]
+			if !ok {
+				resolved = lv()
+				lz.values[k] = resolved
+			}
+			v = resolved
+		}
+		fields[k] = v
+	}
+	lr.Fields = fields
+}
@@ -0,0 +1,103 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countedValue returns a LazyValue counting its calls
+func countedValue(calls *int, v interface{}) LazyValue {
+	return LazyValue(func() interface{} {
+		*calls++
+		return v
+	})
+}
+
+func TestLazyValueEvaluatedOnce(t *testing.T) {
+	var text, js bytes.Buffer
+	logger := NewLogger(OptionHandlers(
+		NewWriterHandler("", &text, &TextFormatter{Fmt: "%(message)"}, InfoLevel),
+		NewWriterHandler("", &js, &JSONFormatter{}, InfoLevel),
+	))
+
+	calls := 0
+	fields := Fields{"plan": countedValue(&calls, "seq scan")}
+	logger.Debug("query", fields)
+	assert.Equal(t, 0, calls)
+
+	logger.Info("query", fields)
+	assert.Equal(t, 1, calls)
+	assert.Contains(t, text.String(), "plan=seq scan")
+	assert.Contains(t, js.String(), `"plan":"seq scan"`)
+	// the fields of the caller are not modified
+	assert.IsType(t, LazyValue(nil), fields["plan"])
+}
+
+func TestLazyValueFiltered(t *testing.T) {
+	var out bytes.Buffer
+	hdlr := NewWriterHandler("", &out, &TextFormatter{Fmt: "%(message)"}, InfoLevel)
+	hdlr.AddFilters(FilterFunc(func(*LogRecord) bool { return false }))
+	logger := NewLogger(OptionHandlers(hdlr))
+
+	calls := 0
+	logger.Info("query", Fields{"plan": countedValue(&calls, "seq scan")})
+	assert.Equal(t, 0, calls)
+	assert.Equal(t, "", out.String())
+}
+
+func TestLazyValueAsyncHandler(t *testing.T) {
+	inner := &gateHandler{open: make(chan struct{})}
+	hdlr := NewAsyncHandler(inner, 8)
+
+	state := "before"
+	record := NewLogRecord("test", InfoLevel, pathname, fun, line, "msg", Fields{
+		"state": LazyValue(func() interface{} { return state }),
+	})
+	hdlr.Emit(record)
+	state = "after"
+	close(inner.open)
+	assert.Nil(t, hdlr.Close())
+
+	assert.Len(t, inner.records, 1)
+	assert.Equal(t, "before", inner.records[0].Fields["state"])
+}
+
+type expensive struct{ calls *int }
+
+func (e expensive) String() string {
+	*e.calls++
+	return "expensive"
+}
+
+func TestStringer(t *testing.T) {
+	calls := 0
+	fields := Stringer("plan", expensive{&calls})
+	assert.Equal(t, 0, calls)
+
+	// direct emits fall back to String and MarshalJSON
+	data, err := json.Marshal(fields)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"plan":"expensive"}`, string(data))
+	assert.Equal(t, "expensive", FieldText(fields["plan"]))
+	assert.Equal(t, "expensive", logfmtString(fields["plan"]))
+	assert.Equal(t, "expensive", jsonSafeValue(fields["plan"]))
+	assert.True(t, strings.Contains(fields.ToKVString("", ""), "plan=expensive"))
+}
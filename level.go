@@ -14,7 +14,13 @@
 
 package logdog
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
 
 const (
 	// NothingLevel log level only used in filter
@@ -44,7 +50,17 @@ var (
 
 // Level is a logging priority.
 // Note that Level satisfies the Option interface
-type Level int
+type Level int32
+
+// loadLevel atomically loads *addr
+func loadLevel(addr *Level) Level {
+	return Level(atomic.LoadInt32((*int32)(addr)))
+}
+
+// storeLevel atomically stores level into *addr
+func storeLevel(addr *Level, level Level) {
+	atomic.StoreInt32((*int32)(addr), int32(level))
+}
 
 func (l Level) String() string {
 	if name, ok := levelNames[l]; ok {
@@ -53,6 +69,74 @@ func (l Level) String() string {
 	return fmt.Sprintf("Level %d", l)
 }
 
+// ParseLevel returns the level registered with the given name,
+// names are case-insensitive, a number is parsed as the level itself.
+// The error lists the accepted names if name is invalid
+func ParseLevel(name string) (Level, error) {
+	name = strings.TrimSpace(name)
+	if v, ok := levels.Get(name); ok {
+		return v.(Level), nil
+	}
+	if v, ok := levels.Get(strings.ToUpper(name)); ok {
+		return v.(Level), nil
+	}
+	if i, err := strconv.ParseInt(name, 10, 32); err == nil {
+		return Level(i), nil
+	}
+	names := levels.Keys()
+	sort.Strings(names)
+	return Level(-1), fmt.Errorf("invalid level %q, accepted names are %s", name, strings.Join(names, ", "))
+}
+
+// Set parses name by ParseLevel and sets the level,
+// so *Level satisfies the flag.Value interface
+func (l *Level) Set(name string) error {
+	level, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	*l = level
+	return nil
+}
+
+// MarshalText returns the name of the level,
+// or the number if the level has no name
+func (l Level) MarshalText() ([]byte, error) {
+	if name, ok := levelNames[l]; ok {
+		return []byte(name), nil
+	}
+	return []byte(strconv.Itoa(int(l))), nil
+}
+
+// UnmarshalText parses the text by ParseLevel
+func (l *Level) UnmarshalText(text []byte) error {
+	return l.Set(string(text))
+}
+
+// MarshalJSON returns the name of the level as a json string
+func (l Level) MarshalJSON() ([]byte, error) {
+	text, _ := l.MarshalText()
+	return []byte(strconv.Quote(string(text))), nil
+}
+
+// UnmarshalJSON parses a json string by ParseLevel,
+// a json number is taken as the level itself
+func (l *Level) UnmarshalJSON(data []byte) error {
+	text := strings.TrimSpace(string(data))
+	if text == "null" {
+		return nil
+	}
+	if name, err := strconv.Unquote(text); err == nil {
+		return l.Set(name)
+	}
+	i, err := strconv.ParseInt(text, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid level %s", text)
+	}
+	*l = Level(i)
+	return nil
+}
+
 func init() {
 	RegisterLevel("NOTHING", NothingLevel)
 	RegisterLevel("DEBUG", DebugLevel)
@@ -62,5 +146,6 @@ func init() {
 	RegisterLevel("NOTICE", NoticeLevel)
 	RegisterLevel("FATAL", FatalLevel)
 	RegisterLevel("ALL", AllLevel)
-
+	// alias does not change the name of WarnLevel
+	levels.Register("WARNING", WarningLevel)
 }
@@ -0,0 +1,91 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"encoding/json"
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLevel(t *testing.T) {
+	cases := map[string]Level{
+		"DEBUG":   DebugLevel,
+		"debug":   DebugLevel,
+		" Info ":  InfoLevel,
+		"warning": WarnLevel,
+		"WARN":    WarnLevel,
+		"fatal":   FatalLevel,
+		"3":       Level(3),
+	}
+	for name, want := range cases {
+		level, err := ParseLevel(name)
+		assert.Nil(t, err, name)
+		assert.Equal(t, want, level, name)
+	}
+
+	_, err := ParseLevel("verbose")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "DEBUG, ERROR, FATAL")
+
+	// custom levels are accepted
+	RegisterLevel("TRACE", Level(64))
+	level, err := ParseLevel("trace")
+	assert.Nil(t, err)
+	assert.Equal(t, Level(64), level)
+	text, _ := level.MarshalText()
+	assert.Equal(t, "TRACE", string(text))
+	// WARNING is an alias and does not rename WarnLevel
+	assert.Equal(t, "WARN", WarnLevel.String())
+}
+
+func TestLevelFlag(t *testing.T) {
+	level := InfoLevel
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&level, "log-level", "log level")
+
+	assert.Nil(t, fs.Parse([]string{"-log-level=debug"}))
+	assert.Equal(t, DebugLevel, level)
+	assert.NotNil(t, fs.Parse([]string{"-log-level=verbose"}))
+}
+
+func TestLevelJSON(t *testing.T) {
+	var config struct {
+		Level Level `json:"level"`
+	}
+	assert.Nil(t, json.Unmarshal([]byte(`{"level": "warning"}`), &config))
+	assert.Equal(t, WarnLevel, config.Level)
+	assert.NotNil(t, json.Unmarshal([]byte(`{"level": "verbose"}`), &config))
+
+	data, err := json.Marshal(config)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"level":"WARN"}`, string(data))
+
+	data, _ = json.Marshal(Level(3))
+	assert.Equal(t, `"3"`, string(data))
+
+	// numbers are accepted and round trip through names
+	assert.Nil(t, json.Unmarshal([]byte(`{"level": 8}`), &config))
+	assert.Equal(t, ErrorLevel, config.Level)
+	assert.Nil(t, json.Unmarshal([]byte(`{"level": null}`), &config))
+	assert.Equal(t, ErrorLevel, config.Level)
+	assert.NotNil(t, json.Unmarshal([]byte(`{"level": 1.5}`), &config))
+
+	var level Level
+	assert.Nil(t, json.Unmarshal([]byte(`"3"`), &level))
+	assert.Equal(t, Level(3), level)
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+// LevelFormatter is a formatter which chooses the formatter of a record
+// by its level, e.g. a compact single line for INFO but a verbose one
+// with caller and stack for ERROR and above, so one handler formats
+// records of different levels differently.
+// A record is formatted by the formatter in Formatters with the highest
+// level not exceeding the record level, or by Default if there is none
+type LevelFormatter struct {
+	Default    Formatter
+	Formatters map[Level]Formatter
+	ConfigLoader
+}
+
+// NewLevelFormatter returns a LevelFormatter falling back to def
+func NewLevelFormatter(def Formatter, formatters map[Level]Formatter) *LevelFormatter {
+	if formatters == nil {
+		formatters = make(map[Level]Formatter)
+	}
+	return &LevelFormatter{
+		Default:    def,
+		Formatters: formatters,
+	}
+}
+
+// Choose returns the formatter of the record
+func (lf *LevelFormatter) Choose(record *LogRecord) Formatter {
+	chosen, found, ok := lf.Default, NothingLevel, false
+	for level, f := range lf.Formatters {
+		if level <= record.Level && (!ok || level > found) {
+			chosen, found, ok = f, level, true
+		}
+	}
+	if chosen == nil {
+		return DefaultFormatter
+	}
+	return chosen
+}
+
+// Format formats the record by the chosen formatter
+func (lf *LevelFormatter) Format(record *LogRecord) (string, error) {
+	return lf.Choose(record).Format(record)
+}
+
+// AppendFormat appends the record formatted by the chosen formatter to dst
+func (lf *LevelFormatter) AppendFormat(dst []byte, record *LogRecord) ([]byte, error) {
+	return AppendFormat(dst, lf.Choose(record), record)
+}
@@ -0,0 +1,44 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLevelFormatter(t *testing.T) {
+	compact := &TextFormatter{Fmt: "%(levelname) %(message)", LevelWidth: 3, ShortLevelNames: true}
+	verbose := &TextFormatter{Fmt: "%(levelname) %(caller) %(message)"}
+	formatter := NewLevelFormatter(compact, map[Level]Formatter{
+		ErrorLevel: verbose,
+		FatalLevel: &TextFormatter{Fmt: "!! %(message)"},
+	})
+	assert.Implements(t, (*Formatter)(nil), formatter)
+
+	var out bytes.Buffer
+	hdlr := NewWriterHandler("", &out, nil, NothingLevel, formatter)
+	hdlr.Emit(NewLogRecord("app", InfoLevel, "pkg/a/b.go", "a.b", 1, "info"))
+	hdlr.Emit(NewLogRecord("app", ErrorLevel, "pkg/a/b.go", "a.b", 2, "error"))
+	hdlr.Emit(NewLogRecord("app", NoticeLevel, "pkg/a/b.go", "a.b", 3, "notice"))
+	hdlr.Emit(NewLogRecord("app", FatalLevel, "pkg/a/b.go", "a.b", 4, "fatal"))
+	assert.Equal(t, "INF info\n ERROR a/b.go:2 error\nNOTICE a/b.go:3 notice\n!! fatal\n", out.String())
+
+	// nil Default falls back to DefaultFormatter
+	formatter = NewLevelFormatter(nil, nil)
+	assert.Equal(t, DefaultFormatter, formatter.Choose(NewLogRecord("app", InfoLevel, "b.go", "a.b", 1, "info")))
+}
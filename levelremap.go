@@ -0,0 +1,156 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"fmt"
+
+	"github.com/zoumo/logdog/pkg/pythonic"
+)
+
+// LevelRemapHandler is a handler which changes the levels of records
+// before emitting them to Handler, e.g. to treat the routine ERROR
+// records of a vendored library as WARN. The level is changed on a copy
+// of the record, so other handlers see the original one, and before the
+// level and filters of Handler are checked. Filtering is left to
+// Handler, ShouldEmit always returns true
+type LevelRemapHandler struct {
+	Name    string
+	Handler Handler
+	// Levels maps the levels of records to the emitted ones,
+	// levels not in it are kept
+	Levels map[Level]Level
+	// Remap returns the emitted level of the record,
+	// it overrides Levels if it is not nil
+	Remap func(record *LogRecord) Level
+	// Pattern limits remapping to records of loggers whose names match
+	// it like NameRule.Pattern, e.g. "vendor.foo", "" means all loggers
+	Pattern string
+}
+
+// NewLevelRemapHandler returns a new LevelRemapHandler emitting
+// records to hdlr with levels remapped by levels
+func NewLevelRemapHandler(hdlr Handler, levels map[Level]Level, options ...Option) *LevelRemapHandler {
+	h := &LevelRemapHandler{
+		Name:    "",
+		Handler: hdlr,
+		Levels:  levels,
+	}
+
+	applyOptions(h, options...)
+
+	return h
+}
+
+// LoadConfig loads config from its input and
+// stores it in the value pointed to by c.
+// The handler is looked up by name, e.g.
+//   "handler": "console",
+//   "pattern": "vendor.foo",
+//   "levels": {"ERROR": "WARN", "DEBUG": "INFO"}
+func (hdlr *LevelRemapHandler) LoadConfig(c map[string]interface{}) error {
+	config, err := pythonic.DictReflect(c)
+	if err != nil {
+		return err
+	}
+
+	hdlr.Name = config.MustGetString("name", "")
+	hdlr.Pattern = config.MustGetString("pattern", "")
+	if _, err := NewNameFilter(NameRule{Pattern: hdlr.Pattern}); err != nil {
+		return err
+	}
+
+	hdlr.Levels = make(map[Level]Level)
+	for from, to := range config.MustGetDict("levels", pythonic.NewDict()) {
+		fromName, ok := from.(string)
+		toName, ok2 := to.(string)
+		if !ok || !ok2 {
+			return fmt.Errorf("invalid level remapping: %v: %v", from, to)
+		}
+		fromLevel, err := ParseLevel(fromName)
+		if err != nil {
+			return err
+		}
+		toLevel, err := ParseLevel(toName)
+		if err != nil {
+			return err
+		}
+		hdlr.Levels[fromLevel] = toLevel
+	}
+
+	name := config.MustGetString("handler", "")
+	h := GetHandler(name)
+	if h == nil {
+		return handlerNotFoundError(name)
+	}
+	hdlr.Handler = h
+
+	return nil
+}
+
+// remap returns the emitted level of the record
+func (hdlr *LevelRemapHandler) remap(record *LogRecord) Level {
+	if hdlr.Pattern != "" && !matchName(hdlr.Pattern, record.Name) {
+		return record.Level
+	}
+	if hdlr.Remap != nil {
+		return hdlr.Remap(record)
+	}
+	if level, ok := hdlr.Levels[record.Level]; ok {
+		return level
+	}
+	return record.Level
+}
+
+// Emit emits a copy of the record with the remapped level to Handler
+// if Handler should emit it
+func (hdlr *LevelRemapHandler) Emit(record *LogRecord) {
+	if level := hdlr.remap(record); level != record.Level {
+		copied := *record
+		copied.Level = level
+		copied.LevelName = level.String()
+		record = &copied
+	}
+	if ShouldEmit(hdlr.Handler, record) {
+		hdlr.Handler.Emit(record)
+	}
+}
+
+// ShouldEmit returns true, filtering is left to Handler
+func (hdlr *LevelRemapHandler) ShouldEmit(record *LogRecord) bool {
+	return true
+}
+
+// Filter checks if handler should filter the specified record.
+// Deprecated: use ShouldEmit instead
+func (hdlr *LevelRemapHandler) Filter(record *LogRecord) bool {
+	return !hdlr.ShouldEmit(record)
+}
+
+// Flush flushes Handler
+func (hdlr *LevelRemapHandler) Flush() error {
+	return hdlr.Handler.Flush()
+}
+
+// Close closes Handler
+func (hdlr *LevelRemapHandler) Close() error {
+	return hdlr.Handler.Close()
+}
+
+func init() {
+	RegisterConstructor("LevelRemapHandler", func() ConfigLoader {
+		return NewLevelRemapHandler(nil, nil)
+	})
+}
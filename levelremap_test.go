@@ -0,0 +1,77 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLevelRemapHandler(t *testing.T) {
+	var out lockedBuffer
+	inner := NewWriterHandler("", &out, &TextFormatter{Fmt: "%(levelname) %(message)"}, WarnLevel)
+	hdlr := NewLevelRemapHandler(inner, map[Level]Level{ErrorLevel: WarnLevel, DebugLevel: ErrorLevel})
+	hdlr.Pattern = "vendor.foo"
+	other := &recordHandler{}
+
+	vendor := NewLogger(OptionName("vendor.foo.retry"), OptionHandlers(hdlr, other))
+	vendor.Error("retrying")
+	// remapped before the level of the inner handler is checked
+	vendor.Debug("interesting")
+	vendor.Info("routine")
+	NewLogger(OptionName("app"), OptionHandlers(hdlr)).Error("failed")
+
+	assert.Equal(t, "  WARN retrying\n ERROR interesting\n ERROR failed\n", out.String())
+	// other handlers see the original levels
+	assert.Equal(t, ErrorLevel, other.records[0].Level)
+	assert.Equal(t, "ERROR", other.records[0].LevelName)
+	assert.Equal(t, DebugLevel, other.records[1].Level)
+
+	hdlr.Remap = func(record *LogRecord) Level {
+		return FatalLevel
+	}
+	vendor.Info("remapped by func")
+	assert.Contains(t, out.String(), "FATAL remapped by func")
+	assert.Nil(t, hdlr.Flush())
+	assert.Nil(t, hdlr.Close())
+}
+
+func TestLevelRemapHandlerConfig(t *testing.T) {
+	config := []byte(`{
+        "handlers": {
+            "a_remap": {
+                "class": "LevelRemapHandler",
+                "handler": "z_inner",
+                "pattern": "vendor.*",
+                "levels": {"ERROR": "WARNING", "DEBUG": "INFO"}
+            },
+            "z_inner": {
+                "class": "NullHandler"
+            }
+        }
+    }`)
+
+	assert.Nil(t, LoadJSONConfig(config))
+	hdlr, ok := GetHandler("a_remap").(*LevelRemapHandler)
+	assert.True(t, ok)
+	assert.Equal(t, "vendor.*", hdlr.Pattern)
+	assert.Equal(t, map[Level]Level{ErrorLevel: WarnLevel, DebugLevel: InfoLevel}, hdlr.Levels)
+	assert.True(t, hdlr.Handler == GetHandler("z_inner"))
+
+	err := LoadJSONConfig([]byte(`{"handlers": {"b_remap": {
+        "class": "LevelRemapHandler", "handler": "z_inner", "levels": {"ERROR": "LOUD"}}}}`))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,225 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/zoumo/logdog/pkg/pythonic"
+)
+
+// LevelRoute routes records whose level is within [Min, Max] to Handler,
+// use AllLevel as Max for no upper bound
+type LevelRoute struct {
+	Min     Level
+	Max     Level
+	Handler Handler
+}
+
+// Match returns true if level is within the range of the route
+func (r LevelRoute) Match(level Level) bool {
+	return level >= r.Min && level <= r.Max
+}
+
+// LevelRouterHandler is a handler which routes records to handlers by
+// level, e.g. INFO and below to app.log, WARN to warn.log, and ERROR and
+// above to error.log and stderr, without configuring handlers with
+// complementary level ranges. A record is emitted to every route it
+// matches, or to Default if it matches none, every handler but the last
+// one gets a clone of the record. Filtering is left to the handlers,
+// ShouldEmit always returns true.
+// Flush and Close are propagated to all routes and Default
+type LevelRouterHandler struct {
+	Name   string
+	Routes []LevelRoute
+	// Default gets records matching no route, they are dropped if it is nil
+	Default Handler
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewLevelRouterHandler returns a new LevelRouterHandler with routes
+func NewLevelRouterHandler(routes []LevelRoute, options ...Option) *LevelRouterHandler {
+	hdlr := &LevelRouterHandler{
+		Name:   "",
+		Routes: routes,
+	}
+
+	applyOptions(hdlr, options...)
+
+	return hdlr
+}
+
+// Route appends a route of records whose level is within [min, max] to handler,
+// it should be called before use
+func (hdlr *LevelRouterHandler) Route(min, max Level, handler Handler) *LevelRouterHandler {
+	hdlr.Routes = append(hdlr.Routes, LevelRoute{Min: min, Max: max, Handler: handler})
+	return hdlr
+}
+
+// LoadConfig loads config from its input and
+// stores it in the value pointed to by c.
+// Handlers of routes and default are looked up by name, e.g.
+//   "routes": [{"min": "DEBUG", "max": "INFO", "handler": "app"},
+//              {"min": "ERROR", "handler": "error"}],
+//   "default": "console"
+// min defaults to NOTHING and max to ALL
+func (hdlr *LevelRouterHandler) LoadConfig(c map[string]interface{}) error {
+	config, err := pythonic.DictReflect(c)
+	if err != nil {
+		return err
+	}
+
+	hdlr.Name = config.MustGetString("name", "")
+
+	hdlr.Routes = nil
+	for _, r := range config.MustGetArray("routes", make([]interface{}, 0)) {
+		m, ok := r.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("invalid route: %v", r)
+		}
+		route, err := pythonic.DictReflect(m)
+		if err != nil {
+			return err
+		}
+		min, err := ParseLevel(route.MustGetString("min", "NOTHING"))
+		if err != nil {
+			return err
+		}
+		max, err := ParseLevel(route.MustGetString("max", "ALL"))
+		if err != nil {
+			return err
+		}
+		name := route.MustGetString("handler", "")
+		h := GetHandler(name)
+		if h == nil {
+			return handlerNotFoundError(name)
+		}
+		hdlr.Route(min, max, h)
+	}
+
+	hdlr.Default = nil
+	if name := config.MustGetString("default", ""); name != "" {
+		h := GetHandler(name)
+		if h == nil {
+			return handlerNotFoundError(name)
+		}
+		hdlr.Default = h
+	}
+
+	return nil
+}
+
+// Emit emits the record to the handlers of all matching routes,
+// or to Default if no route matches
+func (hdlr *LevelRouterHandler) Emit(record *LogRecord) {
+	hdlr.mu.RLock()
+	defer hdlr.mu.RUnlock()
+	if hdlr.closed {
+		return
+	}
+
+	matched := make([]Handler, 0, len(hdlr.Routes))
+	for _, route := range hdlr.Routes {
+		if route.Match(record.Level) {
+			matched = append(matched, route.Handler)
+		}
+	}
+	if len(matched) == 0 && hdlr.Default != nil {
+		matched = append(matched, hdlr.Default)
+	}
+
+	for i, h := range matched {
+		r := record
+		if i < len(matched)-1 {
+			r = record.Clone()
+		}
+		if ShouldEmit(h, r) {
+			h.Emit(r)
+		}
+	}
+}
+
+// ShouldEmit returns true, filtering is left to the handlers
+func (hdlr *LevelRouterHandler) ShouldEmit(record *LogRecord) bool {
+	return true
+}
+
+// Filter checks if handler should filter the specified record.
+// Deprecated: use ShouldEmit instead
+func (hdlr *LevelRouterHandler) Filter(record *LogRecord) bool {
+	return !hdlr.ShouldEmit(record)
+}
+
+// handlers returns the handlers of all routes and Default,
+// a handler shared by several routes is returned once
+func (hdlr *LevelRouterHandler) handlers() []Handler {
+	handlers := make([]Handler, 0, len(hdlr.Routes)+1)
+	seen := make(map[Handler]bool, len(hdlr.Routes)+1)
+	add := func(h Handler) {
+		if h == nil || seen[h] {
+			return
+		}
+		seen[h] = true
+		handlers = append(handlers, h)
+	}
+	for _, route := range hdlr.Routes {
+		add(route.Handler)
+	}
+	add(hdlr.Default)
+	return handlers
+}
+
+// Flush flushes the handlers of all routes and Default
+func (hdlr *LevelRouterHandler) Flush() error {
+	hdlr.mu.RLock()
+	defer hdlr.mu.RUnlock()
+	var ret error
+	for _, h := range hdlr.handlers() {
+		if err := h.Flush(); err != nil {
+			InternalError(fmt.Errorf("Flush handler failed, [%v]", err))
+			ret = err
+		}
+	}
+	return ret
+}
+
+// Close waits for in-flight emits, then closes the handlers
+// of all routes and Default
+func (hdlr *LevelRouterHandler) Close() error {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	if hdlr.closed {
+		return nil
+	}
+	hdlr.closed = true
+
+	var ret error
+	for _, h := range hdlr.handlers() {
+		if err := h.Close(); err != nil {
+			InternalError(fmt.Errorf("Close handler failed, [%v]", err))
+			ret = err
+		}
+	}
+	return ret
+}
+
+func init() {
+	RegisterConstructor("LevelRouterHandler", func() ConfigLoader {
+		return NewLevelRouterHandler(nil)
+	})
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLevelRouterHandler(t *testing.T) {
+	app := &recordHandler{}
+	warn := &recordHandler{}
+	errors := &recordHandler{}
+	stderr := &recordHandler{}
+	other := &recordHandler{}
+	counter := &closeCounter{}
+
+	hdlr := NewLevelRouterHandler(nil, OptionName("router")).
+		Route(DebugLevel, InfoLevel, app).
+		Route(WarnLevel, WarnLevel, warn).
+		Route(ErrorLevel, AllLevel, errors).
+		Route(ErrorLevel, AllLevel, stderr).
+		Route(FatalLevel, FatalLevel, counter)
+	hdlr.Default = other
+	assert.Equal(t, "router", hdlr.Name)
+
+	logger := NewLogger(OptionHandlers(hdlr))
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Warn("warn")
+	record := NewLogRecord("test", ErrorLevel, "a/b.go", "a.b", 1, "error")
+	hdlr.Emit(record)
+	hdlr.Emit(NewLogRecord("test", NothingLevel, "a/b.go", "a.b", 1, "nothing"))
+
+	assert.Len(t, app.records, 2)
+	assert.Len(t, warn.records, 1)
+	assert.Equal(t, "warn", warn.records[0].GetMessage())
+	// a record goes to every matching route,
+	// the last one gets the record, the others get clones
+	assert.Len(t, errors.records, 1)
+	assert.Len(t, stderr.records, 1)
+	assert.False(t, errors.records[0] == record)
+	assert.True(t, stderr.records[0] == record)
+	// records matching no route go to the default route
+	assert.Len(t, other.records, 1)
+	assert.Equal(t, "nothing", other.records[0].Msg)
+
+	assert.Nil(t, hdlr.Flush())
+	assert.Nil(t, hdlr.Close())
+	assert.Equal(t, 1, counter.flushed)
+	assert.Equal(t, 1, counter.closed)
+
+	// records are dropped after closed
+	hdlr.Emit(record)
+	assert.Len(t, stderr.records, 1)
+}
+
+func TestLevelRouterHandlerConfig(t *testing.T) {
+	// the router is built after the handlers it refers to
+	config := []byte(`{
+        "handlers": {
+            "a_router": {
+                "class": "LevelRouterHandler",
+                "routes": [
+                    {"min": "DEBUG", "max": "INFO", "handler": "z_app"},
+                    {"min": "ERROR", "handler": "z_error"}
+                ],
+                "default": "z_app"
+            },
+            "z_app": {
+                "class": "NullHandler"
+            },
+            "z_error": {
+                "class": "NullHandler"
+            }
+        }
+    }`)
+
+	assert.Nil(t, LoadJSONConfig(config))
+	hdlr, ok := GetHandler("a_router").(*LevelRouterHandler)
+	assert.True(t, ok)
+	assert.Equal(t, "a_router", hdlr.Name)
+	assert.Len(t, hdlr.Routes, 2)
+	assert.Equal(t, LevelRoute{Min: DebugLevel, Max: InfoLevel, Handler: GetHandler("z_app")}, hdlr.Routes[0])
+	assert.Equal(t, LevelRoute{Min: ErrorLevel, Max: AllLevel, Handler: GetHandler("z_error")}, hdlr.Routes[1])
+	assert.True(t, hdlr.Default == GetHandler("z_app"))
+
+	// an unknown handler is reported
+	err := LoadJSONConfig([]byte(`{"handlers": {"b_router": {
+        "class": "LevelRouterHandler", "routes": [{"handler": "missing"}]}}}`))
+	assert.EqualError(t, err, "can not find handler: missing")
+}
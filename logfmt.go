@@ -0,0 +1,307 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zoumo/logdog/pkg/pythonic"
+)
+
+// DefaultLogfmtDateFmt is the default time format of LogfmtFormatter
+const DefaultLogfmtDateFmt = "%Y-%m-%dT%H:%M:%S%z"
+
+// LogfmtQuoting is the quoting policy of logfmt values
+type LogfmtQuoting int
+
+const (
+	// QuoteWhenNeeded quotes values containing spaces, '=', '"' or
+	// control characters, like go-logfmt
+	QuoteWhenNeeded LogfmtQuoting = iota
+	// QuoteAlways quotes all values
+	QuoteAlways
+	// QuoteNever never quotes values, characters which need quoting are
+	// escaped as \xNN and backslashes as \\
+	QuoteNever
+)
+
+var logfmtQuotings = map[string]LogfmtQuoting{
+	"whenNeeded": QuoteWhenNeeded,
+	"always":     QuoteAlways,
+	"never":      QuoteNever,
+}
+
+// LogfmtFormatter converts LogRecord to logfmt, e.g.
+// time=2024-05-01T03:04:05+0000 level=info caller=main.go:7 msg="hello world" k=v
+// Keys containing spaces, '=', '"' or control characters are sanitized
+// to '_' and reported by OnError. The defaults round-trip with go-logfmt
+type LogfmtFormatter struct {
+	Datefmt string
+	// Quoting is the quoting policy of values
+	Quoting LogfmtQuoting
+	// QuoteChar quotes values, '"' or '\'', 0 means '"'. The quote
+	// character and backslashes inside quoted values are escaped
+	// by a backslash
+	QuoteChar byte
+	// QuoteEmpty writes empty values as k="" instead of k=, empty
+	// values are always quoted by QuoteAlways and never by QuoteNever
+	QuoteEmpty bool
+	// ReplaceNewlines replaces newlines in values with spaces,
+	// they are escaped as \n by default
+	ReplaceNewlines bool
+	// Interpolate substitutes {field} placeholders in msg by the record
+	// fields, see AppendInterpolated. The fields are still written
+	Interpolate bool
+	// DisableExtra omits the record extras, which are written
+	// after fields by default, prefixed by ExtraPrefix
+	DisableExtra bool
+	// ExtraPrefix prefixes the keys of extras,
+	// "" means DefaultExtraPrefix
+	ExtraPrefix string
+	// OnError is called with invalid keys,
+	// errors are printed to stderr if it is nil
+	OnError func(err error)
+	ConfigLoader
+}
+
+// NewLogfmtFormatter returns a LogfmtFormatter with default config
+func NewLogfmtFormatter() *LogfmtFormatter {
+	return &LogfmtFormatter{
+		Datefmt: DefaultLogfmtDateFmt,
+	}
+}
+
+// LoadConfig loads config from its input and
+// stores it in the value pointed to by c
+func (lf *LogfmtFormatter) LoadConfig(c map[string]interface{}) error {
+	config, err := pythonic.DictReflect(c)
+	if err != nil {
+		return err
+	}
+
+	lf.Datefmt = config.MustGetString("datefmt", DefaultLogfmtDateFmt)
+	quoting := config.MustGetString("quoting", "whenNeeded")
+	if _, ok := logfmtQuotings[quoting]; !ok {
+		return fmt.Errorf("unknown logfmt quoting: %s", quoting)
+	}
+	lf.Quoting = logfmtQuotings[quoting]
+	switch quote := config.MustGetString("quoteChar", `"`); quote {
+	case `"`, "'":
+		lf.QuoteChar = quote[0]
+	default:
+		return fmt.Errorf("unsupported logfmt quote char: %s", quote)
+	}
+	lf.QuoteEmpty = config.MustGetBool("quoteEmpty", false)
+	lf.ReplaceNewlines = config.MustGetBool("replaceNewlines", false)
+	lf.Interpolate = config.MustGetBool("interpolate", false)
+	lf.DisableExtra = config.MustGetBool("disableExtra", false)
+	lf.ExtraPrefix = config.MustGetString("extraPrefix", DefaultExtraPrefix)
+	return nil
+}
+
+// Format converts the specified record to logfmt string.
+func (lf *LogfmtFormatter) Format(record *LogRecord) (string, error) {
+	text, err := lf.AppendFormat(nil, record)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+// AppendFormat appends the record converted to logfmt to dst
+func (lf *LogfmtFormatter) AppendFormat(dst []byte, record *LogRecord) ([]byte, error) {
+	dst = append(dst, "time="...)
+	dst = lf.appendValue(dst, FormatTime(record, lf.Datefmt))
+	dst = append(dst, " level="...)
+	dst = lf.appendValue(dst, strings.ToLower(record.LevelName))
+	if record.Name != "" {
+		dst = append(dst, " logger="...)
+		dst = lf.appendValue(dst, record.Name)
+	}
+	if record.Line > 0 {
+		dst = append(dst, " caller="...)
+		dst = lf.appendValue(dst, fmt.Sprintf("%s:%d", record.FileName, record.Line))
+	}
+	dst = append(dst, " msg="...)
+	dst = lf.appendValue(dst, interpolatedMessage(record, lf.Interpolate))
+	if record.Event != "" {
+		dst = append(dst, " event="...)
+		dst = lf.appendValue(dst, record.Event)
+	}
+
+	fields := record.Fields
+	if !lf.DisableExtra {
+		fields = fieldsWithExtra(record, lf.ExtraPrefix)
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		dst = append(dst, ' ')
+		dst = lf.appendKey(dst, k)
+		dst = append(dst, '=')
+		dst = lf.appendValue(dst, logfmtString(fields[k]))
+	}
+	return dst, nil
+}
+
+// logfmtString converts a field value to string
+func logfmtString(v interface{}) string {
+	switch vv := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return vv
+	case time.Time:
+		return vv.Format(time.RFC3339)
+	case error:
+		return vv.Error()
+	case time.Duration:
+		return vv.String()
+	case HumanReadable:
+		return vv.HumanString()
+	case LazyValue:
+		return logfmtString(vv())
+	}
+	if kind := unsupportedKind(v); kind != "" {
+		return fmt.Sprintf(UnsupportedFieldFormat, kind)
+	}
+	return fmt.Sprint(v)
+}
+
+// logfmtNeedsQuote checks if the rune can not be in an unquoted value
+func logfmtNeedsQuote(r rune) bool {
+	return r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError
+}
+
+// quote returns QuoteChar, '"' by default
+func (lf *LogfmtFormatter) quote() byte {
+	if lf.QuoteChar == 0 {
+		return '"'
+	}
+	return lf.QuoteChar
+}
+
+// needsQuote checks if the rune can not be in an unquoted value,
+// including QuoteChar
+func (lf *LogfmtFormatter) needsQuote(r rune) bool {
+	return logfmtNeedsQuote(r) || r == rune(lf.quote())
+}
+
+// appendKey appends the key, invalid runes are replaced with '_'
+func (lf *LogfmtFormatter) appendKey(dst []byte, key string) []byte {
+	if key != "" && strings.IndexFunc(key, lf.needsQuote) < 0 {
+		return append(dst, key...)
+	}
+	start := len(dst)
+	for _, r := range key {
+		if lf.needsQuote(r) {
+			r = '_'
+		}
+		dst = append(dst, string(r)...)
+	}
+	if len(dst) == start {
+		dst = append(dst, '_')
+	}
+	lf.onError(fmt.Errorf("invalid logfmt key %q, sanitized to %q", key, dst[start:]))
+	return dst
+}
+
+// appendValue appends the value according to Quoting, QuoteChar,
+// QuoteEmpty and ReplaceNewlines
+func (lf *LogfmtFormatter) appendValue(dst []byte, value string) []byte {
+	if lf.ReplaceNewlines && strings.ContainsAny(value, "\r\n") {
+		value = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(value)
+	}
+	quote := lf.quote()
+	switch {
+	case lf.Quoting == QuoteAlways:
+		return appendLogfmtQuoted(dst, value, quote)
+	case value == "" && lf.QuoteEmpty && lf.Quoting != QuoteNever:
+		return appendLogfmtQuoted(dst, value, quote)
+	case strings.IndexFunc(value, lf.needsQuote) < 0:
+		return append(dst, value...)
+	case lf.Quoting == QuoteNever:
+		return appendLogfmtEscaped(dst, value, quote)
+	}
+	return appendLogfmtQuoted(dst, value, quote)
+}
+
+// appendLogfmtQuoted appends the value quoted by quote with json-like escapes
+func appendLogfmtQuoted(dst []byte, value string, quote byte) []byte {
+	dst = append(dst, quote)
+	for i := 0; i < len(value); {
+		r, size := utf8.DecodeRuneInString(value[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			dst = append(dst, `\ufffd`...)
+		case r == rune(quote) || r == '\\':
+			dst = append(dst, '\\', byte(r))
+		case r == '\n':
+			dst = append(dst, `\n`...)
+		case r == '\r':
+			dst = append(dst, `\r`...)
+		case r == '\t':
+			dst = append(dst, `\t`...)
+		case r < ' ' || r == 0x7f:
+			dst = append(dst, '\\', 'u', '0', '0', hexDigits[r>>4], hexDigits[r&0xf])
+		default:
+			dst = append(dst, value[i:i+size]...)
+		}
+		i += size
+	}
+	return append(dst, quote)
+}
+
+// appendLogfmtEscaped appends the value unquoted, runes which need
+// quoting and quote are escaped as \xNN, backslashes as \\
+func appendLogfmtEscaped(dst []byte, value string, quote byte) []byte {
+	for i := 0; i < len(value); {
+		r, size := utf8.DecodeRuneInString(value[i:])
+		switch {
+		case r == utf8.RuneError:
+			// invalid UTF-8 is replaced
+			dst = append(dst, "\uFFFD"...)
+		case r == '\\':
+			dst = append(dst, '\\', '\\')
+		case logfmtNeedsQuote(r) || r == rune(quote) || r == 0x7f:
+			dst = append(dst, '\\', 'x', hexDigits[r>>4], hexDigits[r&0xf])
+		default:
+			dst = append(dst, value[i:i+size]...)
+		}
+		i += size
+	}
+	return dst
+}
+
+func (lf *LogfmtFormatter) onError(err error) {
+	if lf.OnError != nil {
+		lf.OnError(err)
+		return
+	}
+	InternalError(fmt.Errorf("Format logfmt failed, [%v]", err))
+}
+
+func init() {
+	RegisterConstructor("LogfmtFormatter", func() ConfigLoader {
+		return NewLogfmtFormatter()
+	})
+}
@@ -0,0 +1,163 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// decodeLogfmt decodes a logfmt line like go-logfmt,
+// quoted values are unescaped, unquoted values are taken as they are
+func decodeLogfmt(t *testing.T, line string) map[string]string {
+	kvs := make(map[string]string)
+	for i := 0; i < len(line); {
+		if line[i] == ' ' {
+			i++
+			continue
+		}
+		j := i
+		for j < len(line) && line[j] != '=' && line[j] != ' ' {
+			j++
+		}
+		key := line[i:j]
+		if j == len(line) || line[j] == ' ' {
+			kvs[key] = ""
+			i = j
+			continue
+		}
+		j++
+		if j < len(line) && line[j] == '"' {
+			k := j + 1
+			for ; k < len(line) && line[k] != '"'; k++ {
+				if line[k] == '\\' {
+					k++
+				}
+			}
+			value, err := strconv.Unquote(line[j : k+1])
+			if err != nil {
+				t.Fatalf("invalid quoted value %s, [%v]", line[j:k+1], err)
+			}
+			kvs[key] = value
+			i = k + 1
+			continue
+		}
+		k := j
+		for k < len(line) && line[k] != ' ' {
+			k++
+		}
+		kvs[key] = line[j:k]
+		i = k
+	}
+	return kvs
+}
+
+func newLogfmtRecord(msg string, fields Fields) *LogRecord {
+	record := NewLogRecord("app", InfoLevel, "pkg/a/b.go", "a.b", 7, "", msg, fields)
+	record.Time = time.Date(2024, 5, 1, 3, 4, 5, 0, time.UTC)
+	return record
+}
+
+func TestLogfmtFormatter(t *testing.T) {
+	formatter := NewLogfmtFormatter()
+	assert.Implements(t, (*Formatter)(nil), formatter)
+	assert.Implements(t, (*ConfigLoader)(nil), formatter)
+
+	var errs []error
+	formatter.OnError = func(err error) { errs = append(errs, err) }
+	msg, err := formatter.Format(newLogfmtRecord("hello world", Fields{
+		"empty":    "",
+		"eq":       "a=b",
+		"nil":      nil,
+		"num":      42,
+		"bad key=": "v",
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, `time=2024-05-01T03:04:05+0000 level=info logger=app caller=b.go:7 msg="hello world" bad_key_=v empty= eq="a=b" nil=null num=42`, msg)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, `invalid logfmt key "bad key=", sanitized to "bad_key_"`, errs[0].Error())
+}
+
+func TestLogfmtFormatterPolicies(t *testing.T) {
+	value := "line1\nline2 \"q\" \\"
+	cases := []struct {
+		quoting  LogfmtQuoting
+		replace  bool
+		expected string
+	}{
+		{QuoteWhenNeeded, false, `msg="line1\nline2 \"q\" \\" plain=v`},
+		{QuoteWhenNeeded, true, `msg="line1 line2 \"q\" \\" plain=v`},
+		{QuoteAlways, false, `msg="line1\nline2 \"q\" \\" plain="v"`},
+		{QuoteNever, false, `msg=line1\x0aline2\x20\x22q\x22\x20\\ plain=v`},
+		{QuoteNever, true, `msg=line1\x20line2\x20\x22q\x22\x20\\ plain=v`},
+	}
+	for _, c := range cases {
+		formatter := &LogfmtFormatter{Quoting: c.quoting, ReplaceNewlines: c.replace}
+		msg, err := formatter.Format(newLogfmtRecord(value, Fields{"plain": "v"}))
+		assert.Nil(t, err)
+		assert.True(t, strings.HasSuffix(msg, c.expected), "%s", msg)
+	}
+}
+
+func TestLogfmtFormatterQuoteChar(t *testing.T) {
+	cases := []struct {
+		formatter *LogfmtFormatter
+		expected  string
+	}{
+		{&LogfmtFormatter{QuoteChar: '\''}, `msg='it\'s "ok"' empty= plain=v`},
+		{&LogfmtFormatter{QuoteChar: '\'', Quoting: QuoteAlways}, `msg='it\'s "ok"' empty='' plain='v'`},
+		{&LogfmtFormatter{QuoteChar: '\'', Quoting: QuoteNever, QuoteEmpty: true}, `msg=it\x27s\x20\x22ok\x22 empty= plain=v`},
+		{&LogfmtFormatter{QuoteEmpty: true}, `msg="it's \"ok\"" empty="" plain=v`},
+	}
+	for _, c := range cases {
+		msg, err := c.formatter.Format(newLogfmtRecord(`it's "ok"`, Fields{"empty": "", "plain": "v"}))
+		assert.Nil(t, err)
+		assert.True(t, strings.HasSuffix(msg, c.expected), "%s", msg)
+	}
+
+	formatter := NewLogfmtFormatter()
+	assert.Nil(t, formatter.LoadConfig(map[string]interface{}{"quoteChar": "'", "quoteEmpty": true}))
+	assert.Equal(t, byte('\''), formatter.QuoteChar)
+	assert.True(t, formatter.QuoteEmpty)
+	assert.NotNil(t, formatter.LoadConfig(map[string]interface{}{"quoteChar": "`"}))
+}
+
+func TestLogfmtFormatterRoundTrip(t *testing.T) {
+	for _, quoting := range []LogfmtQuoting{QuoteWhenNeeded, QuoteAlways} {
+		formatter := &LogfmtFormatter{Quoting: quoting}
+		roundTrip := func(msg, value string) bool {
+			line, err := formatter.Format(newLogfmtRecord(msg, Fields{"k": value}))
+			if err != nil {
+				return false
+			}
+			kvs := decodeLogfmt(t, line)
+			return kvs["msg"] == msg && kvs["k"] == value && kvs["level"] == "info"
+		}
+		assert.Nil(t, quick.Check(roundTrip, nil))
+		for _, s := range []string{"", " ", "=", `"`, "\\", "\x00\x1b[31m", "a b=c\td\r\n", " 中文"} {
+			assert.True(t, roundTrip(s, s), "%q", s)
+		}
+	}
+
+	// invalid UTF-8 is replaced
+	line, err := NewLogfmtFormatter().Format(newLogfmtRecord("bad \xff", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, "bad \uFFFD", decodeLogfmt(t, line)["msg"])
+}
@@ -0,0 +1,162 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zoumo/logdog/pkg/when"
+)
+
+// Parse converts logfmt text formatted by the LogfmtFormatter back to
+// a LogRecord, it is the inverse of Format with the same config.
+// time and level are required, time is parsed by Datefmt in the local
+// location. caller is restored as FileName, PathName and Line, other
+// keys as string fields. Values escaped by QuoteNever are not unescaped
+func (lf *LogfmtFormatter) Parse(data []byte) (*LogRecord, error) {
+	pairs, err := parseLogfmtPairs(string(data), lf.quote())
+	if err != nil {
+		return nil, err
+	}
+
+	record := &LogRecord{}
+	hasTime, hasLevel := false, false
+	for _, kv := range pairs {
+		switch k, v := kv[0], kv[1]; k {
+		case "time":
+			datefmt := lf.Datefmt
+			if datefmt == "" {
+				datefmt = DefaultDateFmtTemplate
+			}
+			layout, err := when.StrftimeLayout(datefmt)
+			if err != nil {
+				return nil, err
+			}
+			if record.Time, err = time.ParseInLocation(layout, v, time.Local); err != nil {
+				return nil, fmt.Errorf("parse time %s failed, [%v]", v, err)
+			}
+			hasTime = true
+		case "level":
+			level, err := ParseLevel(v)
+			if err != nil {
+				return nil, err
+			}
+			record.Level, record.LevelName = level, level.String()
+			hasLevel = true
+		case "logger":
+			record.Name = v
+		case "caller":
+			i := strings.LastIndexByte(v, ':')
+			if i < 0 {
+				return nil, fmt.Errorf("invalid caller %q", v)
+			}
+			record.FileName = v[:i]
+			record.PathName = record.FileName
+			record.Line, _ = strconv.Atoi(v[i+1:])
+		case "msg":
+			record.Msg = v
+		case "event":
+			record.Event = v
+		default:
+			if record.Fields == nil {
+				record.Fields = make(Fields)
+			}
+			record.Fields[k] = v
+		}
+	}
+	if !hasTime || !hasLevel {
+		return nil, fmt.Errorf("logfmt record should have time and level")
+	}
+	return record, nil
+}
+
+// parseLogfmtPairs splits logfmt text into key value pairs,
+// keys and values may be quoted by quote
+func parseLogfmtPairs(text string, quote byte) ([][2]string, error) {
+	var pairs [][2]string
+	for {
+		text = strings.TrimLeft(text, " \t\r\n")
+		if text == "" {
+			return pairs, nil
+		}
+		key, rest, err := parseLogfmtToken(text, quote, '=')
+		if err != nil {
+			return nil, err
+		}
+		if key == "" || rest == "" || rest[0] != '=' {
+			return nil, fmt.Errorf("invalid logfmt pair at %q", text)
+		}
+		value, rest, err := parseLogfmtToken(rest[1:], quote, ' ')
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, [2]string{key, value})
+		text = rest
+	}
+}
+
+// parseLogfmtToken reads a quoted token, or an unquoted one
+// ending before end or a space, it returns the rest of text
+func parseLogfmtToken(text string, quote byte, end byte) (string, string, error) {
+	if text == "" || text[0] != quote {
+		i := strings.IndexFunc(text, func(r rune) bool {
+			return r == rune(end) || r == ' '
+		})
+		if i < 0 {
+			return text, "", nil
+		}
+		return text[:i], text[i:], nil
+	}
+
+	var token []byte
+	for i := 1; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == quote:
+			return string(token), text[i+1:], nil
+		case c != '\\':
+			token = append(token, c)
+			continue
+		}
+		if i+1 >= len(text) {
+			break
+		}
+		i++
+		switch c := text[i]; c {
+		case 'n':
+			token = append(token, '\n')
+		case 'r':
+			token = append(token, '\r')
+		case 't':
+			token = append(token, '\t')
+		case 'u':
+			if i+4 >= len(text) {
+				return "", "", fmt.Errorf("invalid escape in %q", text)
+			}
+			r, err := strconv.ParseUint(text[i+1:i+5], 16, 32)
+			if err != nil {
+				return "", "", fmt.Errorf("invalid escape in %q", text)
+			}
+			token = append(token, string(rune(r))...)
+			i += 4
+		default:
+			token = append(token, c)
+		}
+	}
+	return "", "", fmt.Errorf("unterminated quote in %q", text)
+}
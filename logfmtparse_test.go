@@ -0,0 +1,61 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogfmtFormatterParse(t *testing.T) {
+	record := NewLogRecord("app.db", WarnLevel, "a/b.go", fun, line, "hello \"jim\"\n", Event("user.login"),
+		Fields{"n": 1, "s": "a b", "empty": "", "quote": `it's`})
+	record.Time = time.Date(2024, 5, 1, 3, 4, 5, 0, time.Local)
+
+	for _, formatter := range []*LogfmtFormatter{
+		NewLogfmtFormatter(),
+		{Datefmt: DefaultLogfmtDateFmt, Quoting: QuoteAlways, QuoteChar: '\''},
+		{Datefmt: "%Y-%m-%d %H:%M:%S", QuoteEmpty: true},
+	} {
+		text, err := formatter.Format(record)
+		assert.Nil(t, err)
+		parsed, err := formatter.Parse([]byte(text))
+		assert.Nil(t, err, text)
+
+		assert.True(t, record.Time.Equal(parsed.Time), text)
+		assert.Equal(t, WarnLevel, parsed.Level)
+		assert.Equal(t, "WARN", parsed.LevelName)
+		assert.Equal(t, "app.db", parsed.Name)
+		assert.Equal(t, "hello \"jim\"\n", parsed.GetMessage())
+		assert.Equal(t, "user.login", parsed.Event)
+		assert.Equal(t, "b.go", parsed.FileName)
+		assert.Equal(t, line, parsed.Line)
+		// logfmt values are strings
+		assert.Equal(t, Fields{"n": "1", "s": "a b", "empty": "", "quote": "it's"}, parsed.Fields)
+	}
+
+	for _, text := range []string{
+		`msg=hello`,
+		`time=2024-05-01T03:04:05+0000 msg=hello`,
+		`time=2024-05-01T03:04:05+0000 level=loud`,
+		`time=2024-05-01T03:04:05+0000 level=info msg="unterminated`,
+		`panic: runtime error`,
+	} {
+		_, err := NewLogfmtFormatter().Parse([]byte(text))
+		assert.NotNil(t, err, text)
+	}
+}
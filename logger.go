@@ -16,8 +16,11 @@ package logdog
 
 import (
 	"fmt"
-	"os"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/zoumo/logdog/pkg/pythonic"
 )
@@ -26,8 +29,19 @@ const (
 	// DefaultCallerStackDepth is 2 because you should ascend 2 frames
 	// to get true caller function by default
 	DefaultCallerStackDepth = 2
+	// DefaultComponentField is the conventional field key
+	// of the package of the caller, see Logger.ComponentField
+	DefaultComponentField = "component"
 )
 
+// packages caches the package path of the caller by program counter
+var packages sync.Map // uintptr -> string
+
+// FieldValidator validates a field when it is attached to a logger or
+// a record, it returns the value to attach, which may be coerced, e.g.
+// a numeric string parsed to a number, or an error if it is invalid
+type FieldValidator func(key string, val interface{}) (interface{}, error)
+
 // Logger entries pass through the formatter before logged to Output. The
 // included formatters are `TextFormatter` and `JSONFormatter` for which
 // TextFormatter is the default. In development (when a TTY is attached) it
@@ -37,11 +51,40 @@ const (
 type Logger struct {
 	Name     string
 	Handlers []Handler
-	Level    Level
+	// Deprecated: Level should not be changed directly while the
+	// logger is in use, use SetLevel and GetLevel instead
+	Level Level
 	// callerStackDepth is the number of stack frames to ascend
 	// you should change it if you implement your own log function
-	CallerStackDepth    int
+	CallerStackDepth int
+	// CallerSkip is the number of extra stack frames to ascend,
+	// set it if you wrap logger in your own helper functions
+	// so that the real call site is reported
+	CallerSkip          int
 	EnableRuntimeCaller bool
+	// ComponentField is the field key of the package of the caller,
+	// e.g. github.com/acme/app/server, it is added to records if
+	// it is not empty and EnableRuntimeCaller is true
+	ComponentField string
+	// OnError is called with errors of the logger itself, e.g. a
+	// panicking hook, errors are printed to stderr if it is nil
+	OnError func(err error)
+	// DropInvalidFields drops fields rejected by a FieldValidator,
+	// they are kept as they are by default
+	DropInvalidFields bool
+	// fields are added to all records, they are never modified
+	// after the logger is derived by With or WithFields
+	fields Fields
+	// time overrides the time of records if it is not zero, see WithTime
+	time time.Time
+	// hooks stores []func(*LogRecord), it is replaced
+	// as a whole by AddHook under hooksMu
+	hooks   atomic.Value
+	hooksMu sync.Mutex
+	// validators stores []FieldValidator, it is replaced
+	// as a whole by AddFieldValidator under validatorsMu
+	validators   atomic.Value
+	validatorsMu sync.Mutex
 }
 
 // NewLogger returns a new Logger
@@ -59,9 +102,7 @@ func NewLogger(options ...Option) *Logger {
 
 // ApplyOptions applys all option to Logger
 func (lg *Logger) ApplyOptions(options ...Option) *Logger {
-	for _, opt := range options {
-		opt.applyOption(lg)
-	}
+	applyOptions(lg, options...)
 	return lg
 }
 
@@ -76,6 +117,8 @@ func (lg *Logger) LoadConfig(c map[string]interface{}) error {
 	lg.Name = config.MustGetString("name", "")
 	lg.Level = GetLevel(config.MustGetString("level", "NOTHING"))
 	lg.EnableRuntimeCaller = config.MustGetBool("enableRuntimeCaller", false)
+	lg.CallerSkip = config.MustGetInt("callerSkip", 0)
+	lg.ComponentField = config.MustGetString("componentField", "")
 
 	_handlers := config.MustGetArray("handlers", make([]interface{}, 0))
 
@@ -91,48 +134,313 @@ func (lg *Logger) LoadConfig(c map[string]interface{}) error {
 
 }
 
+// With returns a derived logger which adds the key-value pairs as fields
+// to all records, keys which are not string are formatted by fmt.Sprint,
+// a key without value gets nil. Fields in place of a key, e.g. returned
+// by Bytes, are merged as they are.
+// The derived logger shares handlers with lg but not its level,
+// and it is not registered
+func (lg *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make(Fields, (len(keyvals)+1)/2)
+	for i := 0; i < len(keyvals); i += 2 {
+		if f, ok := keyvals[i].(Fields); ok {
+			// e.g. Bytes("size", n)
+			for k, v := range f {
+				fields[k] = v
+			}
+			i--
+			continue
+		}
+		key, ok := keyvals[i].(string)
+		if !ok {
+			key = fmt.Sprint(keyvals[i])
+		}
+		var value interface{}
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		fields[key] = value
+	}
+	return lg.WithFields(fields)
+}
+
+// WithFields returns a derived logger which adds fields to all records,
+// fields of records override them, see With
+func (lg *Logger) WithFields(fields Fields) *Logger {
+	// copy on write, so loggers can be derived concurrently
+	merged := make(Fields, len(lg.fields)+len(fields))
+	for k, v := range lg.fields {
+		merged[k] = v
+	}
+	lg.attachFields(merged, fields, lg.loadValidators())
+
+	derived := &Logger{
+		Name: lg.Name,
+		// AddHandlers on the derived logger must not
+		// append to the array shared with lg
+		Handlers:            lg.Handlers[:len(lg.Handlers):len(lg.Handlers)],
+		Level:               lg.GetLevel(),
+		CallerStackDepth:    lg.CallerStackDepth,
+		CallerSkip:          lg.CallerSkip,
+		EnableRuntimeCaller: lg.EnableRuntimeCaller,
+		ComponentField:      lg.ComponentField,
+		OnError:             lg.OnError,
+		DropInvalidFields:   lg.DropInvalidFields,
+		fields:              merged,
+		time:                lg.time,
+	}
+	// hooks and validators are copied on write, so sharing them is safe
+	derived.hooks.Store(lg.loadHooks())
+	derived.validators.Store(lg.loadValidators())
+	return derived
+}
+
+// WithTime returns a derived logger which logs records with the time t
+// instead of the current time, e.g. for events which happened earlier
+// than they are logged. Formatters and time based rotation use it,
+// handlers emitting records in the background keep it as well.
+// The zero time means the current time, see WithFields
+func (lg *Logger) WithTime(t time.Time) *Logger {
+	derived := lg.WithFields(nil)
+	derived.time = t
+	return derived
+}
+
+// Fields returns a copy of the fields added to all records
+func (lg *Logger) Fields() Fields {
+	fields := make(Fields, len(lg.fields))
+	for k, v := range lg.fields {
+		fields[k] = v
+	}
+	return fields
+}
+
 // AddHandlers adds handler to logger
 func (lg *Logger) AddHandlers(handlers ...Handler) *Logger {
 	lg.Handlers = append(lg.Handlers, handlers...)
 	return lg
 }
 
-// log is the true logging function
-func (lg *Logger) log(level Level, msg string, args ...interface{}) {
+// AddHook adds a hook which is called with every record passed to
+// handlers, hooks are called in the order they are added, before any
+// handler, so they can enrich or scrub records, e.g. add deployment
+// metadata. A panicking hook is recovered and reported by OnError.
+// It is safe to add hooks while the logger is in use
+func (lg *Logger) AddHook(hook func(*LogRecord)) *Logger {
+	lg.hooksMu.Lock()
+	defer lg.hooksMu.Unlock()
+	old := lg.loadHooks()
+	hooks := make([]func(*LogRecord), len(old), len(old)+1)
+	copy(hooks, old)
+	lg.hooks.Store(append(hooks, hook))
+	return lg
+}
+
+func (lg *Logger) loadHooks() []func(*LogRecord) {
+	hooks, _ := lg.hooks.Load().([]func(*LogRecord))
+	return hooks
+}
+
+// runHooks calls all hooks with the record
+func (lg *Logger) runHooks(record *LogRecord) {
+	for i, hook := range lg.loadHooks() {
+		lg.runHook(i, hook, record)
+	}
+}
+
+func (lg *Logger) runHook(i int, hook func(*LogRecord), record *LogRecord) {
+	defer func() {
+		if r := recover(); r != nil {
+			lg.onError(fmt.Errorf("hook %d panicked, [%v]", i, r))
+		}
+	}()
+	hook(record)
+}
+
+// AddFieldValidator adds a validator which is called with every field
+// attached by WithFields or passed with a record, validators are called
+// in the order they are added. A rejected field is reported by OnError,
+// it is dropped if DropInvalidFields is true.
+// It is safe to add validators while the logger is in use
+func (lg *Logger) AddFieldValidator(validator FieldValidator) *Logger {
+	lg.validatorsMu.Lock()
+	defer lg.validatorsMu.Unlock()
+	old := lg.loadValidators()
+	validators := make([]FieldValidator, len(old), len(old)+1)
+	copy(validators, old)
+	lg.validators.Store(append(validators, validator))
+	return lg
+}
+
+func (lg *Logger) loadValidators() []FieldValidator {
+	validators, _ := lg.validators.Load().([]FieldValidator)
+	return validators
+}
+
+// attachFields copies fields to dst, validating them by validators
+func (lg *Logger) attachFields(dst, fields Fields, validators []FieldValidator) {
+	for k, v := range fields {
+		if val, ok := lg.validateField(k, v, validators); ok {
+			dst[k] = val
+		}
+	}
+}
+
+// validateField returns the validated value and true if the field
+// should be attached
+func (lg *Logger) validateField(key string, val interface{}, validators []FieldValidator) (interface{}, bool) {
+	for _, validator := range validators {
+		v, err := validator(key, val)
+		if err != nil {
+			lg.onError(fmt.Errorf("invalid field %s, [%v]", key, err))
+			return val, !lg.DropInvalidFields
+		}
+		val = v
+	}
+	return val, true
+}
+
+func (lg *Logger) onError(err error) {
+	if lg.OnError != nil {
+		lg.OnError(err)
+		return
+	}
+	InternalError(fmt.Errorf("Logger %s failed, [%v]", lg.Name, err))
+}
+
+// levelGetter is implemented by handlers with a level,
+// they never emit records below it
+type levelGetter interface {
+	GetLevel() Level
+}
+
+// Enabled checks if records of the level pass the logger level and the
+// level of any handler, handlers without GetLevel are assumed to accept
+// all levels. Logging methods return before building the record if it is
+// false, so disabled DEBUG calls are cheap, hooks are not run for them
+func (lg *Logger) Enabled(level Level) bool {
+	if level < lg.GetLevel() {
+		return false
+	}
+	for _, hdlr := range lg.Handlers {
+		if lv, ok := hdlr.(levelGetter); !ok || level >= lv.GetLevel() {
+			return true
+		}
+	}
+	return false
+}
+
+// log is the true logging function, returns the record even if it is
+// not emitted, or nil if the level is not Enabled. Records of FATAL
+// level are always built, Panic needs their messages
+func (lg *Logger) log(level Level, msg string, args ...interface{}) *LogRecord {
+	if level < FatalLevel && !lg.Enabled(level) {
+		return nil
+	}
 	// 获取runtime的信息
 	file := "??"
 	line := 0
 	funcname := "??"
+	component := ""
 	if lg.EnableRuntimeCaller {
-		if _pc, _file, _line, ok := runtime.Caller(lg.CallerStackDepth); ok {
+		if _pc, _file, _line, ok := runtime.Caller(lg.CallerStackDepth + lg.CallerSkip); ok {
 			file, line = _file, _line
 			if f := runtime.FuncForPC(_pc); f != nil {
 				funcname = f.Name() // full func name
+				if lg.ComponentField != "" {
+					component = packageOf(_pc, funcname)
+				}
 			}
 		}
 	}
 
-	record := NewLogRecord(lg.Name, level, file, funcname, line, msg, args...)
+	record := NewLogRecord(recordName(lg.Name), level, file, funcname, line, msg, args...)
+	if !lg.time.IsZero() {
+		record.Time = lg.time
+	}
+	validators := lg.loadValidators()
+	global := loadGlobalFields()
+	if len(global) > 0 || len(lg.fields) > 0 || component != "" || (len(validators) > 0 && len(record.Fields) > 0) {
+		// handlers may modify fields of the record
+		fields := make(Fields, len(global)+len(lg.fields)+len(record.Fields)+1)
+		for k, v := range global {
+			fields[k] = v
+		}
+		if component != "" {
+			fields[lg.ComponentField] = component
+		}
+		for k, v := range lg.fields {
+			fields[k] = v
+		}
+		lg.attachFields(fields, record.Fields, validators)
+		record.Fields = fields
+	}
 	lg.Handle(record)
+	return record
+}
+
+// packageOf returns the package path of the function funcname at pc,
+// e.g. github.com/acme/app/server for
+// github.com/acme/app/server.(*Server).Serve, it is cached by pc
+func packageOf(pc uintptr, funcname string) string {
+	if v, ok := packages.Load(pc); ok {
+		return v.(string)
+	}
+	pkg := funcname
+	// type arguments of generic functions may contain slashes
+	if k := strings.Index(pkg, "["); k >= 0 {
+		pkg = pkg[:k]
+	}
+	// the package path may contain dots only before the last slash
+	i := strings.LastIndex(pkg, "/")
+	if j := strings.Index(pkg[i+1:], "."); j >= 0 {
+		pkg = pkg[:i+1+j]
+	}
+	packages.Store(pc, pkg)
+	return pkg
 }
 
 // Handle handles the LogRecord, call all halders
 func (lg *Logger) Handle(record *LogRecord) {
-	filtered := lg.Filter(record)
-	if !filtered {
+	if lg.ShouldEmit(record) {
+		lg.runHooks(record)
 		lg.callHandlers(record)
 	}
 }
 
-// Filter checks if logger should filter the specified record
-func (lg Logger) Filter(record *LogRecord) bool {
-	return record.Level < lg.Level
+// ShouldEmit checks if logger should pass the specified record to handlers
+func (lg *Logger) ShouldEmit(record *LogRecord) bool {
+	return record.Level >= lg.GetLevel()
 }
 
-// CallHandlers call all handler registered in logger
+// Filter checks if logger should filter the specified record.
+// Deprecated: use ShouldEmit instead
+func (lg *Logger) Filter(record *LogRecord) bool {
+	return !lg.ShouldEmit(record)
+}
+
+// SetLevel sets the logger's level, it is safe to be called
+// while the logger is in use
+func (lg *Logger) SetLevel(level Level) {
+	storeLevel(&lg.Level, level)
+}
+
+// GetLevel returns the logger's level
+func (lg *Logger) GetLevel() Level {
+	return loadLevel(&lg.Level)
+}
+
+// CallHandlers call all handler registered in logger, every handler
+// gets its own shallow copy of the record, so fields added by the
+// filters of one handler are not seen by other handlers and hooks
 func (lg *Logger) callHandlers(record *LogRecord) {
+	record.prepareLazy()
 	for _, hdlr := range lg.Handlers {
-		hdlr.Emit(record)
+		copied := *record
+		if ShouldEmit(hdlr, &copied) {
+			copied.ResolveLazy()
+			hdlr.Emit(&copied)
+		}
 	}
 }
 
@@ -141,7 +449,7 @@ func (lg *Logger) Flush() error {
 	for _, hdlr := range lg.Handlers {
 		err := hdlr.Flush()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Flush handler failed, [%v]", err)
+			InternalError(fmt.Errorf("Flush handler failed, [%v]", err))
 		}
 	}
 	return nil
@@ -152,92 +460,100 @@ func (lg *Logger) Close() error {
 	for _, hdlr := range lg.Handlers {
 		err := hdlr.Close()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Close handler failed, [%v]", err)
+			InternalError(fmt.Errorf("Close handler failed, [%v]", err))
 		}
 	}
 	return nil
 }
 
 // Logf emits log with specified level and format string
-func (lg Logger) Logf(level Level, msg string, args ...interface{}) {
+func (lg *Logger) Logf(level Level, msg string, args ...interface{}) {
 	lg.log(level, msg, args...)
 }
 
 // Debugf emits log with DEBUG level and format string
-func (lg Logger) Debugf(msg string, args ...interface{}) {
+func (lg *Logger) Debugf(msg string, args ...interface{}) {
 	lg.log(DebugLevel, msg, args...)
 }
 
 // Infof emits log with INFO level and format string
-func (lg Logger) Infof(msg string, args ...interface{}) {
+func (lg *Logger) Infof(msg string, args ...interface{}) {
 	lg.log(InfoLevel, msg, args...)
 }
 
 // Warnf emits log with WARN level and format string
-func (lg Logger) Warnf(msg string, args ...interface{}) {
+func (lg *Logger) Warnf(msg string, args ...interface{}) {
 	lg.log(WarnLevel, msg, args...)
 }
 
 // Errorf emits log with ERROR level and format string
-func (lg Logger) Errorf(msg string, args ...interface{}) {
+func (lg *Logger) Errorf(msg string, args ...interface{}) {
 	lg.log(ErrorLevel, msg, args...)
 }
 
 // Noticef emits log with NOTICE level and format string
-func (lg Logger) Noticef(msg string, args ...interface{}) {
+func (lg *Logger) Noticef(msg string, args ...interface{}) {
 	lg.log(NoticeLevel, msg, args...)
 }
 
-// Fatalf emits log with FATAL level and format string
-func (lg Logger) Fatalf(msg string, args ...interface{}) {
+// Fatalf emits log with FATAL level and format string,
+// then flushes and closes all handlers of lg and all registered loggers
+// by ExitFlush, which drains async handlers, and calls os.Exit(1)
+func (lg *Logger) Fatalf(msg string, args ...interface{}) {
 	lg.log(FatalLevel, msg, args...)
+	exit(lg, 1)
 }
 
-// Panicf emits log with FATAL level and format string
-// and panic it
-func (lg Logger) Panicf(msg string, args ...interface{}) {
-	lg.log(FatalLevel, msg, args...)
-	panic(FatalLevel.String())
+// Panicf emits log with FATAL level and format string,
+// then flushes the handlers and panics with the message
+func (lg *Logger) Panicf(msg string, args ...interface{}) {
+	record := lg.log(FatalLevel, msg, args...)
+	lg.Flush()
+	panic(record.GetMessage())
 }
 
 // Log emits log message
-func (lg Logger) Log(level Level, args ...interface{}) {
+func (lg *Logger) Log(level Level, args ...interface{}) {
 	lg.log(level, "", args...)
 }
 
 // Debug emits log message with DEBUG level
-func (lg Logger) Debug(args ...interface{}) {
+func (lg *Logger) Debug(args ...interface{}) {
 	lg.log(DebugLevel, "", args...)
 }
 
 //Info emits log message with INFO level
-func (lg Logger) Info(args ...interface{}) {
+func (lg *Logger) Info(args ...interface{}) {
 	lg.log(InfoLevel, "", args...)
 }
 
 // Warn emits log message with WARN level
-func (lg Logger) Warn(args ...interface{}) {
+func (lg *Logger) Warn(args ...interface{}) {
 	lg.log(WarnLevel, "", args...)
 }
 
 // Error emits log message with ERROR level
-func (lg Logger) Error(args ...interface{}) {
+func (lg *Logger) Error(args ...interface{}) {
 	lg.log(ErrorLevel, "", args...)
 }
 
 // Notice emits log message with NOTICE level
-func (lg Logger) Notice(args ...interface{}) {
+func (lg *Logger) Notice(args ...interface{}) {
 	lg.log(NoticeLevel, "", args...)
 }
 
-// Fatal emits log message with FATAL level
-func (lg Logger) Fatal(args ...interface{}) {
+// Fatal emits log message with FATAL level,
+// then flushes and closes all handlers of lg and all registered loggers
+// by ExitFlush, which drains async handlers, and calls os.Exit(1)
+func (lg *Logger) Fatal(args ...interface{}) {
 	lg.log(FatalLevel, "", args...)
+	exit(lg, 1)
 }
 
-// Panic emits log message with FATAL level
-// and panic it
-func (lg Logger) Panic(msg string, args ...interface{}) {
-	lg.log(FatalLevel, "", args...)
-	panic(FatalLevel.String())
+// Panic emits log message with FATAL level,
+// then flushes the handlers and panics with the message
+func (lg *Logger) Panic(msg string, args ...interface{}) {
+	record := lg.log(FatalLevel, "", append([]interface{}{msg}, args...)...)
+	lg.Flush()
+	panic(record.GetMessage())
 }
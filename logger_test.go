@@ -14,7 +14,14 @@
 package logdog
 
 import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -47,7 +54,10 @@ func TestLogger(t *testing.T) {
 	logger.Warn("warning warning", Fields{"x": "man"})
 	logger.Notice("this notice is impotant", Fields{"x": "man"})
 	logger.Error("error error..", Fields{"x": "man"})
+	code, restore := stubExit()
 	logger.Fatal("I have no idea !", Fields{"x": "man"})
+	restore()
+	assert.Equal(t, 1, *code)
 
 	logger2 := NewLogger(
 		OptionName("test2"),
@@ -60,6 +70,401 @@ func TestLogger(t *testing.T) {
 	assert.True(t, logger2.EnableRuntimeCaller)
 }
 
+func TestSetLevelWhileLogging(t *testing.T) {
+	hdlr := NewStreamHandler(OptionDiscardOutput())
+	logger := NewLogger(OptionHandlers(hdlr))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				logger.Info("storm")
+			}
+		}()
+	}
+
+	levels := []Level{DebugLevel, ErrorLevel, InfoLevel}
+	for i := 0; i < 1000; i++ {
+		logger.SetLevel(levels[i%len(levels)])
+		hdlr.SetLevel(levels[(i+1)%len(levels)])
+	}
+	wg.Wait()
+
+	logger.SetLevel(WarnLevel)
+	hdlr.SetLevel(ErrorLevel)
+	assert.Equal(t, WarnLevel, logger.GetLevel())
+	assert.Equal(t, ErrorLevel, hdlr.GetLevel())
+}
+
+func TestLoggerEnabled(t *testing.T) {
+	info := NewStreamHandler(OptionDiscardOutput(), InfoLevel)
+	errors := NewStreamHandler(OptionDiscardOutput(), ErrorLevel)
+	logger := NewLogger(OptionHandlers(info, errors))
+	built := 0
+	logger.AddHook(func(*LogRecord) { built++ })
+
+	assert.False(t, logger.Enabled(DebugLevel))
+	assert.True(t, logger.Enabled(InfoLevel))
+	logger.Debug("suppressed")
+	logger.Debugf("suppressed %d", 1)
+	assert.Equal(t, 0, built)
+	logger.Info("emitted")
+	assert.Equal(t, 1, built)
+
+	// handler levels are read on every call
+	info.SetLevel(DebugLevel)
+	assert.True(t, logger.Enabled(DebugLevel))
+
+	// logger level
+	logger.SetLevel(WarnLevel)
+	assert.False(t, logger.Enabled(InfoLevel))
+
+	// handlers without a level accept all levels
+	logger = NewLogger(OptionHandlers(&recordHandler{}))
+	assert.True(t, logger.Enabled(DebugLevel))
+	assert.False(t, NewLogger().Enabled(ErrorLevel))
+}
+
+// recordHandler keeps all emitted records
+type recordHandler struct {
+	NullHandler
+	mu      sync.Mutex
+	records []*LogRecord
+}
+
+func (hdlr *recordHandler) ShouldEmit(*LogRecord) bool {
+	return true
+}
+
+func (hdlr *recordHandler) Emit(record *LogRecord) {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	hdlr.records = append(hdlr.records, record)
+}
+
+// stubExit replaces osExit, code is -1 if exit is not called
+func stubExit() (code *int, restore func()) {
+	code = new(int)
+	*code = -1
+	osExit = func(c int) {
+		*code = c
+	}
+	return code, func() {
+		osExit = os.Exit
+	}
+}
+
+// wrappedInfo is a helper wrapping logger
+func wrappedInfo(logger *Logger, msg string) {
+	logger.Info(msg)
+}
+
+func TestLoggerFatal(t *testing.T) {
+	hdlr := &recordHandler{}
+	counter := &closeCounter{}
+	logger := GetLogger("fatal", OptionHandlers(hdlr, counter))
+
+	code, restore := stubExit()
+	defer restore()
+	logger.Fatalf("exit %d", 1)
+
+	assert.Equal(t, 1, *code)
+	assert.Len(t, hdlr.records, 1)
+	assert.Equal(t, FatalLevel, hdlr.records[0].Level)
+	// flushed and closed before exiting
+	assert.Equal(t, 1, counter.flushed)
+	assert.Equal(t, 1, counter.closed)
+}
+
+// recoverPanic returns the value f panics with
+func recoverPanic(f func()) (v interface{}) {
+	defer func() {
+		v = recover()
+	}()
+	f()
+	return nil
+}
+
+func TestLoggerPanic(t *testing.T) {
+	hdlr := &recordHandler{}
+	counter := &closeCounter{}
+	logger := NewLogger(OptionHandlers(hdlr, counter))
+
+	assert.Equal(t, "something wrong: disk full", recoverPanic(func() {
+		logger.Panicf("something wrong: %s", "disk full", Fields{"x": 1})
+	}))
+	assert.Equal(t, "something wrong", recoverPanic(func() {
+		logger.Panic("something", "wrong")
+	}))
+	assert.Len(t, hdlr.records, 2)
+	assert.Equal(t, 2, counter.flushed)
+	assert.Equal(t, 0, counter.closed)
+}
+
+// rejectHandler rejects all records by ShouldEmit, but keeps them if
+// Emit is called anyway
+type rejectHandler struct {
+	recordHandler
+}
+
+func (hdlr *rejectHandler) ShouldEmit(*LogRecord) bool {
+	return false
+}
+
+func TestLoggerShouldEmit(t *testing.T) {
+	rejected := &rejectHandler{}
+	accepted := &recordHandler{}
+	logger := NewLogger(OptionHandlers(rejected, accepted))
+	logger.Info("checked")
+	assert.Len(t, rejected.records, 0)
+	assert.Len(t, accepted.records, 1)
+
+	// stateful filters are run once per record
+	var buf bytes.Buffer
+	hdlr := NewWriterHandler("", &buf, &TextFormatter{Fmt: "%(message)"}, NothingLevel)
+	hdlr.AddFilters(NewDedupFilter(time.Minute))
+	logger = NewLogger(OptionHandlers(hdlr))
+	logger.Info("first")
+	logger.Info("first")
+	logger.Info("second")
+	assert.Equal(t, "first\nsecond\n", buf.String())
+}
+
+func TestLoggerWith(t *testing.T) {
+	hdlr := &recordHandler{}
+	base := NewLogger(OptionHandlers(hdlr), OptionName("base"))
+
+	reqLog := base.With("req_id", 1, 2, "two", "dangling")
+	assert.Equal(t, Fields{"req_id": 1, "2": "two", "dangling": nil}, reqLog.Fields())
+	assert.Equal(t, "base", reqLog.Name)
+	userLog := reqLog.WithFields(Fields{"user": "jim", "req_id": 2})
+	assert.Equal(t, Fields{"req_id": 2, "2": "two", "dangling": nil, "user": "jim"}, userLog.Fields())
+	// base loggers are not modified
+	assert.Empty(t, base.Fields())
+	assert.Equal(t, 1, reqLog.Fields()["req_id"])
+
+	userLog.Info("login", Fields{"user": "zoumo"})
+	base.Info("base")
+	assert.Len(t, hdlr.records, 2)
+	assert.Equal(t, Fields{"req_id": 2, "2": "two", "dangling": nil, "user": "zoumo"}, hdlr.records[0].Fields)
+	assert.Nil(t, hdlr.records[1].Fields)
+
+	// the derived logger does not share level and new handlers with base
+	reqLog.SetLevel(ErrorLevel)
+	assert.Equal(t, NothingLevel, base.GetLevel())
+	reqLog.AddHandlers(NewNullHandler())
+	assert.Len(t, base.Handlers, 1)
+}
+
+func TestLoggerWithTime(t *testing.T) {
+	var out lockedBuffer
+	inner := NewWriterHandler("", &out, &TextFormatter{Fmt: "%(time) %(message)", DateFmt: "%Y-%m-%dT%H:%M"}, NothingLevel)
+	hdlr := NewAsyncHandler(inner, 0)
+	base := NewLogger(OptionHandlers(hdlr))
+
+	event := time.Date(2024, 5, 1, 12, 30, 0, 0, time.Local)
+	eventLog := base.WithTime(event).With("k", "v")
+	eventLog.Info("event")
+	base.Info("now")
+	// the async handler keeps the time of queued records
+	assert.Nil(t, hdlr.Close())
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	assert.Len(t, lines, 2)
+	assert.Equal(t, "2024-05-01T12:30 event | k=v", lines[0])
+	assert.NotEqual(t, "2024-05-01T12:30 now", lines[1])
+	assert.Equal(t, Fields{"k": "v"}, eventLog.Fields())
+}
+
+func TestLoggerWithConcurrent(t *testing.T) {
+	base := NewLogger(OptionHandlers(NewNullHandler())).With("base", true)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lg := base.With("i", i)
+			lg.Info("derived")
+			assert.Equal(t, Fields{"base": true, "i": i}, lg.Fields())
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, Fields{"base": true}, base.Fields())
+}
+
+func TestLoggerAddHook(t *testing.T) {
+	hdlr := &recordHandler{}
+	var errs []error
+	lg := NewLogger(OptionHandlers(hdlr), OptionName("hooked"))
+	lg.OnError = func(err error) { errs = append(errs, err) }
+
+	var order []int
+	lg.AddHook(func(record *LogRecord) {
+		order = append(order, 1)
+		record.Name = "normalized"
+	})
+	lg.AddHook(func(record *LogRecord) {
+		order = append(order, 2)
+		panic("boom")
+	})
+	lg.AddHook(func(record *LogRecord) {
+		order = append(order, 3)
+		if record.Fields == nil {
+			record.Fields = Fields{}
+		}
+		record.Fields["env"] = "prod"
+	})
+
+	lg.Info("hello")
+	assert.Equal(t, []int{1, 2, 3}, order)
+	assert.Len(t, hdlr.records, 1)
+	assert.Equal(t, "normalized", hdlr.records[0].Name)
+	assert.Equal(t, Fields{"env": "prod"}, hdlr.records[0].Fields)
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "boom")
+
+	// derived loggers share hooks, but hooks added to them are not shared
+	derived := lg.With("k", "v")
+	derived.AddHook(func(record *LogRecord) { order = append(order, 4) })
+	order = nil
+	lg.Info("base")
+	assert.Equal(t, []int{1, 2, 3}, order)
+	order = nil
+	derived.Info("derived")
+	assert.Equal(t, []int{1, 2, 3, 4}, order)
+
+	// filtered records do not run hooks
+	lg.SetLevel(ErrorLevel)
+	order = nil
+	lg.Info("filtered")
+	assert.Nil(t, order)
+}
+
+func TestLoggerAddHookConcurrent(t *testing.T) {
+	lg := NewLogger(OptionHandlers(NewNullHandler()))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lg.AddHook(func(*LogRecord) {})
+			lg.Info("hooked")
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, lg.loadHooks(), 8)
+}
+
+func TestLoggerCallerSkip(t *testing.T) {
+	hdlr := &recordHandler{}
+	logger := NewLogger(OptionHandlers(hdlr))
+
+	wrappedInfo(logger, "without skip")
+	assert.Equal(t, "wrappedInfo", hdlr.records[0].ShortFuncName)
+
+	logger.ApplyOptions(OptionCallerSkip(1))
+	wrappedInfo(logger, "with skip")
+	assert.Equal(t, "TestLoggerCallerSkip", hdlr.records[1].ShortFuncName)
+	assert.Equal(t, "github.com/zoumo/logdog.TestLoggerCallerSkip", hdlr.records[1].FullFuncName)
+}
+
+func TestLoggerComponentField(t *testing.T) {
+	hdlr := &recordHandler{}
+	logger := NewLogger(OptionHandlers(hdlr))
+	logger.Info("untagged")
+	assert.Empty(t, hdlr.records[0].Fields)
+
+	logger.ApplyOptions(OptionComponentField(DefaultComponentField))
+	for i := 0; i < 2; i++ {
+		logger.Info("tagged")
+	}
+	logger.Info("overridden", Fields{"component": "custom"})
+	assert.Equal(t, "github.com/zoumo/logdog", hdlr.records[1].Fields["component"])
+	assert.Equal(t, "github.com/zoumo/logdog", hdlr.records[2].Fields["component"])
+	assert.Equal(t, "custom", hdlr.records[3].Fields["component"])
+
+	cases := [][2]string{
+		{"github.com/acme/app/server.(*Server).Serve", "github.com/acme/app/server"},
+		{"github.com/acme/app.v2/server.Serve.func1", "github.com/acme/app.v2/server"},
+		{"main.main", "main"},
+		{"github.com/acme/app.Map[github.com/acme/x.T]", "github.com/acme/app"},
+	}
+	for i, c := range cases {
+		// fake pcs which are never used by real callers
+		assert.Equal(t, c[1], packageOf(uintptr(i+1), c[0]))
+	}
+}
+
+func TestLoggerGlobalFields(t *testing.T) {
+	global := map[string]interface{}{"service": "api", "region": "us"}
+	SetGlobalFields(global)
+	defer SetGlobalFields(nil)
+	// the map is copied
+	global["service"] = "modified"
+	assert.Equal(t, Fields{"service": "api", "region": "us"}, GlobalFields())
+
+	hdlr := &recordHandler{}
+	logger := NewLogger(OptionHandlers(hdlr)).With("region", "eu")
+	logger.Info("global")
+	logger.Info("overridden", Fields{"service": "worker"})
+	PrintLogger(hdlr, InfoLevel).Print("printed")
+
+	assert.Equal(t, Fields{"service": "api", "region": "eu"}, hdlr.records[0].Fields)
+	assert.Equal(t, Fields{"service": "worker", "region": "eu"}, hdlr.records[1].Fields)
+	assert.Equal(t, Fields{"service": "api", "region": "us"}, hdlr.records[2].Fields)
+
+	SetGlobalFields(nil)
+	NewLogger(OptionHandlers(hdlr)).Info("cleared")
+	assert.Nil(t, hdlr.records[3].Fields)
+}
+
+func TestLoggerFieldValidator(t *testing.T) {
+	hdlr := &recordHandler{}
+	var errs []error
+	logger := NewLogger(OptionHandlers(hdlr))
+	logger.OnError = func(err error) { errs = append(errs, err) }
+	logger.AddFieldValidator(func(key string, val interface{}) (interface{}, error) {
+		if key != "status" {
+			return val, nil
+		}
+		switch val {
+		case "ok", "failed":
+			return val, nil
+		}
+		return nil, fmt.Errorf("unknown status %v", val)
+	})
+	logger.AddFieldValidator(func(key string, val interface{}) (interface{}, error) {
+		if key != "latency_ms" {
+			return val, nil
+		}
+		if s, ok := val.(string); ok {
+			return strconv.Atoi(s)
+		}
+		return val, nil
+	})
+
+	fields := Fields{"status": "ok", "latency_ms": "12"}
+	logger.Info("coerced", fields)
+	assert.Equal(t, Fields{"status": "ok", "latency_ms": 12}, hdlr.records[0].Fields)
+	// fields of caller are not modified
+	assert.Equal(t, "12", fields["latency_ms"])
+	assert.Empty(t, errs)
+
+	logger.Info("kept", Fields{"status": "unknown"})
+	assert.Equal(t, "unknown", hdlr.records[1].Fields["status"])
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "invalid field status, [unknown status unknown]", errs[0].Error())
+
+	logger.DropInvalidFields = true
+	derived := logger.WithFields(Fields{"status": "bad", "user": "jim"})
+	assert.Equal(t, Fields{"user": "jim"}, derived.Fields())
+	derived.Info("dropped", Fields{"latency_ms": "slow"})
+	assert.Equal(t, Fields{"user": "jim"}, hdlr.records[2].Fields)
+	assert.Len(t, errs, 3)
+}
+
 func TestJsonLogger(t *testing.T) {
 	logger := GetLogger("json").AddHandlers(
 		NewStreamHandler(NewJSONFormatter(), OptionDiscardOutput()),
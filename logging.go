@@ -14,7 +14,10 @@
 
 package logdog
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+)
 
 const (
 	// RootLoggerName is the name of root logger
@@ -23,105 +26,185 @@ const (
 
 var (
 	mu = sync.Mutex{}
-	// set default logger
-	root = GetLogger(RootLoggerName, OptionHandlers(NewStreamHandler()))
+	// defaultLogger stores the *Logger used by package-level functions,
+	// it is initialized on first use
+	defaultLogger atomic.Value
+	defaultOnce   sync.Once
 )
 
-// AddHandlers is an alias of root.AddHandler
+// recordName returns the logger name of records, records of unnamed
+// loggers are named RootLoggerName, so filters can rely on it
+func recordName(name string) string {
+	if name == "" {
+		return RootLoggerName
+	}
+	return name
+}
+
+// newRootLogger returns the registered root logger, it is created
+// with a StreamHandler writing to stderr at INFO level if not exists
+func newRootLogger() *Logger {
+	return GetLogger(RootLoggerName, OptionHandlers(NewStreamHandler()), InfoLevel)
+}
+
+// Default returns the default logger used by package-level functions,
+// it is the root logger unless SetDefault is called. The root logger
+// writes to stderr at INFO level with colors if stderr is a terminal,
+// it is created on first use
+func Default() *Logger {
+	defaultOnce.Do(func() {
+		defaultLogger.Store(newRootLogger())
+	})
+	return defaultLogger.Load().(*Logger)
+}
+
+// loadedDefault returns the default logger,
+// or nil if it is not initialized yet
+func loadedDefault() *Logger {
+	lg, _ := defaultLogger.Load().(*Logger)
+	return lg
+}
+
+// SetDefault replaces the default logger, so package-level functions,
+// e.g. those used by libraries, log to the application's configured
+// logger. nil restores the root logger
+func SetDefault(lg *Logger) {
+	// skip the lazy initialization
+	defaultOnce.Do(func() {})
+	if lg == nil {
+		lg = newRootLogger()
+	}
+	defaultLogger.Store(lg)
+}
+
+// AddHandlers is an alias of Default().AddHandler
 func AddHandlers(handlers ...Handler) *Logger {
-	root.AddHandlers(handlers...)
-	return root
+	lg := Default()
+	lg.AddHandlers(handlers...)
+	return lg
+}
+
+// AddHook is an alias of Default().AddHook
+func AddHook(hook func(*LogRecord)) *Logger {
+	lg := Default()
+	lg.AddHook(hook)
+	return lg
 }
 
-// ApplyOptions is an alias of root.ApplyOptions
+// ApplyOptions is an alias of Default().ApplyOptions
 func ApplyOptions(options ...Option) *Logger {
-	root.ApplyOptions(options...)
-	return root
+	lg := Default()
+	lg.ApplyOptions(options...)
+	return lg
+}
+
+// With is an alias of Default().With
+func With(keyvals ...interface{}) *Logger {
+	return Default().With(keyvals...)
+}
+
+// WithFields is an alias of Default().WithFields
+func WithFields(fields Fields) *Logger {
+	return Default().WithFields(fields)
 }
 
 // Flush ...
 func Flush() error {
-	return root.Flush()
+	return Default().Flush()
 }
 
-// Debugf is an alias of root.Debugf
+// Debugf is an alias of Default().Debugf
 func Debugf(msg string, args ...interface{}) {
-	root.log(DebugLevel, msg, args...)
+	Default().log(DebugLevel, msg, args...)
 }
 
-// Infof is an alias of root.Infof
+// Infof is an alias of Default().Infof
 func Infof(msg string, args ...interface{}) {
-	root.log(InfoLevel, msg, args...)
+	Default().log(InfoLevel, msg, args...)
 }
 
-// Warningf is an alias of root.Warningf
+// Warningf is an alias of Default().Warningf
 func Warningf(msg string, args ...interface{}) {
-	root.log(WarnLevel, msg, args...)
+	Default().log(WarnLevel, msg, args...)
 }
 
-// Warnf is an alias of root.Warnf
+// Warnf is an alias of Default().Warnf
 func Warnf(msg string, args ...interface{}) {
-	root.log(WarnLevel, msg, args...)
+	Default().log(WarnLevel, msg, args...)
 }
 
-// Errorf is an alias of root.Errorf
+// Errorf is an alias of Default().Errorf
 func Errorf(msg string, args ...interface{}) {
-	root.log(ErrorLevel, msg, args...)
+	Default().log(ErrorLevel, msg, args...)
+}
+
+// Criticalf emits log with FATAL level and format string like
+// Default().Fatalf, but it neither exits nor panics
+func Criticalf(msg string, args ...interface{}) {
+	Default().log(FatalLevel, msg, args...)
 }
 
-// Noticef is an alias of root.Noticef
+// Noticef is an alias of Default().Noticef
 func Noticef(msg string, args ...interface{}) {
-	root.log(NoticeLevel, msg, args...)
+	Default().log(NoticeLevel, msg, args...)
 }
 
-// Fatalf is an alias of root.Criticalf
+// Fatalf is an alias of Default().Fatalf
 func Fatalf(msg string, args ...interface{}) {
-	root.log(FatalLevel, msg, args...)
+	lg := Default()
+	lg.log(FatalLevel, msg, args...)
+	exit(lg, 1)
 }
 
-// Panicf is an alias of root.Panicf
+// Panicf is an alias of Default().Panicf
 func Panicf(msg string, args ...interface{}) {
-	root.log(FatalLevel, msg, args...)
-	panic("CRITICAL")
+	lg := Default()
+	record := lg.log(FatalLevel, msg, args...)
+	lg.Flush()
+	panic(record.GetMessage())
 }
 
-// Debug is an alias of root.Debug
+// Debug is an alias of Default().Debug
 func Debug(args ...interface{}) {
-	root.log(DebugLevel, "", args...)
+	Default().log(DebugLevel, "", args...)
 }
 
-// Info is an alias of root.Info
+// Info is an alias of Default().Info
 func Info(args ...interface{}) {
-	root.log(InfoLevel, "", args...)
+	Default().log(InfoLevel, "", args...)
 }
 
-// Warning is an alias of root.Warning
+// Warning is an alias of Default().Warning
 func Warning(args ...interface{}) {
-	root.log(WarnLevel, "", args...)
+	Default().log(WarnLevel, "", args...)
 }
 
-// Warn is an alias of root.Warn
+// Warn is an alias of Default().Warn
 func Warn(args ...interface{}) {
-	root.log(WarnLevel, "", args...)
+	Default().log(WarnLevel, "", args...)
 }
 
-// Error is an alias of root.Error
+// Error is an alias of Default().Error
 func Error(args ...interface{}) {
-	root.log(ErrorLevel, "", args...)
+	Default().log(ErrorLevel, "", args...)
 }
 
-// Notice is an alias of root.Notice
+// Notice is an alias of Default().Notice
 func Notice(args ...interface{}) {
-	root.log(NoticeLevel, "", args...)
+	Default().log(NoticeLevel, "", args...)
 }
 
-// Fatal is an alias of root.Critical
+// Fatal is an alias of Default().Fatal
 func Fatal(args ...interface{}) {
-	root.log(FatalLevel, "", args...)
+	lg := Default()
+	lg.log(FatalLevel, "", args...)
+	exit(lg, 1)
 }
 
-// Panic an alias of root.Panic
+// Panic is an alias of Default().Panic
 func Panic(msg string, args ...interface{}) {
-	root.log(FatalLevel, "", args...)
-	panic("CRITICAL")
+	lg := Default()
+	record := lg.log(FatalLevel, "", append([]interface{}{msg}, args...)...)
+	lg.Flush()
+	panic(record.GetMessage())
 }
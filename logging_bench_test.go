@@ -13,7 +13,11 @@
 // limitations under the License.
 package logdog
 
-import "testing"
+import (
+	"os"
+	"strings"
+	"testing"
+)
 
 func createLogger() *Logger {
 	return NewLogger(
@@ -42,3 +46,71 @@ func BenchmarkLogWithFields(b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkLogDisabled(b *testing.B) {
+	logger := NewLogger(
+		OptionHandlers(
+			NewStreamHandler(OptionDiscardOutput(), InfoLevel),
+		),
+	)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			logger.Debug("test", smallFields)
+		}
+	})
+}
+
+// lazyFields costs nothing when the level is disabled
+var lazyFields = Fields{"plan": LazyValue(func() interface{} {
+	return strings.Repeat("plan", 1024)
+})}
+
+func BenchmarkLogDisabledLazy(b *testing.B) {
+	logger := NewLogger(
+		OptionHandlers(
+			NewStreamHandler(OptionDiscardOutput(), InfoLevel),
+		),
+	)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.Debug("test", lazyFields)
+	}
+}
+
+func BenchmarkStreamHandler(b *testing.B) {
+	hdlr := NewStreamHandler(OptionDiscardOutput(), DefaultFormatter)
+	record := NewLogRecord("bench", InfoLevel, "pkg/file.go", "func", 10, "", "test")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hdlr.Emit(record)
+	}
+}
+
+func BenchmarkFileHandler(b *testing.B) {
+	hdlr := NewFileHandler(DefaultFormatter).SetPath(os.DevNull)
+	defer hdlr.Close()
+	record := NewLogRecord("bench", InfoLevel, "pkg/file.go", "func", 10, "", "test")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hdlr.Emit(record)
+	}
+}
+
+func BenchmarkJSONFormat(b *testing.B) {
+	formatter := NewJSONFormatter()
+	record := NewLogRecord("bench", InfoLevel, "pkg/file.go", "func", 10, "", "test", smallFields)
+	buf := make([]byte, 0, 1024)
+	var err error
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf, err = formatter.AppendFormat(buf[:0], record)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefault(t *testing.T) {
+	root := Default()
+	assert.Equal(t, RootLoggerName, root.Name)
+	assert.Equal(t, InfoLevel, root.GetLevel())
+	assert.Equal(t, root, GetLogger(RootLoggerName))
+
+	hdlr := &recordHandler{}
+	SetDefault(NewLogger(OptionHandlers(hdlr), OptionName("app")))
+	defer SetDefault(nil)
+
+	Infof("hello %s", "world")
+	Criticalf("critical")
+	assert.Len(t, hdlr.records, 2)
+	assert.Equal(t, "app", hdlr.records[0].Name)
+	assert.Equal(t, "hello world", hdlr.records[0].GetMessage())
+	// the caller of package-level functions is reported
+	assert.Equal(t, "logging_test.go", hdlr.records[0].FileName)
+	assert.Equal(t, "TestDefault", hdlr.records[0].ShortFuncName)
+	assert.Equal(t, FatalLevel, hdlr.records[1].Level)
+
+	SetDefault(nil)
+	assert.Equal(t, root, Default())
+}
+
+func TestDefaultConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	lgs := make([]*Logger, 8)
+	for i := range lgs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lgs[i] = Default()
+		}(i)
+	}
+	wg.Wait()
+	for _, lg := range lgs {
+		assert.Equal(t, lgs[0], lg)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy decides what MemoryHandler does
+// when its buffer is full
+type OverflowPolicy int
+
+const (
+	// OverflowFlush flushes the buffer to target, then buffers the record,
+	// like MemoryHandler in python logging
+	OverflowFlush OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest buffered record,
+	// so the most recent records are kept, i.e. a ring buffer
+	OverflowDropOldest
+	// OverflowDropNewest drops the incoming record
+	OverflowDropNewest
+)
+
+// DefaultMemoryCapacity is the default number of records
+// buffered by MemoryHandler
+const DefaultMemoryCapacity = 1024
+
+// MemoryHandler is a handler which buffers records in memory and emits
+// them to Target when a record >= FlushLevel is buffered, or on Flush
+// and Close. When the buffer reaches Capacity, the record is handled by
+// the Overflow policy, e.g. OverflowDropOldest keeps the most recent
+// context of a request which logs too much before erroring.
+// A record >= FlushLevel is never dropped
+type MemoryHandler struct {
+	Name       string
+	Target     Handler
+	Capacity   int
+	FlushLevel Level
+	Overflow   OverflowPolicy
+
+	mu sync.Mutex
+	// ring of buffered records, the oldest one is at head
+	ring    []*LogRecord
+	head    int
+	size    int
+	dropped uint64
+}
+
+// NewMemoryHandler returns a new MemoryHandler buffering at most capacity
+// records for target, capacity <= 0 means DefaultMemoryCapacity
+func NewMemoryHandler(target Handler, capacity int, flushLevel Level, overflow OverflowPolicy, options ...Option) *MemoryHandler {
+	if capacity <= 0 {
+		capacity = DefaultMemoryCapacity
+	}
+	hdlr := &MemoryHandler{
+		Name:       "",
+		Target:     target,
+		Capacity:   capacity,
+		FlushLevel: flushLevel,
+		Overflow:   overflow,
+	}
+
+	applyOptions(hdlr, options...)
+
+	hdlr.ring = make([]*LogRecord, hdlr.Capacity)
+	return hdlr
+}
+
+// Emit buffers the record, and flushes the buffer
+// if the record level >= FlushLevel
+func (hdlr *MemoryHandler) Emit(record *LogRecord) {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+
+	if hdlr.size == len(hdlr.ring) {
+		switch {
+		case record.Level >= hdlr.FlushLevel || hdlr.Overflow == OverflowFlush:
+			// the record triggering a flush is never dropped
+			hdlr.flush()
+		case hdlr.Overflow == OverflowDropOldest:
+			hdlr.ring[hdlr.head] = nil
+			hdlr.head = (hdlr.head + 1) % len(hdlr.ring)
+			hdlr.size--
+			atomic.AddUint64(&hdlr.dropped, 1)
+		default:
+			atomic.AddUint64(&hdlr.dropped, 1)
+			return
+		}
+	}
+
+	hdlr.ring[(hdlr.head+hdlr.size)%len(hdlr.ring)] = record
+	hdlr.size++
+
+	if record.Level >= hdlr.FlushLevel {
+		hdlr.flush()
+	}
+}
+
+// flush emits all buffered records to Target in order,
+// it must be called with mu held
+func (hdlr *MemoryHandler) flush() {
+	for i := 0; i < hdlr.size; i++ {
+		j := (hdlr.head + i) % len(hdlr.ring)
+		hdlr.Target.Emit(hdlr.ring[j])
+		hdlr.ring[j] = nil
+	}
+	hdlr.head, hdlr.size = 0, 0
+}
+
+// Len returns the number of buffered records
+func (hdlr *MemoryHandler) Len() int {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	return hdlr.size
+}
+
+// Snapshot returns clones of the buffered records from the oldest one,
+// taken at one point in time, so it is safe to read them while records
+// are emitted, e.g. in a debug endpoint
+func (hdlr *MemoryHandler) Snapshot() []*LogRecord {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	records := make([]*LogRecord, hdlr.size)
+	for i := range records {
+		records[i] = hdlr.ring[(hdlr.head+i)%len(hdlr.ring)].Clone()
+	}
+	return records
+}
+
+// SnapshotFormatted returns the records of Snapshot formatted by formatter,
+// records failed to be formatted are skipped
+func (hdlr *MemoryHandler) SnapshotFormatted(formatter Formatter) []string {
+	records := hdlr.Snapshot()
+	lines := make([]string, 0, len(records))
+	for _, record := range records {
+		line, err := formatter.Format(record)
+		if err != nil {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Dropped returns the number of records dropped by the Overflow policy
+func (hdlr *MemoryHandler) Dropped() uint64 {
+	return atomic.LoadUint64(&hdlr.dropped)
+}
+
+// ShouldEmit checks if Target should emit the specified record
+func (hdlr *MemoryHandler) ShouldEmit(record *LogRecord) bool {
+	return ShouldEmit(hdlr.Target, record)
+}
+
+// Filter checks if handler should filter the specified record.
+// Deprecated: use ShouldEmit instead
+func (hdlr *MemoryHandler) Filter(record *LogRecord) bool {
+	return !hdlr.ShouldEmit(record)
+}
+
+// Flush emits all buffered records to Target and flushes it
+func (hdlr *MemoryHandler) Flush() error {
+	hdlr.mu.Lock()
+	hdlr.flush()
+	hdlr.mu.Unlock()
+	return hdlr.Target.Flush()
+}
+
+// Close emits all buffered records to Target and closes it
+func (hdlr *MemoryHandler) Close() error {
+	hdlr.mu.Lock()
+	hdlr.flush()
+	hdlr.mu.Unlock()
+	return hdlr.Target.Close()
+}
@@ -0,0 +1,102 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func memoryMessages(hdlr *recordHandler) []string {
+	msgs := make([]string, len(hdlr.records))
+	for i, r := range hdlr.records {
+		msgs[i] = r.GetMessage()
+	}
+	return msgs
+}
+
+func TestMemoryHandlerFlushLevel(t *testing.T) {
+	target := &recordHandler{}
+	hdlr := NewMemoryHandler(target, 10, ErrorLevel, OverflowFlush)
+	logger := NewLogger(OptionHandlers(hdlr))
+
+	logger.Info("a")
+	logger.Debug("b")
+	assert.Empty(t, target.records)
+	assert.Equal(t, 2, hdlr.Len())
+
+	logger.Error("c")
+	assert.Equal(t, []string{"a", "b", "c"}, memoryMessages(target))
+	assert.Equal(t, 0, hdlr.Len())
+
+	logger.Info("d")
+	assert.Nil(t, hdlr.Close())
+	assert.Equal(t, []string{"a", "b", "c", "d"}, memoryMessages(target))
+}
+
+func TestMemoryHandlerOverflow(t *testing.T) {
+	cases := []struct {
+		policy  OverflowPolicy
+		before  []string
+		after   []string
+		dropped uint64
+	}{
+		{OverflowFlush, []string{"0", "1", "2"}, []string{"0", "1", "2", "3", "4", "err"}, 0},
+		{OverflowDropOldest, nil, []string{"2", "3", "4", "err"}, 2},
+		{OverflowDropNewest, nil, []string{"0", "1", "2", "err"}, 2},
+	}
+	for _, c := range cases {
+		target := &recordHandler{}
+		hdlr := NewMemoryHandler(target, 3, ErrorLevel, c.policy)
+		logger := NewLogger(OptionHandlers(hdlr))
+		for _, msg := range []string{"0", "1", "2", "3", "4"} {
+			logger.Info(msg)
+		}
+		assert.Equal(t, len(c.before), len(target.records), "policy %d", c.policy)
+		logger.Error("err")
+		assert.Equal(t, c.after, memoryMessages(target), "policy %d", c.policy)
+		assert.Equal(t, c.dropped, hdlr.Dropped(), "policy %d", c.policy)
+	}
+}
+
+func TestMemoryHandlerSnapshot(t *testing.T) {
+	hdlr := NewMemoryHandler(&recordHandler{}, 3, ErrorLevel, OverflowDropOldest)
+	logger := NewLogger(OptionHandlers(hdlr))
+	for _, msg := range []string{"0", "1", "2", "3"} {
+		logger.Info(msg, Fields{"k": msg})
+	}
+
+	records := hdlr.Snapshot()
+	assert.Len(t, records, 3)
+	assert.Equal(t, "1", records[0].GetMessage())
+	// snapshots are not shared with the buffer
+	records[0].Fields["k"] = "changed"
+	assert.Equal(t, Fields{"k": "1"}, hdlr.Snapshot()[0].Fields)
+	assert.Equal(t, []string{"1 | k=1", "2 | k=2", "3 | k=3"}, hdlr.SnapshotFormatted(&TextFormatter{Fmt: "%(message)"}))
+
+	// snapshots never race with emits
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			logger.Info("concurrent")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		assert.Len(t, hdlr.Snapshot(), 3)
+	}
+	<-done
+}
@@ -19,6 +19,7 @@ import (
 	"io"
 	"os"
 	"reflect"
+	"time"
 )
 
 // Option is an interface which is used to set options for the target
@@ -26,15 +27,49 @@ type Option interface {
 	applyOption(target interface{}) bool
 }
 
+// UnsupportedOptionError is returned when options are not supported by
+// the target, e.g. OptionFileMode passed to a StreamHandler, Index holds
+// their positions in the options, the other options are still applied
+type UnsupportedOptionError struct {
+	Target string
+	Index  []int
+}
+
+func (e *UnsupportedOptionError) Error() string {
+	return fmt.Sprintf("target[%s] does not support options at %v", e.Target, e.Index)
+}
+
+// ApplyOptionsTo applys all options to the target,
+// target should be a pointer to struct, e.g. a handler
+// implemented outside this package.
+// It returns an *UnsupportedOptionError if any option is not
+// supported by the target, constructors which can not return
+// it should report it by InternalError
+func ApplyOptionsTo(target interface{}, options ...Option) error {
+	var unsupported []int
+	for i, opt := range options {
+		if !opt.applyOption(target) {
+			unsupported = append(unsupported, i)
+		}
+	}
+	if len(unsupported) > 0 {
+		return &UnsupportedOptionError{Target: fmt.Sprintf("%T", target), Index: unsupported}
+	}
+	return nil
+}
+
+// applyOptions is ApplyOptionsTo, the error is reported by InternalError
+func applyOptions(target interface{}, options ...Option) {
+	if err := ApplyOptionsTo(target, options...); err != nil {
+		InternalError(err)
+	}
+}
+
 // optFuncWraper wraps a function so it satisfies the Option interface.
 type optFuncWraper func(interface{}) bool
 
 func (f optFuncWraper) applyOption(target interface{}) bool {
-	ret := f(target)
-	if !ret {
-		fmt.Fprintf(os.Stderr, "target[%T] does not support this option\n", target)
-	}
-	return ret
+	return f(target)
 }
 
 // makes Level satisfies the Option interface.
@@ -66,6 +101,42 @@ func (fh *JSONFormatter) applyOption(target interface{}) bool {
 	return false
 }
 
+func (ef *ECSFormatter) applyOption(target interface{}) bool {
+	v := reflect.ValueOf(target).Elem()
+	if f := v.FieldByName("Formatter"); f.IsValid() {
+		f.Set(reflect.ValueOf(ef))
+		return true
+	}
+	return false
+}
+
+func (df *DevFormatter) applyOption(target interface{}) bool {
+	v := reflect.ValueOf(target).Elem()
+	if f := v.FieldByName("Formatter"); f.IsValid() {
+		f.Set(reflect.ValueOf(df))
+		return true
+	}
+	return false
+}
+
+func (lf *LevelFormatter) applyOption(target interface{}) bool {
+	v := reflect.ValueOf(target).Elem()
+	if f := v.FieldByName("Formatter"); f.IsValid() {
+		f.Set(reflect.ValueOf(lf))
+		return true
+	}
+	return false
+}
+
+func (lf *LogfmtFormatter) applyOption(target interface{}) bool {
+	v := reflect.ValueOf(target).Elem()
+	if f := v.FieldByName("Formatter"); f.IsValid() {
+		f.Set(reflect.ValueOf(lf))
+		return true
+	}
+	return false
+}
+
 // OptionName is an option
 // used in every target which has fields named `Name`
 func OptionName(name string) Option {
@@ -92,6 +163,19 @@ func OptionCallerStackDepth(depth int) Option {
 	})
 }
 
+// OptionCallerSkip is an option.
+// used in every target which has fields named `CallerSkip`
+func OptionCallerSkip(skip int) Option {
+	return optFuncWraper(func(target interface{}) bool {
+		v := reflect.ValueOf(target).Elem()
+		if f := v.FieldByName("CallerSkip"); f.IsValid() {
+			f.SetInt(int64(skip))
+			return true
+		}
+		return false
+	})
+}
+
 // OptionEnableRuntimeCaller is an option useed in :
 // used in every target which has fields named `EnableRuntimeCaller`
 func OptionEnableRuntimeCaller(enable bool) Option {
@@ -147,3 +231,178 @@ func OptionDiscardOutput() Option {
 		return false
 	})
 }
+
+// OptionOwnsWriter is an option
+// used in every target which has fields named `OwnsWriter`
+// and make the target close its output when it is closed.
+func OptionOwnsWriter(owns bool) Option {
+	return optFuncWraper(func(target interface{}) bool {
+		v := reflect.ValueOf(target).Elem()
+		if f := v.FieldByName("OwnsWriter"); f.IsValid() {
+			f.SetBool(owns)
+			return true
+		}
+		return false
+	})
+}
+
+// OptionComponentField is an option
+// used in every target which has fields named `ComponentField`
+// and tags records with the package of the caller by the key.
+func OptionComponentField(key string) Option {
+	return optFuncWraper(func(target interface{}) bool {
+		v := reflect.ValueOf(target).Elem()
+		if f := v.FieldByName("ComponentField"); f.IsValid() {
+			f.SetString(key)
+			return true
+		}
+		return false
+	})
+}
+
+// OptionStackMaxFrames is an option
+// used in every target which has fields named `StackMaxFrames`
+func OptionStackMaxFrames(n int) Option {
+	return optFuncWraper(func(target interface{}) bool {
+		v := reflect.ValueOf(target).Elem()
+		if f := v.FieldByName("StackMaxFrames"); f.IsValid() {
+			f.SetInt(int64(n))
+			return true
+		}
+		return false
+	})
+}
+
+// OptionStackSkipPrefixes is an option
+// used in every target which has fields named `StackSkipPrefixes`,
+// it replaces the prefixes, e.g. append to DefaultStackSkipPrefixes
+// to skip more packages
+func OptionStackSkipPrefixes(prefixes ...string) Option {
+	return optFuncWraper(func(target interface{}) bool {
+		v := reflect.ValueOf(target).Elem()
+		if f := v.FieldByName("StackSkipPrefixes"); f.IsValid() {
+			f.Set(reflect.ValueOf(append([]string(nil), prefixes...)))
+			return true
+		}
+		return false
+	})
+}
+
+// OptionHeaderFunc is an option
+// used in every target which has fields named `HeaderFunc`,
+// e.g. FileHandler, which writes a header by it to every new file
+func OptionHeaderFunc(header func(*os.File) error) Option {
+	return optFuncWraper(func(target interface{}) bool {
+		v := reflect.ValueOf(target).Elem()
+		if f := v.FieldByName("HeaderFunc"); f.IsValid() {
+			f.Set(reflect.ValueOf(header))
+			return true
+		}
+		return false
+	})
+}
+
+// OptionOnOpen is an option
+// used in every target which has fields named `OnOpen`,
+// e.g. WriterHandler, which calls it before the first record
+// is written to every output it opens
+func OptionOnOpen(onOpen func(io.Writer) error) Option {
+	return optFuncWraper(func(target interface{}) bool {
+		v := reflect.ValueOf(target).Elem()
+		if f := v.FieldByName("OnOpen"); f.IsValid() {
+			f.Set(reflect.ValueOf(onOpen))
+			return true
+		}
+		return false
+	})
+}
+
+// OptionSharedRotation is an option
+// used in every target which has fields named `SharedRotation`,
+// e.g. RotatingFileHandler, which locks the file while rotating it
+// so several processes can append to it
+func OptionSharedRotation(shared bool) Option {
+	return optFuncWraper(func(target interface{}) bool {
+		v := reflect.ValueOf(target).Elem()
+		if f := v.FieldByName("SharedRotation"); f.IsValid() {
+			f.SetBool(shared)
+			return true
+		}
+		return false
+	})
+}
+
+// OptionLocation is an option
+// used in every target which has fields named `Location`,
+// e.g. FileHandler and RotatingFileHandler, which resolve time tokens
+// of paths and rotation names in the time zone
+func OptionLocation(loc *time.Location) Option {
+	return optFuncWraper(func(target interface{}) bool {
+		v := reflect.ValueOf(target).Elem()
+		if f := v.FieldByName("Location"); f.IsValid() {
+			f.Set(reflect.ValueOf(loc))
+			return true
+		}
+		return false
+	})
+}
+
+// OptionFileMode is an option
+// used in every target which has fields named `FileMode`,
+// e.g. FileHandler and RotatingFileHandler, which create
+// files with the permissions
+func OptionFileMode(mode os.FileMode) Option {
+	return optFuncWraper(func(target interface{}) bool {
+		v := reflect.ValueOf(target).Elem()
+		if f := v.FieldByName("FileMode"); f.IsValid() {
+			f.Set(reflect.ValueOf(mode))
+			return true
+		}
+		return false
+	})
+}
+
+// OptionBufferSize is an option
+// used in every target which has fields named `BufferSize`,
+// e.g. handlers queueing records for a remote
+func OptionBufferSize(n int) Option {
+	return optFuncWraper(func(target interface{}) bool {
+		v := reflect.ValueOf(target).Elem()
+		if f := v.FieldByName("BufferSize"); f.IsValid() {
+			f.SetInt(int64(n))
+			return true
+		}
+		return false
+	})
+}
+
+// OptionFilters is an option
+// used in every target which has AddFilters, e.g. handlers
+// embedding Filterer, the filters are added before the
+// handler is used, so they never miss a record
+func OptionFilters(filters ...EmitFilter) Option {
+	return optFuncWraper(func(target interface{}) bool {
+		if f, ok := target.(interface {
+			AddFilters(...EmitFilter)
+		}); ok {
+			f.AddFilters(filters...)
+			return true
+		}
+		return false
+	})
+}
+
+// OptionPath is an option
+// used in every target which has string fields named `Path`,
+// e.g. FileHandler, which opens the file once all options
+// are applied, and RotatingFileHandler
+func OptionPath(path string) Option {
+	return optFuncWraper(func(target interface{}) bool {
+		v := reflect.ValueOf(target).Elem()
+		if f := v.FieldByName("Path"); f.IsValid() && f.Kind() == reflect.String {
+			f.SetString(path)
+			return true
+		}
+		return false
+	})
+}
@@ -14,8 +14,14 @@
 
 package logdog
 
-import "testing"
-import "github.com/stretchr/testify/assert"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
 
 func TestOptionsInterface(t *testing.T) {
 	assert.Implements(t, (*Option)(nil), NoticeLevel)
@@ -23,7 +29,103 @@ func TestOptionsInterface(t *testing.T) {
 	assert.Implements(t, (*Option)(nil), NewJSONFormatter())
 	assert.Implements(t, (*Option)(nil), OptionCallerStackDepth(1))
 	assert.Implements(t, (*Option)(nil), OptionEnableRuntimeCaller(true))
+	assert.Implements(t, (*Option)(nil), OptionCallerSkip(1))
 	assert.Implements(t, (*Option)(nil), OptionHandlers())
 	assert.Implements(t, (*Option)(nil), OptionOutput(devNull(0)))
 	assert.Implements(t, (*Option)(nil), OptionDiscardOutput())
+	assert.Implements(t, (*Option)(nil), OptionOwnsWriter(true))
+	assert.Implements(t, (*Option)(nil), OptionFileMode(0600))
+	assert.Implements(t, (*Option)(nil), OptionBufferSize(1))
+	assert.Implements(t, (*Option)(nil), OptionFilters())
+	assert.Implements(t, (*Option)(nil), OptionPath("app.log"))
+}
+
+func TestApplyOptionsToUnsupported(t *testing.T) {
+	hdlr := &WriterHandler{}
+	err := ApplyOptionsTo(hdlr, InfoLevel, OptionFileMode(0600), OptionBufferSize(1))
+	assert.Equal(t, &UnsupportedOptionError{Target: "*logdog.WriterHandler", Index: []int{1, 2}}, err)
+	// the supported options are still applied
+	assert.Equal(t, InfoLevel, hdlr.Level)
+
+	var errs []error
+	SetInternalErrorHandler(func(err error) {
+		errs = append(errs, err)
+	})
+	defer SetInternalErrorHandler(nil)
+	NewStreamHandler(OptionDiscardOutput(), OptionFileMode(0600))
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "does not support options at [1]")
+}
+
+func TestHandlerConstructorOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	hdlr := NewFileHandler(
+		OptionPath(path),
+		WarnLevel,
+		&TextFormatter{Fmt: "%(message)"},
+		OptionFileMode(0600),
+		OptionFilters(FilterFunc(func(record *LogRecord) bool {
+			return record.Msg != "drop"
+		})),
+	)
+	for _, msg := range []string{"keep", "drop"} {
+		record := NewLogRecord(name, ErrorLevel, pathname, fun, line, msg)
+		if ShouldEmit(hdlr, record) {
+			hdlr.Emit(record)
+		}
+	}
+	assert.Nil(t, hdlr.Close())
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "keep\n", string(data))
+}
+
+func TestOptionPathAppliedLast(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// the header is written although OptionHeaderFunc comes later
+	path := filepath.Join(dir, "app.log")
+	hdlr := NewFileHandler(OptionPath(path), OptionHeaderFunc(CommentFileHeader))
+	assert.Nil(t, hdlr.Close())
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.True(t, len(data) > 0)
+
+	// the logger has no Path
+	assert.NotNil(t, ApplyOptionsTo(&Logger{}, OptionPath(path)))
+}
+
+func TestNewAsyncFileHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	hdlr := NewAsyncFileHandler(16,
+		OptionName("async-file"),
+		OptionPath(path),
+		InfoLevel,
+		&TextFormatter{Fmt: "%(message)"},
+	)
+	assert.Equal(t, "async-file", hdlr.Name)
+	assert.IsType(t, &FileHandler{}, hdlr.Handler)
+
+	logger := NewLogger(OptionHandlers(hdlr))
+	logger.Debug("dropped")
+	logger.Info("kept")
+	assert.Nil(t, hdlr.Close())
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "kept\n", string(data))
 }
@@ -0,0 +1,189 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"fmt"
+	"sync"
+)
+
+// DefaultParallelWorkers is the default number of goroutines
+// emitting a record to handlers concurrently
+const DefaultParallelWorkers = 4
+
+// ParallelHandler is a handler which dispatches records to several
+// handlers concurrently, so a slow handler does not delay the others.
+// Emit returns once all Handlers have emitted the record, the calling
+// goroutine emits to the first one, and at most Workers goroutines to
+// the rest. Detached handlers do not delay Emit at all, each of them
+// emits records in its own goroutine, see Detach.
+//
+// Records of one Emit may reach Handlers in any order relative to each
+// other, but every handler still gets records in the order they are
+// emitted. Every handler but the first one gets a clone of the record,
+// so handlers and their filters may modify it. Filtering is left to the
+// handlers, ShouldEmit always returns true.
+// Close waits for in-flight dispatches and drains detached handlers
+type ParallelHandler struct {
+	Name     string
+	Handlers []Handler
+	// Workers is the max number of goroutines emitting to Handlers
+	// besides the calling one, it should be set before use
+	Workers int
+
+	detached []*AsyncHandler
+	sem      chan struct{}
+	once     sync.Once
+	mu       sync.RWMutex
+	closed   bool
+}
+
+// NewParallelHandler returns a new ParallelHandler dispatching to handlers
+func NewParallelHandler(handlers []Handler, options ...Option) *ParallelHandler {
+	hdlr := &ParallelHandler{
+		Name:     "",
+		Handlers: handlers,
+		Workers:  DefaultParallelWorkers,
+	}
+
+	applyOptions(hdlr, options...)
+
+	return hdlr
+}
+
+// Detach adds handlers which do not delay Emit, each of them is wrapped
+// by an AsyncHandler, records are dropped if it can not keep up
+func (hdlr *ParallelHandler) Detach(handlers ...Handler) *ParallelHandler {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	for _, h := range handlers {
+		async := NewAsyncHandler(h, 0)
+		// shed everything rather than blocking Emit
+		async.HighWatermark = cap(async.queue)
+		async.LowWatermark = cap(async.queue) / 2
+		async.ShedLevel = AllLevel
+		hdlr.detached = append(hdlr.detached, async)
+	}
+	return hdlr
+}
+
+// Detached returns the AsyncHandlers wrapping detached handlers,
+// e.g. to tune their watermarks or get the number of shed records
+func (hdlr *ParallelHandler) Detached() []*AsyncHandler {
+	hdlr.mu.RLock()
+	defer hdlr.mu.RUnlock()
+	return append([]*AsyncHandler(nil), hdlr.detached...)
+}
+
+// Emit dispatches the record to all handlers
+func (hdlr *ParallelHandler) Emit(record *LogRecord) {
+	hdlr.mu.RLock()
+	defer hdlr.mu.RUnlock()
+	if hdlr.closed {
+		return
+	}
+
+	for _, async := range hdlr.detached {
+		if clone := record.Clone(); ShouldEmit(async, clone) {
+			async.Emit(clone)
+		}
+	}
+
+	if len(hdlr.Handlers) == 0 {
+		return
+	}
+	hdlr.once.Do(func() {
+		workers := hdlr.Workers
+		if workers <= 0 {
+			workers = DefaultParallelWorkers
+		}
+		hdlr.sem = make(chan struct{}, workers)
+	})
+
+	var wg sync.WaitGroup
+	for _, h := range hdlr.Handlers[1:] {
+		wg.Add(1)
+		hdlr.sem <- struct{}{}
+		go func(h Handler, record *LogRecord) {
+			defer func() {
+				<-hdlr.sem
+				wg.Done()
+			}()
+			if ShouldEmit(h, record) {
+				h.Emit(record)
+			}
+		}(h, record.Clone())
+	}
+	if ShouldEmit(hdlr.Handlers[0], record) {
+		hdlr.Handlers[0].Emit(record)
+	}
+	wg.Wait()
+}
+
+// ShouldEmit returns true, filtering is left to the handlers
+func (hdlr *ParallelHandler) ShouldEmit(record *LogRecord) bool {
+	return true
+}
+
+// Filter checks if handler should filter the specified record.
+// Deprecated: use ShouldEmit instead
+func (hdlr *ParallelHandler) Filter(record *LogRecord) bool {
+	return !hdlr.ShouldEmit(record)
+}
+
+// handlers returns all handlers including detached ones
+func (hdlr *ParallelHandler) handlers() []Handler {
+	handlers := make([]Handler, 0, len(hdlr.Handlers)+len(hdlr.detached))
+	handlers = append(handlers, hdlr.Handlers...)
+	for _, async := range hdlr.detached {
+		handlers = append(handlers, async)
+	}
+	return handlers
+}
+
+// Flush flushes all handlers, detached ones are flushed
+// after records queued before are emitted
+func (hdlr *ParallelHandler) Flush() error {
+	hdlr.mu.RLock()
+	defer hdlr.mu.RUnlock()
+	var ret error
+	for _, h := range hdlr.handlers() {
+		if err := h.Flush(); err != nil {
+			InternalError(fmt.Errorf("Flush handler failed, [%v]", err))
+			ret = err
+		}
+	}
+	return ret
+}
+
+// Close waits for in-flight dispatches, then closes all handlers,
+// detached ones are closed after queued records are emitted
+func (hdlr *ParallelHandler) Close() error {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	if hdlr.closed {
+		return nil
+	}
+	hdlr.closed = true
+
+	var ret error
+	for _, h := range hdlr.handlers() {
+		if err := h.Close(); err != nil {
+			InternalError(fmt.Errorf("Close handler failed, [%v]", err))
+			ret = err
+		}
+	}
+	return ret
+}
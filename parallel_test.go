@@ -0,0 +1,72 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// signalHandler closes signal on the first Emit
+type signalHandler struct {
+	recordHandler
+	signal chan struct{}
+}
+
+func (hdlr *signalHandler) Emit(record *LogRecord) {
+	hdlr.recordHandler.Emit(record)
+	if len(hdlr.records) == 1 {
+		close(hdlr.signal)
+	}
+}
+
+func TestParallelHandler(t *testing.T) {
+	// first waits for second, so they must be emitted concurrently
+	first := &gateHandler{open: make(chan struct{})}
+	second := &signalHandler{signal: first.open}
+	slow := &gateHandler{open: make(chan struct{})}
+
+	hdlr := NewParallelHandler([]Handler{first, second}, OptionName("parallel"))
+	hdlr.Workers = 1
+	hdlr.Detach(slow)
+	assert.Equal(t, "parallel", hdlr.Name)
+	assert.Len(t, hdlr.Detached(), 1)
+
+	record := NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "msg")
+	record.Fields = Fields{"a": 1}
+	hdlr.Emit(record)
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "msg2"))
+
+	// Emit returns before the detached handler emits
+	assert.Len(t, first.records, 2)
+	assert.Len(t, second.records, 2)
+	assert.Len(t, slow.records, 0)
+	// the first handler gets the record, the others get clones
+	assert.True(t, first.records[0] == record)
+	assert.False(t, second.records[0] == record)
+	assert.Equal(t, record.Fields, second.records[0].Fields)
+	assert.Equal(t, "msg2", second.records[1].Msg)
+
+	// Close drains the detached handler
+	close(slow.open)
+	assert.Nil(t, hdlr.Close())
+	assert.Len(t, slow.records, 2)
+	assert.Equal(t, "msg", slow.records[0].Msg)
+
+	// records are dropped after closed
+	hdlr.Emit(record)
+	assert.Len(t, first.records, 2)
+}
@@ -1,8 +1,8 @@
 package when
 
 import (
-	"bytes"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -75,84 +75,161 @@ func weekNumber(t *time.Time, char int) int {
 
 // Strftime formats time.Date according to the directives in the given format string. The directives begins with a percent (%) character.
 func Strftime(t *time.Time, f string) string {
-	// var result []string
-	var buf = &bytes.Buffer{}
-	format := []rune(f)
-	add := func(str string) {
-		// result = append(result, str)
-		buf.WriteString(str)
+	return string(AppendStrftime(make([]byte, 0, 2*len(f)), t, f))
+}
+
+// appendInt appends v zero-padded to width
+func appendInt(dst []byte, v int, width int) []byte {
+	if v < 0 {
+		dst = append(dst, '-')
+		v = -v
 	}
-	for i := 0; i < len(format); i++ {
-		switch format[i] {
-		case '%':
-			if i < len(format)-1 {
-				switch format[i+1] {
-				case 'a':
-					add(shortDayNames[t.Weekday()])
-				case 'A':
-					add(longDayNames[t.Weekday()])
-				case 'w':
-					add(fmt.Sprintf("%d", t.Weekday()))
-				case 'd':
-					add(fmt.Sprintf("%02d", t.Day()))
-				case 'b':
-					add(shortMonthNames[t.Month()])
-				case 'B':
-					add(longMonthNames[t.Month()])
-				case 'm':
-					add(fmt.Sprintf("%02d", t.Month()))
-				case 'y':
-					add(fmt.Sprintf("%02d", t.Year()%100))
-				case 'Y':
-					add(fmt.Sprintf("%02d", t.Year()))
-				case 'H':
-					add(fmt.Sprintf("%02d", t.Hour()))
-				case 'I':
-					if t.Hour() == 0 {
-						add(fmt.Sprintf("%02d", 12))
-					} else if t.Hour() > 12 {
-						add(fmt.Sprintf("%02d", t.Hour()-12))
-					} else {
-						add(fmt.Sprintf("%02d", t.Hour()))
-					}
-				case 'p':
-					if t.Hour() < 12 {
-						add("AM")
-					} else {
-						add("PM")
-					}
-				case 'M':
-					add(fmt.Sprintf("%02d", t.Minute()))
-				case 'S':
-					add(fmt.Sprintf("%02d", t.Second()))
-				case 'f':
-					add(fmt.Sprintf("%06d", t.Nanosecond()/1000))
-				case 'z':
-					add(t.Format("-0700"))
-				case 'Z':
-					add(t.Format("MST"))
-				case 'j':
-					add(fmt.Sprintf("%03d", t.YearDay()))
-				case 'U':
-					add(fmt.Sprintf("%02d", weekNumber(t, 'U')))
-				case 'W':
-					add(fmt.Sprintf("%02d", weekNumber(t, 'W')))
-				case 'c':
-					add(t.Format("Mon Jan 2 15:04:05 2006"))
-				case 'x':
-					add(fmt.Sprintf("%02d/%02d/%02d", t.Month(), t.Day(), t.Year()%100))
-				case 'X':
-					add(fmt.Sprintf("%02d:%02d:%02d", t.Hour(), t.Minute(), t.Second()))
-				case '%':
-					add("%")
-				}
-				i++
+	var buf [20]byte
+	i := len(buf)
+	for v >= 10 {
+		i--
+		buf[i] = byte('0' + v%10)
+		v /= 10
+	}
+	i--
+	buf[i] = byte('0' + v)
+	for n := len(buf) - i; n < width; n++ {
+		dst = append(dst, '0')
+	}
+	return append(dst, buf[i:]...)
+}
+
+// AppendStrftime is like Strftime but appends the formatted time to dst,
+// it does not allocate if dst has enough capacity
+func AppendStrftime(dst []byte, t *time.Time, f string) []byte {
+	for i := 0; i < len(f); i++ {
+		if f[i] != '%' {
+			dst = append(dst, f[i])
+			continue
+		}
+		if i == len(f)-1 {
+			break
+		}
+		i++
+		switch f[i] {
+		case 'a':
+			dst = append(dst, shortDayNames[t.Weekday()]...)
+		case 'A':
+			dst = append(dst, longDayNames[t.Weekday()]...)
+		case 'w':
+			dst = appendInt(dst, int(t.Weekday()), 1)
+		case 'd':
+			dst = appendInt(dst, t.Day(), 2)
+		case 'b':
+			dst = append(dst, shortMonthNames[t.Month()]...)
+		case 'B':
+			dst = append(dst, longMonthNames[t.Month()]...)
+		case 'm':
+			dst = appendInt(dst, int(t.Month()), 2)
+		case 'y':
+			dst = appendInt(dst, t.Year()%100, 2)
+		case 'Y':
+			dst = appendInt(dst, t.Year(), 2)
+		case 'H':
+			dst = appendInt(dst, t.Hour(), 2)
+		case 'I':
+			if t.Hour() == 0 {
+				dst = appendInt(dst, 12, 2)
+			} else if t.Hour() > 12 {
+				dst = appendInt(dst, t.Hour()-12, 2)
+			} else {
+				dst = appendInt(dst, t.Hour(), 2)
+			}
+		case 'p':
+			if t.Hour() < 12 {
+				dst = append(dst, "AM"...)
+			} else {
+				dst = append(dst, "PM"...)
 			}
-		default:
-			add(string(format[i]))
+		case 'M':
+			dst = appendInt(dst, t.Minute(), 2)
+		case 'S':
+			dst = appendInt(dst, t.Second(), 2)
+		case 'f':
+			dst = appendInt(dst, t.Nanosecond()/1000, 6)
+		case 'z':
+			dst = t.AppendFormat(dst, "-0700")
+		case 'Z':
+			dst = t.AppendFormat(dst, "MST")
+		case 'j':
+			dst = appendInt(dst, t.YearDay(), 3)
+		case 'U':
+			dst = appendInt(dst, weekNumber(t, 'U'), 2)
+		case 'W':
+			dst = appendInt(dst, weekNumber(t, 'W'), 2)
+		case 'c':
+			dst = t.AppendFormat(dst, "Mon Jan 2 15:04:05 2006")
+		case 'x':
+			dst = appendInt(dst, int(t.Month()), 2)
+			dst = append(dst, '/')
+			dst = appendInt(dst, t.Day(), 2)
+			dst = append(dst, '/')
+			dst = appendInt(dst, t.Year()%100, 2)
+		case 'X':
+			dst = appendInt(dst, t.Hour(), 2)
+			dst = append(dst, ':')
+			dst = appendInt(dst, t.Minute(), 2)
+			dst = append(dst, ':')
+			dst = appendInt(dst, t.Second(), 2)
+		case '%':
+			dst = append(dst, '%')
 		}
 	}
+	return dst
+}
 
-	// return strings.Join(result, "")
-	return buf.String()
+// strftimeLayouts maps directives to layouts of time.Parse
+var strftimeLayouts = map[byte]string{
+	'a': "Mon",
+	'A': "Monday",
+	'd': "02",
+	'b': "Jan",
+	'B': "January",
+	'm': "01",
+	'y': "06",
+	'Y': "2006",
+	'H': "15",
+	'I': "03",
+	'p': "PM",
+	'M': "04",
+	'S': "05",
+	'f': "000000",
+	'z': "-0700",
+	'Z': "MST",
+	'c': "Mon Jan 2 15:04:05 2006",
+	'x': "01/02/06",
+	'X': "15:04:05",
+	'%': "%",
+}
+
+// StrftimeLayout converts the strftime format to the layout of time.Parse,
+// so text formatted by Strftime can be parsed back. %w, %j, %U and %W are
+// not supported, %f must follow a dot, e.g. %S.%f, and literal text must
+// not contain layout elements of time.Parse, e.g. digits or month names.
+func StrftimeLayout(f string) (string, error) {
+	var layout strings.Builder
+	for i := 0; i < len(f); i++ {
+		if f[i] != '%' {
+			layout.WriteByte(f[i])
+			continue
+		}
+		if i == len(f)-1 {
+			break
+		}
+		i++
+		l, ok := strftimeLayouts[f[i]]
+		if !ok {
+			return "", fmt.Errorf("unsupported directive %%%c in %q", f[i], f)
+		}
+		if f[i] == 'f' && (i < 2 || f[i-2] != '.') {
+			return "", fmt.Errorf("%%f must follow a dot in %q", f)
+		}
+		layout.WriteString(l)
+	}
+	return layout.String(), nil
 }
@@ -31,3 +31,46 @@ func TestStrftime(t *testing.T) {
 
 	AssertEqual(t, Strftime(&date, "작성일 : %a %A %w %d %b %B %"), "작성일 : Sun Sunday 0 31 Dec December ")
 }
+
+func TestAppendStrftime(t *testing.T) {
+	date := time.Date(2005, 2, 3, 4, 5, 6, 7000, time.UTC)
+	AssertEqual(t, string(AppendStrftime([]byte("at "), &date, "%Y-%m-%d %H:%M:%S")), "at 2005-02-03 04:05:06")
+
+	buf := make([]byte, 0, 64)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf = AppendStrftime(buf[:0], &date, "%Y-%m-%d %H:%M:%S.%f")
+	})
+	AssertEqual(t, allocs, float64(0))
+}
+
+func BenchmarkStrftime(b *testing.B) {
+	date := time.Date(2005, 2, 3, 4, 5, 6, 7000, time.UTC)
+	for i := 0; i < b.N; i++ {
+		Strftime(&date, "%Y-%m-%d %H:%M:%S")
+	}
+}
+
+func TestStrftimeLayout(t *testing.T) {
+	date := time.Date(2005, 2, 3, 16, 5, 6, 7000, time.FixedZone("", 3600))
+	for _, f := range []string{
+		"%Y-%m-%d %H:%M:%S.%f%z",
+		"%a %A %d %b %B %y %I%p %M:%S %z",
+		"%c %z",
+		"%x %X %z",
+		"[%Y] %%",
+	} {
+		layout, err := StrftimeLayout(f)
+		AssertEqual(t, err, nil)
+		AssertEqual(t, date.Format(layout), Strftime(&date, f))
+	}
+
+	layout, _ := StrftimeLayout("%Y-%m-%dT%H:%M:%S.%f%z")
+	parsed, err := time.Parse(layout, Strftime(&date, "%Y-%m-%dT%H:%M:%S.%f%z"))
+	AssertEqual(t, err, nil)
+	AssertEqual(t, parsed.Equal(date), true)
+
+	_, err = StrftimeLayout("%j")
+	AssertEqual(t, err != nil, true)
+	_, err = StrftimeLayout("%S%f")
+	AssertEqual(t, err != nil, true)
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"time"
+)
+
+const (
+	// StackFieldKey is the field key of the stack trace
+	// added by NewDevelopment
+	StackFieldKey = "stack"
+	// DefaultPresetDedupWindow is the window in which NewProduction
+	// suppresses repeated messages
+	DefaultPresetDedupWindow = time.Second
+)
+
+// Preset holds the knobs of NewDevelopment and NewProduction,
+// they are tweaked by options, e.g. a Level, OptionName, OptionOutput,
+// OptionServiceName, OptionStackMaxFrames and OptionStackSkipPrefixes
+type Preset struct {
+	Name        string
+	Level       Level
+	Output      io.Writer
+	ServiceName string
+	// StackMaxFrames and StackSkipPrefixes bound the stack trace
+	// of NewDevelopment, see StackTracer
+	StackMaxFrames    int
+	StackSkipPrefixes []string
+}
+
+// OptionServiceName is an option
+// used in every target which has fields named `ServiceName`
+func OptionServiceName(name string) Option {
+	return optFuncWraper(func(target interface{}) bool {
+		v := reflect.ValueOf(target).Elem()
+		if f := v.FieldByName("ServiceName"); f.IsValid() {
+			f.SetString(name)
+			return true
+		}
+		return false
+	})
+}
+
+// NewDevelopment returns a logger for local development, it writes
+// records at DEBUG level or above to stderr by DevFormatter with caller
+// info, records at ERROR level or above get the stack trace without
+// runtime and logdog frames.
+// The logger is not registered
+func NewDevelopment(options ...Option) *Logger {
+	preset := &Preset{
+		Level:             DebugLevel,
+		Output:            os.Stderr,
+		StackMaxFrames:    DefaultStackMaxFrames,
+		StackSkipPrefixes: DefaultStackSkipPrefixes,
+	}
+	applyOptions(preset, options...)
+
+	handler := NewWriterHandler("", preset.Output, NewDevFormatter(), NothingLevel)
+	logger := NewLogger(
+		OptionName(preset.Name),
+		preset.Level,
+		OptionEnableRuntimeCaller(true),
+		OptionHandlers(handler),
+	)
+	logger.AddHook((&StackTracer{
+		StackMaxFrames:    preset.StackMaxFrames,
+		StackSkipPrefixes: preset.StackSkipPrefixes,
+	}).Hook(ErrorLevel))
+	if preset.ServiceName != "" {
+		return logger.With("service", preset.ServiceName)
+	}
+	return logger
+}
+
+// NewProduction returns a logger for production, it writes records
+// at INFO level or above to stdout by JSONFormatter, repeated messages
+// in DefaultPresetDedupWindow are suppressed, and every record is
+// stamped with host and pid fields.
+// The logger is not registered
+func NewProduction(options ...Option) *Logger {
+	preset := &Preset{
+		Level:  InfoLevel,
+		Output: os.Stdout,
+	}
+	applyOptions(preset, options...)
+
+	formatter := NewJSONFormatter()
+	formatter.Precision = MilliPrecision
+	handler := NewWriterHandler("", preset.Output, formatter, NothingLevel)
+	handler.AddFilters(NewDedupFilter(DefaultPresetDedupWindow))
+	logger := NewLogger(
+		OptionName(preset.Name),
+		preset.Level,
+		OptionEnableRuntimeCaller(true),
+		OptionHandlers(handler),
+	)
+
+	fields := Fields{"pid": os.Getpid()}
+	if host, err := os.Hostname(); err == nil {
+		fields["host"] = host
+	}
+	if preset.ServiceName != "" {
+		fields["service"] = preset.ServiceName
+	}
+	return logger.WithFields(fields)
+}
@@ -0,0 +1,59 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDevelopment(t *testing.T) {
+	out := &closeRecorder{}
+	logger := NewDevelopment(OptionOutput(out), OptionName("dev"))
+	assert.Equal(t, DebugLevel, logger.GetLevel())
+
+	logger.Debug("debug", Fields{"k": "v"})
+	logger.Error("failed")
+	lines := out.String()
+	assert.Contains(t, lines, "DEBUG debug\n  logger: dev\n  caller: preset_test.go:")
+	assert.Contains(t, lines, "ERROR failed")
+	// runtime and logdog frames are skipped
+	assert.Contains(t, lines, "  stack:\n    testing.tRunner\n")
+	assert.NotContains(t, lines, "runtime.")
+	assert.Equal(t, 1, strings.Count(lines, "stack:"))
+}
+
+func TestNewProduction(t *testing.T) {
+	out := &closeRecorder{}
+	logger := NewProduction(OptionOutput(out), WarnLevel, OptionServiceName("checkout"))
+
+	logger.Info("dropped")
+	logger.Warn("repeated")
+	logger.Warn("repeated")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 1)
+
+	var data map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &data))
+	assert.Equal(t, "repeated", data["message"])
+	fields := data["_fields"].(map[string]interface{})
+	assert.Equal(t, "checkout", fields["service"])
+	assert.Equal(t, float64(os.Getpid()), fields["pid"])
+	assert.NotEmpty(t, fields["host"])
+}
@@ -0,0 +1,91 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Printer adapts a Handler to the Print, Printf and Println methods
+// accepted by many third-party libraries, e.g. the standard log.Logger
+// interface. Every call emits a record at the fixed Level named Name,
+// the message is formatted exactly like fmt.Sprint, fmt.Sprintf and
+// fmt.Sprintln, a trailing newline is trimmed.
+type Printer struct {
+	Name    string
+	Level   Level
+	Handler Handler
+	// CallerSkip is the number of extra stack frames to ascend
+	// to report the call site
+	CallerSkip int
+}
+
+// PrintLogger returns a Printer emitting records to h at the level
+func PrintLogger(h Handler, level Level) *Printer {
+	return &Printer{
+		Level:   level,
+		Handler: h,
+	}
+}
+
+// Named returns a copy of the Printer whose records are named name,
+// e.g. the name of the library
+func (p *Printer) Named(name string) *Printer {
+	named := *p
+	named.Name = name
+	return &named
+}
+
+// emit emits msg as it is, it should be called
+// by the Print methods directly to get the caller
+func (p *Printer) emit(msg string) {
+	file, line, funcname := "??", 0, "??"
+	if pc, f, l, ok := runtime.Caller(2 + p.CallerSkip); ok {
+		file, line = f, l
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			funcname = fn.Name()
+		}
+	}
+	// msg is passed as the only arg so it is never formatted again,
+	// and fields are not extracted from library's args
+	record := NewLogRecord(recordName(p.Name), p.Level, file, funcname, line, "", strings.TrimSuffix(msg, "\n"))
+	if global := loadGlobalFields(); len(global) > 0 {
+		record.Fields = make(Fields, len(global))
+		for k, v := range global {
+			record.Fields[k] = v
+		}
+	}
+	if ShouldEmit(p.Handler, record) {
+		record.ResolveLazy()
+		p.Handler.Emit(record)
+	}
+}
+
+// Print emits a record formatted like fmt.Sprint
+func (p *Printer) Print(v ...interface{}) {
+	p.emit(fmt.Sprint(v...))
+}
+
+// Printf emits a record formatted like fmt.Sprintf
+func (p *Printer) Printf(format string, v ...interface{}) {
+	p.emit(fmt.Sprintf(format, v...))
+}
+
+// Println emits a record formatted like fmt.Sprintln
+func (p *Printer) Println(v ...interface{}) {
+	p.emit(fmt.Sprintln(v...))
+}
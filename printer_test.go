@@ -0,0 +1,50 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrintLogger(t *testing.T) {
+	hdlr := &recordHandler{}
+	p := PrintLogger(hdlr, WarnLevel).Named("lib")
+
+	p.Print("a", 1, 2, "b")
+	p.Printf("retry %d%%\n", 3)
+	p.Println("a", 1, 2, "b")
+	p.Println(Fields{"k": "v"})
+
+	assert.Len(t, hdlr.records, 4)
+	for _, r := range hdlr.records {
+		assert.Equal(t, "lib", r.Name)
+		assert.Equal(t, WarnLevel, r.Level)
+		assert.Equal(t, "printer_test.go", r.FileName)
+		assert.Equal(t, "TestPrintLogger", r.ShortFuncName)
+	}
+	assert.Equal(t, fmt.Sprint("a", 1, 2, "b"), hdlr.records[0].GetMessage())
+	assert.Equal(t, "retry 3%", hdlr.records[1].GetMessage())
+	assert.Equal(t, strings.TrimSuffix(fmt.Sprintln("a", 1, 2, "b"), "\n"), hdlr.records[2].GetMessage())
+	// fields are printed, not extracted
+	assert.Equal(t, fmt.Sprint(Fields{"k": "v"}), hdlr.records[3].GetMessage())
+	assert.Empty(t, hdlr.records[3].Fields)
+
+	// level of handler is respected
+	PrintLogger(NewStreamHandler(ErrorLevel, OptionDiscardOutput()), InfoLevel).Print("dropped")
+}
@@ -0,0 +1,117 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+// ProjectionHandler is a handler which keeps only the fields and extras
+// of records with the configured keys, i.e. an allow-list, and drops the others
+// before passing records to the wrapped Handler, e.g. an audit log which
+// should record only an approved subset of fields.
+// The emitted record is a copy, so the original record is untouched
+// for other handlers
+type ProjectionHandler struct {
+	Name    string
+	Handler Handler
+
+	keys map[string]struct{}
+}
+
+// NewProjectionHandler returns a new ProjectionHandler wrapping hdlr
+// which keeps only the fields with the given keys
+func NewProjectionHandler(hdlr Handler, keys []string, options ...Option) *ProjectionHandler {
+	proj := &ProjectionHandler{
+		Name:    "",
+		Handler: hdlr,
+		keys:    make(map[string]struct{}, len(keys)),
+	}
+	for _, key := range keys {
+		proj.keys[key] = struct{}{}
+	}
+
+	applyOptions(proj, options...)
+
+	return proj
+}
+
+// Keys returns the allowed field keys
+func (hdlr *ProjectionHandler) Keys() []string {
+	keys := make([]string, 0, len(hdlr.keys))
+	for key := range hdlr.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Project returns a copy of record whose fields and extras are
+// projected, it returns record itself if nothing is dropped
+func (hdlr *ProjectionHandler) Project(record *LogRecord) *LogRecord {
+	if hdlr.allowed(record.Fields) && hdlr.allowed(record.Extra) {
+		return record
+	}
+
+	projected := *record
+	projected.Fields = Fields(hdlr.project(record.Fields))
+	projected.Extra = hdlr.project(record.Extra)
+	return &projected
+}
+
+// allowed checks if all keys of m are allowed
+func (hdlr *ProjectionHandler) allowed(m map[string]interface{}) bool {
+	for k := range m {
+		if _, ok := hdlr.keys[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// project returns a copy of m with the allowed keys, nil if none
+func (hdlr *ProjectionHandler) project(m map[string]interface{}) map[string]interface{} {
+	var projected map[string]interface{}
+	for k, v := range m {
+		if _, ok := hdlr.keys[k]; ok {
+			if projected == nil {
+				projected = make(map[string]interface{}, len(hdlr.keys))
+			}
+			projected[k] = v
+		}
+	}
+	return projected
+}
+
+// Emit emits the projected record to the wrapped handler
+func (hdlr *ProjectionHandler) Emit(record *LogRecord) {
+	hdlr.Handler.Emit(hdlr.Project(record))
+}
+
+// ShouldEmit checks if the wrapped handler should emit the specified record
+func (hdlr *ProjectionHandler) ShouldEmit(record *LogRecord) bool {
+	return ShouldEmit(hdlr.Handler, record)
+}
+
+// Filter checks if handler should filter the specified record.
+// Deprecated: use ShouldEmit instead
+func (hdlr *ProjectionHandler) Filter(record *LogRecord) bool {
+	return !hdlr.ShouldEmit(record)
+}
+
+// Flush flushes the wrapped handler
+func (hdlr *ProjectionHandler) Flush() error {
+	return hdlr.Handler.Flush()
+}
+
+// Close closes the wrapped handler
+func (hdlr *ProjectionHandler) Close() error {
+	return hdlr.Handler.Close()
+}
@@ -0,0 +1,68 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProjectionHandler(t *testing.T) {
+	audit := &recordHandler{}
+	debug := &recordHandler{}
+	logger := NewLogger(OptionHandlers(NewProjectionHandler(audit, []string{"user", "action"}), debug))
+
+	logger.Info("login", Fields{"user": "jim", "action": "login", "token": "secret", "trace": 1})
+	logger.Info("no fields")
+	logger.Info("allowed", Fields{"user": "jim"})
+
+	assert.Len(t, audit.records, 3)
+	assert.Equal(t, Fields{"user": "jim", "action": "login"}, audit.records[0].Fields)
+	assert.Equal(t, "login", audit.records[0].GetMessage())
+	assert.Empty(t, audit.records[1].Fields)
+	assert.Equal(t, Fields{"user": "jim"}, audit.records[2].Fields)
+
+	// other handlers see the original record
+	assert.Len(t, debug.records, 3)
+	assert.Equal(t, Fields{"user": "jim", "action": "login", "token": "secret", "trace": 1}, debug.records[0].Fields)
+	assert.NotEqual(t, audit.records[0], debug.records[0])
+	// a record needing no projection is passed as is
+	assert.Equal(t, debug.records[2], audit.records[2])
+}
+
+func TestProjectionHandlerExtra(t *testing.T) {
+	var out lockedBuffer
+	audit := NewWriterHandler("", &out, &JSONFormatter{}, NothingLevel)
+	proj := NewProjectionHandler(audit, []string{"user", "sampled"})
+
+	record := NewLogRecord(name, InfoLevel, pathname, fun, line, "login", Fields{"user": "jim"})
+	record.SetExtra("sampled", true)
+	record.SetExtra("session", "secret")
+	projected := proj.Project(record)
+	assert.Equal(t, map[string]interface{}{"sampled": true}, projected.Extra)
+	assert.Equal(t, Fields{"user": "jim"}, projected.Fields)
+	// the original record is untouched
+	assert.Len(t, record.Extra, 2)
+
+	proj.Emit(record)
+	assert.NotContains(t, out.String(), "secret")
+	assert.Contains(t, out.String(), `"sampled":true`)
+
+	// extras with allowed keys only need no projection
+	record = NewLogRecord(name, InfoLevel, pathname, fun, line, "login")
+	record.SetExtra("sampled", true)
+	assert.True(t, proj.Project(record) == record)
+}
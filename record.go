@@ -19,6 +19,8 @@ import (
 	"path"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -40,6 +42,11 @@ func (f Fields) String() string {
 
 // ToKVString convert Fields to string likes k1=v1 k2=v2
 func (f Fields) ToKVString(color, endColor string) string {
+	return f.toKVString(color, endColor, false)
+}
+
+// toKVString is ToKVString, keys and values are sanitized if sanitize is true
+func (f Fields) toKVString(color, endColor string, sanitize bool) string {
 
 	if len(f) == 0 {
 		return ""
@@ -56,23 +63,30 @@ func (f Fields) ToKVString(color, endColor string) string {
 
 	first := true
 	for _, k := range sorted {
-		v := f[k]
-		// auto format time to RFC3339
-		if vv, ok := v.(time.Time); ok {
-			v = vv.Format(time.RFC3339)
+		// auto format time to RFC3339, see FieldText
+		v := FieldText(f[k])
+
+		if sanitize {
+			k = Sanitize(k)
+			v = Sanitize(v)
 		}
 
 		if first {
-			fmt.Fprintf(b, "%s=%s%+v%s", k, color, v, endColor)
+			fmt.Fprintf(b, "%s=%s%s%s", k, color, v, endColor)
 			first = false
 		} else {
-			fmt.Fprintf(b, " %s=%s%+v%s", k, color, v, endColor)
+			fmt.Fprintf(b, " %s=%s%s%s", k, color, v, endColor)
 		}
 	}
 
 	return string(*b)
 }
 
+// Event is a stable event code of a record, e.g. user.login,
+// it is rendered separately from the human message.
+// Event must be the last element in args, or just before Fields
+type Event string
+
 // LogRecord defines a real log record should be
 type LogRecord struct {
 	Name          string
@@ -82,6 +96,7 @@ type LogRecord struct {
 	FileName      string
 	FuncName      string
 	ShortFuncName string
+	FullFuncName  string
 	Line          int
 	Time          time.Time
 	// msg could be ""
@@ -89,6 +104,51 @@ type LogRecord struct {
 	Args []interface{}
 	// extract fields from args
 	Fields Fields
+	// extract event from args
+	Event string
+	// Extra is the metadata which hooks, filters and handlers attach
+	// to the record, e.g. sampling decisions, it is kept apart from
+	// Fields and set by SetExtra
+	Extra map[string]interface{}
+	// Seq is the sequence number of the record in the process, it
+	// orders records with the same Time, copies of the record share it
+	Seq uint64
+	// lazy memoizes LazyValues of Fields, copies of the record share it
+	lazy *lazyValues
+}
+
+// recordSeq is the sequence number of the last record
+var recordSeq uint64
+
+// globalFields stores the Fields set by SetGlobalFields
+var globalFields atomic.Value
+
+// SetGlobalFields sets fields merged into every record logged by loggers
+// and printers, e.g. service name, version and region constant for the
+// whole process. They have the lowest precedence, fields of loggers and
+// calls with the same keys override them. nil clears them
+func SetGlobalFields(fields map[string]interface{}) {
+	copied := make(Fields, len(fields))
+	for k, v := range fields {
+		copied[k] = v
+	}
+	globalFields.Store(copied)
+}
+
+// GlobalFields returns a copy of the fields set by SetGlobalFields
+func GlobalFields() Fields {
+	global := loadGlobalFields()
+	copied := make(Fields, len(global))
+	for k, v := range global {
+		copied[k] = v
+	}
+	return copied
+}
+
+// loadGlobalFields returns the global fields, they must not be modified
+func loadGlobalFields() Fields {
+	global, _ := globalFields.Load().(Fields)
+	return global
 }
 
 // NewLogRecord returns a new log record
@@ -100,7 +160,8 @@ func NewLogRecord(name string, level Level, pathname string, funcname string, li
 		Line:     line,
 		Msg:      msg,
 		Args:     args,
-		Time:     time.Now(),
+		Time:     Now(),
+		Seq:      atomic.AddUint64(&recordSeq, 1),
 	}
 	// level name
 	record.LevelName = level.String()
@@ -110,6 +171,7 @@ func NewLogRecord(name string, level Level, pathname string, funcname string, li
 	record.FileName = filename
 
 	// func name
+	record.FullFuncName = funcname
 	i := strings.LastIndex(funcname, "/")
 	record.FuncName = funcname[i+1:]
 	j := strings.LastIndex(funcname[i+1:], ".")
@@ -121,22 +183,93 @@ func NewLogRecord(name string, level Level, pathname string, funcname string, li
 	return &record
 }
 
+// Elapsed returns the elapsed time since StartTime when the record was
+// created, it uses the monotonic clock if both record.Time and StartTime
+// have monotonic readings, e.g. are from time.Now(), so wall clock steps
+// do not produce negative values
+func (lr LogRecord) Elapsed() time.Duration {
+	return lr.Time.Sub(StartTime())
+}
+
+// Clone returns a copy of the record which does not share
+// args, fields and extras with it, so either can be modified safely
+func (lr *LogRecord) Clone() *LogRecord {
+	clone := *lr
+	if lr.Args != nil {
+		clone.Args = make([]interface{}, len(lr.Args))
+		copy(clone.Args, lr.Args)
+	}
+	if lr.Fields != nil {
+		clone.Fields = make(Fields, len(lr.Fields))
+		for k, v := range lr.Fields {
+			clone.Fields[k] = v
+		}
+	}
+	if lr.Extra != nil {
+		clone.Extra = make(map[string]interface{}, len(lr.Extra))
+		for k, v := range lr.Extra {
+			clone.Extra[k] = v
+		}
+	}
+	return &clone
+}
+
 // GetMessage formats record message by msg and args
 func (lr LogRecord) GetMessage() string {
-	msg := lr.Msg
-	buf := &buffer{}
-	if msg == "" {
+	return string(lr.AppendMessage(nil))
+}
+
+// maxPooledBufferSize is the max capacity of buffers put back to pools,
+// large buffers are dropped to avoid pinning memory after a huge record
+const maxPooledBufferSize = 64 << 10
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return &buffer{}
+	},
+}
+
+// getBuffer returns an empty buffer from the pool
+func getBuffer() *buffer {
+	buf := bufferPool.Get().(*buffer)
+	*buf = (*buf)[:0]
+	return buf
+}
+
+// putBuffer puts buf back to the pool unless it grew too large
+func putBuffer(buf *buffer) {
+	if cap(*buf) <= maxPooledBufferSize {
+		bufferPool.Put(buf)
+	}
+}
+
+// AppendMessage appends the record message formatted by msg and args
+// to dst, the plain message is appended without formatting
+func (lr *LogRecord) AppendMessage(dst []byte) []byte {
+	if lr.Msg == "" && len(lr.Args) == 1 {
+		if s, ok := lr.Args[0].(string); ok {
+			return append(dst, s...)
+		}
+	}
+	if lr.Msg != "" && len(lr.Args) == 0 && strings.IndexByte(lr.Msg, '%') < 0 {
+		return append(dst, lr.Msg...)
+	}
+
+	buf := getBuffer()
+	if lr.Msg == "" {
 		fmt.Fprintln(buf, lr.Args...)
-		msg = string(*buf)
-		msg = msg[:len(msg)-1]
+		dst = append(dst, (*buf)[:len(*buf)-1]...)
 	} else {
-		msg = fmt.Sprintf(lr.Msg, lr.Args...)
+		fmt.Fprintf(buf, lr.Msg, lr.Args...)
+		dst = append(dst, *buf...)
 	}
-	return msg
+	putBuffer(buf)
+	return dst
 }
 
-// ExtractFieldsFromArgs extracts fields (Fields) from args
-// Fields must be the last element in args
+// ExtractFieldsFromArgs extracts fields (Fields) and event (Event) from args
+// Fields must be the last element in args, Event must be the last one
+// or just before Fields
 func (lr *LogRecord) ExtractFieldsFromArgs() {
 	argsLen := len(lr.Args)
 	if argsLen == 0 {
@@ -146,6 +279,16 @@ func (lr *LogRecord) ExtractFieldsFromArgs() {
 	if fields, ok := lr.Args[argsLen-1].(Fields); ok {
 		lr.Args = lr.Args[:argsLen-1]
 		lr.Fields = fields
+		argsLen--
+	}
+
+	if argsLen == 0 {
+		return
+	}
+
+	if event, ok := lr.Args[argsLen-1].(Event); ok {
+		lr.Args = lr.Args[:argsLen-1]
+		lr.Event = string(event)
 	}
 
 }
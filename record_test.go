@@ -14,7 +14,11 @@
 package logdog
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -49,9 +53,47 @@ func TestLogRecord(t *testing.T) {
 
 }
 
+func TestLogRecordSeq(t *testing.T) {
+	var text, js bytes.Buffer
+	first := &recordHandler{}
+	logger := NewLogger(OptionHandlers(
+		first,
+		NewWriterHandler("", &text, &TextFormatter{Fmt: "%(seq) %(message)"}, NothingLevel),
+		NewWriterHandler("", &js, &JSONFormatter{EnableSeq: true}, NothingLevel),
+	))
+
+	logger.Info("1")
+	logger.Info("2")
+	assert.Len(t, first.records, 2)
+	seq := first.records[0].Seq
+	assert.Equal(t, seq+1, first.records[1].Seq)
+	assert.Equal(t, seq, first.records[0].Clone().Seq)
+
+	// all handlers get the same seq
+	assert.Equal(t, fmt.Sprintf("%d 1\n%d 2\n", seq, seq+1), text.String())
+	var data map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(strings.Split(js.String(), "\n")[0]), &data))
+	assert.Equal(t, float64(seq), data["seq"])
+	parsed, err := (&JSONFormatter{EnableSeq: true}).Parse([]byte(strings.Split(js.String(), "\n")[1]))
+	assert.Nil(t, err)
+	assert.Equal(t, seq+1, parsed.Seq)
+}
+
 func TestLogRecordError(t *testing.T) {
 	// fields should be the last one
 	record := NewLogRecord(name, level, pathname, fun, line, "%s", fields, "error fields")
 	assert.Nil(t, record.Fields)
 
 }
+
+func TestLogRecordEvent(t *testing.T) {
+	record := NewLogRecord(name, level, pathname, fun, line, "%s logged in", "jim", Event("user.login"), fields)
+	assert.Equal(t, "user.login", record.Event)
+	assert.Equal(t, fields, record.Fields)
+	assert.Equal(t, "jim logged in", record.GetMessage())
+
+	record = NewLogRecord(name, level, pathname, fun, line, "", "quota exceeded", Event("quota.exceeded"))
+	assert.Equal(t, "quota.exceeded", record.Event)
+	assert.Nil(t, record.Fields)
+	assert.Equal(t, "quota exceeded", record.GetMessage())
+}
@@ -0,0 +1,97 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// RecordParser converts a line of formatted output back to a LogRecord,
+// e.g. JSONFormatter and LogfmtFormatter
+type RecordParser interface {
+	Parse(data []byte) (*LogRecord, error)
+}
+
+// RecordReader reads records from a stream of formatted lines, e.g. a log
+// file, for replaying and computing stats. Each line is parsed by the first
+// of Parsers accepting it, unknown keys are ignored by JSONFormatter and
+// kept as fields by LogfmtFormatter. Lines no parser accepts, e.g. panics
+// interleaved with records, are returned as records of the raw line at
+// RawLevel, or as errors with their line numbers if Strict is set.
+type RecordReader struct {
+	Parsers []RecordParser
+	// RawLevel is the level of records of lines no parser accepts
+	RawLevel Level
+	// Strict returns an error for lines no parser accepts
+	Strict bool
+	r      *bufio.Reader
+	line   int
+}
+
+// NewRecordReader returns a RecordReader reading from r, parsers should
+// have the config of the formatters which wrote the stream, no parsers
+// means the default JSONFormatter and LogfmtFormatter
+func NewRecordReader(r io.Reader, parsers ...RecordParser) *RecordReader {
+	if len(parsers) == 0 {
+		parsers = []RecordParser{NewJSONFormatter(), NewLogfmtFormatter()}
+	}
+	return &RecordReader{
+		Parsers:  parsers,
+		RawLevel: InfoLevel,
+		r:        bufio.NewReader(r),
+	}
+}
+
+// Next returns the next record, blank lines are skipped, trailing
+// "\r\n" or "\n" is trimmed. It returns io.EOF at the end of the stream.
+// In Strict mode a line no parser accepts returns an error with its line
+// number and the error of the last parser, the next call continues with
+// the next line
+func (rr *RecordReader) Next() (*LogRecord, error) {
+	for {
+		data, err := rr.r.ReadBytes('\n')
+		if len(data) == 0 && err != nil {
+			return nil, err
+		}
+		rr.line++
+		line := bytes.TrimRight(data, "\r\n")
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var perr error
+		for _, parser := range rr.Parsers {
+			var record *LogRecord
+			if record, perr = parser.Parse(line); perr == nil {
+				return record, nil
+			}
+		}
+		if rr.Strict {
+			return nil, fmt.Errorf("line %d: %v", rr.line, perr)
+		}
+		return &LogRecord{
+			Level:     rr.RawLevel,
+			LevelName: rr.RawLevel.String(),
+			Msg:       string(line),
+		}, nil
+	}
+}
+
+// Line returns the number of the last line read, starting from 1
+func (rr *RecordReader) Line() int {
+	return rr.line
+}
@@ -0,0 +1,96 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordReader(t *testing.T) {
+	records := []*LogRecord{
+		NewLogRecord("app", InfoLevel, "a/b.go", fun, line, "started", Fields{"port": 8080}),
+		NewLogRecord("app", ErrorLevel, "a/b.go", fun, line, "failed", Fields{"user": "jim"}),
+	}
+	for _, record := range records {
+		record.Time = time.Date(2024, 5, 1, 3, 4, 5, 0, time.Local)
+	}
+
+	var out bytes.Buffer
+	jf, lf := NewJSONFormatter(), NewLogfmtFormatter()
+	text, _ := jf.Format(records[0])
+	out.WriteString(text + "\n")
+	out.WriteString("\n")
+	out.WriteString("goroutine 1 [running]:\n")
+	out.WriteString(`{"time": "2024-05-01 03:04:05", "level": "WARN", "message": "extra", "logger": "other", "host": "a"}` + "\n")
+	text, _ = lf.Format(records[1])
+	// the last line has no newline
+	out.WriteString(text)
+
+	reader := NewRecordReader(&out)
+	reader.RawLevel = ErrorLevel
+
+	record, err := reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "started", record.GetMessage())
+	assert.Equal(t, InfoLevel, record.Level)
+	assert.True(t, records[0].Time.Equal(record.Time))
+	assert.Equal(t, Fields{"port": int64(8080)}, record.Fields)
+
+	// lines no parser accepts are raw records
+	record, err = reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "goroutine 1 [running]:", record.GetMessage())
+	assert.Equal(t, ErrorLevel, record.Level)
+	assert.Equal(t, "ERROR", record.LevelName)
+
+	// unknown keys are ignored
+	record, err = reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "extra", record.GetMessage())
+	assert.Equal(t, "other", record.Name)
+	assert.Equal(t, WarnLevel, record.Level)
+
+	record, err = reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "failed", record.GetMessage())
+	assert.Equal(t, "app", record.Name)
+	assert.Equal(t, ErrorLevel, record.Level)
+	assert.Equal(t, Fields{"user": "jim"}, record.Fields)
+
+	_, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestRecordReaderStrict(t *testing.T) {
+	jf := NewJSONFormatter()
+	text, _ := jf.Format(NewLogRecord("app", InfoLevel, "a/b.go", fun, line, "started"))
+
+	rr := NewRecordReader(bytes.NewBufferString(text+"\r\n\npanic: boom\n"), jf)
+	rr.Strict = true
+	record, err := rr.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "started", record.Msg)
+	assert.Equal(t, 1, rr.Line())
+	_, err = rr.Next()
+	assert.Contains(t, err.Error(), "line 3")
+	assert.Equal(t, 3, rr.Line())
+	_, err = rr.Next()
+	assert.Equal(t, io.EOF, err)
+}
@@ -14,7 +14,12 @@
 
 package logdog
 
-import "github.com/zoumo/register"
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/zoumo/register"
+)
 
 var (
 	formatters   = register.NewRegister(nil)
@@ -22,6 +27,7 @@ var (
 	constructors = register.NewRegister(nil)
 	loggers      = register.NewRegister(nil)
 	levels       = register.NewRegister(nil)
+	encodings    = register.NewRegister(nil)
 )
 
 // Constructor is a function which returns an ConfigLoader
@@ -61,13 +67,76 @@ func RegisterHandler(name string, handler Handler) {
 	handlers.Register(name, handler)
 }
 
-// GetHandler returns a Handler registered with the given name
+// GetHandler returns a Handler registered with the given name, if not,
+// returns the handler with the Name added to a registered logger or
+// the default logger, nil if there is none
 func GetHandler(name string) Handler {
 	v, ok := handlers.Get(name)
-	if !ok {
+	if ok {
+		return v.(Handler)
+	}
+	if name == "" {
 		return nil
 	}
-	return v.(Handler)
+	return AllHandlers()[name]
+}
+
+// AllHandlers returns all handlers by name, including the registered
+// handlers, and the handlers with a Name added to registered loggers
+// and the default logger. A registered handler wins if names collide,
+// handlers wrapped by other handlers are not included.
+// The default logger is not created if it is not used yet
+func AllHandlers() map[string]Handler {
+	all := make(map[string]Handler)
+	lgs := registeredLoggers()
+	if lg := loadedDefault(); lg != nil {
+		lgs = append(lgs, lg)
+	}
+	for _, lg := range lgs {
+		for _, hdlr := range lg.Handlers {
+			if name := HandlerName(hdlr); name != "" {
+				if _, ok := all[name]; !ok {
+					all[name] = hdlr
+				}
+			}
+		}
+	}
+	for _, name := range handlers.Keys() {
+		if v, ok := handlers.Get(name); ok {
+			all[name] = v.(Handler)
+		}
+	}
+	return all
+}
+
+// HandlerName returns the Name field of the handler,
+// "" if it has no such field
+func HandlerName(handler Handler) string {
+	v := reflect.ValueOf(handler)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return ""
+	}
+	if n := v.Elem().FieldByName("Name"); n.IsValid() && n.Kind() == reflect.String {
+		return n.String()
+	}
+	return ""
+}
+
+// SetHandlerLevel sets the level of the handler found by GetHandler,
+// it returns error if there is no such handler or it has no level
+func SetHandlerLevel(name string, level Level) error {
+	hdlr := GetHandler(name)
+	if hdlr == nil {
+		return fmt.Errorf("can not find handler: %s", name)
+	}
+	setter, ok := hdlr.(interface {
+		SetLevel(Level)
+	})
+	if !ok {
+		return fmt.Errorf("handler %s[%T] has no level", name, hdlr)
+	}
+	setter.SetLevel(level)
+	return nil
 }
 
 // GetLogger returns an logger by name
@@ -125,6 +194,5 @@ func DisableExistingLoggers() {
 	loggers.Clear()
 
 	// reset root
-	root = GetLogger(RootLoggerName)
-	root.ApplyOptions(OptionHandlers(NewStreamHandler()))
+	SetDefault(nil)
 }
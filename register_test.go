@@ -12,3 +12,54 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 package logdog
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zoumo/register"
+)
+
+func TestAllHandlers(t *testing.T) {
+	file := NewStreamHandler(OptionName("registry-file"), OptionDiscardOutput())
+	GetLogger("registry", OptionHandlers(file, NewNullHandler()))
+	registered := NewNullHandler()
+	RegisterHandler("registry-null", registered)
+
+	assert.True(t, GetHandler("registry-file") == file)
+	assert.True(t, GetHandler("registry-null") == Handler(registered))
+	assert.Nil(t, GetHandler("registry-missing"))
+	assert.Nil(t, GetHandler(""))
+
+	all := AllHandlers()
+	assert.True(t, all["registry-file"] == file)
+	assert.True(t, all["registry-null"] == Handler(registered))
+	assert.NotContains(t, all, "")
+
+	assert.Nil(t, SetHandlerLevel("registry-file", ErrorLevel))
+	assert.Equal(t, ErrorLevel, file.GetLevel())
+	assert.NotNil(t, SetHandlerLevel("registry-null", ErrorLevel))
+	assert.NotNil(t, SetHandlerLevel("registry-missing", ErrorLevel))
+}
+
+func TestGetHandlerMissingCreatesNoLogger(t *testing.T) {
+	// start like a fresh process, the default logger is not used yet
+	saved, old := loggers, loadedDefault()
+	loggers = register.NewRegister(nil)
+	defaultOnce = sync.Once{}
+	defaultLogger = atomic.Value{}
+	defer func() {
+		loggers = saved
+		defaultOnce = sync.Once{}
+		defaultLogger = atomic.Value{}
+		if old != nil {
+			SetDefault(old)
+		}
+	}()
+
+	assert.Nil(t, GetHandler("missing"))
+	assert.Empty(t, loggers.Keys())
+	assert.Nil(t, loadedDefault())
+}
@@ -0,0 +1,97 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"unicode/utf8"
+)
+
+const hexDigits = "0123456789abcdef"
+
+// needsEscape checks if the rune should be escaped by AppendSanitized,
+// i.e. control characters except tab, and the unicode line separators
+func needsEscape(r rune) bool {
+	switch {
+	case r == '\t':
+		return false
+	case r < 0x20, r == 0x7f:
+		return true
+	case r >= 0x80 && r < 0xa0, r == '\u2028', r == '\u2029':
+		return true
+	}
+	return false
+}
+
+// isSanitized checks if s needs no sanitizing
+func isSanitized(s []byte) bool {
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c < 0x20 && c != '\t' || c == 0x7f {
+				return false
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(s[i:])
+		if r == utf8.RuneError && size == 1 || needsEscape(r) {
+			return false
+		}
+		i += size
+	}
+	return true
+}
+
+// AppendSanitized appends s to dst with control characters escaped, so
+// user-controlled data can not forge log lines or mess up terminals, e.g.
+// \r, \n and ESC of ANSI sequences. ASCII control characters are escaped
+// as \xNN, other ones as \uNNNN, invalid UTF-8 is replaced with U+FFFD
+func AppendSanitized(dst []byte, s string) []byte {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			dst = append(dst, "\uFFFD"...)
+		case r < 0x80 && needsEscape(r):
+			dst = append(dst, '\\', 'x', hexDigits[r>>4], hexDigits[r&0xf])
+		case needsEscape(r):
+			dst = append(dst, '\\', 'u', hexDigits[r>>12&0xf], hexDigits[r>>8&0xf], hexDigits[r>>4&0xf], hexDigits[r&0xf])
+		default:
+			dst = append(dst, s[i:i+size]...)
+		}
+		i += size
+	}
+	return dst
+}
+
+// Sanitize returns s with control characters escaped, see AppendSanitized
+func Sanitize(s string) string {
+	if isSanitized([]byte(s)) {
+		return s
+	}
+	return string(AppendSanitized(make([]byte, 0, len(s)+8), s))
+}
+
+// sanitizeTail sanitizes dst[start:] in place
+func sanitizeTail(dst []byte, start int) []byte {
+	if isSanitized(dst[start:]) {
+		return dst
+	}
+	buf := getBuffer()
+	*buf = append(*buf, dst[start:]...)
+	dst = AppendSanitized(dst[:start], string(*buf))
+	putBuffer(buf)
+	return dst
+}
@@ -0,0 +1,64 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"encoding/json"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitize(t *testing.T) {
+	cases := map[string]string{
+		"plain text":                "plain text",
+		"tab\tkept":                 "tab\tkept",
+		"forged\nINFO admin":        `forged\x0aINFO admin`,
+		"carriage\rreturn":          `carriage\x0dreturn`,
+		"\x1b[31mred\x1b[0m":        `\x1b[31mred\x1b[0m`,
+		"bad \xff\xfe utf8":         "bad \uFFFD\uFFFD utf8",
+		"c1 \u0085 separator\u2028": `c1 \u0085 separator\u2028`,
+		"中文":                        "中文",
+	}
+	for in, want := range cases {
+		assert.Equal(t, want, Sanitize(in), "%q", in)
+	}
+}
+
+func TestTextFormatterSanitize(t *testing.T) {
+	formatter := &TextFormatter{Fmt: "%(message)"}
+	record := NewLogRecord("app", InfoLevel, pathname, fun, line, "user %s", "jim\nINFO forged", Fields{"agent": "\x1b[2J"})
+	msg, err := formatter.Format(record)
+	assert.Nil(t, err)
+	assert.Equal(t, `user jim\x0aINFO forged | agent=\x1b[2J`, msg)
+
+	formatter = &TextFormatter{Fmt: "%(message)", DisableSanitize: true}
+	msg, err = formatter.Format(record)
+	assert.Nil(t, err)
+	assert.Equal(t, "user jim\nINFO forged | agent=\x1b[2J", msg)
+}
+
+func TestJSONFormatterInvalidUTF8(t *testing.T) {
+	record := NewLogRecord("app", InfoLevel, pathname, fun, line, "bad \xff\n", Fields{"k": "\xfe\x1b"})
+	msg, err := NewJSONFormatter().Format(record)
+	assert.Nil(t, err)
+	assert.True(t, utf8.ValidString(msg))
+
+	var data map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(msg), &data))
+	assert.Equal(t, "bad \uFFFD\n", data["message"])
+	assert.Equal(t, "\uFFFD\x1b", data["_fields"].(map[string]interface{})["k"])
+}
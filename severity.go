@@ -0,0 +1,137 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// Severity is a severity on the scale of an interop target,
+// e.g. syslog severity 3 - err
+type Severity struct {
+	Code int
+	Name string
+}
+
+// SeverityMapping maps levels >= MinLevel to Severity
+type SeverityMapping struct {
+	MinLevel Level
+	Severity Severity
+}
+
+// SeverityMap is a list of SeverityMapping ordered by MinLevel,
+// a level is mapped by the highest MinLevel not exceeding it,
+// so custom levels fall into the nearest bracket below them,
+// levels below the lowest MinLevel are mapped by the lowest one
+type SeverityMap []SeverityMapping
+
+// NewSeverityMap returns a SeverityMap sorted by MinLevel
+func NewSeverityMap(mappings ...SeverityMapping) SeverityMap {
+	m := make(SeverityMap, len(mappings))
+	copy(m, mappings)
+	sort.SliceStable(m, func(i, j int) bool {
+		return m[i].MinLevel < m[j].MinLevel
+	})
+	return m
+}
+
+// Resolve returns the Severity of the level,
+// returns zero Severity if the map is empty
+func (m SeverityMap) Resolve(level Level) Severity {
+	if len(m) == 0 {
+		return Severity{}
+	}
+	// the first mapping whose MinLevel exceeds level
+	i := sort.Search(len(m), func(i int) bool {
+		return m[i].MinLevel > level
+	})
+	if i == 0 {
+		return m[0].Severity
+	}
+	return m[i-1].Severity
+}
+
+var (
+	// SyslogSeverityMap maps levels to syslog severities (RFC 5424)
+	SyslogSeverityMap = NewSeverityMap(
+		SeverityMapping{DebugLevel, Severity{7, "debug"}},
+		SeverityMapping{InfoLevel, Severity{6, "info"}},
+		SeverityMapping{WarnLevel, Severity{4, "warning"}},
+		SeverityMapping{ErrorLevel, Severity{3, "err"}},
+		SeverityMapping{NoticeLevel, Severity{5, "notice"}},
+		SeverityMapping{FatalLevel, Severity{2, "crit"}},
+	)
+
+	// GELFSeverityMap maps levels to GELF levels,
+	// which are the same as syslog severities
+	GELFSeverityMap = SyslogSeverityMap
+
+	// SentrySeverityMap maps levels to sentry levels
+	SentrySeverityMap = NewSeverityMap(
+		SeverityMapping{DebugLevel, Severity{0, "debug"}},
+		SeverityMapping{InfoLevel, Severity{1, "info"}},
+		SeverityMapping{WarnLevel, Severity{2, "warning"}},
+		SeverityMapping{ErrorLevel, Severity{3, "error"}},
+		SeverityMapping{NoticeLevel, Severity{1, "info"}},
+		SeverityMapping{FatalLevel, Severity{4, "fatal"}},
+	)
+
+	// GCPSeverityMap maps levels to google cloud logging severities
+	GCPSeverityMap = NewSeverityMap(
+		SeverityMapping{NothingLevel, Severity{0, "DEFAULT"}},
+		SeverityMapping{DebugLevel, Severity{100, "DEBUG"}},
+		SeverityMapping{InfoLevel, Severity{200, "INFO"}},
+		SeverityMapping{WarnLevel, Severity{400, "WARNING"}},
+		SeverityMapping{ErrorLevel, Severity{500, "ERROR"}},
+		SeverityMapping{NoticeLevel, Severity{300, "NOTICE"}},
+		SeverityMapping{FatalLevel, Severity{600, "CRITICAL"}},
+	)
+
+	// OTelSeverityMap maps levels to opentelemetry severity numbers
+	OTelSeverityMap = NewSeverityMap(
+		SeverityMapping{DebugLevel, Severity{5, "DEBUG"}},
+		SeverityMapping{InfoLevel, Severity{9, "INFO"}},
+		SeverityMapping{WarnLevel, Severity{13, "WARN"}},
+		SeverityMapping{ErrorLevel, Severity{17, "ERROR"}},
+		SeverityMapping{NoticeLevel, Severity{10, "INFO2"}},
+		SeverityMapping{FatalLevel, Severity{21, "FATAL"}},
+	)
+)
+
+// SeverityMapper is the base of interop handlers which convert levels
+// to the severities of their targets, the handler sets its default
+// SeverityMap on construction
+type SeverityMapper struct {
+	mapping atomic.Value
+}
+
+// SetSeverityMapping replaces the SeverityMap of the handler,
+// e.g. to map custom levels, it is safe to be called while
+// the handler is in use
+func (s *SeverityMapper) SetSeverityMapping(m SeverityMap) {
+	s.mapping.Store(NewSeverityMap(m...))
+}
+
+// SeverityMapping returns the SeverityMap of the handler
+func (s *SeverityMapper) SeverityMapping() SeverityMap {
+	m, _ := s.mapping.Load().(SeverityMap)
+	return m
+}
+
+// ResolveSeverity returns the Severity of the level
+func (s *SeverityMapper) ResolveSeverity(level Level) Severity {
+	return s.SeverityMapping().Resolve(level)
+}
@@ -0,0 +1,91 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeverityMapResolve(t *testing.T) {
+	m := NewSeverityMap(
+		SeverityMapping{ErrorLevel, Severity{3, "error"}},
+		SeverityMapping{DebugLevel, Severity{1, "debug"}},
+	)
+	// sorted by MinLevel
+	assert.Equal(t, DebugLevel, m[0].MinLevel)
+	assert.Equal(t, "debug", m.Resolve(NothingLevel).Name)
+	assert.Equal(t, "debug", m.Resolve(DebugLevel).Name)
+	assert.Equal(t, "debug", m.Resolve(ErrorLevel-1).Name)
+	assert.Equal(t, "error", m.Resolve(ErrorLevel).Name)
+	assert.Equal(t, "error", m.Resolve(AllLevel).Name)
+	assert.Equal(t, Severity{}, SeverityMap{}.Resolve(InfoLevel))
+}
+
+func TestDefaultSeverityMaps(t *testing.T) {
+	// a custom level falls into the nearest bracket below it
+	custom := Level(12)
+	RegisterLevel("CUSTOM", custom)
+
+	cases := []struct {
+		m        SeverityMap
+		level    Level
+		expected Severity
+	}{
+		{SyslogSeverityMap, NothingLevel, Severity{7, "debug"}},
+		{SyslogSeverityMap, DebugLevel, Severity{7, "debug"}},
+		{SyslogSeverityMap, InfoLevel, Severity{6, "info"}},
+		{SyslogSeverityMap, WarnLevel - 1, Severity{6, "info"}},
+		{SyslogSeverityMap, WarnLevel, Severity{4, "warning"}},
+		{SyslogSeverityMap, ErrorLevel, Severity{3, "err"}},
+		{SyslogSeverityMap, custom, Severity{3, "err"}},
+		{SyslogSeverityMap, NoticeLevel, Severity{5, "notice"}},
+		{SyslogSeverityMap, FatalLevel, Severity{2, "crit"}},
+		{SyslogSeverityMap, AllLevel, Severity{2, "crit"}},
+		{GELFSeverityMap, ErrorLevel, Severity{3, "err"}},
+		{SentrySeverityMap, DebugLevel, Severity{0, "debug"}},
+		{SentrySeverityMap, WarnLevel, Severity{2, "warning"}},
+		{SentrySeverityMap, custom, Severity{3, "error"}},
+		{SentrySeverityMap, FatalLevel, Severity{4, "fatal"}},
+		{GCPSeverityMap, NothingLevel, Severity{0, "DEFAULT"}},
+		{GCPSeverityMap, InfoLevel, Severity{200, "INFO"}},
+		{GCPSeverityMap, ErrorLevel, Severity{500, "ERROR"}},
+		{GCPSeverityMap, NoticeLevel, Severity{300, "NOTICE"}},
+		{GCPSeverityMap, FatalLevel + 1, Severity{600, "CRITICAL"}},
+		{OTelSeverityMap, DebugLevel, Severity{5, "DEBUG"}},
+		{OTelSeverityMap, ErrorLevel, Severity{17, "ERROR"}},
+		{OTelSeverityMap, FatalLevel, Severity{21, "FATAL"}},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, c.m.Resolve(c.level), "level %v", c.level)
+	}
+}
+
+func TestSeverityMapper(t *testing.T) {
+	var s SeverityMapper
+	assert.Equal(t, Severity{}, s.ResolveSeverity(InfoLevel))
+
+	s.SetSeverityMapping(SyslogSeverityMap)
+	assert.Equal(t, Severity{6, "info"}, s.ResolveSeverity(InfoLevel))
+
+	// unsorted mappings are sorted
+	s.SetSeverityMapping(SeverityMap{
+		{ErrorLevel, Severity{2, "high"}},
+		{NothingLevel, Severity{1, "low"}},
+	})
+	assert.Equal(t, Severity{1, "low"}, s.ResolveSeverity(WarnLevel))
+	assert.Equal(t, Severity{2, "high"}, s.ResolveSeverity(FatalLevel))
+}
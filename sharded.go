@@ -0,0 +1,298 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultShardFlushInterval is the default interval
+	// ShardedHandler writes staged records
+	DefaultShardFlushInterval = 100 * time.Millisecond
+	// DefaultShardFlushSize is the default staged bytes of a shard
+	// which makes ShardedHandler write before the interval elapsed
+	DefaultShardFlushSize = 64 << 10
+)
+
+// shard stages formatted records, ends[i] is the end offset of
+// the i-th record in buf and times[i] is its creation time
+type shard struct {
+	mu    sync.Mutex
+	buf   []byte
+	ends  []int
+	times []int64
+	// pad avoids false sharing between shards
+	_ [64]byte
+}
+
+// ShardedHandler is a high-concurrency alternative to WriterHandler
+// for many goroutines logging to one writer, e.g. a file.
+// Emit formats the record into one of Shards staging buffers chosen
+// round-robin, so goroutines rarely wait for each other, and a single
+// flusher merges the shards by record time and writes them to Output
+// with one Write every FlushInterval, or as soon as a shard has
+// staged FlushSize bytes.
+//
+// Ordering trade-off: records of one shard keep their order, records
+// are merged across shards by their creation time, so the output is
+// only roughly time ordered within a flush interval, and two records
+// emitted one after another by the same goroutine may be swapped if
+// they were created at nearly the same time. Records are not written
+// until they are flushed, call Flush or Close before exiting.
+type ShardedHandler struct {
+	Name string
+	// Deprecated: Level should not be changed directly while the
+	// handler is in use, use SetLevel and GetLevel instead
+	Level      Level
+	Formatter  Formatter
+	Output     io.Writer
+	OwnsWriter bool
+	// Shards is the number of staging buffers,
+	// 0 means runtime.GOMAXPROCS(0)
+	Shards int
+	// FlushInterval is the max duration records are staged,
+	// 0 means DefaultShardFlushInterval
+	FlushInterval time.Duration
+	// FlushSize is the staged bytes of a shard which triggers
+	// flushing, 0 means DefaultShardFlushSize
+	FlushSize int
+
+	shards  []shard
+	next    uint32
+	writeMu sync.Mutex
+	// staged and out are reused under writeMu, out is
+	// the merged records of all shards
+	staged  []shard
+	heads   []int
+	out     []byte
+	kick    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+	closed  uint32
+	Filterer
+}
+
+// NewShardedHandler returns a new ShardedHandler fully initialized,
+// which writes to w, the flusher is started on the first Emit
+func NewShardedHandler(w io.Writer, options ...Option) *ShardedHandler {
+	hdlr := &ShardedHandler{
+		Name:      "",
+		Level:     NothingLevel,
+		Formatter: DefaultFormatter,
+		Output:    w,
+	}
+
+	applyOptions(hdlr, options...)
+
+	return hdlr
+}
+
+// start initializes shards and starts the flusher only once
+func (hdlr *ShardedHandler) start() {
+	hdlr.once.Do(func() {
+		n := hdlr.Shards
+		if n <= 0 {
+			n = runtime.GOMAXPROCS(0)
+		}
+		if hdlr.FlushInterval <= 0 {
+			hdlr.FlushInterval = DefaultShardFlushInterval
+		}
+		if hdlr.FlushSize <= 0 {
+			hdlr.FlushSize = DefaultShardFlushSize
+		}
+		hdlr.shards = make([]shard, n)
+		hdlr.kick = make(chan struct{}, 1)
+		hdlr.done = make(chan struct{})
+		hdlr.stopped = make(chan struct{})
+		go hdlr.flushLoop()
+	})
+}
+
+func (hdlr *ShardedHandler) flushLoop() {
+	defer close(hdlr.stopped)
+	ticker := NewTicker(hdlr.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.Chan():
+		case <-hdlr.kick:
+		case <-hdlr.done:
+			return
+		}
+		if err := hdlr.writeShards(); err != nil {
+			InternalError(fmt.Errorf("Write staged records failed, [%v]", err))
+		}
+	}
+}
+
+// Emit formats the record into a staging buffer
+func (hdlr *ShardedHandler) Emit(record *LogRecord) {
+	if record.Level < hdlr.GetLevel() {
+		return
+	}
+
+	if hdlr.Output == nil || hdlr.Formatter == nil {
+		panic("you should set output and fomatter before use this handler")
+	}
+
+	hdlr.start()
+	if atomic.LoadUint32(&hdlr.closed) == 1 {
+		return
+	}
+
+	s := &hdlr.shards[atomic.AddUint32(&hdlr.next, 1)%uint32(len(hdlr.shards))]
+	s.mu.Lock()
+	buf, err := AppendFormat(s.buf, hdlr.Formatter, record)
+	if err != nil {
+		s.mu.Unlock()
+		InternalError(fmt.Errorf("Format record failed, [%v]", err))
+		return
+	}
+	s.buf = append(buf, '\n')
+	s.ends = append(s.ends, len(s.buf))
+	s.times = append(s.times, record.Time.UnixNano())
+	full := len(s.buf) >= hdlr.FlushSize
+	s.mu.Unlock()
+
+	if full {
+		select {
+		case hdlr.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// writeShards merges staged records of all shards by time
+// and writes them with one Write
+func (hdlr *ShardedHandler) writeShards() error {
+	hdlr.writeMu.Lock()
+	defer hdlr.writeMu.Unlock()
+
+	// take the staged records and leave the shards empty,
+	// keeping their capacity, so Emit only waits for copying
+	if hdlr.staged == nil {
+		hdlr.staged = make([]shard, len(hdlr.shards))
+		hdlr.heads = make([]int, len(hdlr.shards))
+	}
+	staged, heads := hdlr.staged, hdlr.heads
+	for i := range hdlr.shards {
+		s := &hdlr.shards[i]
+		s.mu.Lock()
+		staged[i].buf = append(staged[i].buf[:0], s.buf...)
+		staged[i].ends = append(staged[i].ends[:0], s.ends...)
+		staged[i].times = append(staged[i].times[:0], s.times...)
+		s.buf, s.ends, s.times = s.buf[:0], s.ends[:0], s.times[:0]
+		s.mu.Unlock()
+		heads[i] = 0
+	}
+
+	out := hdlr.out[:0]
+	for {
+		// pick the shard whose next record is the oldest
+		min := -1
+		for i := range staged {
+			if heads[i] < len(staged[i].ends) &&
+				(min < 0 || staged[i].times[heads[i]] < staged[min].times[heads[min]]) {
+				min = i
+			}
+		}
+		if min < 0 {
+			break
+		}
+		start := 0
+		if heads[min] > 0 {
+			start = staged[min].ends[heads[min]-1]
+		}
+		out = append(out, staged[min].buf[start:staged[min].ends[heads[min]]]...)
+		heads[min]++
+	}
+	hdlr.out = out
+
+	if len(out) == 0 {
+		return nil
+	}
+	_, err := hdlr.Output.Write(out)
+	return err
+}
+
+// ShouldEmit checks if handler should emit the specified record
+func (hdlr *ShardedHandler) ShouldEmit(record *LogRecord) bool {
+	return record.Level >= hdlr.GetLevel() && hdlr.PassFilters(record)
+}
+
+// Filter checks if handler should filter the specified record.
+// Deprecated: use ShouldEmit instead
+func (hdlr *ShardedHandler) Filter(record *LogRecord) bool {
+	return !hdlr.ShouldEmit(record)
+}
+
+// SetLevel sets the handler's level, it is safe to be called
+// while the handler is in use
+func (hdlr *ShardedHandler) SetLevel(level Level) {
+	storeLevel(&hdlr.Level, level)
+}
+
+// GetLevel returns the handler's level
+func (hdlr *ShardedHandler) GetLevel() Level {
+	return loadLevel(&hdlr.Level)
+}
+
+// Flush writes all staged records, then flushes the writer
+// if it supports Flush() or Sync()
+func (hdlr *ShardedHandler) Flush() error {
+	hdlr.start()
+	err := hdlr.writeShards()
+	hdlr.writeMu.Lock()
+	defer hdlr.writeMu.Unlock()
+	if ferr := flushOutput(hdlr.Output); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// Close stops the flusher, writes all staged records and flushes the
+// writer, then closes it if OwnsWriter is true. Records emitted after
+// Close are dropped
+func (hdlr *ShardedHandler) Close() error {
+	hdlr.start()
+	if !atomic.CompareAndSwapUint32(&hdlr.closed, 0, 1) {
+		return nil
+	}
+	close(hdlr.done)
+	<-hdlr.stopped
+
+	err := hdlr.writeShards()
+	hdlr.writeMu.Lock()
+	defer hdlr.writeMu.Unlock()
+	if ferr := flushOutput(hdlr.Output); err == nil {
+		err = ferr
+	}
+	if !hdlr.OwnsWriter {
+		return err
+	}
+	if c, ok := hdlr.Output.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
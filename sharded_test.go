@@ -0,0 +1,150 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestShardedHandler(t *testing.T) {
+	var out lockedBuffer
+	hdlr := NewShardedHandler(&out, &TextFormatter{Fmt: "%(message)"}, InfoLevel)
+	hdlr.Shards = 4
+	hdlr.FlushInterval = time.Hour
+	assert.Implements(t, (*Handler)(nil), hdlr)
+
+	start := time.Now()
+	for i := 0; i < 8; i++ {
+		record := NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "%d", i)
+		record.Time = start.Add(time.Duration(i))
+		hdlr.Emit(record)
+	}
+	hdlr.Emit(NewLogRecord("test", DebugLevel, "a/b.go", "a.b", 1, "filtered"))
+	// nothing is written until flushed
+	assert.Equal(t, "", out.String())
+
+	assert.Nil(t, hdlr.Flush())
+	assert.Equal(t, "0\n1\n2\n3\n4\n5\n6\n7\n", out.String())
+
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "last"))
+	assert.Nil(t, hdlr.Close())
+	assert.Equal(t, "0\n1\n2\n3\n4\n5\n6\n7\nlast\n", out.String())
+
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "dropped"))
+	assert.Nil(t, hdlr.Close())
+	assert.NotContains(t, out.String(), "dropped")
+}
+
+func TestShardedHandlerFlushSize(t *testing.T) {
+	var out lockedBuffer
+	hdlr := NewShardedHandler(&out, &TextFormatter{Fmt: "%(message)"})
+	hdlr.Shards = 1
+	hdlr.FlushInterval = time.Hour
+	hdlr.FlushSize = 10
+	defer hdlr.Close()
+
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "0123456789"))
+	deadline := time.Now().Add(time.Second)
+	for out.String() == "" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, "0123456789\n", out.String())
+}
+
+func TestShardedHandlerConcurrent(t *testing.T) {
+	var out lockedBuffer
+	hdlr := NewShardedHandler(&out, &TextFormatter{Fmt: "%(message)"})
+	hdlr.FlushInterval = time.Millisecond
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "%d-%d", i, j))
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Nil(t, hdlr.Close())
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	assert.Len(t, lines, 1000)
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		seen[line] = true
+	}
+	assert.Len(t, seen, 1000)
+}
+
+// benchmarkContention emits from 200 goroutines with GOMAXPROCS=8
+func benchmarkContention(b *testing.B, hdlr Handler) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+	defer hdlr.Close()
+	record := NewLogRecord("bench", InfoLevel, "pkg/file.go", "func", 10, "", "test")
+	b.SetParallelism(25)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			hdlr.Emit(record)
+		}
+	})
+}
+
+func openDevNull(b *testing.B) *os.File {
+	f, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(fmt.Sprintf("open %s failed: %v", os.DevNull, err))
+	}
+	return f
+}
+
+func BenchmarkContentionWriterHandler(b *testing.B) {
+	hdlr := NewWriterHandler("", openDevNull(b), DefaultFormatter, NothingLevel, OptionOwnsWriter(true))
+	benchmarkContention(b, hdlr)
+}
+
+func BenchmarkContentionShardedHandler(b *testing.B) {
+	hdlr := NewShardedHandler(openDevNull(b), DefaultFormatter, OptionOwnsWriter(true))
+	benchmarkContention(b, hdlr)
+}
@@ -0,0 +1,302 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+var (
+	// ExitFlushTimeout is the max duration Fatal, FlushAndExit and
+	// SignalFlusher wait for the shutdown before the process exits
+	ExitFlushTimeout = 5 * time.Second
+
+	// osExit is replaced in tests
+	osExit = os.Exit
+)
+
+// ContextCloser is implemented by handlers whose Close can be aborted,
+// e.g. network handlers waiting for an unresponsive remote
+type ContextCloser interface {
+	// CloseContext is like Close, but abandons the records not sent
+	// yet and returns a *CloseAbortedError once ctx is done
+	CloseContext(ctx context.Context) error
+}
+
+// CloseAbortedError is returned when closing a handler is aborted
+// by a context, Abandoned is the number of records not sent,
+// or -1 if it is unknown
+type CloseAbortedError struct {
+	Handler   string
+	Abandoned int
+	Err       error
+}
+
+func (e *CloseAbortedError) Error() string {
+	name := e.Handler
+	if name == "" {
+		name = "handler"
+	}
+	lost := "unknown number of records"
+	if e.Abandoned >= 0 {
+		lost = fmt.Sprintf("%d records", e.Abandoned)
+	}
+	return fmt.Sprintf("close %s aborted, %s abandoned, [%v]", name, lost, e.Err)
+}
+
+// Unwrap returns the error of the context
+func (e *CloseAbortedError) Unwrap() error {
+	return e.Err
+}
+
+// CloseContext closes the handler by its CloseContext if it implements
+// ContextCloser, otherwise Close is called in background and abandoned
+// once ctx is done
+func CloseContext(ctx context.Context, hdlr Handler) error {
+	if c, ok := hdlr.(ContextCloser); ok {
+		return c.CloseContext(ctx)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- hdlr.Close()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return &CloseAbortedError{Handler: HandlerName(hdlr), Abandoned: -1, Err: ctx.Err()}
+	}
+}
+
+// Shutdown flushes and closes the handlers of all registered loggers,
+// a handler shared by several loggers is closed only once.
+// It returns ctx.Err() if ctx is done before all handlers are closed,
+// handlers implementing ContextCloser abandon the records not sent,
+// the remaining ones are still closed in background
+func Shutdown(ctx context.Context) error {
+	return shutdownLoggers(ctx, registeredLoggers())
+}
+
+func registeredLoggers() []*Logger {
+	values := loggers.Values()
+	lgs := make([]*Logger, 0, len(values))
+	for _, v := range values {
+		lgs = append(lgs, v.(*Logger))
+	}
+	return lgs
+}
+
+// ExitFlush shuts down the handlers of all registered loggers and the
+// default logger before the process exits, which drains AsyncHandler
+// queues, flushes buffered writers and sends pending batches.
+// It waits at most timeout, so a dead collector can not hang the exit,
+// and returns context.DeadlineExceeded if the timeout elapsed.
+// Fatal calls it with ExitFlushTimeout, call it from custom signal
+// handlers or exit hooks before os.Exit
+func ExitFlush(timeout time.Duration) error {
+	return exitFlush(timeout)
+}
+
+// exitFlush is ExitFlush including the handlers of lgs,
+// which may be unregistered, e.g. derived by With
+func exitFlush(timeout time.Duration, lgs ...*Logger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	all := append(registeredLoggers(), Default())
+	for _, lg := range lgs {
+		if lg != nil {
+			all = append(all, lg)
+		}
+	}
+	return shutdownLoggers(ctx, all)
+}
+
+func shutdownLoggers(ctx context.Context, lgs []*Logger) error {
+	seen := make(map[Handler]bool)
+	hdlrs := make([]Handler, 0, len(lgs))
+	for _, lg := range lgs {
+		for _, hdlr := range lg.Handlers {
+			if hdlr == nil {
+				continue
+			}
+			// handlers which are not comparable can not be shared
+			if reflect.TypeOf(hdlr).Comparable() {
+				if seen[hdlr] {
+					continue
+				}
+				seen[hdlr] = true
+			}
+			hdlrs = append(hdlrs, hdlr)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, hdlr := range hdlrs {
+			if c, ok := hdlr.(ContextCloser); ok {
+				// Close sends the records, Flush could hang
+				if err := c.CloseContext(ctx); err != nil {
+					InternalError(fmt.Errorf("Close handler failed, [%v]", err))
+				}
+				continue
+			}
+			if err := hdlr.Flush(); err != nil {
+				InternalError(fmt.Errorf("Flush handler failed, [%v]", err))
+			}
+			if err := hdlr.Close(); err != nil {
+				InternalError(fmt.Errorf("Close handler failed, [%v]", err))
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SignalFlusher shuts down the handlers of all registered loggers and the
+// default logger when the process receives a signal, so records queued in
+// AsyncHandlers and buffered writers are written before it terminates.
+//
+// It is notified by signal.Notify on its own channel, application handlers
+// notified for the same signals still receive them. The ordering is: the
+// handlers are shut down, waiting at most Timeout, then OnSignal is called,
+// or the signal is re-raised after the notification of the SignalFlusher
+// is stopped. The re-raised signal terminates the process by the default
+// behavior only if no other channel is notified for it, otherwise it is
+// delivered to the application once more, which decides when to exit
+type SignalFlusher struct {
+	// Signals to flush on, SIGINT and SIGTERM if empty
+	Signals []os.Signal
+	// Timeout bounds the shutdown, ExitFlushTimeout if zero
+	Timeout time.Duration
+	// OnSignal is called after the shutdown instead of re-raising the
+	// signal, e.g. to cancel the main context of the application
+	OnSignal func(os.Signal)
+}
+
+// Install installs the handler, it is uninstalled when ctx is done,
+// the returned stop function is called or the signal is handled.
+// stop waits until it is uninstalled
+func (sf *SignalFlusher) Install(ctx context.Context) (stop func()) {
+	signals := sf.Signals
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	timeout := sf.Timeout
+	if timeout <= 0 {
+		timeout = ExitFlushTimeout
+	}
+	onSignal := sf.OnSignal
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, signals...)
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		signalFlush(ctx, sigs, timeout, flushAll, func(sig os.Signal) {
+			// restore the default behavior before re-raising
+			signal.Stop(sigs)
+			if onSignal != nil {
+				onSignal(sig)
+				return
+			}
+			raiseSignal(sig)
+		})
+		signal.Stop(sigs)
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// FlushOnSignal installs a SignalFlusher for the signals, SIGINT and SIGTERM
+// if none is given, which shuts down the handlers waiting at most
+// ExitFlushTimeout and then re-raises the signal.
+// Call the returned stop function to uninstall it
+func FlushOnSignal(signals ...os.Signal) (stop func()) {
+	sf := &SignalFlusher{Signals: signals}
+	return sf.Install(context.Background())
+}
+
+// InstallSignalFlush installs a SignalFlusher for SIGINT and SIGTERM which
+// is uninstalled when ctx is done or the returned stop function is called
+func InstallSignalFlush(ctx context.Context) (stop func()) {
+	sf := &SignalFlusher{}
+	return sf.Install(ctx)
+}
+
+// FlushAndExit shuts down the handlers like ExitFlush, waiting at most
+// ExitFlushTimeout, and then exits with the code. It installs nothing,
+// call it from the signal handling of the application
+func FlushAndExit(code int) {
+	exit(nil, code)
+}
+
+// flushAll shuts down the handlers of all registered loggers and
+// the default logger
+func flushAll(ctx context.Context) error {
+	return shutdownLoggers(ctx, append(registeredLoggers(), Default()))
+}
+
+// signalFlush waits for a signal and shuts down before raising it,
+// it returns when ctx is done or the signal is raised
+func signalFlush(ctx context.Context, sigs <-chan os.Signal, timeout time.Duration, shutdown func(context.Context) error, raise func(os.Signal)) {
+	select {
+	case sig := <-sigs:
+		sctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		if err := shutdown(sctx); err != nil {
+			InternalError(fmt.Errorf("Shutdown failed, [%v]", err))
+		}
+		raise(sig)
+	case <-ctx.Done():
+	}
+}
+
+// exit flushes the handlers of lg and all registered loggers by
+// ExitFlush, waiting at most ExitFlushTimeout, and then exits with the code
+func exit(lg *Logger, code int) {
+	if err := exitFlush(ExitFlushTimeout, lg); err != nil {
+		InternalError(fmt.Errorf("Shutdown failed, [%v]", err))
+	}
+	osExit(code)
+}
+
+// raiseSignal sends the signal to the current process,
+// exits if the signal can not be sent
+func raiseSignal(sig os.Signal) {
+	p, err := os.FindProcess(os.Getpid())
+	if err == nil {
+		err = p.Signal(sig)
+	}
+	if err != nil {
+		osExit(1)
+	}
+}
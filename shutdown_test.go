@@ -0,0 +1,190 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// closeCounter counts Flush and Close calls
+type closeCounter struct {
+	NullHandler
+	mu      sync.Mutex
+	flushed int
+	closed  int
+	block   chan struct{}
+}
+
+func (hdlr *closeCounter) Flush() error {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	hdlr.flushed++
+	return nil
+}
+
+func (hdlr *closeCounter) Close() error {
+	if hdlr.block != nil {
+		<-hdlr.block
+	}
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+	hdlr.closed++
+	return nil
+}
+
+func TestShutdownLoggers(t *testing.T) {
+	shared := &closeCounter{}
+	other := &closeCounter{}
+	lg1 := NewLogger(OptionHandlers(shared))
+	lg2 := NewLogger(OptionHandlers(shared, other))
+
+	assert.Nil(t, shutdownLoggers(context.Background(), []*Logger{lg1, lg2}))
+	assert.Equal(t, 1, shared.flushed)
+	assert.Equal(t, 1, shared.closed)
+	assert.Equal(t, 1, other.flushed)
+	assert.Equal(t, 1, other.closed)
+}
+
+func TestShutdownLoggersTimeout(t *testing.T) {
+	hdlr := &closeCounter{block: make(chan struct{})}
+	defer close(hdlr.block)
+	lg := NewLogger(OptionHandlers(hdlr))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, shutdownLoggers(ctx, []*Logger{lg}))
+}
+
+func TestFatalDrainsAsyncHandler(t *testing.T) {
+	var out lockedBuffer
+	inner := NewWriterHandler("", &out, &TextFormatter{Fmt: "%(message)"}, NothingLevel)
+	// the logger is not registered, it is flushed as the caller of Fatal
+	logger := NewLogger(OptionHandlers(NewAsyncHandler(inner, 16)))
+
+	code, restore := stubExit()
+	defer restore()
+	for i := 0; i < 10000; i++ {
+		logger.Infof("%d", i)
+	}
+	logger.Fatal("bye")
+
+	assert.Equal(t, 1, *code)
+	assert.Equal(t, 10001, strings.Count(out.String(), "\n"))
+}
+
+func TestExitFlushTimeout(t *testing.T) {
+	hdlr := &closeCounter{block: make(chan struct{})}
+	defer close(hdlr.block)
+	SetDefault(NewLogger(OptionHandlers(hdlr)))
+	defer SetDefault(nil)
+
+	assert.Equal(t, context.DeadlineExceeded, ExitFlush(10*time.Millisecond))
+}
+
+func TestSignalFlush(t *testing.T) {
+	sigs := make(chan os.Signal, 1)
+	sigs <- syscall.SIGTERM
+
+	var order []string
+	signalFlush(context.Background(), sigs, time.Second, func(ctx context.Context) error {
+		order = append(order, "shutdown")
+		return nil
+	}, func(sig os.Signal) {
+		order = append(order, sig.String())
+	})
+	assert.Equal(t, []string{"shutdown", syscall.SIGTERM.String()}, order)
+
+	// returns without shutting down when ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	signalFlush(ctx, make(chan os.Signal), time.Second, func(ctx context.Context) error {
+		t.Fatal("should not shut down")
+		return nil
+	}, nil)
+}
+
+func TestInstallSignalFlush(t *testing.T) {
+	stop := InstallSignalFlush(context.Background())
+	stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop = InstallSignalFlush(ctx)
+	cancel()
+	stop()
+}
+
+func TestSignalFlusher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	file := NewFileHandler(&TextFormatter{Fmt: "%(message)"}).SetPath(path)
+	SetDefault(NewLogger(OptionHandlers(NewAsyncHandler(file, 16))))
+	defer SetDefault(nil)
+
+	handled := make(chan os.Signal, 1)
+	sf := &SignalFlusher{
+		Signals: []os.Signal{syscall.SIGUSR1},
+		Timeout: time.Second,
+		OnSignal: func(sig os.Signal) {
+			handled <- sig
+		},
+	}
+	stop := sf.Install(context.Background())
+	defer stop()
+
+	for i := 0; i < 1000; i++ {
+		Infof("%d", i)
+	}
+	assert.Nil(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	select {
+	case sig := <-handled:
+		assert.Equal(t, syscall.SIGUSR1, sig)
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal not handled")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, strings.Count(string(data), "\n"))
+}
+
+func TestFlushOnSignal(t *testing.T) {
+	stop := FlushOnSignal(syscall.SIGUSR2)
+	stop()
+}
+
+func TestFlushAndExit(t *testing.T) {
+	hdlr := &closeCounter{}
+	SetDefault(NewLogger(OptionHandlers(hdlr)))
+	defer SetDefault(nil)
+
+	code, restore := stubExit()
+	defer restore()
+	FlushAndExit(3)
+	assert.Equal(t, 3, *code)
+	assert.Equal(t, 1, hdlr.closed)
+}
@@ -0,0 +1,130 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// DefaultStackMaxFrames is the default max number of frames
+// rendered by StackTracer
+const DefaultStackMaxFrames = 32
+
+// DefaultStackSkipPrefixes are the default prefixes of frames skipped
+// by StackTracer, the runtime and the logging package's own frames
+var DefaultStackSkipPrefixes = []string{
+	"runtime.",
+	"runtime/debug.",
+	reflect.TypeOf(Logger{}).PkgPath() + ".",
+}
+
+// StackTracer captures clean and bounded stack traces, frames whose
+// function names start with any of StackSkipPrefixes are skipped, and at
+// most StackMaxFrames frames are rendered, StackMaxFrames <= 0 means no
+// limit. Every frame is rendered like runtime/debug.Stack, e.g.
+//
+//	main.(*Server).handle
+//		/app/server.go:42
+//
+// followed by "... N more frames" if it is truncated
+type StackTracer struct {
+	StackMaxFrames    int
+	StackSkipPrefixes []string
+}
+
+// NewStackTracer returns a StackTracer with DefaultStackMaxFrames and
+// DefaultStackSkipPrefixes, use OptionStackMaxFrames and
+// OptionStackSkipPrefixes to change them
+func NewStackTracer(options ...Option) *StackTracer {
+	st := &StackTracer{
+		StackMaxFrames:    DefaultStackMaxFrames,
+		StackSkipPrefixes: DefaultStackSkipPrefixes,
+	}
+	applyOptions(st, options...)
+	return st
+}
+
+// Capture returns the stack trace of the calling goroutine,
+// skip is the number of extra stack frames to ascend
+func (st *StackTracer) Capture(skip int) string {
+	pcs := make([]uintptr, 64)
+	for {
+		n := runtime.Callers(skip+2, pcs)
+		if n < len(pcs) {
+			pcs = pcs[:n]
+			break
+		}
+		pcs = make([]uintptr, 2*len(pcs))
+	}
+
+	var b strings.Builder
+	rendered, omitted := 0, 0
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		if frame.Function != "" && !st.skip(frame.Function) {
+			if st.StackMaxFrames > 0 && rendered >= st.StackMaxFrames {
+				omitted++
+			} else {
+				rendered++
+				b.WriteString(frame.Function)
+				b.WriteString("\n\t")
+				b.WriteString(frame.File)
+				b.WriteByte(':')
+				b.WriteString(strconv.Itoa(frame.Line))
+				b.WriteByte('\n')
+			}
+		}
+		if !more {
+			break
+		}
+	}
+	if omitted > 0 {
+		b.WriteString("... ")
+		b.WriteString(strconv.Itoa(omitted))
+		b.WriteString(" more frames\n")
+	}
+	return b.String()
+}
+
+// skip checks if the function should be skipped
+func (st *StackTracer) skip(function string) bool {
+	for _, prefix := range st.StackSkipPrefixes {
+		if strings.HasPrefix(function, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Hook returns a hook for Logger.AddHook, which adds the stack trace to
+// records at the level or above as the StackFieldKey field
+func (st *StackTracer) Hook(level Level) func(*LogRecord) {
+	return func(record *LogRecord) {
+		if record.Level < level {
+			return
+		}
+		// the fields may be passed by caller, so they are copied
+		fields := make(Fields, len(record.Fields)+1)
+		for k, v := range record.Fields {
+			fields[k] = v
+		}
+		fields[StackFieldKey] = st.Capture(1)
+		record.Fields = fields
+	}
+}
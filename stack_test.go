@@ -0,0 +1,66 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//go:noinline
+func captureNested(st *StackTracer, depth int) string {
+	if depth == 0 {
+		return st.Capture(0)
+	}
+	return captureNested(st, depth-1)
+}
+
+func TestStackTracer(t *testing.T) {
+	st := NewStackTracer(OptionStackSkipPrefixes("runtime.", "testing."))
+	trace := captureNested(st, 2)
+	frames := strings.Split(strings.TrimSuffix(trace, "\n"), "\n")
+	assert.Len(t, frames, 8, trace)
+	assert.Equal(t, "github.com/zoumo/logdog.captureNested", frames[0])
+	assert.True(t, strings.HasPrefix(frames[1], "\t"))
+	assert.Contains(t, frames[1], "stack_test.go:")
+	assert.Equal(t, "github.com/zoumo/logdog.TestStackTracer", frames[6])
+
+	// bounded
+	st.StackMaxFrames = 2
+	trace = captureNested(st, 2)
+	assert.Equal(t, 2, strings.Count(trace, "captureNested\n"))
+	assert.True(t, strings.HasSuffix(trace, "\n... 2 more frames\n"), trace)
+
+	// the default skips runtime and logdog frames
+	trace = NewStackTracer().Capture(0)
+	assert.Equal(t, "testing.tRunner", strings.SplitN(trace, "\n", 2)[0])
+	assert.NotContains(t, trace, "runtime.")
+}
+
+func TestStackTracerHook(t *testing.T) {
+	hdlr := &recordHandler{}
+	logger := NewLogger(OptionHandlers(hdlr))
+	logger.AddHook(NewStackTracer(OptionStackMaxFrames(1)).Hook(WarnLevel))
+	fields := Fields{"k": "v"}
+	logger.Info("info")
+	logger.Warn("warn", fields)
+
+	assert.Nil(t, hdlr.records[0].Fields)
+	assert.Equal(t, "testing.tRunner", strings.SplitN(hdlr.records[1].Fields[StackFieldKey].(string), "\n", 2)[0])
+	// the fields passed by caller are not modified
+	assert.Len(t, fields, 1)
+}
@@ -0,0 +1,126 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// EmitStats is a snapshot of the statistics of a handler
+type EmitStats struct {
+	// Emitted is the number of records written
+	Emitted uint64
+	// Dropped is the number of records dropped without being written,
+	// e.g. emitted after Close, or shed by AsyncHandler
+	Dropped uint64
+	// Failed is the number of records failed to be formatted or written
+	Failed uint64
+	// LastError is the message of the last failure,
+	// LastErrorTime is its time
+	LastError     string
+	LastErrorTime time.Time
+	// QueueLength and QueueCapacity are set by handlers with
+	// a queue, e.g. AsyncHandler
+	QueueLength   int
+	QueueCapacity int
+	// Suppressed is the number of records dropped by the filters
+	// of the handler which report FilterStats, e.g. RateLimitFilter
+	Suppressed uint64
+}
+
+// StatsReporter is implemented by handlers which report EmitStats
+type StatsReporter interface {
+	Stats() EmitStats
+}
+
+// StatsCounter maintains EmitStats with atomics, it is the base of
+// handlers which report their statistics, like Filterer
+type StatsCounter struct {
+	emitted uint64
+	dropped uint64
+	failed  uint64
+	// lastErr stores the statsError of the last failure
+	lastErr atomic.Value
+}
+
+// statsError is the last failure of StatsCounter
+type statsError struct {
+	msg  string
+	time time.Time
+}
+
+// CountEmitted counts a written record
+func (c *StatsCounter) CountEmitted() {
+	atomic.AddUint64(&c.emitted, 1)
+}
+
+// CountDropped counts a dropped record
+func (c *StatsCounter) CountDropped() {
+	atomic.AddUint64(&c.dropped, 1)
+}
+
+// CountFailed counts a record failed to be formatted or written,
+// err is kept as the last error
+func (c *StatsCounter) CountFailed(err error) {
+	atomic.AddUint64(&c.failed, 1)
+	c.lastErr.Store(statsError{msg: err.Error(), time: Now()})
+}
+
+// count counts a written record if err is nil, a failed one if not
+func (c *StatsCounter) count(err error) {
+	if err != nil {
+		c.CountFailed(err)
+		return
+	}
+	c.CountEmitted()
+}
+
+// Stats returns the snapshot of the counters
+func (c *StatsCounter) Stats() EmitStats {
+	stats := EmitStats{
+		Emitted: atomic.LoadUint64(&c.emitted),
+		Dropped: atomic.LoadUint64(&c.dropped),
+		Failed:  atomic.LoadUint64(&c.failed),
+	}
+	if last, ok := c.lastErr.Load().(statsError); ok {
+		stats.LastError, stats.LastErrorTime = last.msg, last.time
+	}
+	return stats
+}
+
+// Suppressed returns the number of records dropped by the filters
+// which report FilterStats
+func (f *Filterer) Suppressed() uint64 {
+	var suppressed uint64
+	for _, filter := range f.Filters() {
+		if s, ok := filter.(interface{ Stats() FilterStats }); ok {
+			suppressed += s.Stats().Dropped
+		}
+	}
+	return suppressed
+}
+
+// HandlerStats returns the statistics of all handlers reporting them by
+// name, see AllHandlers, e.g. to dump them by a debug endpoint
+func HandlerStats() map[string]EmitStats {
+	all := make(map[string]EmitStats)
+	for name, hdlr := range AllHandlers() {
+		if reporter, ok := hdlr.(StatsReporter); ok {
+			all[name] = reporter.Stats()
+		}
+	}
+	return all
+}
@@ -0,0 +1,96 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingWriter fails every write after n writes
+type failingWriter struct {
+	n int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n <= 0 {
+		return 0, errors.New("disk full")
+	}
+	w.n--
+	return len(p), nil
+}
+
+func TestWriterHandlerStats(t *testing.T) {
+	SetClock(ClockFunc(func() time.Time {
+		return time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	}))
+	defer SetClock(nil)
+
+	hdlr := NewWriterHandler("stats", &failingWriter{n: 2}, &TextFormatter{Fmt: "%(message)"}, NothingLevel)
+	hdlr.AddFilters(NewRateLimitFilter(3, time.Hour))
+	logger := NewLogger(OptionHandlers(hdlr))
+	for i := 0; i < 5; i++ {
+		logger.Info("record")
+	}
+
+	assert.Equal(t, EmitStats{
+		Emitted:       2,
+		Failed:        1,
+		LastError:     "disk full",
+		LastErrorTime: Now(),
+		Suppressed:    2,
+	}, hdlr.Stats())
+}
+
+func TestAsyncHandlerStats(t *testing.T) {
+	inner := &gateHandler{open: make(chan struct{})}
+	hdlr := NewAsyncHandler(inner, 8)
+	emit := func() {
+		hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "msg"))
+	}
+	// the first record is taken by the loop which blocks in inner.Emit
+	emit()
+	for len(hdlr.queue) > 0 {
+		runtime.Gosched()
+	}
+	emit()
+	emit()
+	stats := hdlr.Stats()
+	assert.Equal(t, 2, stats.QueueLength)
+	assert.Equal(t, 8, stats.QueueCapacity)
+	assert.Equal(t, uint64(0), stats.Emitted)
+
+	close(inner.open)
+	assert.Nil(t, hdlr.Close())
+	emit()
+	stats = hdlr.Stats()
+	assert.Equal(t, uint64(3), stats.Emitted)
+	assert.Equal(t, uint64(1), stats.Dropped)
+	assert.Equal(t, 0, stats.QueueLength)
+}
+
+func TestHandlerStats(t *testing.T) {
+	hdlr := NewStreamHandler(OptionName("stats-stream"), OptionDiscardOutput())
+	RegisterHandler("stats-stream", hdlr)
+	hdlr.Emit(NewLogRecord("test", InfoLevel, "a/b.go", "a.b", 1, "msg"))
+
+	all := HandlerStats()
+	assert.Contains(t, all, "stats-stream")
+	assert.Equal(t, uint64(1), all["stats-stream"].Emitted)
+}
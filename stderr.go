@@ -0,0 +1,134 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// StderrLoggerName is the logger name of records captured from stderr
+	StderrLoggerName = "stderr"
+	// StderrGroupFieldKey is the field key grouping the lines of a
+	// panic or fatal error dump, its value is the number of the dump
+	StderrGroupFieldKey = "stderr_group"
+)
+
+// ErrCaptureUnsupported is returned by CaptureStderr
+// on platforms which can not redirect stderr
+var ErrCaptureUnsupported = errors.New("capturing stderr is not supported on this platform")
+
+// StderrCapture redirects the file descriptor 2 of the process into a pipe,
+// so runtime panics and messages of cgo or third-party C code reach a
+// handler, every line is emitted as a record and written to the original
+// stderr as well. Lines of a panic or fatal error dump are tagged by
+// StderrGroupFieldKey.
+//
+// The handler must not write to stderr, e.g. a StreamHandler, or its
+// output is captured again. The process exits right after the runtime
+// writes a fatal dump, so the dump reaches the handler on a best-effort
+// basis, it is written to the original stderr by the runtime itself
+// since go1.23
+type StderrCapture struct {
+	Handler Handler
+	Level   Level
+
+	// orig is a duplicate of the original stderr
+	orig *os.File
+	r    *os.File
+	w    *os.File
+	done chan struct{}
+	once sync.Once
+	err  error
+	// crashOutput is true if the runtime writes fatal dumps to orig
+	crashOutput bool
+}
+
+// CaptureStderr starts capturing stderr into the handler at the level,
+// Close restores the original stderr. It returns ErrCaptureUnsupported
+// on platforms other than unix
+func CaptureStderr(h Handler, level Level) (*StderrCapture, error) {
+	c := &StderrCapture{
+		Handler: h,
+		Level:   level,
+		done:    make(chan struct{}),
+	}
+	if err := c.redirect(); err != nil {
+		return nil, err
+	}
+	c.crashOutput = setCrashOutput(c.orig)
+	go c.loop()
+	return c, nil
+}
+
+// loop emits lines read from the pipe until it is closed
+func (c *StderrCapture) loop() {
+	defer close(c.done)
+	reader := bufio.NewReader(c.r)
+	group := 0
+	inGroup := false
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			if strings.HasPrefix(line, "panic: ") || strings.HasPrefix(line, "fatal error: ") {
+				// a dump lasts until the process exits
+				group++
+				inGroup = true
+			}
+			if !inGroup || !c.crashOutput {
+				// the runtime writes dumps to the original stderr itself
+				io.WriteString(c.orig, line)
+			}
+			c.emit(strings.TrimSuffix(line, "\n"), group, inGroup)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// emit emits the line as a record
+func (c *StderrCapture) emit(line string, group int, inGroup bool) {
+	record := NewLogRecord(StderrLoggerName, c.Level, "??", "??", 0, "", line)
+	if inGroup {
+		record.Fields = Fields{StderrGroupFieldKey: group}
+	}
+	if ShouldEmit(c.Handler, record) {
+		c.Handler.Emit(record)
+	}
+}
+
+// Close restores the original stderr, and waits for the captured lines
+// to be emitted, the handler is not closed
+func (c *StderrCapture) Close() error {
+	c.once.Do(func() {
+		setCrashOutput(nil)
+		c.err = c.restore()
+		// the pipe is closed after fd 2 is restored,
+		// so the loop reads all lines then EOF
+		if err := c.w.Close(); err != nil && c.err == nil {
+			c.err = err
+		}
+		<-c.done
+		c.r.Close()
+		c.orig.Close()
+	})
+	return c.err
+}
@@ -0,0 +1,29 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.23
+// +build go1.23
+
+package logdog
+
+import (
+	"os"
+	"runtime/debug"
+)
+
+// setCrashOutput makes the runtime write fatal dumps to f as well,
+// nil stops it, it returns true if it is supported
+func setCrashOutput(f *os.File) bool {
+	return debug.SetCrashOutput(f, debug.CrashOptions{}) == nil
+}
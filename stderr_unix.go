@@ -0,0 +1,50 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package logdog
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// redirect duplicates the original stderr and points fd 2 to a pipe
+func (c *StderrCapture) redirect() error {
+	fd, err := unix.Dup(2)
+	if err != nil {
+		return err
+	}
+	c.orig = os.NewFile(uintptr(fd), "/dev/stderr")
+
+	if c.r, c.w, err = os.Pipe(); err != nil {
+		c.orig.Close()
+		return err
+	}
+	if err := unix.Dup2(int(c.w.Fd()), 2); err != nil {
+		c.r.Close()
+		c.w.Close()
+		c.orig.Close()
+		return err
+	}
+	return nil
+}
+
+// restore points fd 2 back to the original stderr
+func (c *StderrCapture) restore() error {
+	return unix.Dup2(int(c.orig.Fd()), 2)
+}
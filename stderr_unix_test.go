@@ -0,0 +1,49 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package logdog
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCaptureStderr(t *testing.T) {
+	hdlr := &recordHandler{}
+	capture, err := CaptureStderr(hdlr, ErrorLevel)
+	assert.Nil(t, err)
+
+	fmt.Fprintln(os.Stderr, "captured line")
+	fmt.Fprint(os.Stderr, "panic: boom\n\ngoroutine 1 [running]:\n")
+	assert.Nil(t, capture.Close())
+	assert.Nil(t, capture.Close())
+
+	// stderr is restored
+	fmt.Fprintln(os.Stderr, "not captured")
+
+	msgs := memoryMessages(hdlr)
+	assert.Equal(t, []string{"captured line", "panic: boom", "", "goroutine 1 [running]:"}, msgs)
+	assert.Equal(t, StderrLoggerName, hdlr.records[0].Name)
+	assert.Equal(t, ErrorLevel, hdlr.records[0].Level)
+	assert.Nil(t, hdlr.records[0].Fields)
+	for _, record := range hdlr.records[1:] {
+		assert.Equal(t, Fields{StderrGroupFieldKey: 1}, record.Fields)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"strings"
+	"time"
+
+	"github.com/zoumo/logdog/pkg/when"
+)
+
+// timePathUnits are the time units a path pattern may change by,
+// from the finest one, each one shifts t by one unit
+var timePathUnits = []func(t time.Time) time.Time{
+	func(t time.Time) time.Time { return t.Add(time.Second) },
+	func(t time.Time) time.Time { return t.Add(time.Minute) },
+	func(t time.Time) time.Time { return t.Add(time.Hour) },
+	func(t time.Time) time.Time { return t.AddDate(0, 0, 1) },
+	func(t time.Time) time.Time { return t.AddDate(0, 1, 0) },
+	func(t time.Time) time.Time { return t.AddDate(1, 0, 0) },
+}
+
+// timePath is a file path with time tokens, e.g.
+// /var/log/app/%Y-%m-%d/app-%H.log or /var/log/app/{time:2006-01-02}/app.log,
+// it supports strftime directives and Go layouts in {time:layout}
+type timePath struct {
+	pattern string
+	// unit is the index of the finest unit in timePathUnits
+	// which changes the path
+	unit int
+}
+
+// parseTimePath returns nil if the path has no time tokens
+func parseTimePath(path string) *timePath {
+	if !strings.ContainsRune(path, '%') && !strings.Contains(path, "{time:") {
+		return nil
+	}
+	tp := &timePath{pattern: path}
+	// units do not carry from the reference time, so a unit
+	// changes the path only if the path has a token of it
+	ref := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	for i, shift := range timePathUnits {
+		if tp.format(ref) != tp.format(shift(ref)) {
+			tp.unit = i
+			return tp
+		}
+	}
+	// e.g. %% or unknown directives
+	return nil
+}
+
+// format resolves the path at t
+func (tp *timePath) format(t time.Time) string {
+	var dst []byte
+	pattern := tp.pattern
+	for {
+		i := strings.Index(pattern, "{time:")
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(pattern[i:], '}')
+		if j < 0 {
+			break
+		}
+		dst = when.AppendStrftime(dst, &t, pattern[:i])
+		dst = t.AppendFormat(dst, pattern[i+len("{time:"):i+j])
+		pattern = pattern[i+j+1:]
+	}
+	return string(when.AppendStrftime(dst, &t, pattern))
+}
+
+// next returns the start of the next unit after t, the path
+// may change from then on, so it is resolved again only then
+func (tp *timePath) next(t time.Time) time.Time {
+	y, m, d := t.Date()
+	h, min, s := t.Clock()
+	loc := t.Location()
+	switch tp.unit {
+	case 0:
+		return time.Date(y, m, d, h, min, s+1, 0, loc)
+	case 1:
+		return time.Date(y, m, d, h, min+1, 0, 0, loc)
+	case 2:
+		return time.Date(y, m, d, h+1, 0, 0, 0, loc)
+	case 3:
+		return time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+	case 4:
+		return time.Date(y, m+1, 1, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(y+1, 1, 1, 0, 0, 0, 0, loc)
+	}
+}
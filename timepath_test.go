@@ -0,0 +1,136 @@
+// Copyright 2016 Jim Zhang (jim.zoumo@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logdog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimePath(t *testing.T) {
+	assert.Nil(t, parseTimePath("/var/log/app.log"))
+	assert.Nil(t, parseTimePath("/var/log/100%%.log"))
+
+	now := time.Date(2024, 5, 31, 23, 30, 15, 0, time.Local)
+	tp := parseTimePath("/var/log/%Y-%m-%d/app-%H.log")
+	assert.NotNil(t, tp)
+	assert.Equal(t, "/var/log/2024-05-31/app-23.log", tp.format(now))
+	assert.Equal(t, time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local), tp.next(now))
+
+	tp = parseTimePath("/var/log/{time:2006/01}/app-%Y.log")
+	assert.NotNil(t, tp)
+	assert.Equal(t, "/var/log/2024/05/app-2024.log", tp.format(now))
+	assert.Equal(t, time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local), tp.next(now))
+
+	tp = parseTimePath("/var/log/app-{time:20060102T1504}.log")
+	assert.NotNil(t, tp)
+	assert.Equal(t, time.Date(2024, 5, 31, 23, 31, 0, 0, time.Local), tp.next(now))
+}
+
+func TestFileHandlerTimePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	SetClock(ClockFunc(func() time.Time {
+		return time.Date(2024, 5, 1, 10, 30, 0, 0, time.Local)
+	}))
+	defer SetClock(nil)
+
+	pattern := filepath.Join(dir, "%Y-%m-%d", "app-%H.log")
+	hdlr := NewFileHandler(&TextFormatter{Fmt: "%(message)"}).SetPath(pattern)
+	assert.Equal(t, pattern, hdlr.PathPattern)
+	assert.Equal(t, filepath.Join(dir, "2024-05-01", "app-10.log"), hdlr.Path)
+	logger := NewLogger(OptionHandlers(hdlr))
+
+	logger.Info("1")
+	logger.WithTime(time.Date(2024, 5, 1, 10, 59, 0, 0, time.Local)).Info("2")
+	logger.WithTime(time.Date(2024, 5, 2, 0, 0, 0, 0, time.Local)).Info("3")
+	// records earlier than the current file do not switch back
+	logger.WithTime(time.Date(2024, 5, 1, 11, 0, 0, 0, time.Local)).Info("4")
+	assert.Nil(t, hdlr.Close())
+
+	assert.Equal(t, filepath.Join(dir, "2024-05-02", "app-00.log"), hdlr.Path)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "2024-05-01", "app-10.log"))
+	assert.Nil(t, err)
+	assert.Equal(t, "1\n2\n", string(data))
+	data, err = ioutil.ReadFile(hdlr.Path)
+	assert.Nil(t, err)
+	assert.Equal(t, "3\n4\n", string(data))
+}
+
+func TestFileHandlerTimePathLocation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.Nil(t, err)
+	// records are in UTC, the day of Europe/Berlin before DST starts
+	SetClock(ClockFunc(func() time.Time {
+		return time.Date(2024, 3, 30, 22, 30, 0, 0, time.UTC)
+	}))
+	defer SetClock(nil)
+
+	pattern := filepath.Join(dir, "app-%Y-%m-%d.log")
+	hdlr := NewFileHandler(&TextFormatter{Fmt: "%(message)"}, OptionLocation(berlin)).SetPath(pattern)
+	assert.Equal(t, filepath.Join(dir, "app-2024-03-30.log"), hdlr.Path)
+	logger := NewLogger(OptionHandlers(hdlr))
+
+	// 00:30 CET
+	logger.WithTime(time.Date(2024, 3, 30, 23, 30, 0, 0, time.UTC)).Info("1")
+	// 23:30 CEST, the day has 23 hours
+	logger.WithTime(time.Date(2024, 3, 31, 21, 30, 0, 0, time.UTC)).Info("2")
+	// 00:30 CEST
+	logger.WithTime(time.Date(2024, 3, 31, 22, 30, 0, 0, time.UTC)).Info("3")
+	assert.Nil(t, hdlr.Close())
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "app-2024-03-31.log"))
+	assert.Nil(t, err)
+	assert.Equal(t, "1\n2\n", string(data))
+	data, err = ioutil.ReadFile(filepath.Join(dir, "app-2024-04-01.log"))
+	assert.Nil(t, err)
+	assert.Equal(t, "3\n", string(data))
+}
+
+func TestFileHandlerDateDirectories(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	SetClock(ClockFunc(func() time.Time {
+		return time.Date(2024, 1, 2, 23, 0, 0, 0, time.Local)
+	}))
+	defer SetClock(nil)
+
+	// a Go layout may contain path separators
+	hdlr := NewFileHandler(&TextFormatter{Fmt: "%(message)"}).SetPath(filepath.Join(dir, "{time:2006/01/02}", "app.log"))
+	logger := NewLogger(OptionHandlers(hdlr))
+	logger.Info("1")
+	logger.WithTime(time.Date(2024, 1, 3, 0, 0, 1, 0, time.Local)).Info("2")
+	assert.Nil(t, hdlr.Close())
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "2024", "01", "02", "app.log"))
+	assert.Nil(t, err)
+	assert.Equal(t, "1\n", string(data))
+	data, err = ioutil.ReadFile(filepath.Join(dir, "2024", "01", "03", "app.log"))
+	assert.Nil(t, err)
+	assert.Equal(t, "2\n", string(data))
+}